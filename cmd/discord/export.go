@@ -0,0 +1,86 @@
+package main
+
+import (
+	"discord-go-connect/internal/db"
+	"discord-go-connect/internal/export"
+	"discord-go-connect/internal/store"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// exportCommand runs `discord export`, which writes the stored history of a channel or a
+// guild to a file or to stdout:
+//
+//	discord export -format html -channel 123 -after 2024-01-01 -o general.html
+func exportCommand(args []string, history store.Store, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	format := flags.String("format", string(export.NDJSON), "ndjson, csv, text or html")
+	channelID := flags.String("channel", "", "ID of the channel to export")
+	guildID := flags.String("guild", "", "ID of the guild to export, when no channel is given")
+	after := flags.String("after", "", "only export messages after this date or RFC 3339 time")
+	before := flags.String("before", "", "only export messages before this date or RFC 3339 time")
+	output := flags.String("o", "", "file to write to instead of stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w\nusage: discord export -channel ID | -guild ID [-format F] [-after D] [-before D] [-o FILE]", err)
+	}
+
+	request := &export.Request{GuildID: *guildID, ChannelID: *channelID}
+
+	var err error
+
+	if request.Format, err = export.ParseFormat(*format); err != nil {
+		return err
+	}
+
+	if request.After, err = export.ParseDate(*after); err != nil {
+		return err
+	}
+
+	if request.Before, err = export.ParseDate(*before); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = export.NewExporter(history).Export(stdout, request)
+		return err
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", *output, err)
+	}
+
+	count, err := export.NewExporter(history).Export(file, request)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", *output, closeErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "exported %d messages to %s\n", count, *output)
+
+	return nil
+}
+
+// runExport exports from the bot's database and exits on failure.
+func runExport(args []string) {
+	dbManager, err := db.NewDBManager()
+	if err != nil {
+		log.Fatal("Error connecting to the database:", err)
+	}
+
+	err = exportCommand(args, store.NewMySQL(dbManager), os.Stdout)
+	dbManager.Close()
+
+	if err != nil {
+		log.Fatal("Error exporting:", err)
+	}
+}
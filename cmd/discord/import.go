@@ -0,0 +1,79 @@
+package main
+
+import (
+	"discord-go-connect/internal/db"
+	"discord-go-connect/internal/importer"
+	"discord-go-connect/internal/store"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// importCommand runs `discord import`, which imports DiscordChatExporter JSON exports and
+// the bot's own NDJSON exports into the database:
+//
+//	discord import -dry-run exports/*.json
+func importCommand(args []string, history store.Store, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	dryRun := flags.Bool("dry-run", false, "read and check the files without writing anything")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		if err == nil {
+			err = fmt.Errorf("no files to import")
+		}
+
+		return fmt.Errorf("%w\nusage: discord import [-dry-run] FILE...", err)
+	}
+
+	imports := importer.NewImporter(history, importer.Options{
+		DryRun: *dryRun,
+		Progress: func(file string, report importer.Report) {
+			fmt.Fprintf(stdout, "%s: %d messages read, %d new, %d duplicates\n",
+				file, report.Messages, report.Imported, report.Duplicates)
+		},
+	})
+
+	for _, path := range flags.Args() {
+		if err := imports.ImportFile(path); err != nil {
+			return err
+		}
+	}
+
+	report := imports.Report()
+	verb := "imported"
+
+	if *dryRun {
+		verb = "would import"
+	}
+
+	fmt.Fprintf(stdout, "%s %d messages, %d new channels and %d new guilds from %d files; skipped %d duplicates\n",
+		verb, report.Imported, report.Channels, report.Guilds, report.Files, report.Duplicates)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("failed to store %d messages", report.Failed)
+	}
+
+	return nil
+}
+
+// runImport imports into the bot's database and exits on failure.
+func runImport(args []string) {
+	dbManager, err := db.NewDBManager()
+	if err != nil {
+		log.Fatal("Error connecting to the database:", err)
+	}
+
+	if err = dbManager.Migrate(); err == nil {
+		err = importCommand(args, store.NewMySQL(dbManager), os.Stdout)
+	}
+
+	dbManager.Close()
+
+	if err != nil {
+		log.Fatal("Error importing:", err)
+	}
+}
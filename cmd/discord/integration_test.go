@@ -0,0 +1,1187 @@
+package main
+
+import (
+	"discord-go-connect/internal/api"
+	"discord-go-connect/internal/archive"
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/blob"
+	"discord-go-connect/internal/db/dbtest"
+	"discord-go-connect/internal/discord"
+	"discord-go-connect/internal/discord/discordtest"
+	"discord-go-connect/internal/export"
+	"discord-go-connect/internal/media"
+	"discord-go-connect/internal/store"
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	testTimeout = 3 * time.Second
+	// testKeys gives alice guild 1, bob guild 2 and admin every guild.
+	testKeys = "alice=alice-secret@1;bob=bob-secret@2;admin=admin-secret@*"
+)
+
+var botUser = &discordgo.User{ID: "900", Username: "bot", Bot: true}
+
+// harness runs the HTTP server with the hub, the REST API and a bot connected to a fake
+// gateway and an in-memory store.
+type harness struct {
+	bot     *discord.Bot
+	gateway *discordtest.Gateway
+	store   *store.Memory
+	archive *archive.Archive
+	media   *media.Mirror
+	server  *httptest.Server
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	keys, err := auth.NewKeys(testKeys)
+	if err != nil {
+		t.Fatalf("failed to parse keys: %v", err)
+	}
+
+	manager, _ := dbtest.NewRecorder(t)
+	history := store.NewMemory()
+	gateway := discordtest.NewGateway(botUser)
+	hub := wshub.NewHub(keys)
+
+	go hub.ListenToWSChannel()
+
+	bot := discord.NewBot("token", manager, keys)
+	bot.UseStore(history)
+	bot.UseClientFactory(func(shardID, shardCount int, intents discordgo.Intent) (discord.DiscordClient, error) {
+		return gateway.Connect(shardID, shardCount, intents), nil
+	})
+
+	apiServer := api.NewServer(bot, manager, keys)
+	apiServer.UseStore(history)
+	apiServer.UseExportDir(t.TempDir())
+
+	blobs, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create archive directory: %v", err)
+	}
+
+	messages := archive.NewArchive(history, blobs)
+	apiServer.UseArchive(messages)
+
+	mirror := media.NewMirror(history, blobs, media.DefaultOptions())
+	bot.UseMediaMirror(mirror)
+	apiServer.UseMediaMirror(mirror)
+
+	server := httptest.NewServer(routes(bot, hub, apiServer))
+	t.Cleanup(server.Close)
+
+	bot.UseHub("ws" + strings.TrimPrefix(server.URL, "http") + "/ws")
+
+	if err := bot.Start(); err != nil {
+		t.Fatalf("failed to start bot: %v", err)
+	}
+
+	t.Cleanup(func() { _ = bot.Stop() })
+
+	gateway.Ready(0)
+
+	for _, guild := range []*discordgo.Guild{testGuild("1", "10", "11"), testGuild("2", "20")} {
+		gateway.Emit(0, &discordgo.GuildCreate{Guild: guild})
+
+		for _, channel := range guild.Channels {
+			gateway.SetPermissions(channel.ID, discordgo.PermissionViewChannel|discordgo.PermissionSendMessages)
+		}
+	}
+
+	waitFor(t, "the bot to join the hub", func() bool { return hub.BotCount() == 1 })
+
+	return &harness{bot: bot, gateway: gateway, store: history, archive: messages, media: mirror, server: server}
+}
+
+// browser is a web client connected to the hub.
+type browser struct {
+	conn     *websocket.Conn
+	received chan wshub.WSJSONResponse
+	name     string
+}
+
+// connect opens a WebSocket as a web client with an API key and waits for the handshake.
+func (h *harness) connect(t *testing.T, secret string) *browser {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(h.server.URL, "http") + "/ws?token=" + url.QueryEscape(secret)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to connect %s: %v", secret, err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	b := &browser{conn: conn, received: make(chan wshub.WSJSONResponse, 256), name: secret}
+
+	go func() {
+		defer close(b.received)
+
+		for {
+			var response wshub.WSJSONResponse
+			if err := conn.ReadJSON(&response); err != nil {
+				return
+			}
+
+			b.received <- response
+		}
+	}()
+
+	b.expect(t, wshub.ServerHandshake)
+
+	return b
+}
+
+func (b *browser) send(t *testing.T, action wshub.Action[wshub.ClientAction], messageID, message, nonce string) {
+	t.Helper()
+
+	payload := wshub.WSPayload{
+		Action: wshub.Action[wshub.ServerAction](action), MessageID: messageID, Message: message, Nonce: nonce,
+	}
+
+	if err := b.conn.WriteJSON(payload); err != nil {
+		t.Fatalf("%s failed to send %s: %v", b.name, action, err)
+	}
+}
+
+// sync waits until the bot has handled everything the browser sent before, since the
+// hub and the bot handle a client's requests in order.
+func (b *browser) sync(t *testing.T) {
+	t.Helper()
+
+	b.send(t, wshub.ClientJoin, "", "", "sync")
+
+	for {
+		if response := b.expect(t, wshub.ServerListGuilds); response.Nonce == "sync" {
+			return
+		}
+	}
+}
+
+// expect returns the next response, which must have an action.
+func (b *browser) expect(t *testing.T, action wshub.Action[wshub.ServerAction]) wshub.WSJSONResponse {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case response, ok := <-b.received:
+			if !ok {
+				t.Fatalf("%s was disconnected while waiting for %s", b.name, action)
+			}
+
+			if string(response.Action) != string(action) {
+				t.Fatalf("%s got %s while waiting for %s: %s", b.name, response.Action, action, response.Message)
+			}
+
+			return response
+		case <-deadline:
+			t.Fatalf("%s got no %s", b.name, action)
+		}
+	}
+}
+
+// expectNone fails if a response with an action, or any other response, arrives within
+// a moment.
+func (b *browser) expectNone(t *testing.T, action wshub.Action[wshub.ServerAction]) {
+	t.Helper()
+
+	select {
+	case response, ok := <-b.received:
+		if !ok {
+			t.Fatalf("%s was disconnected while expecting no %s", b.name, action)
+		}
+
+		t.Fatalf("%s got %s while expecting no %s: %s", b.name, response.Action, action, response.Message)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func (h *harness) get(t *testing.T, path, secret string, body interface{}) int {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, h.server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	if secret != "" {
+		request.Header.Set("Authorization", "Bearer "+secret)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer response.Body.Close()
+
+	if body != nil && response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(body); err != nil {
+			t.Fatalf("failed to decode %s: %v", path, err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testGuild(id string, channelIDs ...string) *discordgo.Guild {
+	guild := &discordgo.Guild{ID: id, Name: "guild " + id}
+
+	for i, channelID := range channelIDs {
+		guild.Channels = append(guild.Channels, &discordgo.Channel{
+			ID: channelID, GuildID: id, Name: "channel-" + channelID, Type: discordgo.ChannelTypeGuildText, Position: i,
+		})
+	}
+
+	return guild
+}
+
+func testMessage(id int, guildID, channelID string) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        strconv.Itoa(id),
+		ChannelID: channelID,
+		GuildID:   guildID,
+		Content:   fmt.Sprintf("message %d", id),
+		Author:    &discordgo.User{ID: "42", Username: "carol"},
+		Member:    &discordgo.Member{Nick: "Caz"},
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(id) * time.Minute),
+	}}
+}
+
+func guildIDs(t *testing.T, response wshub.WSJSONResponse) []string {
+	t.Helper()
+
+	var guilds map[string]*discordgo.Guild
+	if err := json.Unmarshal([]byte(response.Message), &guilds); err != nil {
+		t.Fatalf("failed to decode guilds: %v", err)
+	}
+
+	ids := make([]string, 0, len(guilds))
+	for id := range guilds {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func TestGuildListingFollowsAPIKeys(t *testing.T) {
+	h := newHarness(t)
+
+	for secret, want := range map[string]int{"alice-secret": 1, "bob-secret": 1, "admin-secret": 2} {
+		b := h.connect(t, secret)
+		b.send(t, wshub.ClientJoin, "", "", "n")
+
+		ids := guildIDs(t, b.expect(t, wshub.ServerListGuilds))
+		if len(ids) != want {
+			t.Fatalf("%s sees guilds %v, want %d", secret, ids, want)
+		}
+	}
+
+	if status := h.get(t, "/api/guilds/1/roles", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("request without a key: status %d, want 401", status)
+	}
+}
+
+func TestSubscriptionFanOut(t *testing.T) {
+	h := newHarness(t)
+
+	alice, admin, bob := h.connect(t, "alice-secret"), h.connect(t, "admin-secret"), h.connect(t, "bob-secret")
+
+	alice.send(t, wshub.ClientSubscribe, "", "guild:1", "")
+	admin.send(t, wshub.ClientSubscribe, "", "thread:10", "")
+	bob.send(t, wshub.ClientSubscribe, "", "guild:1", "denied")
+
+	if response := bob.expect(t, wshub.ServerError); response.Nonce != "denied" {
+		t.Fatalf("bob's error has nonce %q, want denied", response.Nonce)
+	}
+
+	alice.sync(t)
+	admin.sync(t)
+
+	h.gateway.Emit(0, testMessage(1, "1", "10"))
+
+	for _, b := range []*browser{alice, admin} {
+		response := b.expect(t, "messages")
+
+		var message discordgo.MessageCreate
+		if err := json.Unmarshal([]byte(response.Message), &message); err != nil || message.Content != "message 1" {
+			t.Fatalf("%s got %s (%v), want message 1", b.name, response.Message, err)
+		}
+	}
+
+	bob.expectNone(t, "messages")
+
+	// Other channels of the guild only reach the guild's subscribers.
+	h.gateway.Emit(0, testMessage(2, "1", "11"))
+	alice.expect(t, "messages")
+	admin.expectNone(t, "messages")
+}
+
+func TestGuildBroadcastsRespectGrants(t *testing.T) {
+	h := newHarness(t)
+
+	alice, bob := h.connect(t, "alice-secret"), h.connect(t, "bob-secret")
+
+	h.gateway.Emit(0, &discordgo.GuildUpdate{Guild: &discordgo.Guild{ID: "2", Name: "renamed"}})
+
+	if response := bob.expect(t, wshub.ServerGuildUpdated); response.MessageID != "2" {
+		t.Fatalf("bob got guild %s, want 2", response.MessageID)
+	}
+
+	alice.expectNone(t, wshub.ServerGuildUpdated)
+}
+
+func TestWebClientsCannotPublishWithoutBot(t *testing.T) {
+	keys, err := auth.NewKeys(testKeys)
+	if err != nil {
+		t.Fatalf("failed to parse keys: %v", err)
+	}
+
+	hub := wshub.NewHub(keys)
+
+	go hub.ListenToWSChannel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wshub.WSHandler(hub, w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	h := &harness{server: server}
+	alice, bob := h.connect(t, "alice-secret"), h.connect(t, "bob-secret")
+
+	forged := wshub.WSPayload{Action: "messages", MessageID: "20", Message: `{"content": "forged"}`, Nonce: "n1"}
+	if err := alice.conn.WriteJSON(forged); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	if response := alice.expect(t, wshub.ServerError); response.Nonce != "n1" || !strings.Contains(response.Message, "no bot") {
+		t.Errorf("alice got %+v, want an error for her request", response)
+	}
+
+	bob.expectNone(t, "messages")
+}
+
+func TestOutboundSend(t *testing.T) {
+	h := newHarness(t)
+
+	alice := h.connect(t, "alice-secret")
+
+	alice.send(t, wshub.ClientGuildMessage, "10", "hello from the web", "send-1")
+
+	response := alice.expect(t, wshub.ServerMessageSent)
+	if response.Nonce != "send-1" || response.MessageID != "10" {
+		t.Fatalf("message_sent = %+v, want nonce send-1 in channel 10", response)
+	}
+
+	sent := h.gateway.Messages("10")
+	if len(sent) != 1 || sent[0].Content != "hello from the web" {
+		t.Fatalf("channel 10 holds %+v, want the sent message", sent)
+	}
+
+	alice.send(t, wshub.ClientGuildMessage, "20", "not my guild", "send-2")
+
+	if response := alice.expect(t, wshub.ServerError); response.Nonce != "send-2" {
+		t.Fatalf("error nonce = %q, want send-2", response.Nonce)
+	}
+
+	if sent := h.gateway.Messages("20"); len(sent) != 0 {
+		t.Fatalf("channel 20 holds %d messages, want none", len(sent))
+	}
+}
+
+func TestDisconnects(t *testing.T) {
+	h := newHarness(t)
+
+	alice, admin := h.connect(t, "alice-secret"), h.connect(t, "admin-secret")
+
+	alice.send(t, wshub.ClientSubscribe, "", "guild:1", "")
+	admin.send(t, wshub.ClientSubscribe, "", "guild:1", "")
+	alice.sync(t)
+	admin.sync(t)
+
+	// A browser going away leaves the others subscribed.
+	alice.conn.Close()
+	admin.sync(t)
+
+	h.gateway.Emit(0, testMessage(1, "1", "10"))
+	admin.expect(t, "messages")
+
+	// A dropped shard shows up in the health check until it resumes.
+	var health struct {
+		Status string                `json:"status"`
+		Shards []discord.ShardStatus `json:"shards"`
+	}
+
+	h.gateway.Disconnect(0)
+
+	if h.get(t, "/health", "", &health); health.Status != "degraded" {
+		t.Fatalf("health after disconnect = %s, want degraded", health.Status)
+	}
+
+	waitFor(t, "the shard to reopen", h.gateway.Shard(0).IsOpen)
+	h.gateway.Emit(0, &discordgo.Resumed{})
+
+	if h.get(t, "/health", "", &health); health.Status != "healthy" || health.Shards[0].State != discord.ShardReady {
+		t.Fatalf("health after resume = %+v, want healthy", health)
+	}
+}
+
+func TestChannelPagination(t *testing.T) {
+	h := newHarness(t)
+
+	// The bot stores messages in batches of 100.
+	for id := 1; id <= 100; id++ {
+		h.gateway.Emit(0, testMessage(id, "1", "10"))
+	}
+
+	var page struct {
+		Data       []discordgo.Message `json:"data"`
+		NextCursor int                 `json:"nextCursor"`
+	}
+
+	seen := 0
+
+	for cursor := 1; cursor != 0; cursor = page.NextCursor {
+		page.Data, page.NextCursor = nil, 0
+
+		if status := h.get(t, "/api/channel?channelId=10&page="+strconv.Itoa(cursor), "alice-secret", &page); status != http.StatusOK {
+			t.Fatalf("page %d: status %d", cursor, status)
+		}
+
+		for _, message := range page.Data {
+			if want := strconv.Itoa(100 - seen); message.ID != want {
+				t.Fatalf("page %d holds message %s, want %s", cursor, message.ID, want)
+			}
+
+			if message.Author.Username != "carol" || message.Member == nil || message.Member.Nick != "Caz" {
+				t.Fatalf("message %s has author %+v and member %+v", message.ID, message.Author, message.Member)
+			}
+
+			seen++
+		}
+	}
+
+	if seen != 100 {
+		t.Fatalf("paged through %d messages, want 100", seen)
+	}
+
+	if status := h.get(t, "/api/channel?channelId=10&page=1", "bob-secret", nil); status != http.StatusForbidden {
+		t.Fatalf("bob reading guild 1: status %d, want 403", status)
+	}
+}
+
+func TestChannelPaginationIntoArchive(t *testing.T) {
+	h := newHarness(t)
+
+	// Messages 1 to 25 are from January and archived; 26 to 45 from February stay.
+	messages := make([]*discordgo.MessageCreate, 0, 45)
+
+	for id := 1; id <= 45; id++ {
+		message := testMessage(id, "1", "10")
+		if id > 25 {
+			message.Timestamp = message.Timestamp.AddDate(0, 1, 0)
+		}
+
+		messages = append(messages, message)
+	}
+
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	report, err := h.archive.Run(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || report.Messages != 25 {
+		t.Fatalf("archived %+v (%v), want January", report, err)
+	}
+
+	var page struct {
+		Data       []discordgo.Message `json:"data"`
+		NextCursor int                 `json:"nextCursor"`
+	}
+
+	seen := 0
+
+	for cursor := 1; cursor != 0; cursor = page.NextCursor {
+		page.Data, page.NextCursor = nil, 0
+
+		if status := h.get(t, "/api/channel?channelId=10&page="+strconv.Itoa(cursor), "alice-secret", &page); status != http.StatusOK {
+			t.Fatalf("page %d: status %d", cursor, status)
+		}
+
+		for _, message := range page.Data {
+			if want := strconv.Itoa(45 - seen); message.ID != want {
+				t.Fatalf("page %d holds message %s, want %s", cursor, message.ID, want)
+			}
+
+			seen++
+		}
+	}
+
+	if seen != 45 {
+		t.Fatalf("paged through %d messages, want 45", seen)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h := newHarness(t)
+
+	messages := []*discordgo.MessageCreate{
+		testMessage(1, "1", "10"), testMessage(2, "1", "10"), testMessage(3, "1", "11"), testMessage(4, "2", "20"),
+	}
+	messages[0].Content = "the deploy failed again"
+	messages[1].Content = "deploy deploy deploy <script>, failed"
+	messages[1].Attachments = []*discordgo.MessageAttachment{{ID: "5", Filename: "log.txt"}}
+	messages[2].Content = "unrelated"
+	messages[2].Mentions = []*discordgo.User{{ID: "77"}}
+	messages[3].Content = "deploy failed in the other guild"
+
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	var page discord.SearchPage
+
+	if status := h.get(t, "/api/search?q=Deploy+failed", "alice-secret", &page); status != http.StatusOK {
+		t.Fatalf("search: status %d", status)
+	}
+
+	if len(page.Data) != 2 || page.Data[0].Message.ID != "2" || page.Data[1].Message.ID != "1" {
+		t.Fatalf("alice's hits = %+v, want messages 2 then 1 of her guild", page.Data)
+	}
+
+	if want := "<mark>deploy</mark> <mark>deploy</mark> <mark>deploy</mark> &lt;script&gt;, <mark>failed</mark>"; page.Data[0].Snippet != want {
+		t.Fatalf("snippet = %q, want %q", page.Data[0].Snippet, want)
+	}
+
+	for path, want := range map[string]string{
+		"/api/search?q=deploy&has=attachment":                                          "2",
+		"/api/search?mentions=77":                                                      "3",
+		"/api/search?q=deploy&guildId=2":                                               "4",
+		"/api/search?q=failed&before=2024-01-01T00:01:30Z":                             "1",
+		"/api/search?q=" + url.QueryEscape(`in:#channel-10 has:file "Deploy, deploy"`): "2",
+		"/api/search?q=" + url.QueryEscape(`mentions:<@77> from:42`):                   "3",
+		"/api/search?q=" + url.QueryEscape(`pinned:false after:2023-12-31 in:<#20>`):   "4",
+	} {
+		page.Data = nil
+
+		if status := h.get(t, path, "admin-secret", &page); status != http.StatusOK || len(page.Data) != 1 || page.Data[0].Message.ID != want {
+			t.Fatalf("%s: status %d, hits %+v, want message %s", path, status, page.Data, want)
+		}
+	}
+
+	for path, want := range map[string]int{
+		"/api/search?q=deploy&guildId=2":    http.StatusForbidden,
+		"/api/search?q=deploy&channelId=20": http.StatusForbidden,
+		"/api/search?after=yesterday":       http.StatusBadRequest,
+		"/api/search?q=deploy+%22failed":    http.StatusBadRequest,
+		"/api/search?q=in:%23nowhere":       http.StatusBadRequest,
+	} {
+		if status := h.get(t, path, "alice-secret", nil); status != want {
+			t.Fatalf("%s: status %d, want %d", path, status, want)
+		}
+	}
+
+	bob := h.connect(t, "bob-secret")
+
+	request, _ := json.Marshal(map[string]string{"query": "deploy"})
+	if err := bob.conn.WriteJSON(wshub.WSPayload{Action: "search", Data: request, Nonce: "s"}); err != nil {
+		t.Fatalf("failed to send search: %v", err)
+	}
+
+	response := bob.expect(t, wshub.ServerSearchResults)
+	if err := json.Unmarshal([]byte(response.Message), &page); err != nil || response.Nonce != "s" {
+		t.Fatalf("search_results = %+v (%v)", response, err)
+	}
+
+	if len(page.Data) != 1 || page.Data[0].Message.ID != "4" {
+		t.Fatalf("bob's hits = %+v, want message 4 of his guild", page.Data)
+	}
+}
+
+func TestSearchCommand(t *testing.T) {
+	h := newHarness(t)
+	h.bot.AddCommand(h.bot.SearchCommand())
+
+	messages := []*discordgo.MessageCreate{testMessage(1, "1", "10"), testMessage(2, "2", "20")}
+	messages[0].Content = "deploy <failed>"
+	messages[1].Content = "deploy elsewhere"
+
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	search := func(query string) string {
+		t.Helper()
+
+		h.gateway.Emit(0, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			ID: "i-" + query, Type: discordgo.InteractionApplicationCommand, GuildID: "1", ChannelID: "10",
+			Member: &discordgo.Member{User: &discordgo.User{ID: "55"}},
+			Data: discordgo.ApplicationCommandInteractionData{Name: "search", Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "query", Type: discordgo.ApplicationCommandOptionString, Value: query},
+			}},
+		}})
+
+		edits := h.gateway.Calls("InteractionResponseEdit")
+		if len(edits) == 0 {
+			t.Fatalf("/search %s got no answer", query)
+		}
+
+		return *edits[len(edits)-1].Args[1].(*discordgo.WebhookEdit).Content
+	}
+
+	want := "[<#10>](https://discord.com/channels/1/10/1) **carol** <t:1704067260:R>\n> **deploy** <failed>\n"
+	if got := search("deploy"); got != want {
+		t.Fatalf("/search deploy =\n%q, want\n%q", got, want)
+	}
+
+	if got := search("deploy in:#channel-20"); got != `Invalid query: column 11: unknown channel "#channel-20"` {
+		t.Fatalf("/search in another guild's channel = %q", got)
+	}
+
+	h.gateway.SetPermissions("10", 0)
+
+	if got := search("deploy"); got != "No messages found." {
+		t.Fatalf("/search in a hidden channel = %q", got)
+	}
+}
+
+func TestExports(t *testing.T) {
+	h := newHarness(t)
+
+	messages := []*discordgo.MessageCreate{testMessage(1, "1", "10"), testMessage(2, "1", "10"), testMessage(3, "2", "20")}
+	messages[0].Content = "<script>alert(1)</script>"
+
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	start := func(secret, body string) *http.Response {
+		t.Helper()
+
+		request, err := http.NewRequest(http.MethodPost, h.server.URL+"/api/exports", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+
+		request.Header.Set("Authorization", "Bearer "+secret)
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("POST /api/exports: %v", err)
+		}
+
+		t.Cleanup(func() { response.Body.Close() })
+
+		return response
+	}
+
+	response := start("alice-secret", `{"format": "html", "channel_id": "10", "after": "2024-01-01"}`)
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("starting an export returned %d, want 202", response.StatusCode)
+	}
+
+	var job export.Job
+	if err := json.NewDecoder(response.Body).Decode(&job); err != nil {
+		t.Fatalf("failed to decode job: %v", err)
+	}
+
+	location := response.Header.Get("Location")
+	if location != "/api/exports/"+job.ID {
+		t.Fatalf("Location = %q, want the job", location)
+	}
+
+	waitFor(t, "the export to finish", func() bool {
+		return h.get(t, location, "alice-secret", &job) == http.StatusOK && job.Status == export.JobDone
+	})
+
+	if job.Messages != 2 {
+		t.Errorf("exported %d messages, want 2", job.Messages)
+	}
+
+	// Jobs belong to the key that started them.
+	if status := h.get(t, location, "bob-secret", nil); status != http.StatusNotFound {
+		t.Errorf("another key's job returned %d, want 404", status)
+	}
+
+	if status := h.get(t, location, "admin-secret", nil); status != http.StatusOK {
+		t.Errorf("admin got %d for the job, want 200", status)
+	}
+
+	download, err := http.NewRequest(http.MethodGet, h.server.URL+location+"/download", nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	download.Header.Set("Authorization", "Bearer alice-secret")
+
+	file, err := http.DefaultClient.Do(download)
+	if err != nil {
+		t.Fatalf("GET %s/download: %v", location, err)
+	}
+	defer file.Body.Close()
+
+	page, _ := io.ReadAll(file.Body)
+
+	if file.StatusCode != http.StatusOK || file.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("download returned %d %q", file.StatusCode, file.Header.Get("Content-Type"))
+	}
+
+	if disposition := file.Header.Get("Content-Disposition"); disposition != "attachment; filename=channel-10.html" {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	if !strings.Contains(string(page), "&lt;script&gt;alert(1)&lt;/script&gt;") || !strings.Contains(string(page), "message 2") {
+		t.Errorf("unexpected export:\n%s", page)
+	}
+
+	for body, want := range map[string]int{
+		`{"format": "html", "channel_id": "20"}`: http.StatusForbidden,
+		`{"guild_id": "2"}`:                      http.StatusForbidden,
+		`{"format": "pdf", "channel_id": "10"}`:  http.StatusBadRequest,
+		`{"format": "csv"}`:                      http.StatusBadRequest,
+		`{"channel_id": "10", "after": "soon"}`:  http.StatusBadRequest,
+	} {
+		if response := start("alice-secret", body); response.StatusCode != want {
+			t.Errorf("exporting %s returned %d, want %d", body, response.StatusCode, want)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	h := newHarness(t)
+
+	messages := make([]*discordgo.MessageCreate, 0, 8)
+	for id := 1; id <= 5; id++ {
+		messages = append(messages, testMessage(id, "1", "10"))
+	}
+
+	messages = append(messages, testMessage(6, "1", "11"), testMessage(7, "1", "11"), testMessage(8, "2", "20"))
+
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	send := func(method, path, secret, body string) int {
+		t.Helper()
+
+		request, err := http.NewRequest(method, h.server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+
+		request.Header.Set("Authorization", "Bearer "+secret)
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+
+		response.Body.Close()
+
+		return response.StatusCode
+	}
+
+	// The messages are from 2024, so guild 1 keeps none of them, except for the newest two
+	// of channel 10, whose own rule overrides the guild's.
+	for body, want := range map[string]int{
+		`{"guild_id": "1", "mode": "days", "amount": 30}`:       http.StatusOK,
+		`{"channel_id": "10", "mode": "messages", "amount": 2}`: http.StatusOK,
+		`{"channel_id": "20", "mode": "days"}`:                  http.StatusBadRequest,
+		`{"mode": "forever"}`:                                   http.StatusBadRequest,
+		// Unknown channels have no guild to file the rule under.
+		`{"channel_id": "99", "mode": "forever"}`: http.StatusBadRequest,
+	} {
+		if status := send(http.MethodPut, "/api/retention", "admin-secret", body); status != want {
+			t.Errorf("saving %s returned %d, want %d", body, status, want)
+		}
+	}
+
+	if status := send(http.MethodPut, "/api/retention", "alice-secret", `{"guild_id": "1", "mode": "forever"}`); status != http.StatusForbidden {
+		t.Errorf("alice saving a rule returned %d, want 403", status)
+	}
+
+	var rules []store.RetentionRule
+	if status := h.get(t, "/api/retention", "admin-secret", &rules); status != http.StatusOK || len(rules) != 2 {
+		t.Fatalf("listing rules returned %d %+v, want the 2 rules", status, rules)
+	}
+
+	if rules[1].GuildID != "1" || rules[1].ChannelID != "10" {
+		t.Errorf("channel rule = %+v, want it filed under guild 1", rules[1])
+	}
+
+	if pruned := h.bot.EnforceRetention(); pruned != 5 {
+		t.Fatalf("pruned %d messages, want 5", pruned)
+	}
+
+	var page struct {
+		PrunedBefore *time.Time          `json:"prunedBefore"`
+		Data         []discordgo.Message `json:"data"`
+	}
+
+	if status := h.get(t, "/api/channel?channelId=10&page=1", "alice-secret", &page); status != http.StatusOK {
+		t.Fatalf("reading channel 10 returned %d", status)
+	}
+
+	if len(page.Data) != 2 || page.Data[0].ID != "5" || page.Data[1].ID != "4" {
+		t.Errorf("channel 10 holds %+v, want messages 5 and 4", page.Data)
+	}
+
+	if want := testMessage(3, "1", "10").Timestamp; page.PrunedBefore == nil || !page.PrunedBefore.Equal(want) {
+		t.Errorf("prunedBefore = %v, want %v", page.PrunedBefore, want)
+	}
+
+	if known, _ := h.store.KnownMessages([]string{"6", "7", "8"}); known["6"] || known["7"] || !known["8"] {
+		t.Errorf("known messages = %v, want only 8 of guild 2", known)
+	}
+
+	if status := send(http.MethodDelete, "/api/retention?guildId=1&channelId=10", "admin-secret", ""); status != http.StatusNoContent {
+		t.Errorf("deleting the channel rule returned %d, want 204", status)
+	}
+
+	if status := send(http.MethodDelete, "/api/retention?guildId=1&channelId=10", "admin-secret", ""); status != http.StatusNotFound {
+		t.Errorf("deleting it again returned %d, want 404", status)
+	}
+}
+
+func TestRetentionPrunesArchive(t *testing.T) {
+	h := newHarness(t)
+
+	// The bot has started, so setting the archive now starts no archive runs.
+	h.bot.UseArchive(h.archive, archive.DefaultAge)
+
+	// Messages 1 to 25 are from January and archived; 26 to 45 from February stay.
+	messages := make([]*discordgo.MessageCreate, 0, 45)
+
+	for id := 1; id <= 45; id++ {
+		message := testMessage(id, "1", "10")
+		if id > 25 {
+			message.Timestamp = message.Timestamp.AddDate(0, 1, 0)
+		}
+
+		messages = append(messages, message)
+	}
+
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	if _, err := h.archive.Run(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	// Pruning the store alone leaves the archive behind the pruned history, which the
+	// history API must not continue into.
+	if _, err := h.store.PruneMessages("10", time.Time{}, 0, 5); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+
+	var page struct {
+		Data       []discordgo.Message `json:"data"`
+		NextCursor int                 `json:"nextCursor"`
+	}
+
+	seen := 0
+
+	for cursor := 1; cursor != 0; cursor = page.NextCursor {
+		page.Data, page.NextCursor = nil, 0
+
+		if status := h.get(t, "/api/channel?channelId=10&page="+strconv.Itoa(cursor), "alice-secret", &page); status != http.StatusOK {
+			t.Fatalf("page %d: status %d", cursor, status)
+		}
+
+		seen += len(page.Data)
+	}
+
+	if seen != 15 {
+		t.Fatalf("paged through %d messages, want the 15 stored after the pruned ones", seen)
+	}
+
+	// Keeping 20 messages keeps the 15 stored ones and the newest 5 archived ones.
+	if err := h.store.SaveRetentionRule(&store.RetentionRule{GuildID: "1", ChannelID: "10", Mode: store.KeepMessages, Amount: 20}); err != nil {
+		t.Fatalf("failed to save rule: %v", err)
+	}
+
+	if pruned := h.bot.EnforceRetention(); pruned != 20 {
+		t.Fatalf("pruned %d messages, want the 20 oldest archived ones", pruned)
+	}
+
+	archived, err := h.archive.ChannelMessages("10", 50, 0)
+	if err != nil || len(archived) != 5 || archived[0].ID != "25" || archived[4].ID != "21" {
+		t.Fatalf("archive holds %+v (%v), want messages 25 to 21", archived, err)
+	}
+}
+
+func TestMediaMirror(t *testing.T) {
+	h := newHarness(t)
+
+	picture := []byte("\x89PNG\r\n\x1a\n a picture of a cat")
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(picture)
+	}))
+	t.Cleanup(cdn.Close)
+
+	message := testMessage(1, "1", "10")
+	message.Attachments = []*discordgo.MessageAttachment{{ID: "500", URL: cdn.URL + "/cat.png", Filename: "cat.png"}}
+
+	if err := h.bot.CreateMessage([]*discordgo.MessageCreate{message}); err != nil {
+		t.Fatalf("failed to store message: %v", err)
+	}
+
+	var page struct {
+		Data []discordgo.Message `json:"data"`
+	}
+
+	waitFor(t, "the attachment to be mirrored", func() bool {
+		page.Data = nil
+		h.get(t, "/api/channel?channelId=10&page=1", "alice-secret", &page)
+
+		return len(page.Data) == 1 && len(page.Data[0].Attachments) == 1 &&
+			strings.HasPrefix(page.Data[0].Attachments[0].ProxyURL, "/media/")
+	})
+
+	location := page.Data[0].Attachments[0].ProxyURL
+
+	request, err := http.NewRequest(http.MethodGet, h.server.URL+location, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+
+	request.Header.Set("Authorization", "Bearer bob-secret")
+	request.Header.Set("Range", "bytes=4-9")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET %s: %v", location, err)
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+
+	if response.StatusCode != http.StatusPartialContent || string(body) != string(picture[4:10]) {
+		t.Errorf("range returned %d %q, want 206 %q", response.StatusCode, body, picture[4:10])
+	}
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", contentType)
+	}
+
+	for path, want := range map[string]int{
+		"/media/attachment/500":             http.StatusOK,
+		"/media/attachment/501":             http.StatusNotFound,
+		"/media/" + strings.Repeat("0", 64): http.StatusNotFound,
+	} {
+		if status := h.get(t, path, "alice-secret", nil); status != want {
+			t.Errorf("GET %s returned %d, want %d", path, status, want)
+		}
+	}
+
+	// Bob reads the file by hash above, but can't look up guild 1's attachments by ID.
+	if status := h.get(t, "/media/attachment/500", "bob-secret", nil); status != http.StatusNotFound {
+		t.Errorf("bob reading guild 1's attachment by source returned %d, want 404", status)
+	}
+
+	if status := h.get(t, location, "", nil); status != http.StatusUnauthorized {
+		t.Errorf("reading media without a key returned %d, want 401", status)
+	}
+}
+
+func TestMediaMirrorFollowsMessages(t *testing.T) {
+	h := newHarness(t)
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n a picture of a dog"))
+	}))
+	t.Cleanup(cdn.Close)
+
+	message := testMessage(1, "1", "10")
+	message.Attachments = []*discordgo.MessageAttachment{{ID: "500", URL: cdn.URL + "/dog.png", Filename: "dog.png"}}
+
+	if err := h.bot.CreateMessage([]*discordgo.MessageCreate{message}); err != nil {
+		t.Fatalf("failed to store message: %v", err)
+	}
+
+	waitFor(t, "the attachment to be mirrored", func() bool {
+		return h.get(t, "/media/attachment/500", "alice-secret", nil) == http.StatusOK
+	})
+
+	// Archived messages keep their mirrored files.
+	if report, err := h.archive.Run(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil || report.Messages != 1 {
+		t.Fatalf("archived %+v (%v), want the message", report, err)
+	}
+
+	var page struct {
+		Data []discordgo.Message `json:"data"`
+	}
+
+	h.get(t, "/api/channel?channelId=10&page=1", "alice-secret", &page)
+
+	if len(page.Data) != 1 || len(page.Data[0].Attachments) != 1 || !strings.HasPrefix(page.Data[0].Attachments[0].ProxyURL, "/media/") {
+		t.Fatalf("archived page = %+v, want the message with its mirrored attachment", page.Data)
+	}
+
+	location := page.Data[0].Attachments[0].ProxyURL
+
+	if status := h.get(t, location, "alice-secret", nil); status != http.StatusOK {
+		t.Fatalf("GET %s of an archived message returned %d, want 200", location, status)
+	}
+
+	// Deleting the message on Discord deletes its mirrored file.
+	h.gateway.Emit(0, &discordgo.MessageDelete{Message: &discordgo.Message{ID: "1", ChannelID: "10", GuildID: "1"}})
+
+	waitFor(t, "the mirrored file to be deleted", func() bool {
+		return h.get(t, location, "alice-secret", nil) == http.StatusNotFound
+	})
+
+	if status := h.get(t, "/media/attachment/500", "alice-secret", nil); status != http.StatusNotFound {
+		t.Errorf("GET /media/attachment/500 of a deleted message returned %d, want 404", status)
+	}
+}
+
+func TestRESTAPI(t *testing.T) {
+	h := newHarness(t)
+
+	messages := []*discordgo.MessageCreate{testMessage(1, "1", "10"), testMessage(2, "1", "10"), testMessage(3, "2", "20")}
+	if _, err := h.store.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	waitFor(t, "the guilds to be stored", func() bool {
+		guilds, _ := h.store.Guilds()
+		return len(guilds) == 2
+	})
+
+	type named struct {
+		ID     string   `json:"id"`
+		Name   string   `json:"name"`
+		Guilds []string `json:"guilds"`
+	}
+
+	ids := func(list []named) []string {
+		ids := make([]string, 0, len(list))
+		for _, item := range list {
+			ids = append(ids, item.ID)
+		}
+
+		return ids
+	}
+
+	for secret, want := range map[string][]string{"alice-secret": {"1"}, "admin-secret": {"1", "2"}} {
+		var guilds []named
+		if status := h.get(t, "/api/guilds", secret, &guilds); status != http.StatusOK || !reflect.DeepEqual(ids(guilds), want) {
+			t.Errorf("%s listed guilds %v (%d), want %v", secret, ids(guilds), status, want)
+		}
+	}
+
+	var channels []named
+	if status := h.get(t, "/api/guilds/1/channels", "alice-secret", &channels); status != http.StatusOK ||
+		!reflect.DeepEqual(ids(channels), []string{"10", "11"}) {
+		t.Errorf("channels of guild 1 = %v (%d), want 10 and 11", ids(channels), status)
+	}
+
+	var page struct {
+		Data []discordgo.Message `json:"data"`
+	}
+
+	if status := h.get(t, "/api/channels/10/messages", "alice-secret", &page); status != http.StatusOK ||
+		len(page.Data) != 2 || page.Data[0].ID != "2" {
+		t.Errorf("history of channel 10 = %+v (%d), want messages 2 and 1", page.Data, status)
+	}
+
+	var message discordgo.Message
+	if status := h.get(t, "/api/messages/1", "alice-secret", &message); status != http.StatusOK ||
+		message.Content != "message 1" || message.Author.Username != "carol" {
+		t.Errorf("message 1 = %+v (%d)", message, status)
+	}
+
+	var user named
+	if status := h.get(t, "/api/users/42", "bob-secret", &user); status != http.StatusOK ||
+		!reflect.DeepEqual(user.Guilds, []string{"2"}) {
+		t.Errorf("bob sees user 42 as %+v (%d), want a member of guild 2 only", user, status)
+	}
+
+	for path, want := range map[string]map[string]int{
+		"/api/guilds/1":        {"alice-secret": http.StatusOK, "bob-secret": http.StatusForbidden},
+		"/api/guilds/3":        {"admin-secret": http.StatusNotFound},
+		"/api/channels/10":     {"alice-secret": http.StatusOK, "bob-secret": http.StatusForbidden},
+		"/api/messages/3":      {"alice-secret": http.StatusForbidden, "bob-secret": http.StatusOK},
+		"/api/messages/99":     {"admin-secret": http.StatusNotFound},
+		"/api/users/43":        {"admin-secret": http.StatusNotFound},
+		"/api/guilds/1/emojis": {"admin-secret": http.StatusNotFound},
+	} {
+		for secret, status := range want {
+			if got := h.get(t, path, secret, nil); got != status {
+				t.Errorf("%s reading %s returned %d, want %d", secret, path, got, status)
+			}
+		}
+	}
+
+	// Unknown routes and methods get JSON errors, and 405s list the allowed methods.
+	for _, test := range []struct {
+		method, path, allow string
+		status              int
+	}{
+		{http.MethodPost, "/api/guilds/1", http.MethodGet, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/channels/10/pins/1", "PUT, DELETE", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/nothing", "", http.StatusNotFound},
+	} {
+		request, err := http.NewRequest(test.method, h.server.URL+test.path, nil)
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+
+		request.Header.Set("Authorization", "Bearer admin-secret")
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s: %v", test.method, test.path, err)
+		}
+
+		var body struct {
+			Error string `json:"error"`
+		}
+
+		err = json.NewDecoder(response.Body).Decode(&body)
+		response.Body.Close()
+
+		if response.StatusCode != test.status || response.Header.Get("Allow") != test.allow || err != nil || body.Error == "" {
+			t.Errorf("%s %s returned %d, Allow %q and %+v (%v), want %d and Allow %q", test.method, test.path,
+				response.StatusCode, response.Header.Get("Allow"), body, err, test.status, test.allow)
+		}
+	}
+}
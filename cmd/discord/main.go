@@ -1,13 +1,18 @@
 package main
 
 import (
-	"database/sql"
+	"discord-go-connect/internal/api"
+	"discord-go-connect/internal/archive"
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/blob"
 	"discord-go-connect/internal/db"
 	"discord-go-connect/internal/discord"
+	"discord-go-connect/internal/media"
+	"discord-go-connect/internal/metrics"
+	"discord-go-connect/internal/store"
 	"discord-go-connect/internal/wshub"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
@@ -15,13 +20,22 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 )
 
 func main() {
-	hub := wshub.NewHub()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		}
+	}
+
 	dbManager, err := db.NewDBManager()
 
 	if err != nil {
@@ -31,138 +45,113 @@ func main() {
 
 	defer dbManager.Close()
 
-	go func() {
-		http.HandleFunc("/health", healthHandler)
-		http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			wshub.WSHandler(hub, w, r)
-		})
-
-		http.HandleFunc("/api/channel", func(w http.ResponseWriter, r *http.Request) {
-			selectMessages := `
-				SELECT 
-					Message.id, 
-					Message.channel_id, 
-					Message.guild_id,
-					Message.author_id,
-					Message.pinned,
-					Message.type AS message_type,
-					Message.content,
-					Message.timestamp AS message_timestamp,
-					Message.edited_timestamp,
-					Author.username,
-					Author.avatar,
-					Author.bot,
-					Member.nick,
-					Member.avatar 
-				FROM Message
-				JOIN Author ON Message.author_id = Author.id
-				JOIN Member ON Message.member_id = Member.id
-				WHERE Message.channel_id = ?
-				ORDER BY Message.timestamp DESC
-				LIMIT ? OFFSET ?;
-			`
-			w.Header().Set("Content-Type", "application/json")
-			if r.Method == "GET" {
-				channelID := r.URL.Query().Get("channelId")
-
-				page := r.URL.Query().Get("page")
-				pageSize := 20
-				pageNum, err := strconv.Atoi(page)
-
-				if err != nil {
-					http.Error(w, "Invalid page number", http.StatusBadRequest)
-					return
-				}
-
-				offset := (pageNum - 1) * pageSize
-				rows, err := dbManager.Query(selectMessages, channelID, pageSize+1, offset)
-				if err != nil {
-					log.Println("Failed to fetch messages:", err)
-					http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
-					return
-				}
-				defer rows.Close()
-
-				var messages []discordgo.Message
-				for rows.Next() {
-					var message discordgo.Message
-					message.Author = &discordgo.User{}
-					message.Member = &discordgo.Member{}
-					var timestamp string
-					var editedTimestamp sql.NullTime
-					err = rows.Scan(
-						&message.ID,
-						&message.ChannelID,
-						&message.GuildID,
-						&message.Author.ID,
-						&message.Pinned,
-						&message.Type,
-						&message.Content,
-						&timestamp,
-						&editedTimestamp,
-						&message.Author.Username,
-						&message.Author.Avatar,
-						&message.Author.Bot,
-						&message.Member.Nick,
-						&message.Member.Avatar,
-					)
-					if err != nil {
-						log.Println("Error scanning channel row:", err)
-						http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
-						return
-					}
-					layout := "2006-01-02 15:04:05.000"
-					message.Timestamp, _ = time.Parse(layout, timestamp)
-					if editedTimestamp.Valid {
-						message.EditedTimestamp = &editedTimestamp.Time
-					} else {
-						message.EditedTimestamp = nil
-					}
-					messages = append(messages, message)
-				}
-				if err = rows.Err(); err != nil {
-					log.Println("Error iterating over channels rows:", err)
-					http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
-					return
-				}
-
-				var cursorChan int
-				if len(messages) == pageSize+1 {
-					messages = messages[:len(messages)-1]
-					cursorChan = pageNum + 1
-				} else {
-					cursorChan = 0
-				}
-
-				type dunno struct {
-					Data       []discordgo.Message `json:"data"`
-					NextCursor int                 `json:"nextCursor"`
-				}
-				data := dunno{
-					Data:       messages,
-					NextCursor: cursorChan,
-				}
-
-				jsonBytes, err := json.Marshal(data)
-				if err != nil {
-					log.Println("Error marshaling channels to JSON:", err)
-					http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
-					return
-				}
-
-				w.Header().Set("Content-Type", "application/json")
-				if _, err := w.Write(jsonBytes); err != nil {
-					log.Println("api endpoint error:", err)
-				}
-			}
-		})
+	if err = dbManager.Migrate(); err != nil {
+		log.Fatal("Error migrating the database:", err)
+		return
+	}
+
+	err = godotenv.Load()
+	if err != nil {
+		log.Println("DISCORD_BOT_TOKEN missing")
+		return
+	}
+
+	keys, err := auth.NewKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		log.Fatal("Error reading API_KEYS:", err)
+		return
+	}
+
+	presenceConfig, err := discord.ParsePresenceConfig(os.Getenv("PRESENCE_GUILDS"))
+	if err != nil {
+		log.Fatal("Error reading PRESENCE_GUILDS:", err)
+		return
+	}
+
+	shardConfig, err := discord.ParseShardConfig(os.Getenv("SHARD_COUNT"), os.Getenv("SHARD_IDS"))
+	if err != nil {
+		log.Fatal("Error reading SHARD_COUNT or SHARD_IDS:", err)
+		return
+	}
+
+	// Processes running different shards of one bot share a hub and its secret.
+	if secret := os.Getenv("BOT_SECRET"); secret != "" {
+		keys.SetBotSecret(secret)
+	}
+
+	hub := wshub.NewHub(keys)
+	botToken := os.Getenv("DISCORD_BOT_TOKEN")
+	bot := discord.NewBot(botToken, dbManager, keys)
+	bot.TrackPresences(presenceConfig)
+	bot.ConfigureShards(shardConfig)
+	bot.AddCommand(bot.SearchCommand())
+
+	if hubURL := os.Getenv("HUB_URL"); hubURL != "" {
+		bot.UseHub(hubURL)
+	}
+
+	apiServer := api.NewServer(bot, dbManager, keys)
+
+	// Messages older than ARCHIVE_AFTER_DAYS move to the directory or S3 bucket at
+	// ARCHIVE_URL.
+	if location := os.Getenv("ARCHIVE_URL"); location != "" {
+		blobs, err := blob.Open(location)
+		if err != nil {
+			log.Fatal("Error opening ARCHIVE_URL:", err)
+			return
+		}
+
+		age, err := archive.ParseAge(os.Getenv("ARCHIVE_AFTER_DAYS"))
+		if err != nil {
+			log.Fatal("Error reading ARCHIVE_AFTER_DAYS:", err)
+			return
+		}
+
+		messages := archive.NewArchive(store.NewMySQL(dbManager), blobs)
+		bot.UseArchive(messages, age)
+		apiServer.UseArchive(messages)
+	}
+
+	// Attachments, and the avatars and emoji listed in MEDIA_EXTRAS, are mirrored to the
+	// directory or S3 bucket at MEDIA_URL.
+	if location := os.Getenv("MEDIA_URL"); location != "" {
+		blobs, err := blob.Open(location)
+		if err != nil {
+			log.Fatal("Error opening MEDIA_URL:", err)
+			return
+		}
 
+		options, err := media.ParseOptions(os.Getenv("MEDIA_MAX_MB"), os.Getenv("MEDIA_TYPES"), os.Getenv("MEDIA_EXTRAS"))
+		if err != nil {
+			log.Fatal("Error reading the media settings:", err)
+			return
+		}
+
+		mirror := media.NewMirror(store.NewMySQL(dbManager), blobs, options)
+		bot.UseMediaMirror(mirror)
+		apiServer.UseMediaMirror(mirror)
+	}
+
+	// Searches use the database's full-text index unless SEARCH_INDEX_FILE names the
+	// journal of an embedded one. It covers the messages stored while it is in use.
+	if path := os.Getenv("SEARCH_INDEX_FILE"); path != "" {
+		index, err := store.OpenFileIndex(path)
+		if err != nil {
+			log.Fatal("Error opening SEARCH_INDEX_FILE:", err)
+			return
+		}
+
+		defer index.Close()
+
+		bot.UseSearchIndex(index)
+	}
+
+	go func() {
 		log.Println("Starting WebSocket server on localhost:8080")
 
-		corsHandler := cors.Default().Handler(http.DefaultServeMux)
 		server := &http.Server{
 			Addr:              ":80",
-			Handler:           corsHandler,
+			Handler:           routes(bot, hub, apiServer),
 			ReadHeaderTimeout: 3 * time.Second,
 		}
 
@@ -176,15 +165,6 @@ func main() {
 
 	go hub.ListenToWSChannel()
 
-	err = godotenv.Load()
-	if err != nil {
-		log.Println("DISCORD_BOT_TOKEN missing")
-		return
-	}
-
-	botToken := os.Getenv("DISCORD_BOT_TOKEN")
-	bot := discord.NewBot(botToken, dbManager)
-
 	if err = bot.Start(); err != nil {
 		log.Println("Failed to start the bot:", err)
 		return
@@ -202,18 +182,50 @@ func main() {
 	}
 }
 
-func healthHandler(w http.ResponseWriter, _ *http.Request) {
-	healthResponse := struct {
-		Status string `json:"status"`
-	}{
-		Status: "healthy",
-	}
+// routes serves the REST API, the hub, health and metrics.
+func routes(bot *discord.Bot, hub *wshub.Hub, apiServer *api.Server) http.Handler {
+	mux := http.NewServeMux()
+
+	apiServer.Register(mux)
+	mux.HandleFunc("/health", healthHandler(bot))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		wshub.WSHandler(hub, w, r)
+	})
+
+	return cors.New(cors.Options{
+		AllowedMethods: []string{
+			http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead,
+		},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+	}).Handler(mux)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(healthResponse)
+func healthHandler(bot *discord.Bot) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		shards := bot.ShardStatuses()
+		status := "healthy"
 
-	if err != nil {
-		log.Printf("Health check encoding error: %v", err)
+		for _, shard := range shards {
+			if !shard.Connected {
+				status = "degraded"
+			}
+		}
+
+		healthResponse := struct {
+			Status string                `json:"status"`
+			Shards []discord.ShardStatus `json:"shards"`
+		}{
+			Status: status,
+			Shards: shards,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err := json.NewEncoder(w).Encode(healthResponse)
+
+		if err != nil {
+			log.Printf("Health check encoding error: %v", err)
+		}
 	}
 }
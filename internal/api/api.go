@@ -0,0 +1,170 @@
+package api
+
+import (
+	"discord-go-connect/internal/archive"
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/db"
+	"discord-go-connect/internal/discord"
+	"discord-go-connect/internal/export"
+	"discord-go-connect/internal/logger"
+	"discord-go-connect/internal/media"
+	"discord-go-connect/internal/store"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// Server exposes the bot over REST.
+type Server struct {
+	bot     *discord.Bot
+	store   store.Store
+	keys    *auth.Keys
+	exports *export.Jobs
+	archive *archive.Archive
+	media   *media.Mirror
+	logger  *logger.StandardLoggerHandler
+}
+
+// authenticatedHandler is a handler that runs with the grant of the caller's API key.
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, grant *auth.Grant)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewServer(bot *discord.Bot, dbManager *db.Manager, keys *auth.Keys) *Server {
+	history := store.NewMySQL(dbManager)
+
+	return &Server{
+		bot:     bot,
+		store:   history,
+		keys:    keys,
+		exports: export.NewJobs(export.NewExporter(history), export.DefaultDir()),
+		logger:  logger.NewLogger(os.Stderr),
+	}
+}
+
+// UseStore replaces the MySQL store the stored history is read from.
+func (s *Server) UseStore(history store.Store) {
+	s.store = history
+	s.exports = export.NewJobs(export.NewExporter(history), s.exports.Dir())
+}
+
+// UseExportDir replaces the directory finished exports are kept in.
+func (s *Server) UseExportDir(dir string) {
+	s.exports = export.NewJobs(export.NewExporter(s.store), dir)
+}
+
+// UseArchive continues channel history into the archive once the stored messages run out.
+func (s *Server) UseArchive(messages *archive.Archive) {
+	s.archive = messages
+}
+
+// UseMediaMirror serves the files mirrored by mirror under /media/ and points the
+// attachments of messages at them.
+func (s *Server) UseMediaMirror(mirror *media.Mirror) {
+	s.media = mirror
+}
+
+// Register adds the REST endpoints to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	routes := newRouter(s.writeError)
+	get := func(pattern string, handler authenticatedHandler) {
+		routes.handle(http.MethodGet, pattern, s.authenticate(handler))
+	}
+
+	get("/api/guilds", s.handleGuilds)
+	get("/api/guilds/{guild}", s.guildRoute(s.writeGuild))
+	get("/api/guilds/{guild}/channels", s.guildRoute(s.writeGuildChannels))
+	get("/api/guilds/{guild}/members", s.guildRoute(s.writeMembers))
+	get("/api/guilds/{guild}/roles", s.guildRoute(s.writeRoles))
+	get("/api/guilds/{guild}/voice", s.guildRoute(s.writeVoiceOccupancy))
+	get("/api/guilds/{guild}/voice/report", s.guildRoute(s.writeVoiceReport))
+	get("/api/guilds/{guild}/presences", s.guildRoute(s.writePresences))
+
+	get("/api/channel", s.handleChannel)
+	get("/api/channels/{channel}", s.handleChannelInfo)
+	get("/api/channels/{channel}/messages", s.handleChannelHistory)
+	routes.handle(http.MethodPatch, "/api/channels/{channel}/messages/{message}", s.authenticate(s.handleEditMessage))
+	routes.handle(http.MethodDelete, "/api/channels/{channel}/messages/{message}", s.authenticate(s.handleDeleteMessage))
+	routes.handle(http.MethodPut, "/api/channels/{channel}/pins/{message}", s.authenticate(s.handlePin))
+	routes.handle(http.MethodDelete, "/api/channels/{channel}/pins/{message}", s.authenticate(s.handlePin))
+	routes.handle(http.MethodPut, "/api/channels/{channel}/messages/{message}/reactions/{emoji}", s.authenticate(s.handleReaction))
+	routes.handle(http.MethodDelete, "/api/channels/{channel}/messages/{message}/reactions/{emoji}", s.authenticate(s.handleReaction))
+
+	get("/api/threads", s.handleThreads)
+	get("/api/threads/{thread}/messages", s.handleThreadMessages)
+	get("/api/dms", s.handleDMs)
+	get("/api/dms/{channel}/messages", s.handleDMMessages)
+
+	routes.handle(http.MethodPost, "/api/messages", s.authenticate(s.handleSendMessage))
+	get("/api/messages/{message}", s.handleMessage)
+	get("/api/users/{user}", s.handleUser)
+	get("/api/reactions/top", s.handleTopReactions)
+	get("/api/search", s.handleSearch)
+
+	routes.handle(http.MethodPost, "/api/exports", s.authenticate(s.handleExports))
+	get("/api/exports/{export}", s.handleExport)
+	get("/api/exports/{export}/download", s.handleExportDownload)
+
+	get("/api/retention", s.handleRetention)
+	routes.handle(http.MethodPut, "/api/retention", s.authenticate(s.handleRetention))
+	routes.handle(http.MethodDelete, "/api/retention", s.authenticate(s.handleRetention))
+
+	get("/media/{name...}", s.handleMedia)
+	routes.handle(http.MethodHead, "/media/{name...}", s.authenticate(s.handleMedia))
+
+	mux.Handle("/api/", routes)
+	mux.Handle("/media/", routes)
+}
+
+func (s *Server) authenticate(next authenticatedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grant, ok := s.keys.FromRequest(r)
+		if !ok {
+			s.writeError(w, http.StatusUnauthorized, "invalid or missing API key")
+			return
+		}
+
+		next(w, r, grant)
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Error("api endpoint error: %v", err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
+	s.writeJSON(w, status, errorResponse{Error: message})
+}
+
+// writeStoreError answers with 404 for records the store doesn't have and with 500,
+// naming what failed to load, for other errors.
+func (s *Server) writeStoreError(w http.ResponseWriter, err error, what string) {
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.logger.Error("failed to fetch %s: %v", what, err)
+	s.writeError(w, http.StatusInternalServerError, "failed to fetch "+what)
+}
+
+// writeBotError answers with the status matching an error returned by the bot.
+func (s *Server) writeBotError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, discord.ErrForbidden):
+		s.writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, discord.ErrNotFound):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	default:
+		s.logger.Error("bot request failed: %v", err)
+		s.writeError(w, http.StatusBadGateway, err.Error())
+	}
+}
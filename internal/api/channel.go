@@ -0,0 +1,224 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/store"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type messagePage struct {
+	// PrunedBefore is set when retention rules pruned the channel: nothing older is stored.
+	PrunedBefore *time.Time          `json:"prunedBefore,omitempty"`
+	Data         []discordgo.Message `json:"data"`
+	NextCursor   int                 `json:"nextCursor"`
+}
+
+type channel struct {
+	ID       string `json:"id"`
+	GuildID  string `json:"guild_id"`
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+	Type     int    `json:"type"`
+	Position int    `json:"position"`
+	NSFW     bool   `json:"nsfw"`
+}
+
+func newChannel(stored *discordgo.Channel) channel {
+	return channel{
+		ID: stored.ID, GuildID: stored.GuildID, ParentID: stored.ParentID, Name: stored.Name,
+		Type: int(stored.Type), Position: stored.Position, NSFW: stored.NSFW,
+	}
+}
+
+// handleChannel pages through the stored history of a channel, newest first, with
+// GET /api/channel?channelId=&page=. /api/channels/{id}/messages does the same.
+func (s *Server) handleChannel(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	channelID := r.URL.Query().Get("channelId")
+
+	if !s.canAccessChannel(grant, channelID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	s.writeMessagePage(w, channelID, r.URL.Query().Get("page"))
+}
+
+// handleChannelInfo answers GET /api/channels/{id} with a stored channel.
+func (s *Server) handleChannelInfo(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	channelID := pathValue(r, "channel")
+
+	if !s.canAccessChannel(grant, channelID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	stored, err := s.store.Channel(channelID)
+	if err != nil {
+		s.writeStoreError(w, err, "channel")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, newChannel(stored))
+}
+
+// handleChannelHistory pages through a channel's history with
+// GET /api/channels/{id}/messages?page=.
+func (s *Server) handleChannelHistory(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	channelID := pathValue(r, "channel")
+
+	if !s.canAccessChannel(grant, channelID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	s.writeMessagePage(w, channelID, r.URL.Query().Get("page"))
+}
+
+// writeMessagePage writes one page of a channel's or a thread's history. Pages count
+// from 1, which is also the page when none is given.
+func (s *Server) writeMessagePage(w http.ResponseWriter, channelID, page string) {
+	pageSize := 20
+	pageNum := 1
+
+	if page != "" {
+		var err error
+		if pageNum, err = strconv.Atoi(page); err != nil || pageNum < 1 {
+			s.writeError(w, http.StatusBadRequest, "invalid page number")
+			return
+		}
+	}
+
+	offset := (pageNum - 1) * pageSize
+
+	messages, err := s.store.ChannelMessages(channelID, pageSize+1, offset)
+	if err != nil {
+		s.logger.Error("Failed to fetch messages: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch messages")
+
+		return
+	}
+
+	if len(messages) <= pageSize && s.archive != nil {
+		messages, err = s.continueIntoArchive(channelID, messages, pageSize+1, offset)
+		if err != nil {
+			s.logger.Error("Failed to fetch archived messages: %v", err)
+			s.writeError(w, http.StatusInternalServerError, "failed to fetch messages")
+
+			return
+		}
+	}
+
+	var cursorChan int
+	if len(messages) == pageSize+1 {
+		messages = messages[:len(messages)-1]
+		cursorChan = pageNum + 1
+	} else {
+		cursorChan = 0
+	}
+
+	if err = s.attachReactions(messages); err != nil {
+		s.logger.Error("Failed to fetch reactions: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch messages")
+
+		return
+	}
+
+	if err = s.attachMedia(messages); err != nil {
+		s.logger.Error("Failed to fetch mirrored media: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch messages")
+
+		return
+	}
+
+	result := messagePage{Data: messages, NextCursor: cursorChan}
+
+	pruned, err := s.store.PrunedHistory(channelID)
+	if err == nil {
+		result.PrunedBefore = &pruned.Before
+	} else if !errors.Is(err, store.ErrNotFound) {
+		s.logger.Error("Failed to fetch pruned history: %v", err)
+	}
+
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+// continueIntoArchive fills up a page that reached the end of the stored messages with
+// archived ones, which are all older.
+func (s *Server) continueIntoArchive(channelID string, messages []discordgo.Message, limit, offset int) ([]discordgo.Message, error) {
+	archiveOffset := 0
+
+	// Past the stored messages, the offset counts from the first archived one.
+	if len(messages) == 0 && offset > 0 {
+		stored, err := s.store.ChannelMessageCount(channelID)
+		if err != nil {
+			return nil, err
+		}
+
+		if offset > stored {
+			archiveOffset = offset - stored
+		}
+	}
+
+	archived, err := s.archive.ChannelMessages(channelID, limit-len(messages), archiveOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retention rules prune the archive after the store, so skip what they already pruned.
+	pruned, err := s.store.PrunedHistory(channelID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	if pruned != nil {
+		for i := range archived {
+			if archived[i].Timestamp.Before(pruned.Before) {
+				archived = archived[:i]
+				break
+			}
+		}
+	}
+
+	return append(messages, archived...), nil
+}
+
+func (s *Server) canAccessChannel(grant *auth.Grant, channelID string) bool {
+	if grant.Admin() {
+		return true
+	}
+
+	guildID, err := s.store.ChannelGuild(channelID)
+	if err != nil {
+		return false
+	}
+
+	return grant.CanAccessGuild(guildID)
+}
+
+// attachReactions fills in the reaction counts of a page of messages.
+func (s *Server) attachReactions(messages []discordgo.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	for i := range messages {
+		ids = append(ids, messages[i].ID)
+	}
+
+	counts, err := s.store.ReactionCounts(ids, s.bot.UserID())
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = append(messages[i].Reactions, counts[messages[i].ID]...)
+	}
+
+	return nil
+}
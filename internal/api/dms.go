@@ -0,0 +1,29 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"net/http"
+)
+
+// handleDMs lists the bot's DM inbox with GET /api/dms. Both DM endpoints need an admin key.
+func (s *Server) handleDMs(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	channels, err := s.bot.DMChannels(grant)
+	if err != nil {
+		s.writeBotError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, channels)
+}
+
+// handleDMMessages pages through a DM conversation with GET /api/dms/{id}/messages?page=.
+func (s *Server) handleDMMessages(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	channelID := pathValue(r, "channel")
+
+	if !grant.Admin() {
+		s.writeError(w, http.StatusForbidden, "DMs need an admin key")
+		return
+	}
+
+	s.writeMessagePage(w, channelID, r.URL.Query().Get("page"))
+}
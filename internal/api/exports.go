@@ -0,0 +1,135 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/export"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+type exportRequest struct {
+	Format    string `json:"format"`
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	After     string `json:"after"`
+	Before    string `json:"before"`
+}
+
+// handleExports starts an export of a channel or a guild: POST /api/exports with
+// {"format", "channel_id" or "guild_id", "after", "before"}. format is ndjson (the
+// default), csv, text or html. The export runs in the background; the response is the
+// job, whose Location can be polled. A key with too many exports in progress gets a 429.
+func (s *Server) handleExports(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	var body exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	request, err := parseExportRequest(&body)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.ChannelID != "" && !s.canAccessChannel(grant, request.ChannelID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	if request.ChannelID == "" && !grant.CanAccessGuild(request.GuildID) {
+		s.writeError(w, http.StatusForbidden, "no access to this guild")
+		return
+	}
+
+	job, err := s.exports.Start(grant.Name, *request)
+
+	switch {
+	case errors.Is(err, export.ErrTooManyJobs):
+		s.writeError(w, http.StatusTooManyRequests, "too many exports in progress, wait for one to finish")
+		return
+	case err != nil:
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/api/exports/"+job.ID)
+	s.writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleExport reports on an export job with GET /api/exports/{id}. Jobs are only
+// visible to the key that started them and to admin keys.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	job, err := s.exports.Get(pathValue(r, "export"))
+	if err != nil || (!grant.Admin() && job.Owner != grant.Name) {
+		s.writeError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+// handleExportDownload downloads the file of a finished export job with
+// GET /api/exports/{id}/download.
+func (s *Server) handleExportDownload(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	id := pathValue(r, "export")
+
+	job, err := s.exports.Get(id)
+	if err != nil || (!grant.Admin() && job.Owner != grant.Name) {
+		s.writeError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	file, job, err := s.exports.Open(id)
+
+	switch {
+	case errors.Is(err, export.ErrJobNotDone):
+		s.writeError(w, http.StatusConflict, "export is "+string(job.Status))
+		return
+	case errors.Is(err, export.ErrJobNotFound):
+		s.writeError(w, http.StatusNotFound, "export not found")
+		return
+	case err != nil:
+		s.logger.Error("failed to open export: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to open export")
+
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", job.Request.Format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": job.Request.Name(),
+	}))
+
+	http.ServeContent(w, r, job.Request.Name(), *job.FinishedAt, file)
+}
+
+func parseExportRequest(body *exportRequest) (*export.Request, error) {
+	if body.Format == "" {
+		body.Format = string(export.NDJSON)
+	}
+
+	format, err := export.ParseFormat(body.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &export.Request{Format: format, GuildID: body.GuildID, ChannelID: body.ChannelID}
+
+	if request.After, err = export.ParseDate(body.After); err != nil {
+		return nil, err
+	}
+
+	if request.Before, err = export.ParseDate(body.Before); err != nil {
+		return nil, err
+	}
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"net/http"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	defaultMemberPage = 100
+	maxMemberPage     = 1000
+)
+
+type guild struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Icon    string `json:"icon"`
+	Region  string `json:"region"`
+	OwnerID string `json:"owner_id"`
+}
+
+func newGuild(stored *discordgo.Guild) guild {
+	return guild{ID: stored.ID, Name: stored.Name, Icon: stored.Icon, Region: stored.Region, OwnerID: stored.OwnerID}
+}
+
+type role struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Permissions string `json:"permissions"`
+	Color       int    `json:"color"`
+	Position    int    `json:"position"`
+	Hoist       bool   `json:"hoist"`
+	Mentionable bool   `json:"mentionable"`
+	Managed     bool   `json:"managed"`
+}
+
+// handleGuilds lists the stored guilds the caller's key can access with GET /api/guilds.
+func (s *Server) handleGuilds(w http.ResponseWriter, _ *http.Request, grant *auth.Grant) {
+	stored, err := s.store.Guilds()
+	if err != nil {
+		s.writeStoreError(w, err, "guilds")
+		return
+	}
+
+	guilds := make([]guild, 0, len(stored))
+
+	for i := range stored {
+		if grant.CanAccessGuild(stored[i].ID) {
+			guilds = append(guilds, newGuild(&stored[i]))
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, guilds)
+}
+
+// guildRoute checks that the caller's key can access the guild of a /api/guilds/{guild}
+// route before handing it to next.
+func (s *Server) guildRoute(next func(w http.ResponseWriter, r *http.Request, guildID string)) authenticatedHandler {
+	return func(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+		guildID := pathValue(r, "guild")
+
+		if !grant.CanAccessGuild(guildID) {
+			s.writeError(w, http.StatusForbidden, "no access to this guild")
+			return
+		}
+
+		next(w, r, guildID)
+	}
+}
+
+// writeGuild answers GET /api/guilds/{id}.
+func (s *Server) writeGuild(w http.ResponseWriter, _ *http.Request, guildID string) {
+	stored, err := s.store.Guild(guildID)
+	if err != nil {
+		s.writeStoreError(w, err, "guild")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, newGuild(stored))
+}
+
+// writeGuildChannels answers GET /api/guilds/{id}/channels with the guild's channels in
+// sidebar order. Threads are listed by /api/threads.
+func (s *Server) writeGuildChannels(w http.ResponseWriter, _ *http.Request, guildID string) {
+	if _, err := s.store.Guild(guildID); err != nil {
+		s.writeStoreError(w, err, "guild")
+		return
+	}
+
+	stored, err := s.store.GuildChannels(guildID)
+	if err != nil {
+		s.writeStoreError(w, err, "channels")
+		return
+	}
+
+	channels := make([]channel, 0, len(stored))
+	for i := range stored {
+		channels = append(channels, newChannel(&stored[i]))
+	}
+
+	s.writeJSON(w, http.StatusOK, channels)
+}
+
+// writePresences answers GET /api/guilds/{id}/presences.
+func (s *Server) writePresences(w http.ResponseWriter, _ *http.Request, guildID string) {
+	presences, ok := s.bot.Presences(guildID)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "presences are not tracked in this guild")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, presences)
+}
+
+// writeMembers answers GET /api/guilds/{id}/members?after=&limit=, a page of members
+// ordered by user ID.
+func (s *Server) writeMembers(w http.ResponseWriter, r *http.Request, guildID string) {
+	limit := defaultMemberPage
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			s.writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+
+		if parsed < maxMemberPage {
+			limit = parsed
+		} else {
+			limit = maxMemberPage
+		}
+	}
+
+	members, err := s.store.Members(guildID, r.URL.Query().Get("after"), limit)
+	if err != nil {
+		s.logger.Error("failed to fetch members: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch members")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, members)
+}
+
+// writeRoles answers GET /api/guilds/{id}/roles, highest first.
+func (s *Server) writeRoles(w http.ResponseWriter, _ *http.Request, guildID string) {
+	stored, err := s.store.Roles(guildID)
+	if err != nil {
+		s.logger.Error("failed to fetch roles: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch roles")
+
+		return
+	}
+
+	roles := make([]role, 0, len(stored))
+
+	for _, r := range stored {
+		roles = append(roles, role{
+			ID:   r.ID,
+			Name: r.Name,
+			// Permissions are a 64 bit field, which JavaScript numbers cannot hold.
+			Permissions: strconv.FormatInt(r.Permissions, 10),
+			Color:       r.Color,
+			Position:    r.Position,
+			Hoist:       r.Hoist,
+			Mentionable: r.Mentionable,
+			Managed:     r.Managed,
+		})
+	}
+
+	s.writeJSON(w, http.StatusOK, roles)
+}
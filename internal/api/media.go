@@ -0,0 +1,88 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/store"
+	"errors"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleMedia serves mirrored files by hash, GET /media/{hash}, or by what they were
+// mirrored from: /media/attachment/{id}, /media/avatar/{user_id}/{avatar} and
+// /media/emoji/{id}. Range requests get the part asked for. Every API key can read
+// media by hash, since keys only learn the hashes from messages they can read, but only
+// looks up sources of the guilds it covers.
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	if s.media == nil {
+		s.writeError(w, http.StatusNotFound, "media mirroring is disabled")
+		return
+	}
+
+	file, object, err := s.media.Open(pathValue(r, "name"), grant.CanAccessGuild)
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("Failed to open media: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to open media")
+
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("ETag", `"`+object.Hash+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	// The files come from users, so the browser may neither sniff them into something
+	// else nor run scripts in them on this origin.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+	http.ServeContent(w, r, "", object.CreatedAt, file)
+}
+
+// attachMedia points the proxy URLs of mirrored attachments at the mirror.
+func (s *Server) attachMedia(messages []discordgo.Message) error {
+	if s.media == nil {
+		return nil
+	}
+
+	sources := make([]string, 0)
+
+	for _, message := range messages {
+		for _, attachment := range message.Attachments {
+			sources = append(sources, "attachment/"+attachment.ID)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	mirrored, err := s.store.MirroredMedia(sources)
+	if err != nil {
+		return err
+	}
+
+	// The attachments are copied, since messages may share them with a cache.
+	for i := range messages {
+		attachments := make([]*discordgo.MessageAttachment, 0, len(messages[i].Attachments))
+
+		for _, attachment := range messages[i].Attachments {
+			copied := *attachment
+			if object, ok := mirrored["attachment/"+attachment.ID]; ok {
+				copied.ProxyURL = "/media/" + object.Hash
+			}
+
+			attachments = append(attachments, &copied)
+		}
+
+		messages[i].Attachments = attachments
+	}
+
+	return nil
+}
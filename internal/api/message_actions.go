@@ -0,0 +1,81 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/discord"
+	"encoding/json"
+	"net/http"
+)
+
+// The message actions mirror Discord's own routes:
+//
+//	PATCH  /api/channels/{channelID}/messages/{messageID}
+//	DELETE /api/channels/{channelID}/messages/{messageID}
+//	PUT    /api/channels/{channelID}/pins/{messageID}
+//	DELETE /api/channels/{channelID}/pins/{messageID}
+//	PUT    /api/channels/{channelID}/messages/{messageID}/reactions/{emoji}
+//	DELETE /api/channels/{channelID}/messages/{messageID}/reactions/{emoji}
+
+// handleEditMessage edits a message of the bot.
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	result := messageActionResult(r, "edit_message")
+
+	var edit discord.MessageEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	var err error
+
+	result.Message, err = s.bot.EditMessage(grant, result.ChannelID, result.MessageID, &edit)
+	s.writeMessageAction(w, result, err)
+}
+
+// handleDeleteMessage deletes a message.
+func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	result := messageActionResult(r, "delete_message")
+	s.writeMessageAction(w, result, s.bot.DeleteMessage(grant, result.ChannelID, result.MessageID))
+}
+
+// handlePin pins a message with PUT and unpins it with DELETE.
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	pin := r.Method == http.MethodPut
+
+	result := messageActionResult(r, "unpin_message")
+	if pin {
+		result.Action = "pin_message"
+	}
+
+	s.writeMessageAction(w, result, s.bot.PinMessage(grant, result.ChannelID, result.MessageID, pin))
+}
+
+// handleReaction adds the bot's reaction with PUT and removes it with DELETE.
+func (s *Server) handleReaction(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	add := r.Method == http.MethodPut
+
+	result := messageActionResult(r, "remove_reaction")
+	if add {
+		result.Action = "add_reaction"
+	}
+
+	result.Emoji = pathValue(r, "emoji")
+
+	s.writeMessageAction(w, result, s.bot.ReactToMessage(grant, result.ChannelID, result.MessageID, result.Emoji, add))
+}
+
+func messageActionResult(r *http.Request, action string) discord.MessageActionResult {
+	return discord.MessageActionResult{
+		Action: action, ChannelID: pathValue(r, "channel"), MessageID: pathValue(r, "message"),
+	}
+}
+
+func (s *Server) writeMessageAction(w http.ResponseWriter, result discord.MessageActionResult, err error) {
+	if err != nil {
+		s.writeBotError(w, err)
+		return
+	}
+
+	result.OK = true
+	s.writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/discord"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxUploadSize bounds multipart requests to Discord's default attachment limit.
+const maxUploadSize = 25 << 20
+
+// sendMessageRequest targets either a channel or, for DMs, a user.
+type sendMessageRequest struct {
+	discord.OutboundMessage
+	ChannelID string `json:"channel_id"`
+	UserID    string `json:"user_id"`
+}
+
+type sendMessageResponse struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// handleSendMessage sends a message as the bot. The body is either JSON, with files
+// base64 encoded, or multipart/form-data with the JSON in a payload_json field and
+// the files in files[n] parts, the same layout Discord uses.
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	request, err := decodeSendMessageRequest(r)
+	defer closeUploads(r, request)
+
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var message *discordgo.Message
+
+	switch {
+	case request.ChannelID != "":
+		message, err = s.bot.SendMessage(grant, request.ChannelID, &request.OutboundMessage)
+	case request.UserID != "":
+		message, err = s.bot.SendDM(grant, request.UserID, &request.OutboundMessage)
+	default:
+		s.writeError(w, http.StatusBadRequest, "channel_id or user_id is required")
+		return
+	}
+
+	if err != nil {
+		s.writeBotError(w, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, sendMessageResponse{ID: message.ID, ChannelID: message.ChannelID})
+}
+
+func decodeSendMessageRequest(r *http.Request) (*sendMessageRequest, error) {
+	var request sendMessageRequest
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+
+		return &request, nil
+	}
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		return nil, fmt.Errorf("invalid multipart body: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(r.FormValue("payload_json")), &request); err != nil {
+		return nil, fmt.Errorf("invalid payload_json: %w", err)
+	}
+
+	for i := 0; ; i++ {
+		files := r.MultipartForm.File[fmt.Sprintf("files[%d]", i)]
+		if len(files) == 0 {
+			break
+		}
+
+		file, err := files[0].Open()
+		if err != nil {
+			return &request, fmt.Errorf("failed to read files[%d]: %w", i, err)
+		}
+
+		request.Files = append(request.Files, &discord.OutboundFile{
+			Name:        files[0].Filename,
+			ContentType: files[0].Header.Get("Content-Type"),
+			Reader:      file,
+		})
+	}
+
+	return &request, nil
+}
+
+func closeUploads(r *http.Request, request *sendMessageRequest) {
+	if request != nil {
+		for _, file := range request.Files {
+			if closer, ok := file.Reader.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}
+
+	if r.MultipartForm != nil {
+		if err := r.MultipartForm.RemoveAll(); err != nil {
+			return
+		}
+	}
+}
+
+// handleMessage answers GET /api/messages/{id} with a stored message, with its reactions
+// and mirrored attachments like the pages of history.
+func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	stored, err := s.store.Message(pathValue(r, "message"))
+	if err != nil {
+		s.writeStoreError(w, err, "message")
+		return
+	}
+
+	if !s.canAccessChannel(grant, stored.ChannelID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	messages := []discordgo.Message{*stored}
+
+	if err := s.attachReactions(messages); err != nil {
+		s.logger.Error("Failed to fetch reactions: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch message")
+
+		return
+	}
+
+	if err := s.attachMedia(messages); err != nil {
+		s.logger.Error("Failed to fetch mirrored media: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch message")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, messages[0])
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"net/http"
+	"strconv"
+)
+
+const maxTopReactionsLimit = 100
+
+// handleTopReactions lists the most reacted messages of a channel or a guild.
+func (s *Server) handleTopReactions(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	query := r.URL.Query()
+	guildID, channelID := query.Get("guildId"), query.Get("channelId")
+
+	if guildID != "" {
+		if !grant.CanAccessGuild(guildID) {
+			s.writeError(w, http.StatusForbidden, "no access to this guild")
+			return
+		}
+	} else if channelID == "" {
+		s.writeError(w, http.StatusBadRequest, "channelId or guildId is required")
+		return
+	} else if !s.canAccessChannel(grant, channelID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 || limit > maxTopReactionsLimit {
+		limit = 10
+	}
+
+	messages, err := s.store.TopReacted(guildID, channelID, limit)
+	if err != nil {
+		s.logger.Error("failed to fetch top reactions: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch reactions")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, messages)
+}
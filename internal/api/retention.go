@@ -0,0 +1,89 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/store"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// handleRetention manages the retention rules, which only admin keys may do:
+//
+//	GET /api/retention lists the rules.
+//	PUT /api/retention with {"guild_id" or "channel_id", "mode", "amount"} sets one.
+//	DELETE /api/retention?guildId=&channelId= removes one.
+//
+// mode is forever, days or messages; amount is the number of days or messages kept.
+func (s *Server) handleRetention(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	if !grant.Admin() {
+		s.writeError(w, http.StatusForbidden, "retention rules need an admin key")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := s.store.RetentionRules()
+		if err != nil {
+			s.logger.Error("Failed to fetch retention rules: %v", err)
+			s.writeError(w, http.StatusInternalServerError, "failed to fetch retention rules")
+
+			return
+		}
+
+		s.writeJSON(w, http.StatusOK, rules)
+	case http.MethodPut:
+		s.saveRetentionRule(w, r)
+	case http.MethodDelete:
+		query := r.URL.Query()
+
+		err := s.store.DeleteRetentionRule(query.Get("guildId"), query.Get("channelId"))
+		if errors.Is(err, store.ErrNotFound) {
+			s.writeError(w, http.StatusNotFound, "no such retention rule")
+			return
+		}
+
+		if err != nil {
+			s.logger.Error("Failed to delete retention rule: %v", err)
+			s.writeError(w, http.StatusInternalServerError, "failed to delete retention rule")
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// saveRetentionRule adds or replaces a rule. The guild of a channel rule is taken from
+// the store when it knows the channel, so the rule follows the channel's guild; rules
+// for unknown channels need a guild_id.
+func (s *Server) saveRetentionRule(w http.ResponseWriter, r *http.Request) {
+	var rule store.RetentionRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if rule.ChannelID != "" {
+		if guildID, err := s.store.ChannelGuild(rule.ChannelID); err == nil {
+			rule.GuildID = guildID
+		}
+	}
+
+	if err := rule.Validate(); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rule.UpdatedAt = time.Now().UTC()
+
+	if err := s.store.SaveRetentionRule(&rule); err != nil {
+		s.logger.Error("Failed to save retention rule: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to save retention rule")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, rule)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// router dispatches requests by method and path. Pattern segments in braces, such as
+// {guild}, match any one non-empty path segment, and a last segment such as {name...}
+// matches the rest of the path. Paths that match no route get a 404, and paths that
+// only match routes of other methods a 405 listing them, both as JSON errors.
+type router struct {
+	routes     []route
+	writeError func(w http.ResponseWriter, status int, message string)
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  http.HandlerFunc
+}
+
+// pathValues holds the segments matched by the braces of a route, unescaped.
+type pathValues map[string]string
+
+type pathValuesKey struct{}
+
+func newRouter(writeError func(w http.ResponseWriter, status int, message string)) *router {
+	return &router{writeError: writeError}
+}
+
+// handle adds a route. Routes are tried in the order they were added.
+func (rt *router) handle(method, pattern string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, route{method: method, segments: splitPath(pattern), handler: handler})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.EscapedPath())
+	allowed := make([]string, 0)
+
+	for _, route := range rt.routes {
+		values, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+
+		route.handler(w, r.WithContext(context.WithValue(r.Context(), pathValuesKey{}, values)))
+
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		rt.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	rt.writeError(w, http.StatusNotFound, "not found")
+}
+
+// match reports whether the escaped segments of a path match the route and returns the
+// values of its braces.
+func (rt *route) match(segments []string) (pathValues, bool) {
+	values := make(pathValues)
+
+	for i, pattern := range rt.segments {
+		if name, ok := strings.CutSuffix(pattern, "...}"); ok && i == len(rt.segments)-1 {
+			if i >= len(segments) {
+				return nil, false
+			}
+
+			value, err := url.PathUnescape(strings.Join(segments[i:], "/"))
+			if err != nil || value == "" {
+				return nil, false
+			}
+
+			values[strings.TrimPrefix(name, "{")] = value
+
+			return values, true
+		}
+
+		if i >= len(segments) {
+			return nil, false
+		}
+
+		if !strings.HasPrefix(pattern, "{") {
+			if pattern != segments[i] {
+				return nil, false
+			}
+
+			continue
+		}
+
+		value, err := url.PathUnescape(segments[i])
+		if err != nil || value == "" {
+			return nil, false
+		}
+
+		values[strings.Trim(pattern, "{}")] = value
+	}
+
+	return values, len(segments) == len(rt.segments)
+}
+
+// pathValue returns the path segment matched by the braces of a route, such as "guild"
+// for {guild}.
+func pathValue(r *http.Request, name string) string {
+	values, _ := r.Context().Value(pathValuesKey{}).(pathValues)
+	return values[name]
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
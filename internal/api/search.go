@@ -0,0 +1,94 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/discord"
+	"discord-go-connect/internal/store"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleSearch searches the stored messages: GET /api/search?q=&guildId=&channelId=
+// &authorId=&mentions=&after=&before=&has=attachment|link&pinned=true|false&page=. Dates
+// are RFC 3339 timestamps or plain dates. q may use the filters of the query language,
+// which take precedence over the parameters.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	params := r.URL.Query()
+	query := store.SearchQuery{
+		Text:          params.Get("q"),
+		GuildID:       params.Get("guildId"),
+		ChannelID:     params.Get("channelId"),
+		AuthorID:      params.Get("authorId"),
+		Mentions:      params.Get("mentions"),
+		HasAttachment: params.Get("has") == "attachment",
+		HasLink:       params.Get("has") == "link",
+	}
+
+	if pinned := params.Get("pinned"); pinned != "" {
+		value := pinned == "true"
+		query.Pinned = &value
+	}
+
+	var err error
+
+	if query.After, err = parseSearchDate(params.Get("after")); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if query.Before, err = parseSearchDate(params.Get("before")); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page := 1
+
+	if raw := params.Get("page"); raw != "" {
+		if page, err = strconv.Atoi(raw); err != nil || page < 1 {
+			s.writeError(w, http.StatusBadRequest, "invalid page number")
+			return
+		}
+	}
+
+	result, err := s.bot.Search(grant, query, page)
+
+	var queryErr *store.QueryError
+	if errors.As(err, &queryErr) {
+		s.writeError(w, http.StatusBadRequest, "invalid query: "+queryErr.Error())
+		return
+	}
+
+	if errors.Is(err, discord.ErrForbidden) || errors.Is(err, discord.ErrNotFound) {
+		s.writeBotError(w, err)
+		return
+	}
+
+	if err != nil {
+		s.logger.Error("failed to search messages: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to search messages")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, result)
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	return date, nil
+}
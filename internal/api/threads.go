@@ -0,0 +1,50 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"net/http"
+)
+
+// handleThreads lists the threads of a channel or forum with GET /api/threads?parentId=
+// and an optional archived=true|false filter.
+func (s *Server) handleThreads(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	parentID := r.URL.Query().Get("parentId")
+
+	var archived *bool
+	if value := r.URL.Query().Get("archived"); value != "" {
+		archivedOnly := value == "true"
+		archived = &archivedOnly
+	}
+
+	if parentID == "" {
+		s.writeError(w, http.StatusBadRequest, "parentId is required")
+		return
+	}
+
+	if !s.canAccessChannel(grant, parentID) {
+		s.writeError(w, http.StatusForbidden, "no access to this channel")
+		return
+	}
+
+	threads, err := s.store.Threads(parentID, archived)
+	if err != nil {
+		s.logger.Error("failed to fetch threads: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch threads")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, threads)
+}
+
+// handleThreadMessages pages through a thread's history with GET /api/threads/{id}/messages?page=.
+func (s *Server) handleThreadMessages(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	threadID := pathValue(r, "thread")
+
+	if !s.canAccessChannel(grant, threadID) {
+		s.writeError(w, http.StatusForbidden, "no access to this thread")
+		return
+	}
+
+	s.writeMessagePage(w, threadID, r.URL.Query().Get("page"))
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"discord-go-connect/internal/auth"
+	"net/http"
+)
+
+type user struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+	// Guilds are the guilds the user is a member of that the caller's key can access.
+	Guilds []string `json:"guilds"`
+	Bot    bool     `json:"bot"`
+	System bool     `json:"system"`
+}
+
+// handleUser answers GET /api/users/{id} with a stored message author. Keys limited to
+// some guilds only find the members of those guilds.
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request, grant *auth.Grant) {
+	stored, err := s.store.User(pathValue(r, "user"))
+	if err != nil {
+		s.writeStoreError(w, err, "user")
+		return
+	}
+
+	memberOf, err := s.store.UserGuilds(stored.ID)
+	if err != nil {
+		s.writeStoreError(w, err, "user")
+		return
+	}
+
+	guilds := make([]string, 0, len(memberOf))
+
+	for _, guildID := range memberOf {
+		if grant.CanAccessGuild(guildID) {
+			guilds = append(guilds, guildID)
+		}
+	}
+
+	if len(guilds) == 0 && !grant.Admin() {
+		s.writeError(w, http.StatusNotFound, "not found: user "+stored.ID)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, user{
+		ID: stored.ID, Username: stored.Username, Avatar: stored.Avatar, Guilds: guilds, Bot: stored.Bot,
+		System: stored.System,
+	})
+}
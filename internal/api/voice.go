@@ -0,0 +1,70 @@
+package api
+
+import (
+	"discord-go-connect/internal/store"
+	"net/http"
+	"time"
+)
+
+// defaultVoiceReportWindow is the period a voice report covers when no since is given.
+const defaultVoiceReportWindow = 7 * 24 * time.Hour
+
+type voiceReport struct {
+	Since   time.Time         `json:"since"`
+	Until   time.Time         `json:"until"`
+	Members []store.VoiceTime `json:"members"`
+}
+
+// writeVoiceOccupancy answers GET /api/guilds/{id}/voice with the members currently in
+// each voice channel of the guild.
+func (s *Server) writeVoiceOccupancy(w http.ResponseWriter, _ *http.Request, guildID string) {
+	channels, err := s.store.VoiceOccupancy(guildID)
+	if err != nil {
+		s.logger.Error("failed to fetch voice occupancy: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch voice occupancy")
+
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, channels)
+}
+
+// writeVoiceReport answers GET /api/guilds/{id}/voice/report?since=&until= with the time
+// each member spent in voice. Both bounds are RFC 3339 timestamps; the report covers the
+// last week up to now by default.
+func (s *Server) writeVoiceReport(w http.ResponseWriter, r *http.Request, guildID string) {
+	until := time.Now().UTC()
+	since := until.Add(-defaultVoiceReportWindow)
+
+	for name, target := range map[string]*time.Time{"since": &since, "until": &until} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+			return
+		}
+
+		*target = parsed.UTC()
+	}
+
+	if !since.Before(until) {
+		s.writeError(w, http.StatusBadRequest, "since must be before until")
+		return
+	}
+
+	times, err := s.store.VoiceTimes(guildID, since, until)
+	if err != nil {
+		s.logger.Error("failed to fetch voice report: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to fetch voice report")
+
+		return
+	}
+
+	report := voiceReport{Since: since, Until: until, Members: times}
+
+	s.writeJSON(w, http.StatusOK, report)
+}
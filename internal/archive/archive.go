@@ -0,0 +1,547 @@
+// Package archive moves old messages out of the store into a cold archive of compressed
+// files in a blob store, one per channel and month, and reads them back for the history
+// API. The files are gzipped NDJSON, a message per line as the API returns them, oldest
+// first, so a decompressed file can also be read by `discord import`.
+package archive
+
+import (
+	"compress/gzip"
+	"discord-go-connect/internal/blob"
+	"discord-go-connect/internal/logger"
+	"discord-go-connect/internal/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// DefaultAge is how old messages get before they are archived, unless configured.
+	DefaultAge = 365 * 24 * time.Hour
+	// cachedPartitions is how many decoded partitions are kept, so paging through one
+	// doesn't download and decode it for every page.
+	cachedPartitions = 8
+	// monthLayout formats the month of a partition.
+	monthLayout = "2006-01"
+)
+
+// Report counts what a run archived.
+type Report struct {
+	Channels   int `json:"channels"`
+	Partitions int `json:"partitions"`
+	Messages   int `json:"messages"`
+}
+
+// Archive moves messages from the store to the blob store and reads them back.
+type Archive struct {
+	store  store.Store
+	blobs  blob.Store
+	logger *logger.StandardLoggerHandler
+	// cache holds decoded partitions by key and version, oldest message first.
+	cache   map[string][]discordgo.Message
+	cached  []string
+	cacheMu sync.Mutex
+	// runMu makes runs take turns, since they rewrite partitions.
+	runMu sync.Mutex
+}
+
+func NewArchive(history store.Store, blobs blob.Store) *Archive {
+	return &Archive{
+		store:  history,
+		blobs:  blobs,
+		logger: logger.NewLogger(os.Stderr),
+		cache:  make(map[string][]discordgo.Message),
+	}
+}
+
+// ParseAge reads the ARCHIVE_AFTER_DAYS setting: how many days old messages get before
+// they are archived. Empty means DefaultAge.
+func ParseAge(days string) (time.Duration, error) {
+	if days == "" {
+		return DefaultAge, nil
+	}
+
+	count, err := strconv.Atoi(days)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid number of days %q", days)
+	}
+
+	return time.Duration(count) * 24 * time.Hour, nil
+}
+
+// Cutoff returns the start of the month of now minus age. Archiving up to it moves whole
+// months, so a partition is normally written once.
+func Cutoff(now time.Time, age time.Duration) time.Time {
+	then := now.Add(-age).UTC()
+	return time.Date(then.Year(), then.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Key names the blob of a channel's messages of a month. DM channels have no guild.
+func Key(guildID, channelID, month string) string {
+	if guildID == "" {
+		guildID = "dm"
+	}
+
+	return fmt.Sprintf("messages/%s/%s/%s.ndjson.gz", guildID, channelID, month)
+}
+
+// Run archives the messages sent before before. A message is written to its partition
+// before the store deletes it, so a failed run loses nothing, and a partition that
+// already exists, because messages older than it were imported since, is merged with
+// them.
+func (a *Archive) Run(before time.Time) (Report, error) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	var report Report
+
+	channels, err := a.store.ArchivableChannels(before)
+	if err != nil {
+		return report, err
+	}
+
+	for _, channelID := range channels {
+		if err := a.archiveChannel(channelID, before, &report); err != nil {
+			return report, fmt.Errorf("failed to archive channel %s: %w", channelID, err)
+		}
+
+		report.Channels++
+	}
+
+	return report, nil
+}
+
+// pending is a partition being written to a temporary file.
+type pending struct {
+	partition store.ArchivedPartition
+	file      *os.File
+	gzip      *gzip.Writer
+	encoder   *json.Encoder
+	ids       []string
+}
+
+func newPending(message *discordgo.Message) (*pending, error) {
+	file, err := os.CreateTemp("", "discord-archive-*.ndjson.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create partition file: %w", err)
+	}
+
+	compressed := gzip.NewWriter(file)
+	month := message.Timestamp.UTC().Format(monthLayout)
+
+	return &pending{
+		partition: store.ArchivedPartition{
+			ChannelID: message.ChannelID,
+			GuildID:   message.GuildID,
+			Month:     month,
+			Key:       Key(message.GuildID, message.ChannelID, month),
+			First:     message.Timestamp,
+		},
+		file:    file,
+		gzip:    compressed,
+		encoder: json.NewEncoder(compressed),
+	}, nil
+}
+
+func (p *pending) add(message *discordgo.Message) error {
+	if err := p.encoder.Encode(message); err != nil {
+		return fmt.Errorf("failed to write message %s: %w", message.ID, err)
+	}
+
+	p.ids = append(p.ids, message.ID)
+	p.partition.Messages++
+	p.partition.Last = message.Timestamp
+
+	return nil
+}
+
+// finish flushes the file and rewinds it for uploading.
+func (p *pending) finish() error {
+	if err := p.gzip.Close(); err != nil {
+		return fmt.Errorf("failed to write partition %s: %w", p.partition.Key, err)
+	}
+
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind partition %s: %w", p.partition.Key, err)
+	}
+
+	return nil
+}
+
+func (p *pending) remove() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
+
+// archiveChannel writes the channel's messages sent before before to a file per month,
+// then stores the files and deletes their messages. Reading everything first keeps the
+// deletes out of the way of the query reading the messages.
+func (a *Archive) archiveChannel(channelID string, before time.Time, report *Report) error {
+	months := make([]*pending, 0)
+
+	defer func() {
+		for _, month := range months {
+			month.remove()
+		}
+	}()
+
+	err := a.store.EachMessage(&store.HistoryFilter{ChannelID: channelID, Before: before}, func(message *discordgo.Message) error {
+		current := len(months) - 1
+
+		if current < 0 || months[current].partition.Month != message.Timestamp.UTC().Format(monthLayout) {
+			month, err := newPending(message)
+			if err != nil {
+				return err
+			}
+
+			months = append(months, month)
+			current++
+		}
+
+		return months[current].add(message)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(months) == 0 {
+		return nil
+	}
+
+	existing, err := a.store.ArchivedPartitions(channelID)
+	if err != nil {
+		return err
+	}
+
+	archived := make(map[string]bool, len(existing))
+	for _, partition := range existing {
+		archived[partition.Month] = true
+	}
+
+	for _, month := range months {
+		if err := month.finish(); err != nil {
+			return err
+		}
+
+		if archived[month.partition.Month] {
+			if err := a.merge(month); err != nil {
+				return err
+			}
+		}
+
+		if err := a.blobs.Put(month.partition.Key, month.file); err != nil {
+			return err
+		}
+
+		month.partition.ArchivedAt = time.Now().UTC()
+
+		if err := a.store.ArchivePartition(&month.partition, month.ids); err != nil {
+			return err
+		}
+
+		report.Partitions++
+		report.Messages += len(month.ids)
+	}
+
+	return nil
+}
+
+// Prune applies a retention rule to the archive of a channel, like the store does with
+// PruneMessages: the archived messages sent before before, unless it is zero, and not
+// among the newest keep are removed. keep counts archived messages only, so callers
+// subtract the messages the store still holds. Partitions left empty are deleted and the
+// others rewritten. It returns the IDs of the pruned messages.
+func (a *Archive) Prune(channelID string, before time.Time, keep int) ([]string, error) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	partitions, err := a.store.ArchivedPartitions(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := make([]string, 0)
+
+	// Partitions come newest first, so the ones keep protects come first too.
+	for i := range partitions {
+		partition := &partitions[i]
+
+		kept := 0
+		if keep > 0 {
+			kept = keep
+			if kept > partition.Messages {
+				kept = partition.Messages
+			}
+
+			keep -= kept
+		}
+
+		if kept == partition.Messages || (!before.IsZero() && !partition.First.Before(before)) {
+			continue
+		}
+
+		ids, err := a.prunePartition(partition, before, partition.Messages-kept)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to prune archived partition %s: %w", partition.Key, err)
+		}
+
+		pruned = append(pruned, ids...)
+	}
+
+	return pruned, nil
+}
+
+// prunePartition removes up to limit of the oldest messages of a partition that were
+// sent before before, unless it is zero.
+func (a *Archive) prunePartition(partition *store.ArchivedPartition, before time.Time, limit int) ([]string, error) {
+	messages, err := a.read(partition.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for count < limit && count < len(messages) && (before.IsZero() || messages[count].Timestamp.Before(before)) {
+		count++
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, count)
+	for _, message := range messages[:count] {
+		ids = append(ids, message.ID)
+	}
+
+	newest := messages[count-1].Timestamp
+	rest := messages[count:]
+
+	if len(rest) == 0 {
+		// The index forgets the partition before its blob goes, so readers never miss it.
+		partition.Messages = 0
+		if err := a.store.PruneArchivedPartition(partition, ids, newest); err != nil {
+			return nil, err
+		}
+
+		if err := a.blobs.Delete(partition.Key); err != nil {
+			a.logger.Error("failed to delete pruned partition %s: %v", partition.Key, err)
+		}
+
+		return ids, nil
+	}
+
+	month, err := newPending(&rest[0])
+	if err != nil {
+		return nil, err
+	}
+	defer month.remove()
+
+	for i := range rest {
+		if err := month.add(&rest[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := month.finish(); err != nil {
+		return nil, err
+	}
+
+	if err := a.blobs.Put(partition.Key, month.file); err != nil {
+		return nil, err
+	}
+
+	month.partition.ArchivedAt = time.Now().UTC()
+
+	if err := a.store.PruneArchivedPartition(&month.partition, ids, newest); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// merge rewrites a pending partition to also hold the messages already archived for its
+// month. Messages in both keep the pending version.
+func (a *Archive) merge(month *pending) error {
+	archived, err := a.read(month.partition.Key)
+	if err != nil {
+		return err
+	}
+
+	added, err := decode(month.file)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]discordgo.Message, len(archived)+len(added))
+	for _, message := range append(archived, added...) {
+		byID[message.ID] = message
+	}
+
+	messages := make([]discordgo.Message, 0, len(byID))
+	for _, message := range byID {
+		messages = append(messages, message)
+	}
+
+	sortOldestFirst(messages)
+
+	merged, err := newPending(&messages[0])
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if err := merged.add(&messages[i]); err != nil {
+			merged.remove()
+			return err
+		}
+	}
+
+	if err := merged.finish(); err != nil {
+		merged.remove()
+		return err
+	}
+
+	// Only the messages still in the store are deleted, which are the pending ones.
+	month.file.Close()
+	os.Remove(month.file.Name())
+
+	month.file, month.gzip, month.encoder = merged.file, merged.gzip, merged.encoder
+	month.partition.Messages, month.partition.First, month.partition.Last =
+		merged.partition.Messages, merged.partition.First, merged.partition.Last
+
+	return nil
+}
+
+// ChannelMessages returns the archived messages of a channel newest first, skipping
+// offset messages, like the store does for the messages it holds.
+func (a *Archive) ChannelMessages(channelID string, limit, offset int) ([]discordgo.Message, error) {
+	partitions, err := a.store.ArchivedPartitions(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]discordgo.Message, 0, limit)
+
+	for i := range partitions {
+		if len(messages) >= limit {
+			break
+		}
+
+		if offset >= partitions[i].Messages {
+			offset -= partitions[i].Messages
+			continue
+		}
+
+		stored, err := a.partition(&partitions[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(stored) - 1 - offset; j >= 0 && len(messages) < limit; j-- {
+			messages = append(messages, stored[j])
+		}
+
+		offset = 0
+	}
+
+	return messages, nil
+}
+
+// partition returns the messages of a partition, oldest first, from the cache if it has
+// this version of it.
+func (a *Archive) partition(partition *store.ArchivedPartition) ([]discordgo.Message, error) {
+	version := partition.Key + "@" + partition.ArchivedAt.Format(time.RFC3339Nano)
+
+	a.cacheMu.Lock()
+	messages, ok := a.cache[version]
+	a.cacheMu.Unlock()
+
+	if ok {
+		return messages, nil
+	}
+
+	messages, err := a.read(partition.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	if _, ok := a.cache[version]; !ok {
+		a.cached = append(a.cached, version)
+	}
+
+	a.cache[version] = messages
+
+	if len(a.cached) > cachedPartitions {
+		delete(a.cache, a.cached[0])
+		a.cached = a.cached[1:]
+	}
+
+	return messages, nil
+}
+
+// read downloads and decodes a partition.
+func (a *Archive) read(key string) ([]discordgo.Message, error) {
+	reader, err := a.blobs.Get(key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, fmt.Errorf("archived partition %s is missing: %w", key, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	messages, err := decode(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archived partition %s: %w", key, err)
+	}
+
+	return messages, nil
+}
+
+// decode reads the messages of a partition file.
+func decode(r io.Reader) ([]discordgo.Message, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer compressed.Close()
+
+	decoder := json.NewDecoder(compressed)
+	messages := make([]discordgo.Message, 0)
+
+	for {
+		var message discordgo.Message
+
+		err := decoder.Decode(&message)
+		if errors.Is(err, io.EOF) {
+			return messages, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+}
+
+func sortOldestFirst(messages []discordgo.Message) {
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].Timestamp.Equal(messages[j].Timestamp) {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		}
+
+		return messages[i].ID < messages[j].ID
+	})
+}
@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"discord-go-connect/internal/blob"
+	"discord-go-connect/internal/blob/blobtest"
+	"discord-go-connect/internal/store"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// message is sent on day days after the start of 2024.
+func message(id int, channelID string, days int) *discordgo.MessageCreate {
+	guildID := "1"
+	if channelID == "dm" {
+		guildID = ""
+	}
+
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID: strconv.Itoa(id), ChannelID: channelID, GuildID: guildID, Content: "message " + strconv.Itoa(id),
+		Author:    &discordgo.User{ID: "42", Username: "carol"},
+		Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).AddDate(0, 0, days),
+	}}
+}
+
+func ids(messages []discordgo.Message) []string {
+	result := make([]string, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.ID)
+	}
+
+	return result
+}
+
+func TestArchive(t *testing.T) {
+	fake := blobtest.NewS3(t)
+
+	blobs, err := blob.NewS3(blob.S3Config{Endpoint: fake.URL, Bucket: fake.Bucket, AccessKey: fake.AccessKey, SecretKey: fake.SecretKey})
+	if err != nil {
+		t.Fatalf("failed to create S3 store: %v", err)
+	}
+
+	history := store.NewMemory()
+
+	// Three messages in January, two in February and one in March, and a DM in January.
+	batch := []*discordgo.MessageCreate{
+		message(1, "10", 0), message(2, "10", 10), message(3, "10", 20),
+		message(4, "10", 35), message(5, "10", 40), message(6, "10", 65),
+		message(7, "dm", 5),
+	}
+
+	if _, err := history.SaveMessages(batch); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	archive := NewArchive(history, blobs)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if cutoff := Cutoff(time.Date(2025, 3, 20, 0, 0, 0, 0, time.UTC), DefaultAge); !cutoff.Equal(march) {
+		t.Fatalf("cutoff = %v, want the start of March 2024", cutoff)
+	}
+
+	report, err := archive.Run(march)
+	if err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	if want := (Report{Channels: 2, Partitions: 3, Messages: 6}); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	for _, key := range []string{"messages/1/10/2024-01.ndjson.gz", "messages/1/10/2024-02.ndjson.gz", "messages/dm/dm/2024-01.ndjson.gz"} {
+		if _, ok := fake.Object(key); !ok {
+			t.Errorf("%s wasn't uploaded", key)
+		}
+	}
+
+	if stored, _ := history.ChannelMessages("10", 10, 0); !reflect.DeepEqual(ids(stored), []string{"6"}) {
+		t.Errorf("store holds %v, want only the March message", ids(stored))
+	}
+
+	// Pages run newest first across partitions.
+	for _, page := range []struct {
+		limit, offset int
+		want          []string
+	}{
+		{2, 0, []string{"5", "4"}},
+		{2, 1, []string{"4", "3"}},
+		{10, 2, []string{"3", "2", "1"}},
+		{10, 5, []string{}},
+	} {
+		messages, err := archive.ChannelMessages("10", page.limit, page.offset)
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+
+		if got := ids(messages); !reflect.DeepEqual(got, page.want) {
+			t.Errorf("%d messages from %d = %v, want %v", page.limit, page.offset, got, page.want)
+		}
+	}
+
+	messages, _ := archive.ChannelMessages("10", 1, 0)
+	if len(messages) != 1 || messages[0].Author == nil || messages[0].Author.Username != "carol" || messages[0].Content != "message 5" {
+		t.Errorf("archived message = %+v, want it whole", messages)
+	}
+
+	// A January message imported later is merged into the January partition.
+	if _, err := history.SaveMessages([]*discordgo.MessageCreate{message(8, "10", 15)}); err != nil {
+		t.Fatalf("failed to store message: %v", err)
+	}
+
+	if report, err := archive.Run(march); err != nil || report.Messages != 1 {
+		t.Fatalf("second run archived %+v (%v), want the imported message", report, err)
+	}
+
+	partitions, _ := history.ArchivedPartitions("10")
+	if len(partitions) != 2 || partitions[1].Month != "2024-01" || partitions[1].Messages != 4 {
+		t.Fatalf("partitions = %+v, want January to hold 4 messages", partitions)
+	}
+
+	if messages, _ := archive.ChannelMessages("10", 10, 2); !reflect.DeepEqual(ids(messages), []string{"3", "8", "2", "1"}) {
+		t.Errorf("January = %v, want the imported message in order", ids(messages))
+	}
+
+	if report, err := archive.Run(march); err != nil || report != (Report{}) {
+		t.Errorf("third run archived %+v (%v), want nothing", report, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	blobs, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	history := store.NewMemory()
+
+	// Three messages in January and two in February are archived, one in March is not.
+	batch := []*discordgo.MessageCreate{
+		message(1, "10", 0), message(2, "10", 10), message(3, "10", 20),
+		message(4, "10", 35), message(5, "10", 40), message(6, "10", 65),
+	}
+
+	if _, err := history.SaveMessages(batch); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	archive := NewArchive(history, blobs)
+
+	if _, err := archive.Run(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	prunes := []struct {
+		before     time.Time
+		keep       int
+		pruned     []string
+		archived   []string
+		partitions int
+	}{
+		// Part of January is older than the cutoff.
+		{before: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), pruned: []string{"1", "2"}, archived: []string{"5", "4", "3"}, partitions: 2},
+		// Only the newest archived message is kept, which leaves January empty.
+		{keep: 1, pruned: []string{"4", "3"}, archived: []string{"5"}, partitions: 1},
+		{keep: 1, pruned: []string{}, archived: []string{"5"}, partitions: 1},
+	}
+
+	for _, prune := range prunes {
+		pruned, err := archive.Prune("10", prune.before, prune.keep)
+		if err != nil {
+			t.Fatalf("failed to prune: %v", err)
+		}
+
+		if !reflect.DeepEqual(pruned, prune.pruned) {
+			t.Errorf("pruned %v, want %v", pruned, prune.pruned)
+		}
+
+		messages, err := archive.ChannelMessages("10", 10, 0)
+		if err != nil {
+			t.Fatalf("failed to read the archive: %v", err)
+		}
+
+		if got := ids(messages); !reflect.DeepEqual(got, prune.archived) {
+			t.Errorf("archive holds %v, want %v", got, prune.archived)
+		}
+
+		if partitions, _ := history.ArchivedPartitions("10"); len(partitions) != prune.partitions {
+			t.Errorf("got %d partitions, want %d", len(partitions), prune.partitions)
+		}
+	}
+
+	if keys, _ := blobs.List("messages/"); len(keys) != 1 {
+		t.Errorf("blobs %v left, want only February's", keys)
+	}
+
+	pruned, err := history.PrunedHistory("10")
+	if err != nil || pruned.Messages != 4 || !pruned.Before.Equal(message(4, "10", 35).Timestamp) {
+		t.Errorf("pruned history = %+v, %v, want 4 messages up to message 4", pruned, err)
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// allGuilds grants access to every guild, DMs and admin features.
+const allGuilds = "*"
+
+var errInvalidSpec = errors.New("invalid API key spec")
+
+// Grant describes what the holder of an API key may access.
+type Grant struct {
+	guilds map[string]struct{}
+	Name   string
+	all    bool
+}
+
+// Unrestricted is the grant every caller gets when no API keys are configured.
+var Unrestricted = &Grant{Name: "unrestricted", all: true}
+
+// GuildGrant covers a single guild, for callers acting from within it such as slash
+// commands.
+func GuildGrant(guildID string) *Grant {
+	return &Grant{Name: "guild:" + guildID, guilds: map[string]struct{}{guildID: {}}}
+}
+
+// Admin reports whether the grant covers every guild and the admin features.
+func (g *Grant) Admin() bool {
+	return g != nil && g.all
+}
+
+// CanAccessGuild reports whether the grant covers a guild. DMs have no guild and are
+// only reachable with an admin grant.
+func (g *Grant) CanAccessGuild(guildID string) bool {
+	if g == nil {
+		return false
+	}
+
+	if g.all {
+		return true
+	}
+
+	_, ok := g.guilds[guildID]
+
+	return ok && guildID != ""
+}
+
+// Guilds lists the guilds of a restricted grant. It is empty for admin grants, which
+// cover every guild.
+func (g *Grant) Guilds() []string {
+	if g == nil || g.all {
+		return nil
+	}
+
+	guilds := make([]string, 0, len(g.guilds))
+	for guildID := range g.guilds {
+		guilds = append(guilds, guildID)
+	}
+
+	sort.Strings(guilds)
+
+	return guilds
+}
+
+// Keys holds the configured API keys.
+type Keys struct {
+	bySecret  map[[sha256.Size]byte]*Grant
+	byName    map[string]*Grant
+	botSecret string
+}
+
+// NewKeys parses a spec such as "support=s3cret@123|456;admin=t0ken@*", where each
+// entry names a key, gives its secret and lists the guild IDs it may access.
+// An empty spec disables authentication.
+func NewKeys(spec string) (*Keys, error) {
+	botSecret := make([]byte, 32)
+	if _, err := rand.Read(botSecret); err != nil {
+		return nil, fmt.Errorf("failed to generate bot secret: %w", err)
+	}
+
+	keys := &Keys{
+		bySecret:  make(map[[sha256.Size]byte]*Grant),
+		byName:    make(map[string]*Grant),
+		botSecret: hex.EncodeToString(botSecret),
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, rest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q has no secret", errInvalidSpec, entry)
+		}
+
+		secret, guilds, ok := strings.Cut(rest, "@")
+		if !ok || secret == "" || guilds == "" {
+			return nil, fmt.Errorf("%w: %q has no guilds", errInvalidSpec, entry)
+		}
+
+		grant := &Grant{Name: name, guilds: make(map[string]struct{})}
+
+		for _, guildID := range strings.Split(guilds, "|") {
+			if guildID == allGuilds {
+				grant.all = true
+			}
+
+			grant.guilds[guildID] = struct{}{}
+		}
+
+		keys.bySecret[sha256.Sum256([]byte(secret))] = grant
+		keys.byName[name] = grant
+	}
+
+	return keys, nil
+}
+
+// Enabled reports whether any API key is configured.
+func (k *Keys) Enabled() bool {
+	return k != nil && len(k.byName) > 0
+}
+
+// Authenticate returns the grant of a secret.
+func (k *Keys) Authenticate(secret string) (*Grant, bool) {
+	if !k.Enabled() {
+		return Unrestricted, true
+	}
+
+	grant, ok := k.bySecret[sha256.Sum256([]byte(secret))]
+
+	return grant, ok
+}
+
+// Grant returns a grant by the name of its key, as forwarded by the WebSocket hub.
+func (k *Keys) Grant(name string) *Grant {
+	if !k.Enabled() {
+		return Unrestricted
+	}
+
+	return k.byName[name]
+}
+
+// FromRequest authenticates a request by its bearer token or its token query parameter.
+func (k *Keys) FromRequest(r *http.Request) (*Grant, bool) {
+	secret := r.URL.Query().Get("token")
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		secret = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	return k.Authenticate(secret)
+}
+
+// SetBotSecret replaces the generated bot secret, so bot processes sharing a hub can
+// authenticate with it.
+func (k *Keys) SetBotSecret(secret string) {
+	k.botSecret = secret
+}
+
+// BotSecret is the per-process secret the bot presents when it connects to the hub.
+func (k *Keys) BotSecret() string {
+	return k.botSecret
+}
+
+// IsBotSecret reports whether secret is the one returned by BotSecret.
+func (k *Keys) IsBotSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(k.botSecret)) == 1
+}
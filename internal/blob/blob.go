@@ -0,0 +1,80 @@
+// Package blob stores files by key in a local directory or in an S3-compatible bucket.
+// Keys are slash-separated paths such as "messages/1/10/2024-01.ndjson.gz".
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrNotFound is returned for keys that aren't stored.
+var ErrNotFound = errors.New("blob not found")
+
+// Store holds blobs by key.
+type Store interface {
+	// Put stores everything read from r under key, replacing what was there. Readers
+	// see either the old or the new blob, never part of one.
+	Put(key string, r io.Reader) error
+	// Get opens a blob. It returns ErrNotFound if there is none.
+	Get(key string) (io.ReadCloser, error)
+	// Open opens a blob for random access, such as serving ranges of it. It returns
+	// ErrNotFound if there is none.
+	Open(key string) (io.ReadSeekCloser, error)
+	// Delete removes a blob. Deleting a missing blob isn't an error.
+	Delete(key string) error
+	// List returns the keys starting with prefix, sorted.
+	List(prefix string) ([]string, error)
+}
+
+// Open returns the store at location, which is a directory or an S3 URL:
+//
+//	/var/lib/discord/archive
+//	s3://ACCESS_KEY:SECRET_KEY@s3.eu-west-1.amazonaws.com/bucket?region=eu-west-1
+//	s3://minio:secret@localhost:9000/archive?insecure=true
+//
+// region defaults to us-east-1, and insecure talks plain HTTP to the endpoint.
+func Open(location string) (Store, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return NewDir(location)
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 location: %w", err)
+	}
+
+	secret, _ := parsed.User.Password()
+	query := parsed.Query()
+
+	config := S3Config{
+		Endpoint:  "https://" + parsed.Host,
+		Bucket:    strings.Trim(parsed.Path, "/"),
+		Region:    query.Get("region"),
+		AccessKey: parsed.User.Username(),
+		SecretKey: secret,
+	}
+
+	if query.Get("insecure") == "true" {
+		config.Endpoint = "http://" + parsed.Host
+	}
+
+	return NewS3(config)
+}
+
+// validKey rejects keys that could escape a directory or name no file.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package blobtest provides an in-memory stand-in for an S3-compatible service, so the
+// S3 blob store can be tested without one.
+package blobtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// S3 serves one bucket by path, keeps its objects in memory and rejects requests that
+// aren't signed with its access key or whose body doesn't match the signed hash. It
+// implements PUT, GET, HEAD and DELETE of objects, ranged GETs and ListObjectsV2.
+type S3 struct {
+	URL       string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PageSize is how many keys a list response holds before it is truncated.
+	PageSize int
+	objects  map[string][]byte
+	requests int
+	mu       sync.Mutex
+}
+
+// NewS3 starts a server that is closed when the test ends.
+func NewS3(t *testing.T) *S3 {
+	t.Helper()
+
+	s := &S3{Bucket: "archive", AccessKey: "test-access", SecretKey: "test-secret", PageSize: 1000, objects: make(map[string][]byte)}
+
+	server := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(server.Close)
+
+	s.URL = server.URL
+
+	return s
+}
+
+// Object returns a stored object and whether there is one.
+func (s *S3) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.objects[key]
+
+	return object, ok
+}
+
+// Requests counts the requests the server answered.
+func (s *S3) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+func (s *S3) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+
+	credential := "AWS4-HMAC-SHA256 Credential=" + s.AccessKey + "/"
+	if !strings.HasPrefix(r.Header.Get("Authorization"), credential) || r.Header.Get("X-Amz-Date") == "" {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r)
+	case key == "":
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+			return
+		}
+
+		s.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		s.serveObject(w, r, object)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// serveObject answers a GET or HEAD, honouring a bytes=start- or bytes=start-end range.
+func (s *S3) serveObject(w http.ResponseWriter, r *http.Request, object []byte) {
+	start, end, status := 0, len(object)-1, http.StatusOK
+
+	if spec := r.Header.Get("Range"); spec != "" {
+		first, last, _ := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+
+		var err error
+
+		start, err = strconv.Atoi(first)
+		if err == nil && last != "" {
+			end, err = strconv.Atoi(last)
+		}
+
+		if end >= len(object) {
+			end = len(object) - 1
+		}
+
+		if err != nil || start > end {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(object)))
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+
+			return
+		}
+
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(object)))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(object[start : end+1])
+	}
+}
+
+func (s *S3) list(w http.ResponseWriter, r *http.Request) {
+	prefix, after := r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token")
+
+	keys := make([]string, 0)
+
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	type object struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []object `xml:"Contents"`
+		IsTruncated           bool     `xml:"IsTruncated"`
+		NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	}
+
+	if len(keys) > s.PageSize {
+		keys = keys[:s.PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		result.Contents = append(result.Contents, object{Key: key, Size: len(s.objects[key])})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
@@ -0,0 +1,441 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body, which GET, DELETE and list requests
+// sign.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config locates a bucket of an S3-compatible service such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3 stores blobs as objects in a bucket. It addresses the bucket by path, which every
+// S3-compatible service supports, and signs requests with AWS Signature Version 4.
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+
+	if config.Bucket == "" || strings.Contains(config.Bucket, "/") {
+		return nil, fmt.Errorf("invalid S3 bucket %q", config.Bucket)
+	}
+
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	return &S3{config: config, endpoint: endpoint, client: &http.Client{Timeout: 5 * time.Minute}, now: time.Now}, nil
+}
+
+func (s *S3) Put(key string, r io.Reader) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	// S3 needs the length and signs the hash of the body up front, so it is spooled to
+	// a temporary file first.
+	spool, err := os.CreateTemp("", "discord-blob-*")
+	if err != nil {
+		return fmt.Errorf("failed to buffer %s: %w", key, err)
+	}
+
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(spool, hash), r)
+	if err != nil {
+		return fmt.Errorf("failed to buffer %s: %w", key, err)
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to buffer %s: %w", key, err)
+	}
+
+	request, err := http.NewRequest(http.MethodPut, s.objectURL(key, nil), spool)
+	if err != nil {
+		return fmt.Errorf("failed to build request for %s: %w", key, err)
+	}
+
+	request.ContentLength = size
+
+	response, err := s.do(request, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	response.Body.Close()
+
+	return nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, s.objectURL(key, nil), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for %s: %w", key, err)
+	}
+
+	response, err := s.do(request, emptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+
+	return response.Body, nil
+}
+
+func (s *S3) Open(key string) (io.ReadSeekCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodHead, s.objectURL(key, nil), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for %s: %w", key, err)
+	}
+
+	response, err := s.do(request, emptyPayloadHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+
+	response.Body.Close()
+
+	if response.ContentLength < 0 {
+		return nil, fmt.Errorf("failed to open %s: no length", key)
+	}
+
+	return &s3Object{s3: s, key: key, size: response.ContentLength}, nil
+}
+
+// s3Object reads an object from where it was last sought to, with a ranged GET that is
+// reopened after every seek.
+type s3Object struct {
+	s3     *S3
+	body   io.ReadCloser
+	key    string
+	size   int64
+	offset int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		request, err := http.NewRequest(http.MethodGet, o.s3.objectURL(o.key, nil), nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to build request for %s: %w", o.key, err)
+		}
+
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.offset))
+
+		response, err := o.s3.do(request, emptyPayloadHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to download %s: %w", o.key, err)
+		}
+
+		o.body = response.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid offset %d in %s", offset, o.key)
+	}
+
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+
+	err := o.body.Close()
+	o.body = nil
+
+	return err
+}
+
+func (s *S3) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodDelete, s.objectURL(key, nil), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request for %s: %w", key, err)
+	}
+
+	response, err := s.do(request, emptyPayloadHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+
+	response.Body.Close()
+
+	return nil
+}
+
+// listResult is the part of a ListObjectsV2 response the store reads.
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+	for {
+		request, err := http.NewRequest(http.MethodGet, s.objectURL("", query), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build list request: %w", err)
+		}
+
+		response, err := s.do(request, emptyPayloadHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+
+		var result listResult
+
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode blob list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// objectURL returns the URL of an object, or of the bucket when key is empty.
+func (s *S3) objectURL(key string, query url.Values) string {
+	target := *s.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + s.config.Bucket
+
+	if key != "" {
+		target.Path += "/" + key
+	}
+
+	target.RawPath = uriEncode(target.Path, false)
+	target.RawQuery = canonicalQuery(query)
+
+	return target.String()
+}
+
+// do signs and sends a request. Responses other than 2xx are closed and returned as
+// errors, with ErrNotFound for missing objects.
+func (s *S3) do(request *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(request, payloadHash, s.now().UTC())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+
+	var failure struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	_ = xml.Unmarshal(body, &failure)
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if failure.Code != "" {
+		return nil, fmt.Errorf("S3 returned %d %s: %s", response.StatusCode, failure.Code, failure.Message)
+	}
+
+	return nil, fmt.Errorf("S3 returned %d", response.StatusCode)
+}
+
+// sign adds the AWS Signature Version 4 headers to a request. The host, the x-amz-*
+// headers and, if present, Range and Content-Type are signed.
+func (s *S3) sign(request *http.Request, payloadHash string, now time.Time) {
+	date := now.Format("20060102T150405Z")
+	day := date[:8]
+	scope := day + "/" + s.config.Region + "/s3/aws4_request"
+
+	request.Header.Set("X-Amz-Date", date)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+
+	for name, values := range request.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		uriEncode(request.URL.Path, false),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hexSHA256(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), day)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// canonicalQuery encodes a query the way Signature Version 4 signs it: sorted by name,
+// with every character but the unreserved ones percent-encoded.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return uriEncode(names[i], true) < uriEncode(names[j], true) })
+
+	pairs := make([]string, 0, len(query))
+
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and, in paths, slashes.
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -41,9 +41,14 @@ func NewDBManager() (*Manager, error) {
 		return nil, err
 	}
 
+	return NewManager(db), nil
+}
+
+// NewManager wraps an open database.
+func NewManager(db *sql.DB) *Manager {
 	return &Manager{
 		db: db,
-	}, nil
+	}
 }
 
 func (m *Manager) Close() error {
@@ -81,3 +86,7 @@ func (m *Manager) Query(query string, args ...interface{}) (*sql.Rows, error) {
 
 	return rows, nil
 }
+
+func (m *Manager) QueryRow(query string, args ...interface{}) *sql.Row {
+	return m.db.QueryRow(query, args...)
+}
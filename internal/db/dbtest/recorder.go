@@ -0,0 +1,191 @@
+// Package dbtest provides a database driver for tests that records statements instead of
+// running them.
+package dbtest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"discord-go-connect/internal/db"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Recorder keeps the statements run on a database opened with NewRecorder. Queries
+// return no rows, and statements affect one row unless Respond says otherwise.
+type Recorder struct {
+	respond func(exec Execution) (int64, error)
+	execs   []Execution
+	mu      sync.Mutex
+}
+
+// Execution is one recorded statement, with its whitespace collapsed.
+type Execution struct {
+	Query string
+	Args  []driver.Value
+}
+
+var (
+	recorders   = make(map[string]*Recorder)
+	recordersMu sync.Mutex
+)
+
+func init() {
+	sql.Register("recorder", recordingDriver{})
+}
+
+// NewRecorder returns a database manager backed by a fresh Recorder.
+func NewRecorder(t testing.TB) (*db.Manager, *Recorder) {
+	t.Helper()
+
+	rec := &Recorder{}
+
+	recordersMu.Lock()
+	name := t.Name() + "#" + strconv.Itoa(len(recorders))
+	recorders[name] = rec
+	recordersMu.Unlock()
+
+	conn, err := sql.Open("recorder", name)
+	if err != nil {
+		t.Fatalf("failed to open recorder: %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return db.NewManager(conn), rec
+}
+
+func (r *Recorder) record(query string, args []driver.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.execs = append(r.execs, Execution{Query: strings.Join(strings.Fields(query), " "), Args: args})
+}
+
+// Respond sets how statements are answered: with the number of rows they affected, or
+// with an error to fail them. Failed statements are recorded too.
+func (r *Recorder) Respond(respond func(exec Execution) (int64, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.respond = respond
+}
+
+func (r *Recorder) result(query string, args []driver.Value) (driver.Result, error) {
+	exec := Execution{Query: strings.Join(strings.Fields(query), " "), Args: args}
+
+	r.mu.Lock()
+	r.execs = append(r.execs, exec)
+	respond := r.respond
+	r.mu.Unlock()
+
+	if respond == nil {
+		return driver.RowsAffected(1), nil
+	}
+
+	affected, err := respond(exec)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(affected), nil
+}
+
+// Executions returns the statements whose query starts with prefix, such as
+// "INSERT INTO Message".
+func (r *Recorder) Executions(prefix string) []Execution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := make([]Execution, 0)
+
+	for _, exec := range r.execs {
+		if strings.HasPrefix(exec.Query, prefix) {
+			matched = append(matched, exec)
+		}
+	}
+
+	return matched
+}
+
+type recordingDriver struct{}
+
+func (recordingDriver) Open(name string) (driver.Conn, error) {
+	recordersMu.Lock()
+	defer recordersMu.Unlock()
+
+	return &recordingConn{rec: recorders[name]}, nil
+}
+
+type recordingConn struct {
+	rec *Recorder
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{rec: c.rec, query: query}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	c.rec.record("BEGIN", nil)
+
+	return recordingTx{rec: c.rec}, nil
+}
+
+type recordingTx struct {
+	rec *Recorder
+}
+
+func (tx recordingTx) Commit() error {
+	tx.rec.record("COMMIT", nil)
+
+	return nil
+}
+
+func (tx recordingTx) Rollback() error {
+	tx.rec.record("ROLLBACK", nil)
+
+	return nil
+}
+
+type recordingStmt struct {
+	rec   *Recorder
+	query string
+}
+
+func (s *recordingStmt) Close() error {
+	return nil
+}
+
+func (s *recordingStmt) NumInput() int {
+	return -1
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.rec.result(s.query, args)
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.rec.record(s.query, args)
+
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string {
+	return nil
+}
+
+func (emptyRows) Close() error {
+	return nil
+}
+
+func (emptyRows) Next([]driver.Value) error {
+	return io.EOF
+}
@@ -0,0 +1,368 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type migration struct {
+	name       string
+	statements []string
+}
+
+// migrations are applied in order and recorded in SchemaMigration, so entries must
+// only ever be appended. The first one describes the tables the bot has always used
+// and is a no-op on databases that already have them.
+var migrations = []migration{
+	{
+		name: "base schema",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS Guild (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				name VARCHAR(100) NOT NULL,
+				icon VARCHAR(255),
+				region VARCHAR(50),
+				owner_id VARCHAR(20)
+			)`,
+			`CREATE TABLE IF NOT EXISTS Channel (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				guild_id VARCHAR(20),
+				name VARCHAR(100),
+				nsfw BOOLEAN NOT NULL DEFAULT FALSE,
+				position INT NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS Author (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				email VARCHAR(255),
+				username VARCHAR(100) NOT NULL,
+				avatar VARCHAR(255),
+				bot BOOLEAN NOT NULL DEFAULT FALSE,
+				system BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE TABLE IF NOT EXISTS Member (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				guild_id VARCHAR(20),
+				author_id VARCHAR(20),
+				nick VARCHAR(100),
+				avatar VARCHAR(255)
+			)`,
+			`CREATE TABLE IF NOT EXISTS Message (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				channel_id VARCHAR(20) NOT NULL,
+				guild_id VARCHAR(20),
+				author_id VARCHAR(20) NOT NULL,
+				member_id VARCHAR(20),
+				pinned BOOLEAN NOT NULL DEFAULT FALSE,
+				type INT NOT NULL DEFAULT 0,
+				content TEXT,
+				timestamp DATETIME(3) NOT NULL,
+				edited_timestamp DATETIME(3),
+				INDEX idx_message_channel_timestamp (channel_id, timestamp)
+			)`,
+		},
+	},
+	{
+		name: "interaction state",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS InteractionState (
+				id VARCHAR(36) NOT NULL PRIMARY KEY,
+				route VARCHAR(100) NOT NULL,
+				data TEXT NOT NULL,
+				created_at DATETIME(3) NOT NULL,
+				expires_at DATETIME(3),
+				INDEX idx_interaction_state_expires_at (expires_at)
+			)`,
+		},
+	},
+	{
+		name: "reactions",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS Reaction (
+				message_id VARCHAR(20) NOT NULL,
+				user_id VARCHAR(20) NOT NULL,
+				emoji VARCHAR(120) NOT NULL,
+				emoji_id VARCHAR(20),
+				emoji_name VARCHAR(100) NOT NULL,
+				channel_id VARCHAR(20) NOT NULL,
+				guild_id VARCHAR(20),
+				created_at DATETIME(3) NOT NULL,
+				PRIMARY KEY (message_id, user_id, emoji),
+				INDEX idx_reaction_channel (channel_id),
+				INDEX idx_reaction_guild (guild_id)
+			)`,
+		},
+	},
+	{
+		name: "threads and forums",
+		statements: []string{
+			`ALTER TABLE Channel
+				ADD COLUMN type INT NOT NULL DEFAULT 0,
+				ADD COLUMN parent_id VARCHAR(20),
+				ADD COLUMN owner_id VARCHAR(20),
+				ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE,
+				ADD COLUMN locked BOOLEAN NOT NULL DEFAULT FALSE,
+				ADD COLUMN archive_timestamp DATETIME(3),
+				ADD COLUMN backfilled_at DATETIME(3),
+				ADD INDEX idx_channel_parent (parent_id)`,
+			`CREATE TABLE IF NOT EXISTS ForumTag (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				channel_id VARCHAR(20) NOT NULL,
+				name VARCHAR(100) NOT NULL,
+				emoji_id VARCHAR(20),
+				emoji_name VARCHAR(100),
+				moderated BOOLEAN NOT NULL DEFAULT FALSE,
+				INDEX idx_forum_tag_channel (channel_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS ThreadTag (
+				thread_id VARCHAR(20) NOT NULL,
+				tag_id VARCHAR(20) NOT NULL,
+				PRIMARY KEY (thread_id, tag_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS ThreadMember (
+				thread_id VARCHAR(20) NOT NULL,
+				user_id VARCHAR(20) NOT NULL,
+				join_timestamp DATETIME(3),
+				PRIMARY KEY (thread_id, user_id)
+			)`,
+		},
+	},
+	{
+		name: "members and roles",
+		statements: []string{
+			// Rows written before this migration swapped guild_id and author_id.
+			`UPDATE Member SET guild_id = author_id, author_id = id WHERE guild_id = id`,
+			`DELETE FROM Member WHERE guild_id IS NULL`,
+			`ALTER TABLE Member
+				MODIFY COLUMN guild_id VARCHAR(20) NOT NULL,
+				DROP PRIMARY KEY,
+				ADD PRIMARY KEY (guild_id, id),
+				ADD COLUMN joined_at DATETIME(3),
+				ADD COLUMN premium_since DATETIME(3),
+				ADD COLUMN pending BOOLEAN NOT NULL DEFAULT FALSE,
+				ADD COLUMN synced_at DATETIME(3)`,
+			`CREATE TABLE IF NOT EXISTS Role (
+				id VARCHAR(20) NOT NULL PRIMARY KEY,
+				guild_id VARCHAR(20) NOT NULL,
+				name VARCHAR(100) NOT NULL,
+				color INT NOT NULL DEFAULT 0,
+				position INT NOT NULL DEFAULT 0,
+				permissions BIGINT NOT NULL DEFAULT 0,
+				hoist BOOLEAN NOT NULL DEFAULT FALSE,
+				mentionable BOOLEAN NOT NULL DEFAULT FALSE,
+				managed BOOLEAN NOT NULL DEFAULT FALSE,
+				INDEX idx_role_guild (guild_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS MemberRole (
+				guild_id VARCHAR(20) NOT NULL,
+				user_id VARCHAR(20) NOT NULL,
+				role_id VARCHAR(20) NOT NULL,
+				PRIMARY KEY (guild_id, user_id, role_id),
+				INDEX idx_member_role_role (role_id)
+			)`,
+		},
+	},
+	{
+		name: "voice sessions",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS VoiceSession (
+				id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				guild_id VARCHAR(20) NOT NULL,
+				channel_id VARCHAR(20) NOT NULL,
+				user_id VARCHAR(20) NOT NULL,
+				session_id VARCHAR(64),
+				joined_at DATETIME(3) NOT NULL,
+				started_at DATETIME(3) NOT NULL,
+				ended_at DATETIME(3),
+				mute BOOLEAN NOT NULL DEFAULT FALSE,
+				deaf BOOLEAN NOT NULL DEFAULT FALSE,
+				self_mute BOOLEAN NOT NULL DEFAULT FALSE,
+				self_deaf BOOLEAN NOT NULL DEFAULT FALSE,
+				self_stream BOOLEAN NOT NULL DEFAULT FALSE,
+				self_video BOOLEAN NOT NULL DEFAULT FALSE,
+				suppress BOOLEAN NOT NULL DEFAULT FALSE,
+				INDEX idx_voice_session_open (guild_id, ended_at),
+				INDEX idx_voice_session_user (guild_id, user_id, started_at)
+			)`,
+		},
+	},
+	{
+		name: "presence samples",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS PresenceSample (
+				guild_id VARCHAR(20) NOT NULL,
+				user_id VARCHAR(20) NOT NULL,
+				sampled_at DATETIME(3) NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				activity_type INT,
+				activity_name VARCHAR(255),
+				PRIMARY KEY (guild_id, user_id, sampled_at),
+				INDEX idx_presence_sample_guild_time (guild_id, sampled_at)
+			)`,
+		},
+	},
+	{
+		name: "direct messages",
+		statements: []string{
+			// DM channels and their messages have no guild, and DM messages have no member.
+			`ALTER TABLE Channel
+				ADD COLUMN recipient_id VARCHAR(20),
+				ADD INDEX idx_channel_recipient (recipient_id)`,
+			`UPDATE Message SET guild_id = NULL WHERE guild_id = ''`,
+			`UPDATE Message SET member_id = NULL WHERE guild_id IS NULL`,
+		},
+	},
+	{
+		name: "message search",
+		statements: []string{
+			`ALTER TABLE Message
+				ADD COLUMN attachment_count INT NOT NULL DEFAULT 0,
+				ADD INDEX idx_message_guild_timestamp (guild_id, timestamp),
+				ADD INDEX idx_message_author_timestamp (author_id, timestamp)`,
+			`ALTER TABLE Message ADD FULLTEXT INDEX idx_message_content (content)`,
+			`CREATE TABLE IF NOT EXISTS MessageMention (
+				message_id VARCHAR(20) NOT NULL,
+				user_id VARCHAR(20) NOT NULL,
+				PRIMARY KEY (message_id, user_id),
+				INDEX idx_message_mention_user (user_id)
+			)`,
+		},
+	},
+	{
+		name: "message attachments and embeds",
+		statements: []string{
+			// Both hold the JSON Discord sends for them.
+			`ALTER TABLE Message
+				ADD COLUMN attachments TEXT,
+				ADD COLUMN embeds TEXT`,
+		},
+	},
+	{
+		name: "retention",
+		statements: []string{
+			// Guild rules have an empty channel_id.
+			`CREATE TABLE IF NOT EXISTS RetentionRule (
+				guild_id VARCHAR(20) NOT NULL,
+				channel_id VARCHAR(20) NOT NULL DEFAULT '',
+				mode VARCHAR(20) NOT NULL,
+				amount INT NOT NULL DEFAULT 0,
+				updated_at DATETIME(3) NOT NULL,
+				PRIMARY KEY (guild_id, channel_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS PrunedHistory (
+				channel_id VARCHAR(20) NOT NULL PRIMARY KEY,
+				guild_id VARCHAR(20),
+				pruned_before DATETIME(3) NOT NULL,
+				messages INT NOT NULL DEFAULT 0,
+				pruned_at DATETIME(3) NOT NULL
+			)`,
+		},
+	},
+	{
+		name: "message archive",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS ArchivedPartition (
+				channel_id VARCHAR(20) NOT NULL,
+				guild_id VARCHAR(20),
+				month CHAR(7) NOT NULL,
+				object_key VARCHAR(255) NOT NULL,
+				messages INT NOT NULL,
+				first_timestamp DATETIME(3) NOT NULL,
+				last_timestamp DATETIME(3) NOT NULL,
+				archived_at DATETIME(3) NOT NULL,
+				PRIMARY KEY (channel_id, month)
+			)`,
+			`ALTER TABLE Message ADD INDEX idx_message_timestamp (timestamp)`,
+		},
+	},
+	{
+		name: "media mirror",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS MediaObject (
+				hash CHAR(64) NOT NULL PRIMARY KEY,
+				content_type VARCHAR(255) NOT NULL,
+				size BIGINT NOT NULL,
+				created_at DATETIME(3) NOT NULL
+			)`,
+			// A source is an attachment, avatar or emoji; identical files share an object.
+			`CREATE TABLE IF NOT EXISTS MediaSource (
+				source VARCHAR(255) NOT NULL PRIMARY KEY,
+				hash CHAR(64) NOT NULL,
+				mirrored_at DATETIME(3) NOT NULL,
+				INDEX idx_media_source_hash (hash)
+			)`,
+		},
+	},
+	{
+		name: "channel permission overwrites",
+		statements: []string{
+			// type is 0 for a role and 1 for a member, as Discord sends it.
+			`CREATE TABLE IF NOT EXISTS ChannelOverwrite (
+				channel_id VARCHAR(20) NOT NULL,
+				id VARCHAR(20) NOT NULL,
+				type TINYINT NOT NULL,
+				allow BIGINT NOT NULL DEFAULT 0,
+				deny BIGINT NOT NULL DEFAULT 0,
+				PRIMARY KEY (channel_id, id)
+			)`,
+		},
+	},
+	{
+		name: "media source guilds",
+		statements: []string{
+			// Sources mirrored before have no guild, so only admin keys find them by source.
+			`ALTER TABLE MediaSource ADD COLUMN guild_id VARCHAR(20)`,
+		},
+	},
+	{
+		name: "media source messages",
+		statements: []string{
+			// Attachments go with their message; avatars and emoji have no message.
+			`ALTER TABLE MediaSource
+				ADD COLUMN message_id VARCHAR(20),
+				ADD INDEX idx_media_source_message (message_id)`,
+		},
+	},
+}
+
+const (
+	createMigrationTable = `
+			CREATE TABLE IF NOT EXISTS SchemaMigration (
+				version INT NOT NULL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				applied_at DATETIME(3) NOT NULL
+			)
+		`
+	selectMigrationVersion = `SELECT COALESCE(MAX(version), 0) FROM SchemaMigration`
+	insertMigration        = `INSERT INTO SchemaMigration (version, name, applied_at) VALUES (?, ?, NOW(3))`
+)
+
+// Migrate brings the database schema up to date.
+func (m *Manager) Migrate() error {
+	if _, err := m.db.Exec(createMigrationTable); err != nil {
+		return fmt.Errorf("failed to create migration table: %w", err)
+	}
+
+	var current int
+	if err := m.db.QueryRow(selectMigrationVersion).Scan(&current); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+
+		for _, statement := range migrations[i].statements {
+			if _, err := m.db.Exec(statement); err != nil {
+				return fmt.Errorf("failed to apply migration %d (%s): %w", version, migrations[i].name, err)
+			}
+		}
+
+		if _, err := m.db.Exec(insertMigration, version, migrations[i].name); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", version, err)
+		}
+
+		log.Printf("applied migration %d: %s", version, migrations[i].name)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package discord
+
+import (
+	"discord-go-connect/internal/archive"
+	"time"
+)
+
+// archiveInterval is how often messages are moved to the cold archive.
+const archiveInterval = 24 * time.Hour
+
+// UseArchive moves messages older than age to an archive, once the bot starts and daily
+// after that.
+func (b *Bot) UseArchive(messages *archive.Archive, age time.Duration) {
+	b.archive, b.archiveAge = messages, age
+}
+
+// archiveHistory archives old messages now and then every archiveInterval.
+func (b *Bot) archiveHistory() {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+
+	for {
+		report, err := b.archive.Run(archive.Cutoff(time.Now(), b.archiveAge))
+		if err != nil {
+			b.logger.Error("failed to archive messages: %v", err)
+		}
+
+		if report.Messages > 0 {
+			b.logger.Info("archived %d messages of %d channels", report.Messages, report.Channels)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-b.stopped:
+			return
+		}
+	}
+}
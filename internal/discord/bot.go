@@ -1,11 +1,18 @@
 package discord
 
 import (
+	"discord-go-connect/internal/archive"
+	"discord-go-connect/internal/auth"
 	"discord-go-connect/internal/db"
 	"discord-go-connect/internal/logger"
+	"discord-go-connect/internal/media"
+	"discord-go-connect/internal/store"
 	"discord-go-connect/internal/wshub"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -13,32 +20,70 @@ import (
 )
 
 type Bot struct {
-	db             *db.Manager
-	session        *discordgo.Session
-	conn           *websocket.Conn
-	logger         *logger.StandardLoggerHandler
-	writer         *messageWriter
-	writeInterval  time.Duration
-	guilds         map[string]*discordgo.Guild
-	dms            map[string]*discordgo.Channel
-	subscribers    map[string]string
-	onClose        chan struct{}
-	token          string
-	maxBufferCount int
+	store           store.Store
+	searchIndex     store.Index
+	archive         *archive.Archive
+	archiveAge      time.Duration
+	mediaMirror     *media.Mirror
+	keys            *auth.Keys
+	shards          []*shard
+	shardsMu        sync.RWMutex
+	shardConfig     ShardConfig
+	identifyDelay   time.Duration
+	newClient       ClientFactory
+	ratelimiter     *discordgo.RateLimiter
+	hubOnce         sync.Once
+	stopping        atomic.Bool
+	stopped         chan struct{}
+	stopOnce        sync.Once
+	hubURL          string
+	conn            *websocket.Conn
+	connMu          sync.Mutex
+	logger          *logger.StandardLoggerHandler
+	writer          *messageWriter
+	writeInterval   time.Duration
+	guilds          map[string]*discordgo.Guild
+	guildsMu        sync.RWMutex
+	backfilled      map[string]struct{}
+	memberSyncs     map[string]time.Time
+	commandsSynced  map[string]struct{}
+	dms             map[string]*discordgo.Channel
+	dmsMu           sync.RWMutex
+	subscriptions   *subscriptions
+	presences       *presences
+	presenceConfig  PresenceConfig
+	commands        map[string]*Command
+	componentRoutes map[string]ComponentHandler
+	routesMu        sync.RWMutex
+	onClose         chan struct{}
+	token           string
+	maxBufferCount  int
 }
 
-func NewBot(token string, dbManager *db.Manager) *Bot {
+func NewBot(token string, dbManager *db.Manager, keys *auth.Keys) *Bot {
 	b := &Bot{
-		db:             dbManager,
-		token:          token,
-		guilds:         make(map[string]*discordgo.Guild),
-		dms:            make(map[string]*discordgo.Channel),
-		subscribers:    make(map[string]string),
-		logger:         logger.NewLogger(os.Stderr),
-		onClose:        make(chan struct{}),
-		writeInterval:  300 * time.Second,
-		maxBufferCount: 100,
+		store:           store.NewMySQL(dbManager),
+		keys:            keys,
+		token:           token,
+		guilds:          make(map[string]*discordgo.Guild),
+		backfilled:      make(map[string]struct{}),
+		memberSyncs:     make(map[string]time.Time),
+		commandsSynced:  make(map[string]struct{}),
+		dms:             make(map[string]*discordgo.Channel),
+		subscriptions:   newSubscriptions(),
+		presences:       newPresences(),
+		commands:        make(map[string]*Command),
+		componentRoutes: make(map[string]ComponentHandler),
+		logger:          logger.NewLogger(os.Stderr),
+		onClose:         make(chan struct{}),
+		stopped:         make(chan struct{}),
+		writeInterval:   300 * time.Second,
+		maxBufferCount:  100,
+		hubURL:          defaultHubURL,
+		identifyDelay:   shardIdentifyInterval,
+		ratelimiter:     discordgo.NewRatelimiter(),
 	}
+	b.newClient = b.newGatewaySession
 	writer := newMessageWriter(b)
 	b.writer = writer
 	writer.start()
@@ -47,123 +92,176 @@ func NewBot(token string, dbManager *db.Manager) *Bot {
 }
 
 func (b *Bot) Start() error {
-	session, err := discordgo.New("Bot " + b.token)
-	if err != nil {
-		return err
+	if b.shardConfig.Count == 0 {
+		b.shardConfig = ShardConfig{IDs: []int{0}, Count: 1}
 	}
 
-	session.AddHandler(b.onReady)
-	session.AddHandler(b.onMessage)
-	session.AddHandler(b.onDisconnect)
-	
+	if b.presenceConfig.Enabled() {
+		go b.samplePresences()
+	}
 
-	session.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsGuildVoiceStates
+	go b.pruneHistory()
+	go b.pruneInteractionState()
 
-	err = session.Open()
-	if err != nil {
-		return err
+	if b.archive != nil {
+		go b.archiveHistory()
 	}
 
-	b.session = session
-
-	return nil
-}
+	shards := make([]*shard, 0, len(b.shardConfig.IDs))
 
-func (b *Bot) Stop() error {
-	if b.session != nil {
-		err := b.session.Close()
+	for _, id := range b.shardConfig.IDs {
+		session, err := b.connectShard(id)
 		if err != nil {
-			b.logger.Error("failed to close Discord session: %v", err)
 			return err
 		}
-	}
-
-	return nil
-}
 
-func (b *Bot) onDisconnect(_ *discordgo.Session, event *discordgo.Disconnect) {
-	b.logger.Error("Lost connection to Discord. Reconnecting...")
-
-	// Attempt to reconnect by reopening the connection
-	if err := b.session.Open(); err != nil {
-		b.logger.Error("failed to reconnect: %v", err)
+		shards = append(shards, &shard{session: session, id: id, state: ShardConnecting, since: time.Now().UTC()})
 	}
-}
-func (b *Bot) onReady(s *discordgo.Session, event *discordgo.Ready) {
-	b.logger.Debug("Bot is ready!")
 
-	if len(event.Guilds) > 1 {
-		for _, guild := range event.Guilds {
-			guildData, _ := s.Guild(guild.ID)
-			channels, _ := s.GuildChannels(guild.ID)
+	b.shardsMu.Lock()
+	b.shards = shards
+	b.shardsMu.Unlock()
 
-			b.guilds[guild.ID] = guildData
-			b.guilds[guild.ID].Channels = channels
+	for i, shard := range shards {
+		// Discord accepts one identify every few seconds.
+		if i > 0 {
+			time.Sleep(b.identifyDelay)
 		}
 
-		go func() {
-			if err := b.CreateOrUpdateGuildsAndChannels(); err != nil {
-				b.logger.Error("%v", err)
-			}
-		}()
-	}
+		b.setShardState(shard, ShardConnecting, 0)
 
-	if len(event.PrivateChannels) > 1 {
-		for _, channel := range event.PrivateChannels {
-			b.dms[channel.ID] = channel
+		if err := shard.session.Open(); err != nil {
+			return fmt.Errorf("failed to open shard %d: %w", shard.id, err)
 		}
 	}
 
-	go b.subscribeToWebSocket()
+	return nil
 }
 
-func (b *Bot) onMessage(_ *discordgo.Session, msg *discordgo.MessageCreate) {
-	if msg.Content == "" {
-		return
+// connectShard creates the client of a shard and registers the bot's handlers on it.
+func (b *Bot) connectShard(shardID int) (DiscordClient, error) {
+	intents := discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsGuildVoiceStates |
+		discordgo.IntentsGuildMessageReactions | discordgo.IntentsGuildMembers | discordgo.IntentsGuildMessageTyping |
+		discordgo.IntentsDirectMessages | discordgo.IntentsDirectMessageTyping | discordgo.IntentsMessageContent
+
+	if b.presenceConfig.Enabled() {
+		intents |= discordgo.IntentsGuildPresences
 	}
 
-	b.writer.AddMessage(msg)
+	session, err := b.newClient(shardID, b.shardConfig.Count, intents)
+	if err != nil {
+		return nil, err
+	}
 
-	for receiver, guildID := range b.subscribers {
-		b.logger.Debug("listening to guild %s, and with user id %s", msg.GuildID, receiver)
+	session.AddHandler(b.onReady)
+	session.AddHandler(b.onGuildCreate)
+	session.AddHandler(b.onGuildUpdate)
+	session.AddHandler(b.onGuildDelete)
+	session.AddHandler(b.onChannelCreate)
+	session.AddHandler(b.onChannelUpdate)
+	session.AddHandler(b.onChannelDelete)
+	session.AddHandler(b.onGuildMembersChunk)
+	session.AddHandler(b.onGuildMemberAdd)
+	session.AddHandler(b.onGuildMemberUpdate)
+	session.AddHandler(b.onGuildMemberRemove)
+	session.AddHandler(b.onGuildRoleCreate)
+	session.AddHandler(b.onGuildRoleUpdate)
+	session.AddHandler(b.onGuildRoleDelete)
+	session.AddHandler(b.onVoiceStateUpdate)
+	session.AddHandler(b.onPresenceUpdate)
+	session.AddHandler(b.onTypingStart)
+	session.AddHandler(b.onMessage)
+	session.AddHandler(b.onMessageUpdate)
+	session.AddHandler(b.onMessageDelete)
+	session.AddHandler(b.onMessageDeleteBulk)
+	session.AddHandler(b.onReactionAdd)
+	session.AddHandler(b.onReactionRemove)
+	session.AddHandler(b.onReactionRemoveAll)
+	session.AddHandler(b.onRawEvent)
+	session.AddHandler(b.onThreadCreate)
+	session.AddHandler(b.onThreadUpdate)
+	session.AddHandler(b.onThreadDelete)
+	session.AddHandler(b.onThreadListSync)
+	session.AddHandler(b.onThreadMembersUpdate)
+	session.AddHandler(b.onDisconnect)
+	session.AddHandler(b.onResumed)
+	session.AddHandler(b.onInteraction)
+
+	return session, nil
+}
 
-		if guildID == msg.GuildID {
-			b.sendJSONReponse(msg, &wshub.WSPayload{Action: "messages", MessageID: msg.ChannelID, Receiver: receiver})
+// Stop closes the shards and ends the background loops Start began. It is safe to call
+// more than once.
+func (b *Bot) Stop() error {
+	var closeErr error
+
+	b.stopping.Store(true)
+	b.stopOnce.Do(func() { close(b.stopped) })
+
+	for _, shard := range b.shardList() {
+		if err := shard.current().Close(); err != nil {
+			b.logger.Error("failed to close Discord session of shard %d: %v", shard.id, err)
+			closeErr = err
 		}
+
+		b.setShardState(shard, ShardDisconnected, 0)
 	}
+
+	return closeErr
 }
 
-func (b *Bot) sendMessageToChannel(channelID, message string) {
-	channel, err := b.session.Channel(channelID)
+// UserID returns the bot's own user ID, or "" before the bot is ready.
+func (b *Bot) UserID() string {
+	shards := b.shardList()
+	if len(shards) == 0 {
+		return ""
+	}
 
-	if err != nil {
-		b.logger.Error("sendMessageToChannel channel not found. Error: %v", err)
+	state := shards[0].current().SessionState()
+	if state == nil || state.User == nil {
+		return ""
 	}
 
-	_, err = b.session.ChannelMessageSend(channel.ID, message)
+	return state.User.ID
+}
 
-	if err != nil {
-		b.logger.Error("sendMessageToChannel error: %v", err)
+func (b *Bot) onReady(s *discordgo.Session, event *discordgo.Ready) {
+	b.logger.Debug("Bot is ready!")
+
+	if shard := b.shardOf(s); shard != nil {
+		b.setShardState(shard, ShardReady, 0)
 	}
-}
 
-func (b *Bot) sendDM(userId, message string) {
-	channel, err := b.session.UserChannelCreate(userId)
+	// Guilds arrive unavailable here; onGuildCreate caches and stores each of them.
+	for _, channel := range event.PrivateChannels {
+		b.storeDM(channel)
+	}
 
-	if err != nil {
-		b.logger.Error("sendDM couldn't create channel. Error: %v", err)
+	// Application commands are global to the bot, so only shard 0 syncs them.
+	go b.syncCommands(b.clientOf(s), b.claimCommandSync(event.Guilds...), s.ShardID == 0)
+	b.hubOnce.Do(func() { go b.subscribeToWebSocket() })
+}
+
+func (b *Bot) onMessage(_ *discordgo.Session, msg *discordgo.MessageCreate) {
+	// Messages with nothing in them, such as joins and pins, aren't worth storing.
+	if msg.Content == "" && len(msg.Attachments) == 0 && len(msg.Embeds) == 0 && len(msg.StickerItems) == 0 {
+		return
 	}
 
-	_, err = b.session.ChannelMessageSend(channel.ID, message)
+	b.writer.AddMessage(msg)
 
-	if err != nil {
-		b.logger.Error("sendDM error: %v", err)
+	if msg.GuildID == "" {
+		b.onDirectMessage(msg)
+		return
 	}
+
+	b.publish(msg, &wshub.WSPayload{Action: "messages", MessageID: msg.ChannelID},
+		topic(topicGuild, msg.GuildID), topic(topicThread, msg.ChannelID))
 }
 
 func (b *Bot) subscribeToWebSocket() {
 	for {
-		conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1/ws?type=D-BOT", nil)
+		conn, _, err := websocket.DefaultDialer.Dial(b.hubDialURL(), nil)
 		if err != nil {
 			b.logger.Info("Bot error - WebSocket connection error: %v", err)
 
@@ -173,10 +271,12 @@ func (b *Bot) subscribeToWebSocket() {
 			continue
 		}
 
+		b.connMu.Lock()
 		b.conn = conn
+		b.connMu.Unlock()
 
-		go b.handleWebSocketMessages()
-		go b.heartbeat()
+		go b.handleWebSocketMessages(conn)
+		go b.heartbeat(conn)
 
 		<-b.onClose
 
@@ -185,30 +285,34 @@ func (b *Bot) subscribeToWebSocket() {
 	}
 }
 
-func (b *Bot) heartbeat() {
+func (b *Bot) heartbeat(conn *websocket.Conn) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
 		ticker.Stop()
-		b.conn.Close()
+		conn.Close()
 	}()
 
 	for range ticker.C {
-		if err := b.conn.WriteJSON(&wshub.WSPayload{Action: wshub.Action[wshub.ServerAction](wshub.ClientHearbeat)}); err != nil {
+		b.connMu.Lock()
+		err := conn.WriteJSON(&wshub.WSPayload{Action: wshub.Action[wshub.ServerAction](wshub.ClientHearbeat)})
+		b.connMu.Unlock()
+
+		if err != nil {
 			return
 		}
 	}
 }
 
-func (b *Bot) handleWebSocketMessages() {
+func (b *Bot) handleWebSocketMessages(conn *websocket.Conn) {
 	for {
 		var wsPayload wshub.WSPayload
 
-		_, message, err := b.conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 
 		if err != nil {
 			b.logger.Error("bot error - reading message from WebSocket: %v", err)
 
-			b.conn.Close()
+			conn.Close()
 			b.onClose <- struct{}{}
 
 			return
@@ -223,11 +327,19 @@ func (b *Bot) handleWebSocketMessages() {
 
 		switch action {
 		case wshub.ClientJoin:
-			// b.sendJSONReponse(b.dms, &wshub.WSPayload{Action: wshub.ServerListDms})
-			b.sendJSONReponse(b.guilds, &wshub.WSPayload{Action: wshub.ServerListGuilds})
-		case wshub.ClientGuildMessage:
-			b.sendMessageToChannel(wsPayload.MessageID, wsPayload.Message)
+			b.sendJSONReponse(b.guildsFor(b.keys.Grant(wsPayload.Caller)), &wshub.WSPayload{
+				Action:   wshub.ServerListGuilds,
+				Receiver: wsPayload.Receiver,
+				Nonce:    wsPayload.Nonce,
+			})
+		case wshub.ClientGuildMessage, wshub.ClientComponentMessage:
+			b.sendFromWebSocket(&wsPayload, false)
 		case wshub.ClientSubscribeToGuild:
+			if err := b.authorizeTopic(b.keys.Grant(wsPayload.Caller), topic(topicGuild, wsPayload.Message)); err != nil {
+				b.sendError(&wsPayload, err)
+				continue
+			}
+
 			msgs := make([]*discordgo.MessageCreate, 0)
 
 			for _, msg := range b.writer.WriteBuffer {
@@ -237,15 +349,51 @@ func (b *Bot) handleWebSocketMessages() {
 			}
 
 			b.sendJSONReponse(msgs, &wshub.WSPayload{Action: "messages", Receiver: wsPayload.Receiver})
-			b.subscribers[wsPayload.Receiver] = wsPayload.Message
+			b.subscriptions.replace(wsPayload.Receiver, topic(topicGuild, wsPayload.Message))
+		case wshub.ClientSubscribe:
+			if err := b.authorizeTopic(b.keys.Grant(wsPayload.Caller), wsPayload.Message); err != nil {
+				b.sendError(&wsPayload, err)
+				continue
+			}
+
+			b.subscriptions.subscribe(wsPayload.Receiver, wsPayload.Message)
+		case wshub.ClientUnsubscribe:
+			b.subscriptions.unsubscribe(wsPayload.Receiver, wsPayload.Message)
 		case wshub.ClientLeave:
-			delete(b.subscribers, wsPayload.Message)
+			b.subscriptions.unsubscribeAll(wsPayload.Message)
 		case wshub.ClientDmMessage:
-			b.sendDM(wsPayload.MessageID, wsPayload.Message)
+			b.sendFromWebSocket(&wsPayload, true)
+		case wshub.ClientListDms:
+			b.listDMs(&wsPayload)
+		case wshub.ClientTyping:
+			if err := b.SendTyping(b.keys.Grant(wsPayload.Caller), wsPayload.Message); err != nil {
+				b.sendError(&wsPayload, err)
+			}
+		case wshub.ClientEditMessage, wshub.ClientDeleteMessage, wshub.ClientPinMessage,
+			wshub.ClientUnpinMessage, wshub.ClientAddReaction, wshub.ClientRemoveReaction:
+			b.handleMessageAction(&wsPayload)
+		case wshub.ClientSearch:
+			b.handleSearch(&wsPayload)
 		}
 	}
 }
 
+// requestError tells a web client why its request failed.
+type requestError struct {
+	Action string `json:"action"`
+	Error  string `json:"error"`
+}
+
+func (b *Bot) sendError(request *wshub.WSPayload, err error) {
+	b.logger.Error("%s failed: %v", request.Action, err)
+
+	b.sendJSONReponse(requestError{Action: string(request.Action), Error: err.Error()}, &wshub.WSPayload{
+		Action:   wshub.ServerError,
+		Receiver: request.Receiver,
+		Nonce:    request.Nonce,
+	})
+}
+
 func (b *Bot) sendJSONReponse(toMarshal interface{}, wsReponse *wshub.WSPayload) {
 	message, err := json.Marshal(toMarshal)
 	if err != nil {
@@ -253,11 +401,20 @@ func (b *Bot) sendJSONReponse(toMarshal interface{}, wsReponse *wshub.WSPayload)
 		return
 	}
 
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if b.conn == nil {
+		return
+	}
+
 	err = b.conn.WriteJSON(wshub.WSPayload{
 		Action:    wsReponse.Action,
 		Message:   string(message),
 		MessageID: wsReponse.MessageID,
 		Receiver:  wsReponse.Receiver,
+		Nonce:     wsReponse.Nonce,
+		GuildID:   wsReponse.GuildID,
 	})
 
 	if err != nil {
@@ -0,0 +1,633 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/db/dbtest"
+	"discord-go-connect/internal/discord/discordtest"
+	"discord-go-connect/internal/logger"
+	"discord-go-connect/internal/store"
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+const testTimeout = 3 * time.Second
+
+var _ DiscordClient = (*discordtest.Session)(nil)
+
+var botUser = &discordgo.User{ID: "900", Username: "bot", Bot: true}
+
+// testBot is a bot connected to a fake gateway, a recording database and a stub hub.
+type testBot struct {
+	*Bot
+	gateway *discordtest.Gateway
+	db      *dbtest.Recorder
+	hub     *stubHub
+}
+
+func newTestBot(t *testing.T, shards ShardConfig) *testBot {
+	t.Helper()
+
+	keys, err := auth.NewKeys("")
+	if err != nil {
+		t.Fatalf("failed to create keys: %v", err)
+	}
+
+	manager, rec := dbtest.NewRecorder(t)
+	gateway := discordtest.NewGateway(botUser)
+	hub := newStubHub(t)
+
+	bot := NewBot("token", manager, keys)
+	bot.logger = logger.NewLogger(io.Discard)
+	bot.identifyDelay = 0
+	bot.ConfigureShards(shards)
+	bot.UseHub(hub.url)
+	bot.UseClientFactory(func(shardID, shardCount int, intents discordgo.Intent) (DiscordClient, error) {
+		return gateway.Connect(shardID, shardCount, intents), nil
+	})
+
+	if err := bot.Start(); err != nil {
+		t.Fatalf("failed to start bot: %v", err)
+	}
+
+	t.Cleanup(func() { _ = bot.Stop() })
+
+	return &testBot{Bot: bot, gateway: gateway, db: rec, hub: hub}
+}
+
+// ready emits Ready and the guild on the shard it belongs to, then waits for the bot to
+// connect to the hub.
+func (tb *testBot) ready(t *testing.T, guilds ...*discordgo.Guild) {
+	t.Helper()
+
+	for _, id := range tb.shardConfig.IDs {
+		tb.gateway.Ready(id)
+	}
+
+	for _, guild := range guilds {
+		tb.gateway.Emit(shardFor(guild.ID, tb.shardConfig.Count), &discordgo.GuildCreate{Guild: guild})
+	}
+
+	tb.hub.waitForBot(t)
+}
+
+// request sends a client request to the bot and waits until the bot has handled it, by
+// following it with a join that the bot answers in order.
+func (tb *testBot) request(t *testing.T, payload wshub.WSPayload) {
+	t.Helper()
+
+	tb.hub.send(t, payload)
+	tb.hub.send(t, wshub.WSPayload{Action: asRequest(wshub.ClientJoin), Receiver: "sync"})
+	tb.hub.expect(t, wshub.ServerListGuilds, "sync")
+}
+
+// stubHub accepts the bot's hub connection and records what the bot sends.
+type stubHub struct {
+	url      string
+	conns    chan *websocket.Conn
+	conn     *websocket.Conn
+	received chan wshub.WSPayload
+}
+
+func newStubHub(t *testing.T) *stubHub {
+	t.Helper()
+
+	hub := &stubHub{conns: make(chan *websocket.Conn, 1), received: make(chan wshub.WSPayload, 256)}
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		hub.conns <- conn
+
+		for {
+			var payload wshub.WSPayload
+			if err := conn.ReadJSON(&payload); err != nil {
+				return
+			}
+
+			hub.received <- payload
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	hub.url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	return hub
+}
+
+func (h *stubHub) waitForBot(t *testing.T) {
+	t.Helper()
+
+	if h.conn != nil {
+		return
+	}
+
+	select {
+	case h.conn = <-h.conns:
+	case <-time.After(testTimeout):
+		t.Fatal("bot didn't connect to the hub")
+	}
+}
+
+func (h *stubHub) send(t *testing.T, payload wshub.WSPayload) {
+	t.Helper()
+
+	if err := h.conn.WriteJSON(payload); err != nil {
+		t.Fatalf("failed to send to bot: %v", err)
+	}
+}
+
+// expect returns the next payload with an action for a receiver, skipping others.
+func (h *stubHub) expect(t *testing.T, action wshub.Action[wshub.ServerAction], receiver string) wshub.WSPayload {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+
+	for {
+		select {
+		case payload := <-h.received:
+			if payload.Action == action && payload.Receiver == receiver {
+				return payload
+			}
+		case <-deadline:
+			t.Fatalf("no %s for %q", action, receiver)
+		}
+	}
+}
+
+// expectNone fails if a payload with an action for a receiver arrives within a moment.
+func (h *stubHub) expectNone(t *testing.T, action wshub.Action[wshub.ServerAction], receiver string) {
+	t.Helper()
+
+	deadline := time.After(200 * time.Millisecond)
+
+	for {
+		select {
+		case payload := <-h.received:
+			if payload.Action == action && payload.Receiver == receiver {
+				t.Fatalf("unexpected %s for %q: %s", action, receiver, payload.Message)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// asRequest types a client action the way WSPayload carries it.
+func asRequest(action wshub.Action[wshub.ClientAction]) wshub.Action[wshub.ServerAction] {
+	return wshub.Action[wshub.ServerAction](action)
+}
+
+// eventually polls a condition until it holds or the test times out.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testGuild(id string, channelIDs ...string) *discordgo.Guild {
+	guild := &discordgo.Guild{ID: id, Name: "guild " + id}
+
+	for i, channelID := range channelIDs {
+		guild.Channels = append(guild.Channels, &discordgo.Channel{
+			ID: channelID, GuildID: id, Name: "channel-" + channelID, Type: discordgo.ChannelTypeGuildText, Position: i,
+		})
+	}
+
+	return guild
+}
+
+func testMessage(id, guildID, channelID, content string) *discordgo.MessageCreate {
+	message := &discordgo.Message{
+		ID:        id,
+		ChannelID: channelID,
+		GuildID:   guildID,
+		Content:   content,
+		Author:    &discordgo.User{ID: "42", Username: "alice"},
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	if guildID != "" {
+		message.Member = &discordgo.Member{Nick: "Al"}
+	}
+
+	return &discordgo.MessageCreate{Message: message}
+}
+
+func TestReadySyncsCommandsAndIdentifiesBot(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	if got := tb.UserID(); got != botUser.ID {
+		t.Fatalf("UserID() = %q, want %q", got, botUser.ID)
+	}
+
+	eventually(t, "command sync", func() bool {
+		return len(tb.gateway.Calls("ApplicationCommandBulkOverwrite")) > 0
+	})
+
+	if calls := tb.gateway.Calls("RequestGuildMembers"); len(calls) != 1 || calls[0].Args[0] != "1" {
+		t.Fatalf("RequestGuildMembers calls = %+v, want one for guild 1", calls)
+	}
+}
+
+func TestOnMessagePublishesToSubscribers(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10", "11"))
+
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicGuild, "1"), Receiver: "guild-follower"})
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicThread, "11"), Receiver: "thread-follower"})
+
+	tb.gateway.Emit(0, testMessage("100", "1", "10", "hello"))
+
+	payload := tb.hub.expect(t, "messages", "guild-follower")
+	if payload.MessageID != "10" {
+		t.Fatalf("MessageID = %q, want channel 10", payload.MessageID)
+	}
+
+	var msg discordgo.MessageCreate
+	if err := json.Unmarshal([]byte(payload.Message), &msg); err != nil || msg.Content != "hello" {
+		t.Fatalf("message = %q (%v), want hello", payload.Message, err)
+	}
+
+	tb.hub.expectNone(t, "messages", "thread-follower")
+
+	tb.gateway.Emit(0, testMessage("101", "1", "11", "in thread"))
+	tb.hub.expect(t, "messages", "guild-follower")
+	tb.hub.expect(t, "messages", "thread-follower")
+
+	// A message with only an attachment counts; one with nothing in it doesn't.
+	attachment := testMessage("102", "1", "10", "")
+	attachment.Attachments = []*discordgo.MessageAttachment{{ID: "500", Filename: "cat.png"}}
+	tb.gateway.Emit(0, attachment)
+	tb.hub.expect(t, "messages", "guild-follower")
+
+	tb.gateway.Emit(0, testMessage("103", "1", "10", ""))
+	tb.hub.expectNone(t, "messages", "guild-follower")
+}
+
+func TestUnsubscribeAndLeaveStopEvents(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicGuild, "1"), Receiver: "a"})
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicThread, "10"), Receiver: "a"})
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicGuild, "1"), Receiver: "b"})
+
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientUnsubscribe), Message: topic(topicGuild, "1"), Receiver: "a"})
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientLeave), Message: "b"})
+
+	tb.gateway.Emit(0, testMessage("100", "1", "10", "hello"))
+
+	tb.hub.expect(t, "messages", "a")
+	tb.hub.expectNone(t, "messages", "a")
+	tb.hub.expectNone(t, "messages", "b")
+}
+
+func TestSubscribeRejectsInvalidTopics(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.hub.send(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: "nonsense", Receiver: "a"})
+
+	payload := tb.hub.expect(t, wshub.ServerError, "a")
+	if !strings.Contains(payload.Message, errInvalidTopic.Error()) {
+		t.Fatalf("error = %s, want %q", payload.Message, errInvalidTopic)
+	}
+}
+
+func TestSubscriptionsReplaceKeepsOtherKinds(t *testing.T) {
+	subs := newSubscriptions()
+	subs.subscribe("a", topic(topicGuild, "1"))
+	subs.subscribe("a", topic(topicThread, "10"))
+	subs.replace("a", topic(topicGuild, "2"))
+
+	if got := subs.receivers(topic(topicGuild, "1")); len(got) != 0 {
+		t.Fatalf("receivers of guild 1 = %v, want none", got)
+	}
+
+	if got := subs.receivers(topic(topicGuild, "2"), topic(topicThread, "10")); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("receivers = %v, want [a] once", got)
+	}
+}
+
+func TestShardsHandleTheirGuilds(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0, 1}, Count: 2})
+
+	// Guild ids are routed by (id >> 22) % count.
+	guild0, guild1 := testGuild("0", "10"), testGuild("4194304", "20")
+	tb.ready(t, guild0, guild1)
+
+	for _, call := range tb.gateway.Calls("RequestGuildMembers") {
+		if want := shardFor(call.Args[0].(string), 2); call.Shard != want {
+			t.Fatalf("members of guild %v requested on shard %d, want %d", call.Args[0], call.Shard, want)
+		}
+	}
+
+	if got := len(tb.guildsFor(auth.Unrestricted)); got != 2 {
+		t.Fatalf("cached guilds = %d, want 2", got)
+	}
+
+	statuses := tb.ShardStatuses()
+	if len(statuses) != 2 || statuses[1].Guilds != 1 || statuses[1].State != ShardReady {
+		t.Fatalf("statuses = %+v, want two ready shards with one guild each", statuses)
+	}
+
+	want := discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+	if intents := tb.gateway.Shard(1).Intents(); intents&want != want {
+		t.Fatalf("intents = %b, want guild messages with their content", intents)
+	}
+}
+
+func TestDisconnectedShardResumes(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t)
+
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicGateway, gatewayAll), Receiver: "admin"})
+
+	tb.gateway.Disconnect(0)
+	tb.hub.expect(t, wshub.ServerShardState, "admin")
+
+	if state := tb.ShardStatuses()[0].State; state != ShardDisconnected && state != ShardResuming {
+		t.Fatalf("state = %s, want disconnected or resuming", state)
+	}
+
+	eventually(t, "the shard to reopen", tb.gateway.Shard(0).IsOpen)
+
+	tb.gateway.Emit(0, &discordgo.Resumed{})
+
+	if state := tb.ShardStatuses()[0].State; state != ShardReady {
+		t.Fatalf("state = %s, want ready", state)
+	}
+}
+
+func TestSendMessageChecksPermissions(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.gateway.SetPermissions("10", discordgo.PermissionViewChannel)
+
+	if _, err := tb.SendMessage(auth.Unrestricted, "10", &OutboundMessage{Content: "hi"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("err = %v, want ErrForbidden", err)
+	}
+
+	tb.gateway.SetPermissions("10", discordgo.PermissionViewChannel|discordgo.PermissionSendMessages)
+
+	sent, err := tb.SendMessage(auth.Unrestricted, "10", &OutboundMessage{Content: "hi"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	if messages := tb.gateway.Messages("10"); len(messages) != 1 || messages[0].ID != sent.ID || messages[0].Content != "hi" {
+		t.Fatalf("channel messages = %+v, want the sent one", messages)
+	}
+
+	if _, err := tb.SendMessage(auth.Unrestricted, "99", &OutboundMessage{Content: "hi"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSendMessageWithStickers(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+	tb.gateway.SetPermissions("10", discordgo.PermissionSendMessages)
+
+	// Uploads lock the bucket on the session's limiter, so a second one only goes out
+	// once the first released it.
+	for i := 0; i < 2; i++ {
+		msg := &OutboundMessage{StickerIDs: []string{"900"}, Files: []*OutboundFile{{Name: "a.txt", Data: []byte("a")}}}
+		if _, err := tb.SendMessage(auth.Unrestricted, "10", msg); err != nil {
+			t.Fatalf("SendMessage with a file: %v", err)
+		}
+	}
+
+	if _, err := tb.SendMessage(auth.Unrestricted, "10", &OutboundMessage{StickerIDs: []string{"900"}}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	endpoint := discordgo.EndpointChannelMessages("10")
+
+	if calls := tb.gateway.Calls("RequestWithLockedBucket"); len(calls) != 2 || calls[0].Args[1] != endpoint {
+		t.Fatalf("uploads = %+v, want two to %s", calls, endpoint)
+	}
+
+	if calls := tb.gateway.Calls("RequestWithBucketID"); len(calls) != 1 || calls[0].Args[1] != endpoint {
+		t.Fatalf("sends = %+v, want one to %s", calls, endpoint)
+	}
+}
+
+func TestSendFromWebSocketRepliesWithSentMessage(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+	tb.gateway.SetPermissions("10", discordgo.PermissionSendMessages)
+
+	tb.hub.send(t, wshub.WSPayload{
+		Action: asRequest(wshub.ClientGuildMessage), MessageID: "10", Message: "from the web", Receiver: "a", Nonce: "n1",
+	})
+
+	payload := tb.hub.expect(t, wshub.ServerMessageSent, "a")
+	if payload.Nonce != "n1" {
+		t.Fatalf("nonce = %q, want n1", payload.Nonce)
+	}
+
+	if calls := tb.gateway.Calls("ChannelMessageSendComplex"); len(calls) != 1 || calls[0].Args[0] != "10" {
+		t.Fatalf("sends = %+v, want one to channel 10", calls)
+	}
+}
+
+func TestComponentMessageAcceptsDataChannel(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+	tb.gateway.SetPermissions("10", discordgo.PermissionSendMessages)
+
+	tb.hub.send(t, wshub.WSPayload{
+		Action: asRequest(wshub.ClientComponentMessage), Receiver: "a",
+		Data: json.RawMessage(`{"channel_id": "10", "content": "pick one", "components": [
+			{"type": 1, "components": [{"type": 2, "style": 1, "label": "Yes", "custom_id": "yes"}]}
+		]}`),
+	})
+
+	tb.hub.expect(t, wshub.ServerMessageSent, "a")
+
+	if calls := tb.gateway.Calls("ChannelMessageSendComplex"); len(calls) != 1 || calls[0].Args[0] != "10" {
+		t.Fatalf("sends = %+v, want one to channel 10", calls)
+	}
+}
+
+func TestDMComponentsOnlyReachDMFollowers(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicGuild, "1"), Receiver: "guild-follower"})
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicDM, dmInbox), Receiver: "inbox"})
+
+	tb.gateway.Emit(0, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID: "700", Type: discordgo.InteractionMessageComponent, ChannelID: "50",
+		User: &discordgo.User{ID: "42", Username: "carol"},
+		Data: discordgo.MessageComponentInteractionData{CustomID: "choice", Values: []string{"secret"}},
+	}})
+
+	payload := tb.hub.expect(t, wshub.ServerComponentInteraction, "inbox")
+	if payload.MessageID != "50" || !strings.Contains(payload.Message, "secret") {
+		t.Fatalf("inbox got %+v, want the component of DM 50", payload)
+	}
+
+	tb.hub.expectNone(t, wshub.ServerComponentInteraction, "guild-follower")
+	tb.hub.expectNone(t, wshub.ServerComponentInteraction, "")
+}
+
+func TestThreadListSyncArchivesStaleThreads(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.gateway.Emit(0, &discordgo.ThreadListSync{GuildID: "1", ChannelIDs: []string{"10"}})
+
+	if stale := tb.db.Executions("UPDATE Channel SET archived = TRUE WHERE parent_id"); len(stale) != 1 || stale[0].Args[0] != "10" {
+		t.Fatalf("channel sync archived %+v, want the threads of channel 10", stale)
+	}
+
+	tb.gateway.Emit(0, &discordgo.ThreadListSync{GuildID: "1"})
+
+	if stale := tb.db.Executions("UPDATE Channel SET archived = TRUE WHERE guild_id"); len(stale) != 1 || stale[0].Args[0] != "1" {
+		t.Fatalf("guild sync archived %+v, want the threads of guild 1", stale)
+	}
+}
+
+func TestGuildUpdateKeepsCachedMembers(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+
+	guild := testGuild("1", "10")
+	guild.Members = []*discordgo.Member{{GuildID: "1", User: &discordgo.User{ID: "42", Username: "alice"}}}
+	guild.VoiceStates = []*discordgo.VoiceState{{GuildID: "1", ChannelID: "10", UserID: "42"}}
+	tb.ready(t, guild)
+
+	tb.gateway.Emit(0, &discordgo.GuildUpdate{Guild: &discordgo.Guild{ID: "1", Name: "renamed"}})
+
+	tb.guildsMu.RLock()
+	cached := tb.guilds["1"]
+	tb.guildsMu.RUnlock()
+
+	if cached.Name != "renamed" || len(cached.Members) != 1 || len(cached.VoiceStates) != 1 || len(cached.Channels) != 1 {
+		t.Fatalf("cached guild = %+v, want the new name with the members, voice states and channels kept", cached)
+	}
+}
+
+func TestChannelPermissionOverwritesArePersisted(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.gateway.Emit(0, &discordgo.ChannelCreate{Channel: &discordgo.Channel{
+		ID: "11", GuildID: "1", Name: "private", Type: discordgo.ChannelTypeGuildText,
+		PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			{ID: "1", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+			{ID: "42", Type: discordgo.PermissionOverwriteTypeMember, Allow: discordgo.PermissionViewChannel},
+		},
+	}})
+
+	overwrites := tb.db.Executions("INSERT INTO ChannelOverwrite")
+	if len(overwrites) != 2 || overwrites[0].Args[0] != "11" || overwrites[1].Args[1] != "42" {
+		t.Fatalf("stored overwrites %+v, want both overwrites of channel 11", overwrites)
+	}
+
+	tb.gateway.Emit(0, &discordgo.ChannelDelete{Channel: &discordgo.Channel{ID: "11", GuildID: "1"}})
+
+	deletes := 0
+
+	for _, exec := range tb.db.Executions("DELETE FROM ChannelOverwrite WHERE channel_id") {
+		if exec.Args[0] == "11" {
+			deletes++
+		}
+	}
+
+	if deletes != 2 {
+		t.Fatalf("got %d overwrite deletes of channel 11, want one on create and one on delete", deletes)
+	}
+}
+
+func TestTypingReachesGuildAndDMFollowers(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicGuild, "1"), Receiver: "guild-follower"})
+	tb.request(t, wshub.WSPayload{Action: asRequest(wshub.ClientSubscribe), Message: topic(topicDM, dmInbox), Receiver: "inbox"})
+
+	tb.gateway.Emit(0, &discordgo.TypingStart{GuildID: "1", ChannelID: "10", UserID: "42"})
+
+	if payload := tb.hub.expect(t, wshub.ServerTyping, "guild-follower"); payload.MessageID != "10" {
+		t.Fatalf("guild follower got %+v, want typing in channel 10", payload)
+	}
+
+	tb.hub.expectNone(t, wshub.ServerTyping, "inbox")
+
+	tb.gateway.Emit(0, &discordgo.TypingStart{ChannelID: "50", UserID: "42"})
+
+	if payload := tb.hub.expect(t, wshub.ServerTyping, "inbox"); payload.MessageID != "50" {
+		t.Fatalf("inbox got %+v, want typing in DM 50", payload)
+	}
+
+	tb.hub.expectNone(t, wshub.ServerTyping, "guild-follower")
+}
+
+// channelRulesFirst lists the retention rules of channels before those of their guilds.
+type channelRulesFirst struct {
+	*store.Memory
+}
+
+func (s channelRulesFirst) RetentionRules() ([]store.RetentionRule, error) {
+	rules, err := s.Memory.RetentionRules()
+
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].ChannelID != "" && rules[j].ChannelID == "" })
+
+	return rules, err
+}
+
+func TestChannelRetentionRulesOverrideTheirGuild(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+
+	history := store.NewMemory()
+	tb.UseStore(channelRulesFirst{history})
+
+	_, _ = history.SaveMessages([]*discordgo.MessageCreate{
+		testMessage("100", "1", "10", "kept"), testMessage("101", "1", "11", "pruned"),
+	})
+
+	for _, rule := range []*store.RetentionRule{
+		{GuildID: "1", Mode: store.KeepDays, Amount: 1},
+		{GuildID: "1", ChannelID: "10", Mode: store.KeepForever},
+	} {
+		if err := history.SaveRetentionRule(rule); err != nil {
+			t.Fatalf("failed to save rule: %v", err)
+		}
+	}
+
+	if pruned := tb.EnforceRetention(); pruned != 1 {
+		t.Fatalf("pruned %d messages, want only the one of channel 11", pruned)
+	}
+
+	if known, _ := history.KnownMessages([]string{"100", "101"}); !known["100"] || known["101"] {
+		t.Errorf("known messages = %v, want only 100", known)
+	}
+}
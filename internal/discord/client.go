@@ -0,0 +1,98 @@
+package discord
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// DiscordClient is the part of a gateway session the bot uses. Every shard has one; in
+// production it wraps a *discordgo.Session, tests use the fake in discordtest.
+//
+// Event handlers are registered with AddHandler and keep discordgo's signature. The
+// *discordgo.Session they receive only identifies the shard and its state; calls go
+// through the shard's DiscordClient instead.
+type DiscordClient interface {
+	Open() error
+	Close() error
+	AddHandler(handler interface{}) func()
+	HeartbeatLatency() time.Duration
+	// SessionState is the cache discordgo keeps from gateway events.
+	SessionState() *discordgo.State
+	// RateLimiter is the limiter the REST requests of the session wait on.
+	RateLimiter() *discordgo.RateLimiter
+	RequestGuildMembers(guildID, query string, limit int, nonce string, presences bool) error
+
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	GuildChannels(guildID string, options ...discordgo.RequestOption) ([]*discordgo.Channel, error)
+	GuildThreadsActive(guildID string, options ...discordgo.RequestOption) (*discordgo.ThreadsList, error)
+	ThreadsArchived(channelID string, before *time.Time, limit int, options ...discordgo.RequestOption) (*discordgo.ThreadsList, error)
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserChannelPermissions(userID, channelID string, options ...discordgo.RequestOption) (int64, error)
+	ChannelTyping(channelID string, options ...discordgo.RequestOption) error
+	ChannelMessage(channelID, messageID string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEditComplex(data *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessagePin(channelID, messageID string, options ...discordgo.RequestOption) error
+	ChannelMessageUnpin(channelID, messageID string, options ...discordgo.RequestOption) error
+	MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error
+	ApplicationCommandBulkOverwrite(appID, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	InteractionResponseEdit(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	FollowupMessageCreate(interaction *discordgo.Interaction, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	RequestWithBucketID(method, urlStr string, data interface{}, bucketID string, options ...discordgo.RequestOption) ([]byte, error)
+	RequestWithLockedBucket(method, urlStr, contentType string, body []byte, bucket *discordgo.Bucket, sequence int, options ...discordgo.RequestOption) ([]byte, error)
+}
+
+// ClientFactory creates the client of one shard. Intents are the gateway intents the
+// bot needs for its handlers.
+type ClientFactory func(shardID, shardCount int, intents discordgo.Intent) (DiscordClient, error)
+
+// gatewaySession adapts a *discordgo.Session to DiscordClient.
+type gatewaySession struct {
+	*discordgo.Session
+}
+
+func (s gatewaySession) SessionState() *discordgo.State {
+	return s.State
+}
+
+func (s gatewaySession) RateLimiter() *discordgo.RateLimiter {
+	return s.Ratelimiter
+}
+
+// UseClientFactory replaces the discordgo sessions the bot connects with, so that it can
+// run against a fake gateway. It must be called before Start.
+func (b *Bot) UseClientFactory(factory ClientFactory) {
+	b.newClient = factory
+}
+
+// newGatewaySession connects a shard to Discord.
+func (b *Bot) newGatewaySession(shardID, shardCount int, intents discordgo.Intent) (DiscordClient, error) {
+	session, err := discordgo.New("Bot " + b.token)
+	if err != nil {
+		return nil, err
+	}
+
+	session.ShardID = shardID
+	session.ShardCount = shardCount
+	session.Identify.Intents = intents
+	// The supervisor reconnects shards; discordgo's own loop would race with it.
+	session.ShouldReconnectOnError = false
+	// Shards share one REST rate limiter, as Discord counts requests per bot.
+	session.Ratelimiter = b.ratelimiter
+
+	return gatewaySession{Session: session}, nil
+}
+
+// clientOf returns the client of the shard an event arrived on.
+func (b *Bot) clientOf(s *discordgo.Session) DiscordClient {
+	if shard := b.shardOf(s); shard != nil {
+		return shard.current()
+	}
+
+	return b.sessionFor("")
+}
@@ -0,0 +1,267 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CommandHandler handles an invoked slash command or subcommand.
+type CommandHandler func(ctx *CommandContext) error
+
+// AutocompleteHandler returns the choices offered for the focused option.
+type AutocompleteHandler func(ctx *CommandContext) ([]*discordgo.ApplicationCommandOptionChoice, error)
+
+// Command describes a slash command. Nested Subcommands turn it into a
+// subcommand group, in which case the parent Handler is never invoked.
+type Command struct {
+	Handler      CommandHandler
+	Autocomplete AutocompleteHandler
+	Name         string
+	Description  string
+	Options      []*discordgo.ApplicationCommandOption
+	Subcommands  []*Command
+	// GuildIDs limits the command to the given guilds. An empty list registers it globally.
+	GuildIDs []string
+}
+
+// maxAutocompleteChoices is the number of choices Discord accepts in an autocomplete result.
+const maxAutocompleteChoices = 25
+
+var errUnknownCommand = errors.New("unknown command")
+
+// AddCommand registers a slash command. Commands are synced with Discord when the bot
+// becomes ready or joins a guild, so AddCommand should be called before Start.
+func (b *Bot) AddCommand(cmd *Command) {
+	b.routesMu.Lock()
+	defer b.routesMu.Unlock()
+
+	b.commands[cmd.Name] = cmd
+}
+
+func (b *Bot) lookupCommand(name string) (*Command, bool) {
+	b.routesMu.RLock()
+	defer b.routesMu.RUnlock()
+
+	cmd, ok := b.commands[name]
+
+	return cmd, ok
+}
+
+// claimCommandSync returns the IDs of the guilds whose commands weren't synced yet and
+// marks them as synced, so each guild is synced once however many events announce it.
+func (b *Bot) claimCommandSync(guilds ...*discordgo.Guild) []string {
+	b.guildsMu.Lock()
+	defer b.guildsMu.Unlock()
+
+	claimed := make([]string, 0, len(guilds))
+
+	for _, guild := range guilds {
+		if _, done := b.commandsSynced[guild.ID]; done {
+			continue
+		}
+
+		b.commandsSynced[guild.ID] = struct{}{}
+		claimed = append(claimed, guild.ID)
+	}
+
+	return claimed
+}
+
+// syncCommands overwrites the per-guild commands, and the global ones if asked to, with
+// the registered ones. Bulk overwriting removes any command Discord knows about that is
+// no longer defined. Guilds that fail are synced again when they next arrive.
+func (b *Bot) syncCommands(s DiscordClient, guildIDs []string, syncGlobal bool) {
+	appID := s.SessionState().User.ID
+	global, perGuild := b.commandDefinitions()
+
+	if syncGlobal {
+		if _, err := s.ApplicationCommandBulkOverwrite(appID, "", global); err != nil {
+			b.logger.Error("failed to sync global commands: %v", err)
+		}
+	}
+
+	for _, guildID := range guildIDs {
+		definitions, ok := perGuild[guildID]
+		if !ok {
+			definitions = []*discordgo.ApplicationCommand{}
+		}
+
+		if _, err := s.ApplicationCommandBulkOverwrite(appID, guildID, definitions); err != nil {
+			b.logger.Error("failed to sync commands for guild %s: %v", guildID, err)
+
+			b.guildsMu.Lock()
+			delete(b.commandsSynced, guildID)
+			b.guildsMu.Unlock()
+		}
+	}
+}
+
+func (b *Bot) commandDefinitions() (global []*discordgo.ApplicationCommand, perGuild map[string][]*discordgo.ApplicationCommand) {
+	b.routesMu.RLock()
+	defer b.routesMu.RUnlock()
+
+	global = make([]*discordgo.ApplicationCommand, 0, len(b.commands))
+	perGuild = make(map[string][]*discordgo.ApplicationCommand)
+
+	for _, cmd := range b.commands {
+		definition := &discordgo.ApplicationCommand{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Options:     cmd.options(),
+		}
+
+		if len(cmd.GuildIDs) == 0 {
+			global = append(global, definition)
+			continue
+		}
+
+		for _, guildID := range cmd.GuildIDs {
+			perGuild[guildID] = append(perGuild[guildID], definition)
+		}
+	}
+
+	return global, perGuild
+}
+
+func (c *Command) options() []*discordgo.ApplicationCommandOption {
+	if len(c.Subcommands) == 0 {
+		// Definitions are built by concurrent syncs, so the registered options are copied
+		// rather than flagged in place.
+		options := make([]*discordgo.ApplicationCommandOption, 0, len(c.Options))
+
+		for _, option := range c.Options {
+			if c.Autocomplete != nil && len(option.Choices) == 0 && isAutocompletable(option.Type) {
+				flagged := *option
+				flagged.Autocomplete = true
+				option = &flagged
+			}
+
+			options = append(options, option)
+		}
+
+		return options
+	}
+
+	options := make([]*discordgo.ApplicationCommandOption, 0, len(c.Subcommands))
+
+	for _, sub := range c.Subcommands {
+		optionType := discordgo.ApplicationCommandOptionSubCommand
+		if len(sub.Subcommands) > 0 {
+			optionType = discordgo.ApplicationCommandOptionSubCommandGroup
+		}
+
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        optionType,
+			Name:        sub.Name,
+			Description: sub.Description,
+			Options:     sub.options(),
+		})
+	}
+
+	return options
+}
+
+func isAutocompletable(optionType discordgo.ApplicationCommandOptionType) bool {
+	switch optionType {
+	case discordgo.ApplicationCommandOptionString,
+		discordgo.ApplicationCommandOptionInteger,
+		discordgo.ApplicationCommandOptionNumber:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolve walks the subcommand path of an invocation and returns the command that
+// should handle it together with the options passed to that command.
+func (c *Command) resolve(
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+) (*Command, []*discordgo.ApplicationCommandInteractionDataOption, error) {
+	if len(c.Subcommands) == 0 {
+		return c, options, nil
+	}
+
+	for _, option := range options {
+		if option.Type != discordgo.ApplicationCommandOptionSubCommand &&
+			option.Type != discordgo.ApplicationCommandOptionSubCommandGroup {
+			continue
+		}
+
+		for _, sub := range c.Subcommands {
+			if sub.Name == option.Name {
+				return sub.resolve(option.Options)
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s", errUnknownCommand, c.Name)
+}
+
+func (b *Bot) handleCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+
+	root, ok := b.lookupCommand(data.Name)
+	if !ok {
+		b.logger.Error("received unknown command %s", data.Name)
+		return
+	}
+
+	cmd, options, err := root.resolve(data.Options)
+	if err != nil {
+		b.logger.Error("%v", err)
+		return
+	}
+
+	ctx := newCommandContext(b.clientOf(s), i, options)
+
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		b.handleAutocomplete(ctx, cmd)
+		return
+	}
+
+	if cmd.Handler == nil {
+		b.logger.Error("command %s has no handler", data.Name)
+		return
+	}
+
+	if err := cmd.Handler(ctx); err != nil {
+		b.logger.Error("command %s failed: %v", data.Name, err)
+
+		if err := ctx.ReplyEphemeral("Something went wrong while running this command."); err != nil {
+			b.logger.Error("failed to report command error: %v", err)
+		}
+	}
+}
+
+// handleAutocomplete answers every autocomplete request, with no choices when the command
+// has no Autocomplete handler or it fails, since Discord clients report unanswered
+// requests as failures to load the options.
+func (b *Bot) handleAutocomplete(ctx *CommandContext, cmd *Command) {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+
+	if cmd.Autocomplete != nil {
+		var err error
+		if choices, err = cmd.Autocomplete(ctx); err != nil {
+			b.logger.Error("autocomplete for %s failed: %v", cmd.Name, err)
+			choices = nil
+		}
+	}
+
+	if choices == nil {
+		choices = []*discordgo.ApplicationCommandOptionChoice{}
+	}
+
+	if len(choices) > maxAutocompleteChoices {
+		choices = choices[:maxAutocompleteChoices]
+	}
+
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		b.logger.Error("failed to send autocomplete choices: %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package discord
+
+import (
+	"discord-go-connect/internal/discord/discordtest"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func commandInteraction(kind discordgo.InteractionType, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID: "700", Type: kind, GuildID: "1", ChannelID: "10",
+		Data: discordgo.ApplicationCommandInteractionData{Name: name, Options: options},
+	}}
+}
+
+func subcommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	optionType := discordgo.ApplicationCommandOptionSubCommand
+	if len(options) > 0 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		optionType = discordgo.ApplicationCommandOptionSubCommandGroup
+	}
+
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: optionType, Options: options}
+}
+
+func stringOption(name, value string, focused bool) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value, Focused: focused,
+	}
+}
+
+func methods(calls []discordtest.Call) []string {
+	names := make([]string, 0, len(calls))
+	for _, call := range calls {
+		names = append(names, call.Method)
+	}
+
+	return names
+}
+
+func TestRespondTransitions(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail makes the first call of a method fail.
+		fail  string
+		steps func(ctx *InteractionContext) error
+		want  []string
+		// first is the type of the first InteractionRespond.
+		first discordgo.InteractionResponseType
+	}{
+		{
+			name:  "reply",
+			steps: func(ctx *InteractionContext) error { return ctx.Reply("done") },
+			want:  []string{"InteractionRespond"},
+			first: discordgo.InteractionResponseChannelMessageWithSource,
+		},
+		{
+			name: "deferred reply edits the placeholder",
+			steps: func(ctx *InteractionContext) error {
+				if err := ctx.Defer(true); err != nil {
+					return err
+				}
+
+				return ctx.Reply("done")
+			},
+			want:  []string{"InteractionRespond", "InteractionResponseEdit"},
+			first: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		},
+		{
+			name: "replies after the first are follow-ups",
+			steps: func(ctx *InteractionContext) error {
+				if err := ctx.Defer(false); err != nil {
+					return err
+				}
+
+				for i := 0; i < 2; i++ {
+					if err := ctx.Reply("done"); err != nil {
+						return err
+					}
+				}
+
+				return ctx.ReplyEphemeral("also done")
+			},
+			want:  []string{"InteractionRespond", "InteractionResponseEdit", "FollowupMessageCreate", "FollowupMessageCreate"},
+			first: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		},
+		{
+			name: "a modal answers the interaction",
+			steps: func(ctx *InteractionContext) error {
+				if err := ctx.ShowModal("form", "Form"); err != nil {
+					return err
+				}
+
+				return ctx.Reply("done")
+			},
+			want:  []string{"InteractionRespond", "FollowupMessageCreate"},
+			first: discordgo.InteractionResponseModal,
+		},
+		{
+			name: "a failed reply leaves the interaction unanswered",
+			fail: "InteractionRespond",
+			steps: func(ctx *InteractionContext) error {
+				if err := ctx.Reply("lost"); err == nil {
+					return errors.New("the failed reply succeeded")
+				}
+
+				return ctx.Reply("done")
+			},
+			want:  []string{"InteractionRespond", "InteractionRespond"},
+			first: discordgo.InteractionResponseChannelMessageWithSource,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gateway := discordtest.NewGateway(botUser)
+			ctx := &InteractionContext{
+				Session:     gateway.Connect(0, 1, 0),
+				Interaction: commandInteraction(discordgo.InteractionApplicationCommand, "test"),
+			}
+
+			if test.fail != "" {
+				gateway.Fail(test.fail, errors.New("unavailable"))
+			}
+
+			if err := test.steps(ctx); err != nil {
+				t.Fatalf("steps failed: %v", err)
+			}
+
+			calls := gateway.Calls()
+			if got := methods(calls); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("calls = %v, want %v", got, test.want)
+			}
+
+			if response := calls[0].Args[1].(*discordgo.InteractionResponse); response.Type != test.first {
+				t.Errorf("first response type = %v, want %v", response.Type, test.first)
+			}
+		})
+	}
+}
+
+func TestCommandRouting(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+
+	var handled []string
+
+	record := func(path string) CommandHandler {
+		return func(ctx *CommandContext) error {
+			handled = append(handled, path+" "+ctx.String("name"))
+			return nil
+		}
+	}
+
+	tb.AddCommand(&Command{Name: "role", Description: "Manage roles", GuildIDs: []string{"1"}, Subcommands: []*Command{
+		{Name: "add", Description: "Add a role", Handler: record("role add"), Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "Role name"},
+		}},
+		{Name: "color", Description: "Role colors", Subcommands: []*Command{
+			{Name: "set", Description: "Set a color", Handler: record("role color set")},
+		}},
+	}})
+	tb.AddCommand(&Command{Name: "broken", Description: "Always fails", Handler: func(*CommandContext) error {
+		return errors.New("boom")
+	}})
+
+	tb.gateway.Ready(0, testGuild("1", "10"))
+	tb.gateway.Emit(0, &discordgo.GuildCreate{Guild: testGuild("1", "10")})
+	tb.hub.waitForBot(t)
+
+	eventually(t, "command sync", func() bool {
+		return len(tb.gateway.Calls("ApplicationCommandBulkOverwrite")) == 2
+	})
+
+	synced := make(map[string][]string)
+
+	for _, call := range tb.gateway.Calls("ApplicationCommandBulkOverwrite") {
+		for _, command := range call.Args[2].([]*discordgo.ApplicationCommand) {
+			synced[call.Args[1].(string)] = append(synced[call.Args[1].(string)], command.Name)
+		}
+	}
+
+	if want := map[string][]string{"": {"broken"}, "1": {"role"}}; !reflect.DeepEqual(synced, want) {
+		t.Errorf("synced commands = %v, want %v", synced, want)
+	}
+
+	tests := []struct {
+		name        string
+		interaction *discordgo.InteractionCreate
+		handled     []string
+		responses   int
+	}{
+		{
+			name:        "subcommand with options",
+			interaction: commandInteraction(discordgo.InteractionApplicationCommand, "role", subcommand("add", stringOption("name", "mods", false))),
+			handled:     []string{"role add mods"},
+		},
+		{
+			name:        "subcommand group",
+			interaction: commandInteraction(discordgo.InteractionApplicationCommand, "role", subcommand("color", subcommand("set"))),
+			handled:     []string{"role color set "},
+		},
+		{
+			name:        "unknown subcommand",
+			interaction: commandInteraction(discordgo.InteractionApplicationCommand, "role", subcommand("remove")),
+		},
+		{
+			name:        "unknown command",
+			interaction: commandInteraction(discordgo.InteractionApplicationCommand, "nothing"),
+		},
+		{
+			name:        "failing handler reports the error",
+			interaction: commandInteraction(discordgo.InteractionApplicationCommand, "broken"),
+			responses:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled = nil
+			before := len(tb.gateway.Calls("InteractionRespond"))
+
+			tb.gateway.Emit(0, test.interaction)
+
+			if !reflect.DeepEqual(handled, test.handled) {
+				t.Errorf("handled %v, want %v", handled, test.handled)
+			}
+
+			responses := tb.gateway.Calls("InteractionRespond")[before:]
+			if len(responses) != test.responses {
+				t.Fatalf("got %d responses, want %d", len(responses), test.responses)
+			}
+
+			for _, call := range responses {
+				if response := call.Args[1].(*discordgo.InteractionResponse); response.Data.Flags != discordgo.MessageFlagsEphemeral {
+					t.Errorf("error report %+v is not ephemeral", response.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandsSyncForJoinedGuilds(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.AddCommand(&Command{Name: "ping", Description: "Ping", GuildIDs: []string{"2"}})
+
+	tb.gateway.Ready(0, testGuild("1", "10"))
+	tb.gateway.Emit(0, &discordgo.GuildCreate{Guild: testGuild("1", "10")})
+
+	// Guild 2 adds the bot after Ready, announces itself twice, then leaves and comes back.
+	tb.gateway.Emit(0, &discordgo.GuildCreate{Guild: testGuild("2", "20")})
+	tb.gateway.Emit(0, &discordgo.GuildCreate{Guild: testGuild("2", "20")})
+
+	eventually(t, "the joined guild's commands", func() bool {
+		return len(tb.gateway.Calls("ApplicationCommandBulkOverwrite")) == 3
+	})
+
+	tb.gateway.Emit(0, &discordgo.GuildDelete{Guild: &discordgo.Guild{ID: "2"}})
+	tb.gateway.Emit(0, &discordgo.GuildCreate{Guild: testGuild("2", "20")})
+
+	eventually(t, "the rejoined guild's commands", func() bool {
+		return len(tb.gateway.Calls("ApplicationCommandBulkOverwrite")) >= 4
+	})
+
+	synced := make(map[string]int)
+	for _, call := range tb.gateway.Calls("ApplicationCommandBulkOverwrite") {
+		synced[call.Args[1].(string)]++
+	}
+
+	if want := map[string]int{"": 1, "1": 1, "2": 2}; !reflect.DeepEqual(synced, want) {
+		t.Errorf("syncs per guild = %v, want %v", synced, want)
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+
+	suggest := func(count int, err error) AutocompleteHandler {
+		return func(ctx *CommandContext) ([]*discordgo.ApplicationCommandOptionChoice, error) {
+			choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, count)
+			for i := 0; i < count; i++ {
+				name := fmt.Sprintf("%s%d", ctx.Focused.StringValue(), i)
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+			}
+
+			return choices, err
+		}
+	}
+
+	options := []*discordgo.ApplicationCommandOption{{Type: discordgo.ApplicationCommandOptionString, Name: "query", Description: "Query"}}
+
+	tb.AddCommand(&Command{Name: "few", Description: "Few", Options: options, Autocomplete: suggest(3, nil)})
+	tb.AddCommand(&Command{Name: "many", Description: "Many", Options: options, Autocomplete: suggest(40, nil)})
+	tb.AddCommand(&Command{Name: "failing", Description: "Failing", Options: options, Autocomplete: suggest(3, errors.New("boom"))})
+	tb.AddCommand(&Command{Name: "plain", Description: "Plain", Options: options, Handler: func(*CommandContext) error { return nil }})
+
+	tb.ready(t, testGuild("1", "10"))
+
+	tests := []struct {
+		command string
+		want    int
+		first   string
+	}{
+		{command: "few", want: 3, first: "ab0"},
+		{command: "many", want: maxAutocompleteChoices, first: "ab0"},
+		{command: "failing", want: 0},
+		{command: "plain", want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			before := len(tb.gateway.Calls("InteractionRespond"))
+
+			tb.gateway.Emit(0, commandInteraction(discordgo.InteractionApplicationCommandAutocomplete, test.command, stringOption("query", "ab", true)))
+
+			responses := tb.gateway.Calls("InteractionRespond")[before:]
+			if len(responses) != 1 {
+				t.Fatalf("got %d responses, want 1", len(responses))
+			}
+
+			response := responses[0].Args[1].(*discordgo.InteractionResponse)
+			if response.Type != discordgo.InteractionApplicationCommandAutocompleteResult {
+				t.Errorf("response type = %v, want an autocomplete result", response.Type)
+			}
+
+			choices := response.Data.Choices
+			if choices == nil || len(choices) != test.want || (test.first != "" && choices[0].Name != test.first) {
+				t.Errorf("choices = %v, want %d starting with %q", choices, test.want, test.first)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package discord
+
+import (
+	"discord-go-connect/internal/store"
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+)
+
+// customIDSeparator splits a custom ID into its route and the ID of its stored state.
+const customIDSeparator = ":"
+
+// ErrStateNotFound is returned when the state of a component has expired or was never stored.
+var ErrStateNotFound = errors.New("interaction state not found")
+
+// ComponentHandler handles a button click, a select menu choice or a modal submission.
+type ComponentHandler func(ctx *ComponentContext) error
+
+// ComponentContext is the InteractionContext of a message component or modal.
+type ComponentContext struct {
+	InteractionContext
+	b        *Bot
+	CustomID string
+	Route    string
+	StateID  string
+	// Values holds the choices of a select menu.
+	Values []string
+	// Fields maps the custom IDs of a modal's text inputs to their submitted values.
+	Fields map[string]string
+}
+
+// componentEvent is relayed to web clients when nobody in Go handles a component.
+type componentEvent struct {
+	Fields        map[string]string `json:"fields,omitempty"`
+	User          *discordgo.User   `json:"user"`
+	InteractionID string            `json:"interaction_id"`
+	CustomID      string            `json:"custom_id"`
+	GuildID       string            `json:"guild_id"`
+	ChannelID     string            `json:"channel_id"`
+	MessageID     string            `json:"message_id,omitempty"`
+	Values        []string          `json:"values,omitempty"`
+	Modal         bool              `json:"modal"`
+}
+
+// AddComponentRoute routes every component and modal whose custom ID starts with
+// route to handler. Custom IDs are built with NewCustomID.
+func (b *Bot) AddComponentRoute(route string, handler ComponentHandler) {
+	b.routesMu.Lock()
+	defer b.routesMu.Unlock()
+
+	b.componentRoutes[route] = handler
+}
+
+// NewCustomID stores state for a component and returns a custom ID routing its
+// interactions to route. State is kept in the database so components keep working
+// across restarts; a ttl of zero keeps it until the component is cleaned up by hand.
+func (b *Bot) NewCustomID(route string, state interface{}, ttl time.Duration) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode interaction state: %w", err)
+	}
+
+	id := uuid.NewString()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().UTC().Add(ttl)
+	}
+
+	if err := b.store.SaveInteractionState(id, route, data, expiresAt); err != nil {
+		return "", err
+	}
+
+	return route + customIDSeparator + id, nil
+}
+
+// DeleteState removes the stored state of a component.
+func (b *Bot) DeleteState(stateID string) error {
+	return b.store.DeleteInteractionState(stateID)
+}
+
+// pruneInteractionState periodically deletes the component state that expired.
+func (b *Bot) pruneInteractionState() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.stopped:
+			return
+		}
+
+		if err := b.store.DeleteExpiredInteractionState(); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+}
+
+func (b *Bot) lookupComponentRoute(route string) (ComponentHandler, bool) {
+	b.routesMu.RLock()
+	defer b.routesMu.RUnlock()
+
+	handler, ok := b.componentRoutes[route]
+
+	return handler, ok
+}
+
+func (b *Bot) handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	ctx := &ComponentContext{
+		InteractionContext: InteractionContext{Session: b.clientOf(s), Interaction: i},
+		b:                  b,
+	}
+
+	if i.Type == discordgo.InteractionModalSubmit {
+		data := i.ModalSubmitData()
+		ctx.CustomID = data.CustomID
+		ctx.Fields = modalFields(data.Components)
+	} else {
+		data := i.MessageComponentData()
+		ctx.CustomID = data.CustomID
+		ctx.Values = data.Values
+	}
+
+	ctx.Route, ctx.StateID, _ = strings.Cut(ctx.CustomID, customIDSeparator)
+
+	handler, ok := b.lookupComponentRoute(ctx.Route)
+	if !ok {
+		b.relayComponent(ctx)
+		return
+	}
+
+	if err := handler(ctx); err != nil {
+		b.logger.Error("component %s failed: %v", ctx.CustomID, err)
+
+		if err := ctx.ReplyEphemeral("Something went wrong while handling this interaction."); err != nil {
+			b.logger.Error("failed to report component error: %v", err)
+		}
+	}
+}
+
+// relayComponent acknowledges a component that was posted from the WebSocket API and
+// forwards the interaction to the web clients subscribed to its guild or, in DMs, to the
+// DM.
+func (b *Bot) relayComponent(ctx *ComponentContext) {
+	if err := ctx.DeferUpdate(); err != nil {
+		b.logger.Error("failed to acknowledge component %s: %v", ctx.CustomID, err)
+	}
+
+	i := ctx.Interaction
+	event := componentEvent{
+		InteractionID: i.ID,
+		CustomID:      ctx.CustomID,
+		GuildID:       i.GuildID,
+		ChannelID:     i.ChannelID,
+		Values:        ctx.Values,
+		Fields:        ctx.Fields,
+		Modal:         i.Type == discordgo.InteractionModalSubmit,
+	}
+
+	if i.Message != nil {
+		event.MessageID = i.Message.ID
+	}
+
+	if i.Member != nil {
+		event.User = i.Member.User
+	} else {
+		event.User = i.User
+	}
+
+	// DM components only reach the clients following DMs, like the messages themselves.
+	if i.GuildID == "" {
+		b.publish(event, &wshub.WSPayload{Action: wshub.ServerComponentInteraction, MessageID: i.ChannelID},
+			topic(topicDM, i.ChannelID), topic(topicDM, dmInbox))
+
+		return
+	}
+
+	b.publish(event, &wshub.WSPayload{Action: wshub.ServerComponentInteraction, MessageID: i.ChannelID},
+		topic(topicGuild, i.GuildID), topic(topicThread, i.ChannelID))
+}
+
+func modalFields(rows []discordgo.MessageComponent) map[string]string {
+	fields := make(map[string]string)
+
+	for _, row := range rows {
+		actionsRow, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+
+		for _, component := range actionsRow.Components {
+			if input, ok := component.(*discordgo.TextInput); ok {
+				fields[input.CustomID] = input.Value
+			}
+		}
+	}
+
+	return fields
+}
+
+// State decodes the state stored for this component into v.
+func (ctx *ComponentContext) State(v interface{}) error {
+	data, err := ctx.b.store.InteractionState(ctx.StateID)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrStateNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// DeferUpdate acknowledges the interaction without sending a message. The message the
+// component belongs to can then be changed with Respond.
+func (ctx *ComponentContext) DeferUpdate() error {
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.deferred = true
+
+	return nil
+}
+
+// Update answers the interaction by editing the message the component belongs to.
+func (ctx *ComponentContext) Update(data *discordgo.InteractionResponseData) error {
+	if ctx.deferred || ctx.responded {
+		return ctx.Respond(data)
+	}
+
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.responded = true
+
+	return nil
+}
+
+func decodeComponents(raw []json.RawMessage) ([]discordgo.MessageComponent, error) {
+	components := make([]discordgo.MessageComponent, 0, len(raw))
+
+	for _, data := range raw {
+		component, err := discordgo.MessageComponentFromJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component: %w", err)
+		}
+
+		components = append(components, component)
+	}
+
+	return components, nil
+}
@@ -1,106 +1,33 @@
 package discord
 
 import (
+	"discord-go-connect/internal/metrics"
+	"discord-go-connect/internal/store"
 	"fmt"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-const (
-	insertGuilds = `
-			INSERT INTO Guild (id, name, icon, region, owner_id)
-			VALUES (?, ?, ?, ?, ?)
-			ON DUPLICATE KEY UPDATE
-			name = VALUES(name),
-			icon = VALUES(icon),
-			region = VALUES(region),
-			owner_id = VALUES(owner_id)
-		`
-	insertChannels = `
-			INSERT INTO Channel (id, guild_id, name, nsfw, position)
-			VALUES (?, ?, ?, ?, ?)
-			ON DUPLICATE KEY UPDATE
-			guild_id = VALUES(guild_id),
-			name = VALUES(name),
-			nsfw = VALUES(nsfw),
-			position = VALUES(position)
-		`
-	insertAuthor = `
-			INSERT IGNORE INTO Author (id, email, username, avatar, bot, system)
-			VALUES (?, ?, ?, ?, ?, ?)
-		`
-	insertMember = `
-			INSERT IGNORE INTO Member (id, guild_id, author_id, nick, avatar)
-			VALUES (?, ?, ?, ?, ?)
-		`
-	insertMessage = `
-			INSERT INTO Message (id, channel_id, guild_id, author_id, member_id, pinned, type, content, timestamp, edited_timestamp)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`
-)
-
-func (b *Bot) CreateOrUpdateGuilds() error {
-	stmt, err := b.db.Prepare(insertGuilds)
-	if err != nil {
-		return fmt.Errorf("failed to prepare SQL statement: %v", err)
-	}
-	defer stmt.Close()
-
-	for _, guild := range b.guilds {
-		_, err := stmt.Exec(guild.ID, guild.Name, guild.Icon, guild.Region, guild.OwnerID)
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL statement: %w", err)
-		}
-	}
-
-	return nil
+// UseStore replaces the MySQL store the bot writes guilds, channels and messages to. It
+// must be called before Start.
+func (b *Bot) UseStore(history store.Store) {
+	b.store = history
 }
 
-func (b *Bot) CreateOrUpdateChannels() error {
-	stmt, err := b.db.Prepare(insertChannels)
-	if err != nil {
-		return fmt.Errorf("failed to prepare SQL statement: %w", err)
-	}
-	defer stmt.Close()
-
-	for _, guild := range b.guilds {
-		for _, channel := range guild.Channels {
-			for _, message := range channel.Messages {
-				_, err := stmt.Exec(message.ID, channel.ID, guild.ID, message.Pinned, message.Type, message.Attachments, message.Embeds, message.Mentions)
-				if err != nil {
-					return fmt.Errorf("failed to execute SQL statement: %w", err)
-				}
-			}
+func (b *Bot) CreateOrUpdateGuilds() error {
+	for _, guild := range b.guildSnapshot() {
+		if err := b.store.SaveGuild(guild); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (b *Bot) CreateOrUpdateGuildsAndChannels() error {	
-	guildStmt, err := b.db.Prepare(insertGuilds)
-	if err != nil {
-		return fmt.Errorf("failed to prepare Guild SQL statement: %w", err)
-	}
-	defer guildStmt.Close()
-
-	channelStmt, err := b.db.Prepare(insertChannels)
-	if err != nil {
-		return fmt.Errorf("failed to prepare Channel SQL statement: %w", err)
-	}
-	defer channelStmt.Close()
-
-	for _, guild := range b.guilds {
-		_, err := guildStmt.Exec(guild.ID, guild.Name, guild.Icon, guild.Region, guild.OwnerID)
-		if err != nil {
-			return fmt.Errorf("failed to execute Guild SQL statement: %w", err)
-		}
-
-		for _, channel := range guild.Channels {
-			_, err := channelStmt.Exec(channel.ID, guild.ID, channel.Name, channel.NSFW, channel.Position)
-			if err != nil {
-				return fmt.Errorf("failed to execute Channel SQL statement: %w", err)
-			}
+func (b *Bot) CreateOrUpdateGuildsAndChannels() error {
+	for _, guild := range b.guildSnapshot() {
+		if err := b.CreateOrUpdateGuild(guild); err != nil {
+			return err
 		}
 	}
 
@@ -111,73 +38,46 @@ func (b *Bot) CreateMessage(messages []*discordgo.MessageCreate) error {
 	if len(messages) < 1 {
 		return nil
 	}
-	
-	b.logger.Info("updating database")
-
-	tx, err := b.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to start database transaction: %w", err)
-	}
-
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			b.logger.Error("%v", err)
-		}
-	}()
 
-	stmtAuthor, err := tx.Prepare(insertAuthor)
-	if err != nil {
-		return fmt.Errorf("failed to prepare SQL statement for authors: %w", err)
-	}
-	defer stmtAuthor.Close()
+	b.logger.Info("updating database")
 
-	stmtMember, err := tx.Prepare(insertMember)
+	result, err := b.store.SaveMessages(messages)
 	if err != nil {
-		return fmt.Errorf("failed to prepare SQL statement for members: %w", err)
+		return fmt.Errorf("failed to store %d messages: %w", len(messages), err)
 	}
-	defer stmtAuthor.Close()
 
-	stmtMessage, err := tx.Prepare(insertMessage)
-	if err != nil {
-		return fmt.Errorf("failed to prepare SQL statement for messages: %w", err)
-	}
-	defer stmtMessage.Close()
+	recordSaveResult(result)
 
+	stored := make([]*discordgo.Message, 0, len(messages))
 	for _, message := range messages {
-		if message == nil || message.Author == nil || message.Member == nil {
+		if message == nil || message.Message == nil {
 			continue
 		}
 
-		_, err := stmtAuthor.Exec(
-			message.Author.ID, message.Author.Email, message.Author.Username,
-			message.Author.Avatar, message.Author.Bot, message.Author.System,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL statement for authors: %w", err)
-		}
-
-		_, err = stmtMember.Exec(
-			message.Author.ID, message.Author.ID, message.GuildID, message.Member.Nick,
-			message.Member.Avatar,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL statement for members: %w", err)
+		// Messages that failed are logged and dropped; the rest of the batch is stored.
+		if err, failed := result.Failed[message.ID]; failed {
+			b.logger.Error("failed to store message %s: %v", message.ID, err)
+			continue
 		}
 
-		_, err = stmtMessage.Exec(
-			message.ID, message.ChannelID, message.GuildID, message.Author.ID,
-			message.Author.ID, message.Pinned, message.Type,
-			//TODO: message.Attachments, message.Embeds, message.Mentions,
-			message.Content, message.Timestamp, message.EditedTimestamp,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL statement for messages: %w", err)
-		}
+		stored = append(stored, message.Message)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit database transaction: %w", err)
-	}
+	b.indexMessages(stored...)
+	b.mirrorMedia(stored)
 
 	return nil
 }
+
+func recordSaveResult(result *store.SaveResult) {
+	for outcome, count := range map[string]int{
+		"inserted":  result.Inserted,
+		"duplicate": result.Duplicates,
+		"failed":    len(result.Failed),
+	} {
+		if count > 0 {
+			metrics.AddCounter("discord_stored_messages_total", "Messages written to the store by outcome.",
+				metrics.Labels{"outcome": outcome}, float64(count))
+		}
+	}
+}
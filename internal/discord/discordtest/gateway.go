@@ -0,0 +1,695 @@
+// Package discordtest provides an in-memory Discord gateway for tests. It hands out
+// clients for the shards of a bot, dispatches the events a test emits to the bot's
+// handlers and records every call the bot makes.
+package discordtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// firstSnowflake is the id of the first message the gateway creates. Later ids count up
+// from it, so messages sort in the order they were sent.
+const firstSnowflake = 1100000000000000000
+
+// Call is a request the bot made on a shard.
+type Call struct {
+	Method string
+	Args   []interface{}
+	Shard  int
+}
+
+// Gateway simulates Discord for every shard of one bot.
+type Gateway struct {
+	User        *discordgo.User
+	sessions    map[int]*Session
+	channels    map[string]*discordgo.Channel
+	messages    map[string][]*discordgo.Message
+	permissions map[string]int64
+	failures    map[string]error
+	calls       []Call
+	nextID      int64
+	mu          sync.Mutex
+}
+
+// NewGateway returns a gateway for a bot with the given user.
+func NewGateway(user *discordgo.User) *Gateway {
+	return &Gateway{
+		User:        user,
+		sessions:    make(map[int]*Session),
+		channels:    make(map[string]*discordgo.Channel),
+		messages:    make(map[string][]*discordgo.Message),
+		permissions: make(map[string]int64),
+		failures:    make(map[string]error),
+		nextID:      firstSnowflake,
+	}
+}
+
+// Connect returns the client of a shard. It has the signature of discord.ClientFactory
+// apart from the concrete return type.
+func (g *Gateway) Connect(shardID, shardCount int, intents discordgo.Intent) *Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	state := discordgo.NewState()
+	state.TrackMembers = true
+
+	session := &Session{
+		gateway: g,
+		intents: intents,
+		session: &discordgo.Session{
+			ShardID:      shardID,
+			ShardCount:   shardCount,
+			State:        state,
+			StateEnabled: true,
+			Ratelimiter:  discordgo.NewRatelimiter(),
+		},
+	}
+
+	g.sessions[shardID] = session
+
+	return session
+}
+
+// Shard returns the latest client of a shard, or nil if it never connected.
+func (g *Gateway) Shard(id int) *Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.sessions[id]
+}
+
+// AddChannel makes a channel known to REST lookups.
+func (g *Gateway) AddChannel(channel *discordgo.Channel) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.channels[channel.ID] = channel
+}
+
+// AddMessage stores a message that ChannelMessage and ChannelMessages return.
+func (g *Gateway) AddMessage(message *discordgo.Message) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.messages[message.ChannelID] = append(g.messages[message.ChannelID], message)
+}
+
+// SetPermissions overrides the permissions the bot has in a channel.
+func (g *Gateway) SetPermissions(channelID string, permissions int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.permissions[channelID] = permissions
+}
+
+// Fail makes the next call of a method return err.
+func (g *Gateway) Fail(method string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failures[method] = err
+}
+
+// Calls returns the calls made so far, limited to the given methods if any.
+func (g *Gateway) Calls(methods ...string) []Call {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	calls := make([]Call, 0, len(g.calls))
+
+	for _, call := range g.calls {
+		if len(methods) == 0 || contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// Messages returns the messages of a channel, oldest first.
+func (g *Gateway) Messages(channelID string) []*discordgo.Message {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]*discordgo.Message(nil), g.messages[channelID]...)
+}
+
+// Emit delivers an event on a shard. The shard's state is updated first, as discordgo
+// does, then every handler for the event's type runs before Emit returns.
+func (g *Gateway) Emit(shardID int, event interface{}) {
+	session := g.Shard(shardID)
+	if session == nil {
+		panic(fmt.Sprintf("discordtest: shard %d is not connected", shardID))
+	}
+
+	session.emit(event)
+}
+
+// Ready emits the Ready event of a shard with the bot user and the given guilds, which
+// arrive unavailable like on Discord.
+func (g *Gateway) Ready(shardID int, guilds ...*discordgo.Guild) {
+	unavailable := make([]*discordgo.Guild, 0, len(guilds))
+	for _, guild := range guilds {
+		unavailable = append(unavailable, &discordgo.Guild{ID: guild.ID, Unavailable: true})
+	}
+
+	g.Emit(shardID, &discordgo.Ready{
+		Version:   10,
+		SessionID: "session-" + strconv.Itoa(shardID),
+		User:      g.User,
+		Guilds:    unavailable,
+	})
+}
+
+// Disconnect drops the connection of a shard the way a network error does.
+func (g *Gateway) Disconnect(shardID int) {
+	session := g.Shard(shardID)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	session.open = false
+	session.mu.Unlock()
+
+	session.emit(&discordgo.Disconnect{})
+}
+
+func (g *Gateway) record(shard int, method string, args ...interface{}) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.calls = append(g.calls, Call{Shard: shard, Method: method, Args: args})
+
+	if err, ok := g.failures[method]; ok {
+		delete(g.failures, method)
+		return err
+	}
+
+	return nil
+}
+
+func (g *Gateway) snowflake() string {
+	g.nextID++
+
+	return strconv.FormatInt(g.nextID, 10)
+}
+
+// Session is the client of one shard.
+type Session struct {
+	gateway  *Gateway
+	session  *discordgo.Session
+	handlers []*handler
+	intents  discordgo.Intent
+	open     bool
+	mu       sync.Mutex
+}
+
+type handler struct {
+	fn    reflect.Value
+	event reflect.Type
+}
+
+// Intents returns the gateway intents the shard was connected with.
+func (s *Session) Intents() discordgo.Intent {
+	return s.intents
+}
+
+// IsOpen reports whether the shard is connected.
+func (s *Session) IsOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.open
+}
+
+func (s *Session) Open() error {
+	if err := s.gateway.record(s.session.ShardID, "Open"); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.open {
+		return discordgo.ErrWSAlreadyOpen
+	}
+
+	s.open = true
+
+	return nil
+}
+
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.open = false
+	s.mu.Unlock()
+
+	return s.gateway.record(s.session.ShardID, "Close")
+}
+
+// AddHandler registers a handler with discordgo's signature,
+// func(*discordgo.Session, *discordgo.EventType).
+func (s *Session) AddHandler(fn interface{}) func() {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.Type().NumIn() != 2 {
+		panic(fmt.Sprintf("discordtest: invalid handler %T", fn))
+	}
+
+	h := &handler{fn: value, event: value.Type().In(1)}
+
+	s.mu.Lock()
+	s.handlers = append(s.handlers, h)
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for i, registered := range s.handlers {
+			if registered == h {
+				s.handlers = append(s.handlers[:i], s.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) emit(event interface{}) {
+	// discordgo only logs state errors, such as events for guilds it doesn't know.
+	_ = s.session.State.OnInterface(s.session, event)
+
+	s.mu.Lock()
+	handlers := append([]*handler(nil), s.handlers...)
+	s.mu.Unlock()
+
+	eventValue := reflect.ValueOf(event)
+	args := []reflect.Value{reflect.ValueOf(s.session), eventValue}
+
+	for _, h := range handlers {
+		if eventValue.Type() == h.event || (h.event.Kind() == reflect.Interface && eventValue.Type().Implements(h.event)) {
+			h.fn.Call(args)
+		}
+	}
+}
+
+func (s *Session) HeartbeatLatency() time.Duration {
+	return 42 * time.Millisecond
+}
+
+func (s *Session) SessionState() *discordgo.State {
+	return s.session.State
+}
+
+func (s *Session) RateLimiter() *discordgo.RateLimiter {
+	return s.session.Ratelimiter
+}
+
+func (s *Session) RequestGuildMembers(guildID, query string, limit int, nonce string, presences bool) error {
+	return s.gateway.record(s.session.ShardID, "RequestGuildMembers", guildID, query, limit, nonce, presences)
+}
+
+func (s *Session) Channel(channelID string, _ ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	if err := s.gateway.record(s.session.ShardID, "Channel", channelID); err != nil {
+		return nil, err
+	}
+
+	if channel, err := s.session.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	if channel, ok := s.gateway.channels[channelID]; ok {
+		return channel, nil
+	}
+
+	return nil, notFound()
+}
+
+func (s *Session) GuildChannels(guildID string, _ ...discordgo.RequestOption) ([]*discordgo.Channel, error) {
+	if err := s.gateway.record(s.session.ShardID, "GuildChannels", guildID); err != nil {
+		return nil, err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	channels := make([]*discordgo.Channel, 0)
+
+	for _, channel := range s.gateway.channels {
+		if channel.GuildID == guildID {
+			channels = append(channels, channel)
+		}
+	}
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Position < channels[j].Position })
+
+	return channels, nil
+}
+
+func (s *Session) GuildThreadsActive(guildID string, _ ...discordgo.RequestOption) (*discordgo.ThreadsList, error) {
+	if err := s.gateway.record(s.session.ShardID, "GuildThreadsActive", guildID); err != nil {
+		return nil, err
+	}
+
+	return &discordgo.ThreadsList{}, nil
+}
+
+func (s *Session) ThreadsArchived(channelID string, before *time.Time, limit int, _ ...discordgo.RequestOption) (*discordgo.ThreadsList, error) {
+	if err := s.gateway.record(s.session.ShardID, "ThreadsArchived", channelID, before, limit); err != nil {
+		return nil, err
+	}
+
+	return &discordgo.ThreadsList{}, nil
+}
+
+func (s *Session) UserChannelCreate(recipientID string, _ ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	if err := s.gateway.record(s.session.ShardID, "UserChannelCreate", recipientID); err != nil {
+		return nil, err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	for _, channel := range s.gateway.channels {
+		if channel.Type == discordgo.ChannelTypeDM && len(channel.Recipients) > 0 && channel.Recipients[0].ID == recipientID {
+			return channel, nil
+		}
+	}
+
+	channel := &discordgo.Channel{
+		ID:         s.gateway.snowflake(),
+		Type:       discordgo.ChannelTypeDM,
+		Recipients: []*discordgo.User{{ID: recipientID}},
+	}
+	s.gateway.channels[channel.ID] = channel
+
+	return channel, nil
+}
+
+// UserChannelPermissions returns the permissions set with SetPermissions, or computes
+// them from the shard's state.
+func (s *Session) UserChannelPermissions(userID, channelID string, _ ...discordgo.RequestOption) (int64, error) {
+	if err := s.gateway.record(s.session.ShardID, "UserChannelPermissions", userID, channelID); err != nil {
+		return 0, err
+	}
+
+	s.gateway.mu.Lock()
+	permissions, ok := s.gateway.permissions[channelID]
+	s.gateway.mu.Unlock()
+
+	if ok {
+		return permissions, nil
+	}
+
+	return s.session.State.UserChannelPermissions(userID, channelID)
+}
+
+func (s *Session) ChannelTyping(channelID string, _ ...discordgo.RequestOption) error {
+	return s.gateway.record(s.session.ShardID, "ChannelTyping", channelID)
+}
+
+func (s *Session) ChannelMessage(channelID, messageID string, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessage", channelID, messageID); err != nil {
+		return nil, err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	if _, message := s.gateway.findMessage(channelID, messageID); message != nil {
+		return message, nil
+	}
+
+	return nil, notFound()
+}
+
+// ChannelMessages pages through a channel newest first, like Discord.
+func (s *Session) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, _ ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessages", channelID, limit, beforeID, afterID, aroundID); err != nil {
+		return nil, err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	stored := s.gateway.messages[channelID]
+	page := make([]*discordgo.Message, 0, limit)
+
+	for i := len(stored) - 1; i >= 0 && len(page) < limit; i-- {
+		message := stored[i]
+
+		if beforeID != "" && !olderThan(message.ID, beforeID) {
+			continue
+		}
+
+		if afterID != "" && !olderThan(afterID, message.ID) {
+			continue
+		}
+
+		page = append(page, message)
+	}
+
+	return page, nil
+}
+
+// ChannelMessageSendComplex stores the message as sent by the bot. Like Discord, the
+// gateway doesn't echo it as MessageCreate unless the test emits one.
+func (s *Session) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessageSendComplex", channelID, data); err != nil {
+		return nil, err
+	}
+
+	return s.gateway.storeSent(channelID, data.Content, data.Embeds), nil
+}
+
+func (s *Session) ChannelMessageEditComplex(data *discordgo.MessageEdit, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessageEditComplex", data); err != nil {
+		return nil, err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	_, message := s.gateway.findMessage(data.Channel, data.ID)
+	if message == nil {
+		return nil, notFound()
+	}
+
+	if data.Content != nil {
+		message.Content = *data.Content
+	}
+
+	if data.Embeds != nil {
+		message.Embeds = data.Embeds
+	}
+
+	now := time.Now().UTC()
+	message.EditedTimestamp = &now
+
+	return message, nil
+}
+
+func (s *Session) ChannelMessageDelete(channelID, messageID string, _ ...discordgo.RequestOption) error {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessageDelete", channelID, messageID); err != nil {
+		return err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+
+	i, message := s.gateway.findMessage(channelID, messageID)
+	if message == nil {
+		return notFound()
+	}
+
+	stored := s.gateway.messages[channelID]
+	s.gateway.messages[channelID] = append(stored[:i], stored[i+1:]...)
+
+	return nil
+}
+
+func (s *Session) ChannelMessagePin(channelID, messageID string, _ ...discordgo.RequestOption) error {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessagePin", channelID, messageID); err != nil {
+		return err
+	}
+
+	return s.gateway.setPinned(channelID, messageID, true)
+}
+
+func (s *Session) ChannelMessageUnpin(channelID, messageID string, _ ...discordgo.RequestOption) error {
+	if err := s.gateway.record(s.session.ShardID, "ChannelMessageUnpin", channelID, messageID); err != nil {
+		return err
+	}
+
+	return s.gateway.setPinned(channelID, messageID, false)
+}
+
+func (s *Session) MessageReactionAdd(channelID, messageID, emojiID string, _ ...discordgo.RequestOption) error {
+	return s.gateway.record(s.session.ShardID, "MessageReactionAdd", channelID, messageID, emojiID)
+}
+
+func (s *Session) MessageReactionRemove(channelID, messageID, emojiID, userID string, _ ...discordgo.RequestOption) error {
+	return s.gateway.record(s.session.ShardID, "MessageReactionRemove", channelID, messageID, emojiID, userID)
+}
+
+func (s *Session) ApplicationCommandBulkOverwrite(appID, guildID string, commands []*discordgo.ApplicationCommand, _ ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	if err := s.gateway.record(s.session.ShardID, "ApplicationCommandBulkOverwrite", appID, guildID, commands); err != nil {
+		return nil, err
+	}
+
+	return commands, nil
+}
+
+func (s *Session) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, _ ...discordgo.RequestOption) error {
+	return s.gateway.record(s.session.ShardID, "InteractionRespond", interaction, resp)
+}
+
+func (s *Session) InteractionResponseEdit(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := s.gateway.record(s.session.ShardID, "InteractionResponseEdit", interaction, edit); err != nil {
+		return nil, err
+	}
+
+	message := &discordgo.Message{ID: interaction.ID, ChannelID: interaction.ChannelID, Author: s.gateway.User}
+	if edit.Content != nil {
+		message.Content = *edit.Content
+	}
+
+	return message, nil
+}
+
+func (s *Session) FollowupMessageCreate(interaction *discordgo.Interaction, _ bool, data *discordgo.WebhookParams, _ ...discordgo.RequestOption) (*discordgo.Message, error) {
+	if err := s.gateway.record(s.session.ShardID, "FollowupMessageCreate", interaction, data); err != nil {
+		return nil, err
+	}
+
+	return s.gateway.storeSent(interaction.ChannelID, data.Content, data.Embeds), nil
+}
+
+// RequestWithBucketID answers raw message sends, the only raw requests the bot makes.
+func (s *Session) RequestWithBucketID(method, urlStr string, data interface{}, _ string, _ ...discordgo.RequestOption) ([]byte, error) {
+	if err := s.gateway.record(s.session.ShardID, "RequestWithBucketID", method, urlStr, data); err != nil {
+		return nil, err
+	}
+
+	return s.gateway.rawSend(urlStr, data)
+}
+
+func (s *Session) RequestWithLockedBucket(method, urlStr, contentType string, body []byte, bucket *discordgo.Bucket, _ int, _ ...discordgo.RequestOption) ([]byte, error) {
+	// discordgo releases the bucket once the request is done.
+	defer func() { _ = bucket.Release(nil) }()
+
+	if err := s.gateway.record(s.session.ShardID, "RequestWithLockedBucket", method, urlStr, contentType, body); err != nil {
+		return nil, err
+	}
+
+	return s.gateway.rawSend(urlStr, nil)
+}
+
+func (g *Gateway) rawSend(urlStr string, data interface{}) ([]byte, error) {
+	var send struct {
+		Content string                    `json:"content"`
+		Embeds  []*discordgo.MessageEmbed `json:"embeds"`
+	}
+
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(encoded, &send); err != nil {
+			return nil, err
+		}
+	}
+
+	channelID := strings.TrimSuffix(strings.TrimPrefix(urlStr, discordgo.EndpointChannels), "/messages")
+
+	return json.Marshal(g.storeSent(channelID, send.Content, send.Embeds))
+}
+
+func (g *Gateway) storeSent(channelID, content string, embeds []*discordgo.MessageEmbed) *discordgo.Message {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	message := &discordgo.Message{
+		ID:        g.snowflake(),
+		ChannelID: channelID,
+		Content:   content,
+		Embeds:    embeds,
+		Author:    g.User,
+		Timestamp: time.Now().UTC(),
+	}
+
+	if channel, ok := g.channels[channelID]; ok {
+		message.GuildID = channel.GuildID
+	}
+
+	g.messages[channelID] = append(g.messages[channelID], message)
+
+	return message
+}
+
+func (g *Gateway) setPinned(channelID, messageID string, pinned bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, message := g.findMessage(channelID, messageID)
+	if message == nil {
+		return notFound()
+	}
+
+	message.Pinned = pinned
+
+	return nil
+}
+
+// findMessage looks a message up. The caller holds g.mu.
+func (g *Gateway) findMessage(channelID, messageID string) (int, *discordgo.Message) {
+	for i, message := range g.messages[channelID] {
+		if message.ID == messageID {
+			return i, message
+		}
+	}
+
+	return -1, nil
+}
+
+// notFound is the error discordgo returns for a 404 response.
+func notFound() error {
+	return &discordgo.RESTError{
+		Response:     &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found"},
+		ResponseBody: []byte(`{"message": "Unknown Message", "code": 10008}`),
+	}
+}
+
+// olderThan compares two snowflakes.
+func olderThan(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+
+	return a < b
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/store"
+	"discord-go-connect/internal/wshub"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// dmInbox is the id of the DM topic that follows every DM channel.
+const dmInbox = "*"
+
+// onDirectMessage makes sure the DM channel of a message is known and stored, then
+// pushes the message to the inbox and to the subscribers of its channel.
+func (b *Bot) onDirectMessage(msg *discordgo.MessageCreate) {
+	b.dmsMu.RLock()
+	_, known := b.dms[msg.ChannelID]
+	b.dmsMu.RUnlock()
+
+	if !known {
+		channel, err := b.channel(msg.ChannelID)
+		if err != nil {
+			b.logger.Error("failed to fetch DM channel %s: %v", msg.ChannelID, err)
+
+			channel = &discordgo.Channel{ID: msg.ChannelID, Type: discordgo.ChannelTypeDM}
+			if msg.Author != nil && msg.Author.ID != b.UserID() {
+				channel.Recipients = []*discordgo.User{msg.Author}
+			}
+		}
+
+		b.storeDM(channel)
+	}
+
+	b.publish(msg, &wshub.WSPayload{Action: "messages", MessageID: msg.ChannelID},
+		topic(topicDM, msg.ChannelID), topic(topicDM, dmInbox))
+}
+
+// storeDM caches and persists a DM channel.
+func (b *Bot) storeDM(channel *discordgo.Channel) {
+	b.dmsMu.Lock()
+	b.dms[channel.ID] = channel
+	b.dmsMu.Unlock()
+
+	if err := b.store.SaveDMChannel(channel); err != nil {
+		b.logger.Error("%v", err)
+	}
+}
+
+// DMChannels lists the bot's DM conversations, most recently active first.
+func (b *Bot) DMChannels(grant *auth.Grant) ([]store.DMChannel, error) {
+	if !grant.Admin() {
+		return nil, fmt.Errorf("%w: the DM inbox needs an admin grant", ErrForbidden)
+	}
+
+	return b.store.DMChannels()
+}
+
+func (b *Bot) listDMs(payload *wshub.WSPayload) {
+	channels, err := b.DMChannels(b.keys.Grant(payload.Caller))
+	if err != nil {
+		b.sendError(payload, err)
+		return
+	}
+
+	b.sendJSONReponse(channels, &wshub.WSPayload{
+		Action:   wshub.ServerListDms,
+		Receiver: payload.Receiver,
+		Nonce:    payload.Nonce,
+	})
+}
@@ -0,0 +1,316 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/wshub"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildEvent is pushed to web clients when a guild is joined, changed or left.
+type guildEvent struct {
+	Guild   *discordgo.Guild `json:"guild"`
+	Removed bool             `json:"removed"`
+}
+
+// channelEvent is pushed to web clients when a channel is created, changed or deleted.
+type channelEvent struct {
+	Channel *discordgo.Channel `json:"channel"`
+	Deleted bool               `json:"deleted"`
+}
+
+// guildSnapshot returns the cached guilds so they can be used without holding the lock.
+func (b *Bot) guildSnapshot() []*discordgo.Guild {
+	b.guildsMu.RLock()
+	defer b.guildsMu.RUnlock()
+
+	guilds := make([]*discordgo.Guild, 0, len(b.guilds))
+	for _, guild := range b.guilds {
+		guilds = append(guilds, guild)
+	}
+
+	return guilds
+}
+
+// guildsFor returns the cached guilds a grant may access.
+func (b *Bot) guildsFor(grant *auth.Grant) map[string]*discordgo.Guild {
+	b.guildsMu.RLock()
+	defer b.guildsMu.RUnlock()
+
+	guilds := make(map[string]*discordgo.Guild, len(b.guilds))
+
+	for id, guild := range b.guilds {
+		if grant.CanAccessGuild(id) {
+			guilds[id] = guild
+		}
+	}
+
+	return guilds
+}
+
+// onGuildCreate fires for every guild after Ready, whenever a guild becomes available
+// again and when the bot joins a guild.
+func (b *Bot) onGuildCreate(s *discordgo.Session, event *discordgo.GuildCreate) {
+	guild := event.Guild
+
+	if len(guild.Channels) == 0 {
+		channels, err := b.clientOf(s).GuildChannels(guild.ID)
+		if err != nil {
+			b.logger.Error("failed to fetch channels of guild %s: %v", guild.ID, err)
+		}
+
+		guild.Channels = channels
+	}
+
+	b.cacheGuild(guild)
+
+	if err := b.CreateOrUpdateGuild(guild); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	for _, thread := range guild.Threads {
+		if err := b.CreateOrUpdateThread(thread); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+
+	if err := b.CreateOrUpdateRoles(guild.ID, guild.Roles); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.syncVoiceStates(guild)
+	b.cachePresences(guild)
+
+	b.broadcastGuild(guildEvent{Guild: guild}, guild.ID)
+
+	b.requestMembers(b.clientOf(s), guild.ID)
+
+	// Guilds joined after Ready weren't in it, so their commands are synced here.
+	if guildIDs := b.claimCommandSync(guild); len(guildIDs) > 0 {
+		go b.syncCommands(b.clientOf(s), guildIDs, false)
+	}
+
+	go b.backfillGuildOnce(guild)
+}
+
+func (b *Bot) onGuildUpdate(_ *discordgo.Session, event *discordgo.GuildUpdate) {
+	guild := event.Guild
+
+	b.guildsMu.Lock()
+	if cached, ok := b.guilds[guild.ID]; ok {
+		mergeGuild(guild, cached)
+	}
+	b.guilds[guild.ID] = guild
+	b.guildsMu.Unlock()
+
+	if err := b.store.SaveGuild(guild); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	if len(guild.Roles) > 0 {
+		if err := b.CreateOrUpdateRoles(guild.ID, guild.Roles); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+
+	b.broadcastGuild(guildEvent{Guild: guild}, guild.ID)
+}
+
+func (b *Bot) onGuildDelete(_ *discordgo.Session, event *discordgo.GuildDelete) {
+	// Unavailable guilds are part of an outage and come back with a GuildCreate.
+	if event.Unavailable {
+		return
+	}
+
+	b.guildsMu.Lock()
+	delete(b.guilds, event.ID)
+	// A guild that adds the bot back needs its commands again.
+	delete(b.commandsSynced, event.ID)
+	b.guildsMu.Unlock()
+
+	b.presences.forget(event.ID)
+
+	if err := b.store.DeleteGuild(event.ID); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.broadcastGuild(guildEvent{Guild: event.Guild, Removed: true}, event.ID)
+}
+
+func (b *Bot) onChannelCreate(_ *discordgo.Session, event *discordgo.ChannelCreate) {
+	b.storeChannel(event.Channel)
+}
+
+func (b *Bot) onChannelUpdate(_ *discordgo.Session, event *discordgo.ChannelUpdate) {
+	b.storeChannel(event.Channel)
+}
+
+func (b *Bot) onChannelDelete(_ *discordgo.Session, event *discordgo.ChannelDelete) {
+	channel := event.Channel
+
+	if channel.GuildID == "" {
+		return
+	}
+
+	b.guildsMu.Lock()
+	if guild, ok := b.guilds[channel.GuildID]; ok {
+		for i, cached := range guild.Channels {
+			if cached.ID == channel.ID {
+				guild.Channels = append(guild.Channels[:i:i], guild.Channels[i+1:]...)
+				break
+			}
+		}
+	}
+	b.guildsMu.Unlock()
+
+	if err := b.store.DeleteChannel(channel.ID); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.broadcastChannel(channelEvent{Channel: channel, Deleted: true})
+}
+
+// storeChannel caches and persists a created or changed channel. DM channels go to the
+// DM inbox.
+func (b *Bot) storeChannel(channel *discordgo.Channel) {
+	if channel.GuildID == "" {
+		if channel.Type == discordgo.ChannelTypeDM {
+			b.storeDM(channel)
+		}
+
+		return
+	}
+
+	b.guildsMu.Lock()
+	if guild, ok := b.guilds[channel.GuildID]; ok {
+		replaced := false
+
+		for i, cached := range guild.Channels {
+			if cached.ID == channel.ID {
+				guild.Channels[i] = channel
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			guild.Channels = append(guild.Channels, channel)
+		}
+	}
+	b.guildsMu.Unlock()
+
+	if err := b.CreateOrUpdateChannel(channel.GuildID, channel); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.broadcastChannel(channelEvent{Channel: channel})
+}
+
+// mergeGuild fills in what a GUILD_UPDATE leaves out, such as the members, voice states
+// and presences, from the cached guild it replaces.
+func mergeGuild(update, cached *discordgo.Guild) {
+	if update.JoinedAt.IsZero() {
+		update.JoinedAt = cached.JoinedAt
+	}
+
+	if update.MemberCount == 0 {
+		update.MemberCount = cached.MemberCount
+	}
+
+	update.Large = update.Large || cached.Large
+
+	if len(update.Roles) == 0 {
+		update.Roles = cached.Roles
+	}
+
+	if len(update.Emojis) == 0 {
+		update.Emojis = cached.Emojis
+	}
+
+	if len(update.Members) == 0 {
+		update.Members = cached.Members
+	}
+
+	if len(update.Presences) == 0 {
+		update.Presences = cached.Presences
+	}
+
+	if len(update.Channels) == 0 {
+		update.Channels = cached.Channels
+	}
+
+	if len(update.Threads) == 0 {
+		update.Threads = cached.Threads
+	}
+
+	if len(update.VoiceStates) == 0 {
+		update.VoiceStates = cached.VoiceStates
+	}
+
+	if len(update.StageInstances) == 0 {
+		update.StageInstances = cached.StageInstances
+	}
+}
+
+func (b *Bot) cacheGuild(guild *discordgo.Guild) {
+	b.guildsMu.Lock()
+	defer b.guildsMu.Unlock()
+
+	b.guilds[guild.ID] = guild
+}
+
+// backfillGuildOnce backfills the threads of a guild the first time it becomes
+// available in this process.
+func (b *Bot) backfillGuildOnce(guild *discordgo.Guild) {
+	b.guildsMu.Lock()
+	_, done := b.backfilled[guild.ID]
+	b.backfilled[guild.ID] = struct{}{}
+	b.guildsMu.Unlock()
+
+	if !done {
+		b.backfillThreads(guild)
+	}
+}
+
+// broadcastGuild sends a guild event to every web client allowed to see the guild.
+func (b *Bot) broadcastGuild(event guildEvent, guildID string) {
+	b.sendJSONReponse(event, &wshub.WSPayload{Action: wshub.ServerGuildUpdated, MessageID: guildID, GuildID: guildID})
+}
+
+// broadcastChannel sends a channel event to every web client allowed to see its guild.
+func (b *Bot) broadcastChannel(event channelEvent) {
+	b.sendJSONReponse(event, &wshub.WSPayload{
+		Action:    wshub.ServerChannelUpdated,
+		MessageID: event.Channel.ID,
+		GuildID:   event.Channel.GuildID,
+	})
+}
+
+// CreateOrUpdateGuild stores a guild together with its channels.
+func (b *Bot) CreateOrUpdateGuild(guild *discordgo.Guild) error {
+	if err := b.store.SaveGuild(guild); err != nil {
+		return err
+	}
+
+	for _, channel := range guild.Channels {
+		if err := b.CreateOrUpdateChannel(guild.ID, channel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateOrUpdateChannel stores a guild channel and the tags it offers if it is a forum.
+func (b *Bot) CreateOrUpdateChannel(guildID string, channel *discordgo.Channel) error {
+	if channel.IsThread() {
+		return b.CreateOrUpdateThread(channel)
+	}
+
+	if err := b.store.SaveChannel(guildID, channel); err != nil {
+		return err
+	}
+
+	return b.CreateOrUpdateForumTags(channel)
+}
@@ -0,0 +1,241 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// InteractionContext carries an interaction and tracks whether it has already been answered.
+type InteractionContext struct {
+	Session     DiscordClient
+	Interaction *discordgo.InteractionCreate
+	deferred    bool
+	responded   bool
+}
+
+// CommandContext is the InteractionContext of a slash command with its parsed options.
+type CommandContext struct {
+	InteractionContext
+	Options map[string]*discordgo.ApplicationCommandInteractionDataOption
+	// Focused is the option being typed during an autocomplete request.
+	Focused *discordgo.ApplicationCommandInteractionDataOption
+}
+
+func newCommandContext(
+	s DiscordClient,
+	i *discordgo.InteractionCreate,
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+) *CommandContext {
+	ctx := &CommandContext{
+		InteractionContext: InteractionContext{Session: s, Interaction: i},
+		Options:            make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options)),
+	}
+
+	for _, option := range options {
+		ctx.Options[option.Name] = option
+
+		if option.Focused {
+			ctx.Focused = option
+		}
+	}
+
+	return ctx
+}
+
+func (b *Bot) onInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand, discordgo.InteractionApplicationCommandAutocomplete:
+		b.handleCommand(s, i)
+	case discordgo.InteractionMessageComponent, discordgo.InteractionModalSubmit:
+		b.handleComponent(s, i)
+	}
+}
+
+// String returns the value of a string option, or "" when it was not provided.
+func (ctx *CommandContext) String(name string) string {
+	if option, ok := ctx.Options[name]; ok {
+		return option.StringValue()
+	}
+
+	return ""
+}
+
+// Int returns the value of an integer option, or 0 when it was not provided.
+func (ctx *CommandContext) Int(name string) int64 {
+	if option, ok := ctx.Options[name]; ok {
+		return option.IntValue()
+	}
+
+	return 0
+}
+
+// Float returns the value of a number option, or 0 when it was not provided.
+func (ctx *CommandContext) Float(name string) float64 {
+	if option, ok := ctx.Options[name]; ok {
+		return option.FloatValue()
+	}
+
+	return 0
+}
+
+// Bool returns the value of a boolean option, or false when it was not provided.
+func (ctx *CommandContext) Bool(name string) bool {
+	if option, ok := ctx.Options[name]; ok {
+		return option.BoolValue()
+	}
+
+	return false
+}
+
+// User returns the user passed to a user option, or nil when it was not provided.
+func (ctx *CommandContext) User(name string) *discordgo.User {
+	option, ok := ctx.Options[name]
+	if !ok {
+		return nil
+	}
+
+	user := option.UserValue(nil)
+
+	if resolved := ctx.resolved(); resolved != nil {
+		if full, ok := resolved.Users[user.ID]; ok {
+			return full
+		}
+	}
+
+	return user
+}
+
+// Channel returns the channel passed to a channel option, or nil when it was not provided.
+func (ctx *CommandContext) Channel(name string) *discordgo.Channel {
+	option, ok := ctx.Options[name]
+	if !ok {
+		return nil
+	}
+
+	channel := option.ChannelValue(nil)
+
+	if resolved := ctx.resolved(); resolved != nil {
+		if full, ok := resolved.Channels[channel.ID]; ok {
+			return full
+		}
+	}
+
+	return channel
+}
+
+// Role returns the role passed to a role option, or nil when it was not provided.
+func (ctx *CommandContext) Role(name string) *discordgo.Role {
+	option, ok := ctx.Options[name]
+	if !ok {
+		return nil
+	}
+
+	role := option.RoleValue(nil, ctx.Interaction.GuildID)
+
+	if resolved := ctx.resolved(); resolved != nil {
+		if full, ok := resolved.Roles[role.ID]; ok {
+			return full
+		}
+	}
+
+	return role
+}
+
+// resolved holds the users, channels and roles Discord sends along with the options.
+func (ctx *CommandContext) resolved() *discordgo.ApplicationCommandInteractionDataResolved {
+	if ctx.Interaction.Type != discordgo.InteractionApplicationCommand {
+		return nil
+	}
+
+	return ctx.Interaction.ApplicationCommandData().Resolved
+}
+
+// Defer acknowledges the interaction so the handler can take longer than Discord's
+// three second limit. The final answer is then sent with Respond or Reply.
+func (ctx *InteractionContext) Defer(ephemeral bool) error {
+	data := &discordgo.InteractionResponseData{}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.deferred = true
+
+	return nil
+}
+
+// Reply answers the interaction with a plain text message.
+func (ctx *InteractionContext) Reply(content string) error {
+	return ctx.Respond(&discordgo.InteractionResponseData{Content: content})
+}
+
+// ReplyEphemeral answers the interaction with a message only the invoking user can see.
+func (ctx *InteractionContext) ReplyEphemeral(content string) error {
+	return ctx.Respond(&discordgo.InteractionResponseData{Content: content, Flags: discordgo.MessageFlagsEphemeral})
+}
+
+// Respond answers the interaction. Deferred interactions get their placeholder edited,
+// and interactions that were already answered get a follow-up message instead.
+func (ctx *InteractionContext) Respond(data *discordgo.InteractionResponseData) error {
+	switch {
+	case ctx.responded:
+		_, err := ctx.Session.FollowupMessageCreate(ctx.Interaction.Interaction, true, &discordgo.WebhookParams{
+			Content:         data.Content,
+			Embeds:          data.Embeds,
+			Components:      data.Components,
+			AllowedMentions: data.AllowedMentions,
+			Files:           data.Files,
+			Flags:           data.Flags,
+		})
+
+		return err
+	case ctx.deferred:
+		_, err := ctx.Session.InteractionResponseEdit(ctx.Interaction.Interaction, &discordgo.WebhookEdit{
+			Content:         &data.Content,
+			Embeds:          &data.Embeds,
+			Components:      &data.Components,
+			AllowedMentions: data.AllowedMentions,
+			Files:           data.Files,
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx.responded = true
+
+	return nil
+}
+
+// ShowModal answers the interaction by opening a modal dialog.
+func (ctx *InteractionContext) ShowModal(customID, title string, components ...discordgo.MessageComponent) error {
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   customID,
+			Title:      title,
+			Components: components,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.responded = true
+
+	return nil
+}
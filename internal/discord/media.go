@@ -0,0 +1,37 @@
+package discord
+
+import (
+	"discord-go-connect/internal/media"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// UseMediaMirror mirrors the attachments of stored messages, and the avatars and emoji
+// the mirror is configured for.
+func (b *Bot) UseMediaMirror(mirror *media.Mirror) {
+	b.mediaMirror = mirror
+}
+
+func (b *Bot) mirrorMedia(messages []*discordgo.Message) {
+	if b.mediaMirror == nil || len(messages) == 0 {
+		return
+	}
+
+	b.mediaMirror.MirrorMessages(messages)
+}
+
+// collectMedia deletes the mirrored files of messages that were pruned or deleted.
+func (b *Bot) collectMedia() {
+	if b.mediaMirror == nil {
+		return
+	}
+
+	collected, err := b.mediaMirror.CollectGarbage()
+	if err != nil {
+		b.logger.Error("failed to collect mirrored media: %v", err)
+	}
+
+	if collected > 0 {
+		b.logger.Info("deleted %d mirrored files", collected)
+	}
+}
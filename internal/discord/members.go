@@ -0,0 +1,136 @@
+package discord
+
+import (
+	"discord-go-connect/internal/wshub"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// memberEvent is pushed to guild subscribers when a member joins, changes or leaves.
+type memberEvent struct {
+	Member  *discordgo.Member `json:"member"`
+	GuildID string            `json:"guild_id"`
+	Removed bool              `json:"removed"`
+}
+
+// roleEvent is pushed to guild subscribers when a role is created, changed or deleted.
+type roleEvent struct {
+	Role    *discordgo.Role `json:"role"`
+	GuildID string          `json:"guild_id"`
+	Deleted bool            `json:"deleted"`
+}
+
+// requestMembers asks Discord for every member of a guild. They arrive in chunks, and
+// members that are missing once the last chunk is stored have left while the bot was away.
+func (b *Bot) requestMembers(s DiscordClient, guildID string) {
+	b.guildsMu.Lock()
+	b.memberSyncs[guildID] = time.Now().UTC()
+	b.guildsMu.Unlock()
+
+	if err := s.RequestGuildMembers(guildID, "", 0, guildID, false); err != nil {
+		b.logger.Error("failed to request members of guild %s: %v", guildID, err)
+	}
+}
+
+func (b *Bot) onGuildMembersChunk(_ *discordgo.Session, chunk *discordgo.GuildMembersChunk) {
+	for _, member := range chunk.Members {
+		member.GuildID = chunk.GuildID
+
+		if err := b.CreateOrUpdateMember(member); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+
+	if chunk.ChunkIndex != chunk.ChunkCount-1 {
+		return
+	}
+
+	b.guildsMu.Lock()
+	started, ok := b.memberSyncs[chunk.GuildID]
+	delete(b.memberSyncs, chunk.GuildID)
+	b.guildsMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err := b.store.DeleteStaleMembers(chunk.GuildID, started); err != nil {
+		b.logger.Error("%v", err)
+	}
+}
+
+func (b *Bot) onGuildMemberAdd(_ *discordgo.Session, event *discordgo.GuildMemberAdd) {
+	b.storeMember(event.Member)
+}
+
+func (b *Bot) onGuildMemberUpdate(_ *discordgo.Session, event *discordgo.GuildMemberUpdate) {
+	b.storeMember(event.Member)
+}
+
+func (b *Bot) onGuildMemberRemove(_ *discordgo.Session, event *discordgo.GuildMemberRemove) {
+	member := event.Member
+	if member.User == nil {
+		return
+	}
+
+	if err := b.store.DeleteMember(member.GuildID, member.User.ID); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publish(memberEvent{Member: member, GuildID: member.GuildID, Removed: true},
+		&wshub.WSPayload{Action: wshub.ServerMemberUpdated, MessageID: member.User.ID},
+		topic(topicGuild, member.GuildID))
+}
+
+func (b *Bot) storeMember(member *discordgo.Member) {
+	if err := b.CreateOrUpdateMember(member); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	if member.User == nil {
+		return
+	}
+
+	b.publish(memberEvent{Member: member, GuildID: member.GuildID},
+		&wshub.WSPayload{Action: wshub.ServerMemberUpdated, MessageID: member.User.ID},
+		topic(topicGuild, member.GuildID))
+}
+
+func (b *Bot) onGuildRoleCreate(_ *discordgo.Session, event *discordgo.GuildRoleCreate) {
+	b.storeRole(event.GuildID, event.Role)
+}
+
+func (b *Bot) onGuildRoleUpdate(_ *discordgo.Session, event *discordgo.GuildRoleUpdate) {
+	b.storeRole(event.GuildID, event.Role)
+}
+
+func (b *Bot) onGuildRoleDelete(_ *discordgo.Session, event *discordgo.GuildRoleDelete) {
+	if err := b.store.DeleteRole(event.RoleID); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publish(roleEvent{Role: &discordgo.Role{ID: event.RoleID}, GuildID: event.GuildID, Deleted: true},
+		&wshub.WSPayload{Action: wshub.ServerRoleUpdated, MessageID: event.RoleID},
+		topic(topicGuild, event.GuildID))
+}
+
+func (b *Bot) storeRole(guildID string, role *discordgo.Role) {
+	if err := b.store.SaveRole(guildID, role); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publish(roleEvent{Role: role, GuildID: guildID},
+		&wshub.WSPayload{Action: wshub.ServerRoleUpdated, MessageID: role.ID},
+		topic(topicGuild, guildID))
+}
+
+// CreateOrUpdateRoles replaces the stored roles of a guild with the given ones.
+func (b *Bot) CreateOrUpdateRoles(guildID string, roles []*discordgo.Role) error {
+	return b.store.ReplaceRoles(guildID, roles)
+}
+
+// CreateOrUpdateMember stores a guild member, its user and the roles it holds.
+func (b *Bot) CreateOrUpdateMember(member *discordgo.Member) error {
+	return b.store.SaveMember(member)
+}
@@ -0,0 +1,263 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	// ErrForbidden is returned when the caller's grant or the bot's permissions do not allow an action.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is returned when the target channel or message does not exist.
+	ErrNotFound = errors.New("not found")
+)
+
+// MessageEdit holds the fields of a message that can be changed. Nil fields are left as they are.
+type MessageEdit struct {
+	Content         *string                           `json:"content,omitempty"`
+	Embeds          []*discordgo.MessageEmbed         `json:"embeds,omitempty"`
+	Components      []json.RawMessage                 `json:"components,omitempty"`
+	AllowedMentions *discordgo.MessageAllowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+// MessageActionResult is returned to callers of the message actions.
+type MessageActionResult struct {
+	Message   *discordgo.Message `json:"message,omitempty"`
+	Action    string             `json:"action"`
+	ChannelID string             `json:"channel_id"`
+	MessageID string             `json:"message_id"`
+	Emoji     string             `json:"emoji,omitempty"`
+	OK        bool               `json:"ok"`
+}
+
+// messageActionRequest is the Data of the message action WebSocket requests.
+type messageActionRequest struct {
+	Edit      *MessageEdit `json:"edit,omitempty"`
+	ChannelID string       `json:"channel_id"`
+	MessageID string       `json:"message_id"`
+	Emoji     string       `json:"emoji,omitempty"`
+}
+
+// authorizeChannel checks that the caller may act in a channel and that the bot holds
+// permission there, returning the channel.
+func (b *Bot) authorizeChannel(grant *auth.Grant, channelID string, permission int64) (*discordgo.Channel, error) {
+	channel, err := b.channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !grant.CanAccessGuild(channel.GuildID) {
+		return nil, fmt.Errorf("%w: no access to channel %s", ErrForbidden, channelID)
+	}
+
+	if channel.GuildID == "" || permission == 0 {
+		return channel, nil
+	}
+
+	session := b.sessionFor(channel.GuildID)
+
+	permissions, err := session.UserChannelPermissions(b.UserID(), channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve permissions for channel %s: %w", channelID, err)
+	}
+
+	if permissions&permission != permission {
+		return nil, fmt.Errorf("%w: bot lacks permission in channel %s", ErrForbidden, channelID)
+	}
+
+	return channel, nil
+}
+
+func (b *Bot) channel(channelID string) (*discordgo.Channel, error) {
+	if _, channel, ok := b.stateChannel(channelID); ok {
+		return channel, nil
+	}
+
+	channel, err := b.sessionFor("").Channel(channelID)
+	if err != nil {
+		return nil, wrapRESTError(err, "channel "+channelID)
+	}
+
+	return channel, nil
+}
+
+// wrapRESTError maps Discord's 403 and 404 responses onto ErrForbidden and ErrNotFound.
+func wrapRESTError(err error, target string) error {
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil {
+		switch restErr.Response.StatusCode {
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrNotFound, target)
+		case http.StatusForbidden:
+			return fmt.Errorf("%w: %s", ErrForbidden, target)
+		}
+	}
+
+	return err
+}
+
+// EditMessage edits a message the bot has authored.
+func (b *Bot) EditMessage(grant *auth.Grant, channelID, messageID string, edit *MessageEdit) (*discordgo.Message, error) {
+	channel, err := b.authorizeChannel(grant, channelID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	session := b.sessionFor(channel.GuildID)
+
+	message, err := session.ChannelMessage(channelID, messageID)
+	if err != nil {
+		return nil, wrapRESTError(err, "message "+messageID)
+	}
+
+	if message.Author == nil || message.Author.ID != b.UserID() {
+		return nil, fmt.Errorf("%w: only messages sent by the bot can be edited", ErrForbidden)
+	}
+
+	components, err := decodeComponents(edit.Components)
+	if err != nil {
+		return nil, err
+	}
+
+	// discordgo sends nil embeds and components as null, which would clear them.
+	data := discordgo.NewMessageEdit(channelID, messageID)
+	data.Content = edit.Content
+	data.AllowedMentions = edit.AllowedMentions
+	data.Embeds = message.Embeds
+	data.Components = message.Components
+
+	if edit.Embeds != nil {
+		data.Embeds = edit.Embeds
+	}
+
+	if edit.Components != nil {
+		data.Components = components
+	}
+
+	edited, err := session.ChannelMessageEditComplex(data)
+	if err != nil {
+		return nil, wrapRESTError(err, "message "+messageID)
+	}
+
+	return edited, nil
+}
+
+// DeleteMessage deletes a message. Messages of other users need Manage Messages.
+func (b *Bot) DeleteMessage(grant *auth.Grant, channelID, messageID string) error {
+	channel, err := b.authorizeChannel(grant, channelID, 0)
+	if err != nil {
+		return err
+	}
+
+	session := b.sessionFor(channel.GuildID)
+
+	message, err := session.ChannelMessage(channelID, messageID)
+	if err != nil {
+		return wrapRESTError(err, "message "+messageID)
+	}
+
+	if message.Author == nil || message.Author.ID != b.UserID() {
+		if _, err := b.authorizeChannel(grant, channelID, discordgo.PermissionManageMessages); err != nil {
+			return err
+		}
+	}
+
+	return wrapRESTError(session.ChannelMessageDelete(channelID, messageID), "message "+messageID)
+}
+
+// PinMessage pins or unpins a message.
+func (b *Bot) PinMessage(grant *auth.Grant, channelID, messageID string, pin bool) error {
+	channel, err := b.authorizeChannel(grant, channelID, discordgo.PermissionManageMessages)
+	if err != nil {
+		return err
+	}
+
+	session := b.sessionFor(channel.GuildID)
+
+	if pin {
+		return wrapRESTError(session.ChannelMessagePin(channelID, messageID), "message "+messageID)
+	}
+
+	return wrapRESTError(session.ChannelMessageUnpin(channelID, messageID), "message "+messageID)
+}
+
+// ReactToMessage adds or removes the bot's reaction. Emoji is either a unicode emoji
+// or a custom one written as name:id.
+func (b *Bot) ReactToMessage(grant *auth.Grant, channelID, messageID, emoji string, add bool) error {
+	permission := int64(0)
+	if add {
+		permission = discordgo.PermissionAddReactions
+	}
+
+	channel, err := b.authorizeChannel(grant, channelID, permission)
+	if err != nil {
+		return err
+	}
+
+	session := b.sessionFor(channel.GuildID)
+
+	if add {
+		return wrapRESTError(session.MessageReactionAdd(channelID, messageID, emoji), "message "+messageID)
+	}
+
+	return wrapRESTError(session.MessageReactionRemove(channelID, messageID, emoji, "@me"), "message "+messageID)
+}
+
+// handleMessageAction runs the edit, delete, pin and reaction requests of web clients.
+func (b *Bot) handleMessageAction(payload *wshub.WSPayload) {
+	var request messageActionRequest
+	if err := json.Unmarshal(payload.Data, &request); err != nil {
+		b.sendError(payload, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	grant := b.keys.Grant(payload.Caller)
+	result := MessageActionResult{
+		Action:    string(payload.Action),
+		ChannelID: request.ChannelID,
+		MessageID: request.MessageID,
+		Emoji:     request.Emoji,
+	}
+
+	var err error
+
+	switch wshub.Action[wshub.ClientAction](payload.Action) {
+	case wshub.ClientEditMessage:
+		if request.Edit == nil {
+			err = errors.New("edit is required")
+			break
+		}
+
+		result.Message, err = b.EditMessage(grant, request.ChannelID, request.MessageID, request.Edit)
+	case wshub.ClientDeleteMessage:
+		err = b.DeleteMessage(grant, request.ChannelID, request.MessageID)
+	case wshub.ClientPinMessage:
+		err = b.PinMessage(grant, request.ChannelID, request.MessageID, true)
+	case wshub.ClientUnpinMessage:
+		err = b.PinMessage(grant, request.ChannelID, request.MessageID, false)
+	case wshub.ClientAddReaction:
+		err = b.ReactToMessage(grant, request.ChannelID, request.MessageID, request.Emoji, true)
+	case wshub.ClientRemoveReaction:
+		err = b.ReactToMessage(grant, request.ChannelID, request.MessageID, request.Emoji, false)
+	}
+
+	if err != nil {
+		b.sendError(payload, err)
+		return
+	}
+
+	result.OK = true
+
+	b.sendJSONReponse(result, &wshub.WSPayload{
+		Action:    wshub.ServerMessageActionResult,
+		MessageID: request.MessageID,
+		Receiver:  payload.Receiver,
+		Nonce:     payload.Nonce,
+	})
+}
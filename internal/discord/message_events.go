@@ -0,0 +1,82 @@
+package discord
+
+import (
+	"discord-go-connect/internal/wshub"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// deletedMessage is pushed to subscribers when a message is removed.
+type deletedMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+}
+
+func (b *Bot) onMessageUpdate(_ *discordgo.Session, msg *discordgo.MessageUpdate) {
+	// Updates without an author only carry embed unfurls and would blank the content.
+	if msg.Author == nil {
+		return
+	}
+
+	if !b.writer.UpdateMessage(msg.Message) {
+		if err := b.UpdateMessage(msg.Message); err != nil {
+			b.logger.Error("%v", err)
+		}
+
+		b.indexMessages(msg.Message)
+	}
+
+	b.publish(msg.Message, &wshub.WSPayload{Action: wshub.ServerMessageUpdated, MessageID: msg.ChannelID},
+		topic(topicGuild, msg.GuildID), topic(topicThread, msg.ChannelID))
+}
+
+func (b *Bot) onMessageDelete(_ *discordgo.Session, msg *discordgo.MessageDelete) {
+	if b.removeMessage(msg.GuildID, msg.ChannelID, msg.ID) {
+		b.collectMedia()
+	}
+}
+
+func (b *Bot) onMessageDeleteBulk(_ *discordgo.Session, event *discordgo.MessageDeleteBulk) {
+	stored := false
+
+	for _, id := range event.Messages {
+		if b.removeMessage(event.GuildID, event.ChannelID, id) {
+			stored = true
+		}
+	}
+
+	if stored {
+		b.collectMedia()
+	}
+}
+
+// removeMessage deletes a message and reports whether it had been stored, so its
+// mirrored files may need collecting.
+func (b *Bot) removeMessage(guildID, channelID, messageID string) bool {
+	// A message still in the writer's buffer was never stored, but reactions to it may be.
+	buffered := b.writer.RemoveMessage(messageID)
+
+	if buffered {
+		if err := b.store.DeleteReactions(messageID, ""); err != nil {
+			b.logger.Error("%v", err)
+		}
+	} else {
+		if err := b.store.DeleteMessage(messageID); err != nil {
+			b.logger.Error("%v", err)
+		}
+
+		b.unindexMessage(messageID)
+	}
+
+	b.publish(deletedMessage{ID: messageID, ChannelID: channelID, GuildID: guildID},
+		&wshub.WSPayload{Action: wshub.ServerMessageDeleted, MessageID: channelID},
+		topic(topicGuild, guildID), topic(topicThread, channelID))
+
+	return !buffered
+}
+
+// UpdateMessage stores the new content, pin state and edit time of a message.
+func (b *Bot) UpdateMessage(message *discordgo.Message) error {
+	return b.store.UpdateMessage(message)
+}
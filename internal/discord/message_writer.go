@@ -36,7 +36,7 @@ func (mw *messageWriter) AddMessage(msg *discordgo.MessageCreate) {
 	mw.writeCounter++
 
 	if len(mw.WriteBuffer) >= mw.b.maxBufferCount || mw.writeCounter >= mw.b.maxBufferCount {
-		mw.writeToDatabase()
+		mw.flushLocked()
 		return
 	}
 
@@ -59,6 +59,11 @@ func (mw *messageWriter) writeToDatabase() {
 	mw.writeMu.Lock()
 	defer mw.writeMu.Unlock()
 
+	mw.flushLocked()
+}
+
+// flushLocked stores the buffered messages. The caller holds writeMu.
+func (mw *messageWriter) flushLocked() {
 	if len(mw.WriteBuffer) == 0 {
 		return
 	}
@@ -71,3 +76,36 @@ func (mw *messageWriter) writeToDatabase() {
 	mw.WriteBuffer = make([]*discordgo.MessageCreate, 0)
 	mw.writeCounter = 0
 }
+
+// UpdateMessage applies an edit to a buffered message and reports whether it was buffered.
+func (mw *messageWriter) UpdateMessage(msg *discordgo.Message) bool {
+	mw.writeMu.Lock()
+	defer mw.writeMu.Unlock()
+
+	for _, buffered := range mw.WriteBuffer {
+		if buffered.ID == msg.ID {
+			buffered.Content = msg.Content
+			buffered.Pinned = msg.Pinned
+			buffered.EditedTimestamp = msg.EditedTimestamp
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoveMessage drops a buffered message and reports whether it was buffered.
+func (mw *messageWriter) RemoveMessage(id string) bool {
+	mw.writeMu.Lock()
+	defer mw.writeMu.Unlock()
+
+	for i, buffered := range mw.WriteBuffer {
+		if buffered.ID == id {
+			mw.WriteBuffer = append(mw.WriteBuffer[:i], mw.WriteBuffer[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
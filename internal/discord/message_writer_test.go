@@ -0,0 +1,185 @@
+package discord
+
+import (
+	"discord-go-connect/internal/db/dbtest"
+	"discord-go-connect/internal/metrics"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestWriterStoresBufferedMessages(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.gateway.Emit(0, testMessage("100", "1", "10", "hello"))
+	tb.gateway.Emit(0, testMessage("101", "", "20", "a DM"))
+
+	if got := len(tb.db.Executions("INSERT INTO Message")); got != 0 {
+		t.Fatalf("messages stored before the flush = %d, want 0", got)
+	}
+
+	commits := len(tb.db.Executions("COMMIT"))
+
+	tb.writer.writeToDatabase()
+
+	messages := tb.db.Executions("INSERT INTO Message")
+	if len(messages) != 2 {
+		t.Fatalf("stored messages = %d, want 2", len(messages))
+	}
+
+	guildMessage, dm := messages[0].Args, messages[1].Args
+	if guildMessage[0] != "100" || guildMessage[2] != "1" || guildMessage[4] != "42" || guildMessage[7] != "hello" {
+		t.Fatalf("guild message args = %v", guildMessage)
+	}
+
+	if dm[0] != "101" || dm[2] != nil || dm[4] != nil {
+		t.Fatalf("DM args = %v, want no guild and no member", dm)
+	}
+
+	if stamp, ok := guildMessage[8].(time.Time); !ok || !stamp.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("timestamp = %v", guildMessage[8])
+	}
+
+	if members := tb.db.Executions("INSERT INTO Member"); len(members) != 1 || members[0].Args[1] != "1" {
+		t.Fatalf("stored members = %v, want one in guild 1", members)
+	}
+
+	if got := len(tb.db.Executions("COMMIT")) - commits; got != 1 {
+		t.Fatalf("commits = %d, want the batch in one transaction", got)
+	}
+
+	if len(tb.writer.WriteBuffer) != 0 {
+		t.Fatalf("buffer holds %d messages after the flush", len(tb.writer.WriteBuffer))
+	}
+}
+
+func TestWriterFlushesFullBuffer(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+	tb.maxBufferCount = 2
+
+	tb.gateway.Emit(0, testMessage("100", "1", "10", "one"))
+	tb.gateway.Emit(0, testMessage("101", "1", "10", "two"))
+
+	if got := len(tb.db.Executions("INSERT INTO Message")); got != 2 {
+		t.Fatalf("stored messages = %d, want 2", got)
+	}
+}
+
+func TestEditsApplyToBufferOrDatabase(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.gateway.Emit(0, testMessage("100", "1", "10", "draft"))
+
+	edit := testMessage("100", "1", "10", "final").Message
+	tb.gateway.Emit(0, &discordgo.MessageUpdate{Message: edit})
+
+	if got := tb.writer.WriteBuffer[0].Content; got != "final" {
+		t.Fatalf("buffered content = %q, want final", got)
+	}
+
+	if got := len(tb.db.Executions("UPDATE Message")); got != 0 {
+		t.Fatalf("updates of a buffered message = %d, want 0", got)
+	}
+
+	tb.writer.writeToDatabase()
+	tb.gateway.Emit(0, &discordgo.MessageUpdate{Message: testMessage("100", "1", "10", "again").Message})
+
+	updates := tb.db.Executions("UPDATE Message")
+	if len(updates) != 1 || updates[0].Args[0] != "again" || updates[0].Args[3] != "100" {
+		t.Fatalf("updates = %v, want one setting the new content", updates)
+	}
+}
+
+func TestDeletesRemoveFromBufferOrDatabase(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	tb.gateway.Emit(0, testMessage("100", "1", "10", "buffered"))
+	tb.gateway.Emit(0, &discordgo.MessageDelete{Message: &discordgo.Message{ID: "100", ChannelID: "10", GuildID: "1"}})
+
+	if len(tb.writer.WriteBuffer) != 0 {
+		t.Fatal("deleted message is still buffered")
+	}
+
+	tb.gateway.Emit(0, &discordgo.MessageDelete{Message: &discordgo.Message{ID: "99", ChannelID: "10", GuildID: "1"}})
+
+	if deletes := tb.db.Executions("DELETE FROM Message WHERE"); len(deletes) != 1 || deletes[0].Args[0] != "99" {
+		t.Fatalf("deletes = %v, want one for the stored message", deletes)
+	}
+}
+
+func TestWriterIsolatesFailedMessages(t *testing.T) {
+	tb := newTestBot(t, ShardConfig{IDs: []int{0}, Count: 1})
+	tb.ready(t, testGuild("1", "10"))
+
+	// 101 can't be stored and 102 was stored before, such as by a backfill.
+	tb.db.Respond(func(exec dbtest.Execution) (int64, error) {
+		if !strings.HasPrefix(exec.Query, "INSERT INTO Message ") {
+			return 1, nil
+		}
+
+		switch exec.Args[0] {
+		case "101":
+			return 0, errors.New("data too long for column 'content'")
+		case "102":
+			return 0, nil
+		default:
+			return 1, nil
+		}
+	})
+
+	inserted, duplicates, failed := storedMessages(t, "inserted"), storedMessages(t, "duplicate"), storedMessages(t, "failed")
+	commits := len(tb.db.Executions("COMMIT"))
+
+	for _, id := range []string{"100", "101", "102", "103"} {
+		tb.gateway.Emit(0, testMessage(id, "1", "10", "message "+id))
+	}
+
+	tb.writer.writeToDatabase()
+
+	if got := len(tb.db.Executions("INSERT INTO Message ")); got != 4 {
+		t.Fatalf("message inserts = %d, want every message tried", got)
+	}
+
+	if got := len(tb.db.Executions("COMMIT")) - commits; got != 1 {
+		t.Fatalf("commits = %d, want the rest of the batch committed", got)
+	}
+
+	for outcome, want := range map[string]float64{"inserted": inserted + 2, "duplicate": duplicates + 1, "failed": failed + 1} {
+		if got := storedMessages(t, outcome); got != want {
+			t.Errorf("%s messages = %v, want %v", outcome, got, want)
+		}
+	}
+}
+
+// storedMessages reads the counter of messages written to the store with an outcome.
+func storedMessages(t *testing.T, outcome string) float64 {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	prefix := `discord_stored_messages_total{outcome="` + outcome + `"} `
+
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, prefix); ok {
+			count, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("invalid metric %q: %v", line, err)
+			}
+
+			return count
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,231 @@
+package discord
+
+import (
+	"bytes"
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxOutboundFiles is the number of attachments Discord accepts on a single message.
+const maxOutboundFiles = 10
+
+var errEmptyMessage = errors.New("message has no content, embeds, components, stickers or files")
+
+// OutboundMessage is a message sent through the WebSocket or REST API. It mirrors
+// discordgo.MessageSend, adding sticker IDs and base64 encoded files.
+type OutboundMessage struct {
+	AllowedMentions *discordgo.MessageAllowedMentions `json:"allowed_mentions,omitempty"`
+	Reference       *discordgo.MessageReference       `json:"message_reference,omitempty"`
+	Content         string                            `json:"content,omitempty"`
+	Embeds          []*discordgo.MessageEmbed         `json:"embeds,omitempty"`
+	Components      []json.RawMessage                 `json:"components,omitempty"`
+	StickerIDs      []string                          `json:"sticker_ids,omitempty"`
+	Files           []*OutboundFile                   `json:"files,omitempty"`
+	TTS             bool                              `json:"tts,omitempty"`
+}
+
+// OutboundFile is an attachment of an OutboundMessage. Over WebSocket Data holds the
+// base64 encoded file; multipart uploads set Reader instead.
+type OutboundFile struct {
+	Reader      io.Reader `json:"-"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type,omitempty"`
+	Data        []byte    `json:"data,omitempty"`
+}
+
+// sentMessage tells a web client which message its send request created.
+type sentMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// messageSendWithStickers adds the sticker_ids field discordgo.MessageSend lacks.
+type messageSendWithStickers struct {
+	*discordgo.MessageSend
+	StickerIDs []string `json:"sticker_ids"`
+}
+
+// SendMessage sends msg to a channel and returns the created message.
+func (b *Bot) SendMessage(grant *auth.Grant, channelID string, msg *OutboundMessage) (*discordgo.Message, error) {
+	channel, err := b.authorizeChannel(grant, channelID, discordgo.PermissionSendMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.sendMessage(b.sessionFor(channel.GuildID), channelID, msg)
+}
+
+// SendDM opens a DM channel with a user, sends msg and returns the created message.
+// DMs are outside of any guild, so they need an admin grant.
+func (b *Bot) SendDM(grant *auth.Grant, userID string, msg *OutboundMessage) (*discordgo.Message, error) {
+	if !grant.Admin() {
+		return nil, fmt.Errorf("%w: sending DMs needs an admin grant", ErrForbidden)
+	}
+
+	// DMs arrive on shard 0, but REST requests work from any shard.
+	session := b.sessionFor("")
+
+	channel, err := session.UserChannelCreate(userID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create DM channel: %w", wrapRESTError(err, "user "+userID))
+	}
+
+	return b.sendMessage(session, channel.ID, msg)
+}
+
+func (b *Bot) sendMessage(session DiscordClient, channelID string, msg *OutboundMessage) (*discordgo.Message, error) {
+	data, err := msg.messageSend()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg.StickerIDs) == 0 {
+		return session.ChannelMessageSendComplex(channelID, data)
+	}
+
+	return b.sendWithStickers(session, channelID, data, msg.StickerIDs)
+}
+
+func (b *Bot) sendWithStickers(session DiscordClient, channelID string, data *discordgo.MessageSend, stickerIDs []string) (*discordgo.Message, error) {
+	endpoint := discordgo.EndpointChannelMessages(channelID)
+	body := messageSendWithStickers{MessageSend: data, StickerIDs: stickerIDs}
+
+	var (
+		response []byte
+		err      error
+	)
+
+	if len(data.Files) > 0 {
+		contentType, multipartBody, encodeErr := discordgo.MultipartBodyWithJSON(body, data.Files)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+
+		// Multipart bodies bypass RequestWithBucketID, so the bucket it would use is locked here.
+		bucket := session.RateLimiter().LockBucket(endpoint)
+		response, err = session.RequestWithLockedBucket(http.MethodPost, endpoint, contentType, multipartBody, bucket, 0)
+	} else {
+		response, err = session.RequestWithBucketID(http.MethodPost, endpoint, body, endpoint)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var message discordgo.Message
+	if err := json.Unmarshal(response, &message); err != nil {
+		return nil, fmt.Errorf("failed to decode sent message: %w", err)
+	}
+
+	return &message, nil
+}
+
+func (msg *OutboundMessage) messageSend() (*discordgo.MessageSend, error) {
+	if msg.Content == "" && len(msg.Embeds) == 0 && len(msg.Components) == 0 &&
+		len(msg.StickerIDs) == 0 && len(msg.Files) == 0 {
+		return nil, errEmptyMessage
+	}
+
+	if len(msg.Files) > maxOutboundFiles {
+		return nil, fmt.Errorf("a message can have at most %d files", maxOutboundFiles)
+	}
+
+	components, err := decodeComponents(msg.Components)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*discordgo.File, 0, len(msg.Files))
+
+	for _, file := range msg.Files {
+		reader := file.Reader
+		if reader == nil {
+			reader = bytes.NewReader(file.Data)
+		}
+
+		files = append(files, &discordgo.File{Name: file.Name, ContentType: file.ContentType, Reader: reader})
+	}
+
+	return &discordgo.MessageSend{
+		Content:         msg.Content,
+		Embeds:          msg.Embeds,
+		TTS:             msg.TTS,
+		Components:      components,
+		Files:           files,
+		AllowedMentions: msg.AllowedMentions,
+		Reference:       msg.Reference,
+	}, nil
+}
+
+// targetChannel returns the channel of a guild_message or component_message request.
+// component_message requests used to name it in data.channel_id, which is still read
+// when MessageID is empty.
+func targetChannel(payload *wshub.WSPayload) string {
+	if payload.MessageID != "" || len(payload.Data) == 0 ||
+		wshub.Action[wshub.ClientAction](payload.Action) != wshub.ClientComponentMessage {
+		return payload.MessageID
+	}
+
+	var target struct {
+		ChannelID string `json:"channel_id"`
+	}
+
+	if err := json.Unmarshal(payload.Data, &target); err != nil {
+		return ""
+	}
+
+	return target.ChannelID
+}
+
+// outboundMessage reads the message of a send request. Rich messages come in Data;
+// older clients send plain text in Message.
+func outboundMessage(payload *wshub.WSPayload) (*OutboundMessage, error) {
+	if len(payload.Data) == 0 {
+		return &OutboundMessage{Content: payload.Message}, nil
+	}
+
+	var msg OutboundMessage
+	if err := json.Unmarshal(payload.Data, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// sendFromWebSocket handles guild_message, component_message and dm_message requests.
+// MessageID holds the target channel, or the target user for DMs.
+func (b *Bot) sendFromWebSocket(payload *wshub.WSPayload, dm bool) {
+	msg, err := outboundMessage(payload)
+	if err != nil {
+		b.sendError(payload, err)
+		return
+	}
+
+	grant := b.keys.Grant(payload.Caller)
+
+	var message *discordgo.Message
+	if dm {
+		message, err = b.SendDM(grant, payload.MessageID, msg)
+	} else {
+		message, err = b.SendMessage(grant, targetChannel(payload), msg)
+	}
+
+	if err != nil {
+		b.sendError(payload, err)
+		return
+	}
+
+	b.sendJSONReponse(sentMessage{ID: message.ID, ChannelID: message.ChannelID}, &wshub.WSPayload{
+		Action:    wshub.ServerMessageSent,
+		MessageID: message.ChannelID,
+		Receiver:  payload.Receiver,
+		Nonce:     payload.Nonce,
+	})
+}
@@ -0,0 +1,224 @@
+package discord
+
+import (
+	"discord-go-connect/internal/wshub"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// presenceSampleInterval is how often the presences of history guilds are written to the database.
+const presenceSampleInterval = 5 * time.Minute
+
+// PresenceMode controls what the bot keeps of the presences in a guild.
+type PresenceMode string
+
+const (
+	// PresenceOff ignores presences.
+	PresenceOff PresenceMode = "off"
+	// PresenceLive keeps presences in memory only.
+	PresenceLive PresenceMode = "live"
+	// PresenceHistory also samples presences into the database.
+	PresenceHistory PresenceMode = "history"
+)
+
+var errInvalidPresenceSpec = errors.New("invalid presence spec")
+
+// PresenceConfig holds the presence mode of each guild. The mode under "*" applies to
+// guilds without one of their own.
+type PresenceConfig map[string]PresenceMode
+
+// ParsePresenceConfig reads a spec such as "123=history;456=off;*=live". An empty spec
+// turns presence tracking off everywhere.
+func ParsePresenceConfig(spec string) (PresenceConfig, error) {
+	config := make(PresenceConfig)
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		guildID, mode, ok := strings.Cut(entry, "=")
+		if !ok || guildID == "" {
+			return nil, fmt.Errorf("%w: %q has no mode", errInvalidPresenceSpec, entry)
+		}
+
+		switch PresenceMode(mode) {
+		case PresenceOff, PresenceLive, PresenceHistory:
+			config[guildID] = PresenceMode(mode)
+		default:
+			return nil, fmt.Errorf("%w: unknown mode %q", errInvalidPresenceSpec, mode)
+		}
+	}
+
+	return config, nil
+}
+
+// Mode returns the presence mode of a guild.
+func (c PresenceConfig) Mode(guildID string) PresenceMode {
+	if mode, ok := c[guildID]; ok {
+		return mode
+	}
+
+	if mode, ok := c["*"]; ok {
+		return mode
+	}
+
+	return PresenceOff
+}
+
+// Enabled reports whether presences are tracked in any guild.
+func (c PresenceConfig) Enabled() bool {
+	for _, mode := range c {
+		if mode != PresenceOff {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Presence is the last known status and activities of a member.
+type Presence struct {
+	UpdatedAt    time.Time              `json:"updated_at"`
+	UserID       string                 `json:"user_id"`
+	Status       discordgo.Status       `json:"status"`
+	Activities   []*discordgo.Activity  `json:"activities"`
+	ClientStatus discordgo.ClientStatus `json:"client_status"`
+}
+
+// presences caches the presences of the members of each tracked guild. Offline members
+// are dropped.
+type presences struct {
+	guilds map[string]map[string]*Presence
+	mu     sync.RWMutex
+}
+
+func newPresences() *presences {
+	return &presences{guilds: make(map[string]map[string]*Presence)}
+}
+
+func (p *presences) set(guildID string, presence *discordgo.Presence) *Presence {
+	cached := &Presence{
+		UpdatedAt:    time.Now().UTC(),
+		UserID:       presence.User.ID,
+		Status:       presence.Status,
+		Activities:   presence.Activities,
+		ClientStatus: presence.ClientStatus,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if presence.Status == discordgo.StatusOffline {
+		delete(p.guilds[guildID], presence.User.ID)
+		return cached
+	}
+
+	if p.guilds[guildID] == nil {
+		p.guilds[guildID] = make(map[string]*Presence)
+	}
+
+	p.guilds[guildID][presence.User.ID] = cached
+
+	return cached
+}
+
+func (p *presences) list(guildID string) []*Presence {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	list := make([]*Presence, 0, len(p.guilds[guildID]))
+	for _, presence := range p.guilds[guildID] {
+		list = append(list, presence)
+	}
+
+	return list
+}
+
+func (p *presences) forget(guildID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.guilds, guildID)
+}
+
+// TrackPresences sets which guilds the bot follows presences in. It must be called
+// before Start, which only asks Discord for presences when some guild tracks them.
+func (b *Bot) TrackPresences(config PresenceConfig) {
+	b.presenceConfig = config
+}
+
+// Presences returns the cached presences of a guild, or false if the guild doesn't track them.
+func (b *Bot) Presences(guildID string) ([]*Presence, bool) {
+	if b.presenceConfig.Mode(guildID) == PresenceOff {
+		return nil, false
+	}
+
+	return b.presences.list(guildID), true
+}
+
+func (b *Bot) onPresenceUpdate(_ *discordgo.Session, event *discordgo.PresenceUpdate) {
+	if event.User == nil || b.presenceConfig.Mode(event.GuildID) == PresenceOff {
+		return
+	}
+
+	presence := b.presences.set(event.GuildID, &event.Presence)
+
+	b.publish(presence, &wshub.WSPayload{Action: wshub.ServerPresenceUpdated, MessageID: event.GuildID},
+		topic(topicPresence, event.GuildID))
+}
+
+// cachePresences fills the cache with the presences a guild reports when it becomes available.
+func (b *Bot) cachePresences(guild *discordgo.Guild) {
+	b.presences.forget(guild.ID)
+
+	if b.presenceConfig.Mode(guild.ID) == PresenceOff {
+		return
+	}
+
+	for _, presence := range guild.Presences {
+		if presence.User != nil {
+			b.presences.set(guild.ID, presence)
+		}
+	}
+}
+
+// samplePresences periodically stores the cached presences of guilds in history mode.
+func (b *Bot) samplePresences() {
+	ticker := time.NewTicker(presenceSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.stopped:
+			return
+		}
+
+		sampledAt := time.Now().UTC()
+
+		for _, guild := range b.guildSnapshot() {
+			if b.presenceConfig.Mode(guild.ID) != PresenceHistory {
+				continue
+			}
+
+			for _, presence := range b.presences.list(guild.ID) {
+				sample := &discordgo.Presence{
+					User:       &discordgo.User{ID: presence.UserID},
+					Status:     presence.Status,
+					Activities: presence.Activities,
+				}
+
+				if err := b.store.SavePresenceSample(guild.ID, sample, sampledAt); err != nil {
+					b.logger.Error("%v", err)
+				}
+			}
+		}
+	}
+}
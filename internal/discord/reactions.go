@@ -0,0 +1,97 @@
+package discord
+
+import (
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// eventMessageReactionRemoveEmoji is dispatched by Discord but not typed by discordgo.
+const eventMessageReactionRemoveEmoji = "MESSAGE_REACTION_REMOVE_EMOJI"
+
+// reactionDelta is pushed to subscribers whenever the reactions of a message change.
+// Removals of every reaction, or of every reaction with one emoji, have no UserID.
+type reactionDelta struct {
+	MessageID string `json:"message_id"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	UserID    string `json:"user_id,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	Delta     int    `json:"delta"`
+	Cleared   bool   `json:"cleared"`
+}
+
+// messageReactionRemoveEmoji is the payload of MESSAGE_REACTION_REMOVE_EMOJI.
+type messageReactionRemoveEmoji struct {
+	Emoji     discordgo.Emoji `json:"emoji"`
+	MessageID string          `json:"message_id"`
+	ChannelID string          `json:"channel_id"`
+	GuildID   string          `json:"guild_id"`
+}
+
+func (b *Bot) onReactionAdd(_ *discordgo.Session, event *discordgo.MessageReactionAdd) {
+	reaction := event.MessageReaction
+	emoji := reaction.Emoji.APIName()
+
+	if err := b.store.SaveReaction(reaction); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publishReaction(reaction, reactionDelta{UserID: reaction.UserID, Emoji: emoji, Delta: 1})
+}
+
+func (b *Bot) onReactionRemove(_ *discordgo.Session, event *discordgo.MessageReactionRemove) {
+	reaction := event.MessageReaction
+	emoji := reaction.Emoji.APIName()
+
+	if err := b.store.DeleteReaction(reaction.MessageID, reaction.UserID, emoji); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publishReaction(reaction, reactionDelta{UserID: reaction.UserID, Emoji: emoji, Delta: -1})
+}
+
+func (b *Bot) onReactionRemoveAll(_ *discordgo.Session, event *discordgo.MessageReactionRemoveAll) {
+	reaction := event.MessageReaction
+
+	if err := b.store.DeleteReactions(reaction.MessageID, ""); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publishReaction(reaction, reactionDelta{Cleared: true})
+}
+
+// onRawEvent handles the gateway events discordgo has no type for.
+func (b *Bot) onRawEvent(_ *discordgo.Session, event *discordgo.Event) {
+	if event.Type != eventMessageReactionRemoveEmoji {
+		return
+	}
+
+	var removal messageReactionRemoveEmoji
+	if err := json.Unmarshal(event.RawData, &removal); err != nil {
+		b.logger.Error("failed to decode %s: %v", event.Type, err)
+		return
+	}
+
+	emoji := removal.Emoji.APIName()
+
+	if err := b.store.DeleteReactions(removal.MessageID, emoji); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publishReaction(&discordgo.MessageReaction{
+		MessageID: removal.MessageID,
+		ChannelID: removal.ChannelID,
+		GuildID:   removal.GuildID,
+	}, reactionDelta{Emoji: emoji, Cleared: true})
+}
+
+func (b *Bot) publishReaction(reaction *discordgo.MessageReaction, delta reactionDelta) {
+	delta.MessageID = reaction.MessageID
+	delta.ChannelID = reaction.ChannelID
+	delta.GuildID = reaction.GuildID
+
+	b.publish(delta, &wshub.WSPayload{Action: wshub.ServerReactionDelta, MessageID: reaction.ChannelID},
+		topic(topicGuild, reaction.GuildID), topic(topicThread, reaction.ChannelID))
+}
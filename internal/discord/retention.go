@@ -0,0 +1,180 @@
+package discord
+
+import (
+	"discord-go-connect/internal/metrics"
+	"discord-go-connect/internal/store"
+	"sort"
+	"time"
+)
+
+const (
+	// retentionInterval is how often the retention rules are enforced.
+	retentionInterval = time.Hour
+	// pruneBatchSize is how many messages are deleted in one transaction.
+	pruneBatchSize = 500
+	// pruneBatchPause spaces out the batches of a channel, so pruning doesn't hold up the
+	// message writer.
+	pruneBatchPause = 200 * time.Millisecond
+)
+
+// pruneHistory periodically enforces the retention rules.
+func (b *Bot) pruneHistory() {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.stopped:
+			return
+		}
+
+		if pruned := b.EnforceRetention(); pruned > 0 {
+			b.logger.Info("pruned %d messages", pruned)
+		}
+	}
+}
+
+// EnforceRetention prunes the stored history of every channel with a retention rule of
+// its own or of its guild, and returns how many messages it pruned. Channels that fail
+// are logged and don't stop the others. Mirrored files left without messages are deleted
+// afterwards.
+func (b *Bot) EnforceRetention() int {
+	rules, err := b.store.RetentionRules()
+	if err != nil {
+		b.logger.Error("failed to load retention rules: %v", err)
+		return 0
+	}
+
+	// Guild rules are resolved first, so the rules of their channels replace them
+	// whatever order the rules come in.
+	channels := make(map[string]store.RetentionRule)
+
+	for _, rule := range rules {
+		if rule.ChannelID != "" {
+			continue
+		}
+
+		stored, err := b.store.StoredChannels(rule.GuildID)
+		if err != nil {
+			b.logger.Error("failed to list the channels of guild %s: %v", rule.GuildID, err)
+			continue
+		}
+
+		for _, channelID := range stored {
+			channels[channelID] = rule
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.ChannelID != "" {
+			channels[rule.ChannelID] = rule
+		}
+	}
+
+	channelIDs := make([]string, 0, len(channels))
+	for channelID := range channels {
+		channelIDs = append(channelIDs, channelID)
+	}
+
+	sort.Strings(channelIDs)
+
+	now, pruned := time.Now().UTC(), 0
+
+	for _, channelID := range channelIDs {
+		count, err := b.pruneChannel(channelID, channels[channelID], now)
+		if err != nil {
+			b.logger.Error("failed to prune channel %s: %v", channelID, err)
+		}
+
+		pruned += count
+	}
+
+	if pruned > 0 {
+		b.collectMedia()
+	}
+
+	return pruned
+}
+
+// pruneChannel deletes the messages of a channel that its rule doesn't keep, a batch at a
+// time, and then the archived ones.
+func (b *Bot) pruneChannel(channelID string, rule store.RetentionRule, now time.Time) (int, error) {
+	var (
+		before time.Time
+		keep   int
+	)
+
+	switch rule.Mode {
+	case store.KeepDays:
+		before = now.AddDate(0, 0, -rule.Amount)
+	case store.KeepMessages:
+		keep = rule.Amount
+	default:
+		return 0, nil
+	}
+
+	pruned := 0
+
+	for {
+		ids, err := b.store.PruneMessages(channelID, before, keep, pruneBatchSize)
+		if err != nil {
+			return pruned, err
+		}
+
+		for _, id := range ids {
+			b.unindexMessage(id)
+		}
+
+		if len(ids) > 0 {
+			metrics.AddCounter("discord_pruned_messages_total", "Messages deleted by retention rules.",
+				metrics.Labels{"mode": string(rule.Mode)}, float64(len(ids)))
+		}
+
+		pruned += len(ids)
+
+		if len(ids) < pruneBatchSize {
+			break
+		}
+
+		time.Sleep(pruneBatchPause)
+	}
+
+	if b.archive == nil {
+		return pruned, nil
+	}
+
+	archived, err := b.pruneArchive(channelID, rule, before, keep)
+	pruned += archived
+
+	return pruned, err
+}
+
+// pruneArchive applies a rule to the archived messages of a channel, which are older
+// than the ones the store holds. A rule keeping messages keeps the archived ones the
+// stored ones leave room for.
+func (b *Bot) pruneArchive(channelID string, rule store.RetentionRule, before time.Time, keep int) (int, error) {
+	if keep > 0 {
+		stored, err := b.store.ChannelMessageCount(channelID)
+		if err != nil {
+			return 0, err
+		}
+
+		if keep -= stored; keep < 0 {
+			keep = 0
+		}
+	}
+
+	ids, err := b.archive.Prune(channelID, before, keep)
+
+	for _, id := range ids {
+		b.unindexMessage(id)
+	}
+
+	if len(ids) > 0 {
+		metrics.AddCounter("discord_pruned_messages_total", "Messages deleted by retention rules.",
+			metrics.Labels{"mode": string(rule.Mode)}, float64(len(ids)))
+	}
+
+	return len(ids), err
+}
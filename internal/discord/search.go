@@ -0,0 +1,266 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/store"
+	"discord-go-connect/internal/wshub"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// searchPageSize is the number of hits in a page of search results.
+const searchPageSize = 20
+
+// SearchPage is a page of search results. NextCursor is the next page, or 0 after the last.
+type SearchPage struct {
+	Data       []store.SearchHit `json:"data"`
+	NextCursor int               `json:"nextCursor"`
+}
+
+// searchRequest is the data of a search WebSocket request.
+type searchRequest struct {
+	store.SearchQuery
+	Page int `json:"page"`
+}
+
+// UseSearchIndex answers searches from an index instead of the store and feeds it every
+// stored, edited and deleted message. It must be called before Start.
+func (b *Bot) UseSearchIndex(index store.Index) {
+	b.searchIndex = index
+}
+
+func (b *Bot) searcher() store.Searcher {
+	if b.searchIndex != nil {
+		return b.searchIndex
+	}
+
+	return b.store
+}
+
+// Search returns a page of the stored messages matching a query, limited to the guilds
+// of the grant. The text of the query is parsed with store.ParseQuery, so its filters
+// override the fields of query, and the users and channels it names are looked up in
+// the guilds it covers. Pages start at 1.
+func (b *Bot) Search(grant *auth.Grant, query store.SearchQuery, page int) (*SearchPage, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	refs, err := store.ParseQuery(&query)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.resolveQueryRefs(grant, &query, refs); err != nil {
+		return nil, err
+	}
+
+	if query.ChannelID != "" {
+		guildID, err := b.store.ChannelGuild(query.ChannelID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("%w: channel %s", ErrNotFound, query.ChannelID)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !grant.CanAccessGuild(guildID) {
+			return nil, fmt.Errorf("%w: no access to channel %s", ErrForbidden, query.ChannelID)
+		}
+	}
+
+	if query.GuildID != "" && !grant.CanAccessGuild(query.GuildID) {
+		return nil, fmt.Errorf("%w: no access to guild %s", ErrForbidden, query.GuildID)
+	}
+
+	if !grant.Admin() {
+		query.Guilds = grant.Guilds()
+
+		if len(query.Guilds) == 0 {
+			return nil, fmt.Errorf("%w: the grant covers no guild", ErrForbidden)
+		}
+	}
+
+	query.Limit, query.Offset = searchPageSize+1, (page-1)*searchPageSize
+
+	hits, err := b.searcher().Search(&query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchPage{Data: hits}
+
+	if len(hits) > searchPageSize {
+		result.Data, result.NextCursor = hits[:searchPageSize], page+1
+	}
+
+	return result, nil
+}
+
+// resolveQueryRefs fills in the IDs of the users and channels a parsed query names.
+func (b *Bot) resolveQueryRefs(grant *auth.Grant, query *store.SearchQuery, refs *store.QueryRefs) error {
+	guildIDs := grant.Guilds()
+
+	switch {
+	case query.GuildID != "":
+		guildIDs = []string{query.GuildID}
+	case grant.Admin():
+		for _, guild := range b.guildSnapshot() {
+			guildIDs = append(guildIDs, guild.ID)
+		}
+	}
+
+	if refs.From != nil {
+		id, err := b.resolveUser(guildIDs, refs.From)
+		if err != nil {
+			return err
+		}
+
+		query.AuthorID = id
+	}
+
+	if refs.Mentions != nil {
+		id, err := b.resolveUser(guildIDs, refs.Mentions)
+		if err != nil {
+			return err
+		}
+
+		query.Mentions = id
+	}
+
+	if refs.In != nil {
+		id, err := b.resolveChannel(guildIDs, refs.In)
+		if err != nil {
+			return err
+		}
+
+		query.ChannelID = id
+	}
+
+	return nil
+}
+
+// resolveUser takes a user ID, a mention, or a username or nickname of a cached member.
+func (b *Bot) resolveUser(guildIDs []string, ref *store.QueryRef) (string, error) {
+	if id, ok := mentionedID(ref.Name, "<@!", "<@"); ok {
+		return id, nil
+	}
+
+	name := strings.TrimPrefix(ref.Name, "@")
+
+	for _, guildID := range guildIDs {
+		state := b.sessionFor(guildID).SessionState()
+
+		guild, err := state.Guild(guildID)
+		if err != nil {
+			continue
+		}
+
+		state.RLock()
+		for _, member := range guild.Members {
+			if member.User != nil && (strings.EqualFold(member.User.Username, name) || strings.EqualFold(member.Nick, name)) {
+				state.RUnlock()
+				return member.User.ID, nil
+			}
+		}
+		state.RUnlock()
+	}
+
+	return "", &store.QueryError{Message: fmt.Sprintf("unknown user %q", ref.Name), Column: ref.Column}
+}
+
+// resolveChannel takes a channel ID, a mention, or the name of a cached channel or thread.
+func (b *Bot) resolveChannel(guildIDs []string, ref *store.QueryRef) (string, error) {
+	if id, ok := mentionedID(ref.Name, "<#"); ok {
+		return id, nil
+	}
+
+	name := strings.TrimPrefix(ref.Name, "#")
+
+	for _, guildID := range guildIDs {
+		state := b.sessionFor(guildID).SessionState()
+
+		guild, err := state.Guild(guildID)
+		if err != nil {
+			continue
+		}
+
+		state.RLock()
+		for _, channels := range [][]*discordgo.Channel{guild.Channels, guild.Threads} {
+			for _, channel := range channels {
+				if strings.EqualFold(channel.Name, name) {
+					state.RUnlock()
+					return channel.ID, nil
+				}
+			}
+		}
+		state.RUnlock()
+	}
+
+	return "", &store.QueryError{Message: fmt.Sprintf("unknown channel %q", ref.Name), Column: ref.Column}
+}
+
+// mentionedID returns the ID in a mention with one of the prefixes, or a bare ID.
+func mentionedID(s string, prefixes ...string) (string, bool) {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) && strings.HasSuffix(s, ">") {
+			s = strings.TrimSuffix(strings.TrimPrefix(s, prefix), ">")
+			break
+		}
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+	}
+
+	return s, s != ""
+}
+
+// handleSearch answers a search WebSocket request with a page of results.
+func (b *Bot) handleSearch(payload *wshub.WSPayload) {
+	var request searchRequest
+	if err := json.Unmarshal(payload.Data, &request); err != nil {
+		b.sendError(payload, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	result, err := b.Search(b.keys.Grant(payload.Caller), request.SearchQuery, request.Page)
+	if err != nil {
+		b.sendError(payload, err)
+		return
+	}
+
+	b.sendJSONReponse(result, &wshub.WSPayload{
+		Action:   wshub.ServerSearchResults,
+		Receiver: payload.Receiver,
+		Nonce:    payload.Nonce,
+	})
+}
+
+// indexMessages feeds stored or edited messages to the search index, if there is one.
+func (b *Bot) indexMessages(messages ...*discordgo.Message) {
+	if b.searchIndex == nil || len(messages) == 0 {
+		return
+	}
+
+	if err := b.searchIndex.IndexMessages(messages); err != nil {
+		b.logger.Error("failed to index %d messages: %v", len(messages), err)
+	}
+}
+
+func (b *Bot) unindexMessage(messageID string) {
+	if b.searchIndex == nil {
+		return
+	}
+
+	if err := b.searchIndex.RemoveMessage(messageID); err != nil {
+		b.logger.Error("failed to remove message %s from the search index: %v", messageID, err)
+	}
+}
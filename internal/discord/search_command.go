@@ -0,0 +1,113 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/store"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// searchCommandHits is the number of hits the /search command lists.
+	searchCommandHits = 10
+	// maxMessageLength is the longest message content Discord accepts.
+	maxMessageLength = 2000
+)
+
+var snippetMarks = strings.NewReplacer("<mark>", "**", "</mark>", "**")
+
+// SearchCommand is the /search slash command. It searches the stored messages of the
+// guild it is used in with the same query language as the search API, and lists the
+// best matches in channels the invoking member can see. Register it with AddCommand.
+func (b *Bot) SearchCommand() *Command {
+	return &Command{
+		Name:        "search",
+		Description: "Search the message history of this server",
+		Options: []*discordgo.ApplicationCommandOption{{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "query",
+			Description: `Words, "exact phrases" and filters such as from:user in:#channel before:2024-01-01 has:link`,
+			Required:    true,
+		}},
+		Handler: b.runSearchCommand,
+	}
+}
+
+func (b *Bot) runSearchCommand(ctx *CommandContext) error {
+	guildID := ctx.Interaction.GuildID
+	if guildID == "" || ctx.Interaction.Member == nil {
+		return ctx.ReplyEphemeral("Search only works in servers.")
+	}
+
+	if err := ctx.Defer(true); err != nil {
+		return err
+	}
+
+	query := store.SearchQuery{Text: ctx.String("query"), GuildID: guildID}
+
+	result, err := b.Search(auth.GuildGrant(guildID), query, 1)
+
+	var queryErr *store.QueryError
+	if errors.As(err, &queryErr) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrNotFound) {
+		return ctx.ReplyEphemeral(fmt.Sprintf("Invalid query: %v", err))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	userID := ctx.Interaction.Member.User.ID
+	visible := make(map[string]bool)
+	lines := make([]string, 0, searchCommandHits)
+
+	for _, hit := range result.Data {
+		if len(lines) == searchCommandHits {
+			break
+		}
+
+		channelID := hit.Message.ChannelID
+
+		if _, ok := visible[channelID]; !ok {
+			permissions, err := ctx.Session.UserChannelPermissions(userID, channelID)
+			visible[channelID] = err == nil && permissions&discordgo.PermissionViewChannel != 0
+		}
+
+		if visible[channelID] {
+			lines = append(lines, searchCommandLine(guildID, &hit))
+		}
+	}
+
+	if len(lines) == 0 {
+		return ctx.ReplyEphemeral("No messages found.")
+	}
+
+	content := ""
+
+	for _, line := range lines {
+		if len(content)+len(line)+1 > maxMessageLength {
+			break
+		}
+
+		content += line + "\n"
+	}
+
+	return ctx.Respond(&discordgo.InteractionResponseData{
+		Content:         content,
+		Flags:           discordgo.MessageFlagsEphemeral,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+}
+
+// searchCommandLine links a hit and quotes its snippet with the matches in bold.
+func searchCommandLine(guildID string, hit *store.SearchHit) string {
+	snippet := html.UnescapeString(snippetMarks.Replace(hit.Snippet))
+	snippet = strings.ReplaceAll(snippet, "\n", " ")
+
+	return fmt.Sprintf("[<#%s>](https://discord.com/channels/%s/%s/%s) **%s** <t:%d:R>\n> %s",
+		hit.Message.ChannelID, guildID, hit.Message.ChannelID, hit.Message.ID,
+		hit.Message.Author.Username, hit.Message.Timestamp.Unix(), snippet)
+}
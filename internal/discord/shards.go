@@ -0,0 +1,233 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// defaultHubURL is the hub of the process the bot runs in.
+const defaultHubURL = "ws://127.0.0.1/ws"
+
+// shardIdentifyInterval spaces out the shards' identify calls, which Discord rate limits.
+const shardIdentifyInterval = 5 * time.Second
+
+var errInvalidShardSpec = errors.New("invalid shard spec")
+
+// ShardConfig selects the gateway shards a process runs. Shards of one bot may be spread
+// over several processes as long as every process uses the same Count.
+type ShardConfig struct {
+	IDs   []int
+	Count int
+}
+
+// ShardStatus reports the connection of one gateway shard.
+type ShardStatus struct {
+	Since     time.Time  `json:"since"`
+	State     ShardState `json:"state"`
+	ID        int        `json:"id"`
+	Count     int        `json:"count"`
+	Guilds    int        `json:"guilds"`
+	LatencyMS int64      `json:"latency_ms"`
+	Connected bool       `json:"connected"`
+}
+
+// shard is one gateway connection. Its session is replaced when the supervisor has to
+// identify from scratch.
+type shard struct {
+	since        time.Time
+	session      DiscordClient
+	state        ShardState
+	id           int
+	reconnecting atomic.Bool
+	mu           sync.RWMutex
+}
+
+func (s *shard) current() DiscordClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.session
+}
+
+func (s *shard) replace(session DiscordClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.session = session
+}
+
+// ParseShardConfig reads the total shard count and the shards this process runs, given
+// as a list such as "0,1" or a range such as "0-3". Without a count the bot runs a
+// single shard; without IDs it runs every shard.
+func ParseShardConfig(count, ids string) (ShardConfig, error) {
+	config := ShardConfig{Count: 1}
+
+	if count != "" {
+		parsed, err := strconv.Atoi(count)
+		if err != nil || parsed < 1 {
+			return config, fmt.Errorf("%w: count %q must be a positive number", errInvalidShardSpec, count)
+		}
+
+		config.Count = parsed
+	}
+
+	for _, entry := range strings.Split(ids, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			last = first
+		}
+
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return config, fmt.Errorf("%w: %q is not a shard id", errInvalidShardSpec, entry)
+		}
+
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			return config, fmt.Errorf("%w: %q is not a shard range", errInvalidShardSpec, entry)
+		}
+
+		for id := from; id <= to; id++ {
+			if id < 0 || id >= config.Count {
+				return config, fmt.Errorf("%w: shard %d is outside of 0-%d", errInvalidShardSpec, id, config.Count-1)
+			}
+
+			config.IDs = append(config.IDs, id)
+		}
+	}
+
+	if len(config.IDs) == 0 {
+		for id := 0; id < config.Count; id++ {
+			config.IDs = append(config.IDs, id)
+		}
+	}
+
+	return config, nil
+}
+
+// ConfigureShards sets the shards Start opens. It must be called before Start.
+func (b *Bot) ConfigureShards(config ShardConfig) {
+	b.shardConfig = config
+}
+
+// UseHub connects the bot to the hub at hubURL instead of the one in its own process, so
+// that the shards of several processes feed one hub. It must be called before Start.
+func (b *Bot) UseHub(hubURL string) {
+	b.hubURL = hubURL
+}
+
+func (b *Bot) hubDialURL() string {
+	shards := make([]string, 0, len(b.shardConfig.IDs))
+	for _, id := range b.shardConfig.IDs {
+		shards = append(shards, strconv.Itoa(id))
+	}
+
+	query := url.Values{
+		"type":   {"D-BOT"},
+		"token":  {b.keys.BotSecret()},
+		"shards": {strings.Join(shards, ",")},
+	}
+
+	return b.hubURL + "?" + query.Encode()
+}
+
+// ShardStatuses reports every shard this process runs.
+func (b *Bot) ShardStatuses() []ShardStatus {
+	guilds := make(map[int]int)
+
+	for _, guild := range b.guildSnapshot() {
+		guilds[shardFor(guild.ID, b.shardConfig.Count)]++
+	}
+
+	shards := b.shardList()
+	statuses := make([]ShardStatus, 0, len(shards))
+
+	for _, shard := range shards {
+		shard.mu.RLock()
+		statuses = append(statuses, ShardStatus{
+			Since:     shard.since,
+			State:     shard.state,
+			ID:        shard.id,
+			Count:     b.shardConfig.Count,
+			Guilds:    guilds[shard.id],
+			LatencyMS: shard.session.HeartbeatLatency().Milliseconds(),
+			Connected: shard.state == ShardReady,
+		})
+		shard.mu.RUnlock()
+	}
+
+	return statuses
+}
+
+// shardOf returns the shard a session belongs to.
+func (b *Bot) shardOf(s *discordgo.Session) *shard {
+	for _, shard := range b.shardList() {
+		if shard.id == s.ShardID {
+			return shard
+		}
+	}
+
+	return nil
+}
+
+func (b *Bot) shardList() []*shard {
+	b.shardsMu.RLock()
+	defer b.shardsMu.RUnlock()
+
+	return b.shards
+}
+
+// shardFor returns the shard Discord delivers a guild's events on.
+func shardFor(guildID string, count int) int {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil || count < 2 {
+		return 0
+	}
+
+	return int((id >> 22) % uint64(count))
+}
+
+// sessionFor returns the session of the shard a guild belongs to. Guilds of shards run
+// by other processes, and DMs, which always arrive on shard 0, fall back to the first
+// session of this process; REST requests work from any shard.
+func (b *Bot) sessionFor(guildID string) DiscordClient {
+	id := shardFor(guildID, b.shardConfig.Count)
+	shards := b.shardList()
+
+	for _, shard := range shards {
+		if shard.id == id {
+			return shard.current()
+		}
+	}
+
+	if len(shards) == 0 {
+		return nil
+	}
+
+	return shards[0].current()
+}
+
+// stateChannel looks a channel up in the state of every shard.
+func (b *Bot) stateChannel(channelID string) (*discordgo.State, *discordgo.Channel, bool) {
+	for _, shard := range b.shardList() {
+		state := shard.current().SessionState()
+		if channel, err := state.Channel(channelID); err == nil {
+			return state, channel, true
+		}
+	}
+
+	return nil, nil, false
+}
@@ -0,0 +1,158 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/wshub"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Topic kinds web clients can subscribe to. A topic is written as kind:id.
+const (
+	topicGuild    = "guild"
+	topicThread   = "thread"
+	topicPresence = "presence"
+	// topicDM follows one DM channel, or the whole inbox as dm:*.
+	topicDM = "dm"
+	// topicGateway follows the connection state of one shard, or of all as gateway:*.
+	topicGateway = "gateway"
+)
+
+var errInvalidTopic = errors.New("invalid topic")
+
+// subscriptions maps topics to the web clients following them.
+type subscriptions struct {
+	topics map[string]map[string]struct{}
+	mu     sync.RWMutex
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{topics: make(map[string]map[string]struct{})}
+}
+
+func topic(kind, id string) string {
+	return kind + ":" + id
+}
+
+func (s *subscriptions) subscribe(receiver, topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.topics[topic] == nil {
+		s.topics[topic] = make(map[string]struct{})
+	}
+
+	s.topics[topic][receiver] = struct{}{}
+}
+
+func (s *subscriptions) unsubscribe(receiver, topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeLocked(receiver, topic)
+}
+
+// replace subscribes a receiver to topic and drops its other topics of the same kind.
+func (s *subscriptions) replace(receiver, topic string) {
+	kind, _, _ := strings.Cut(topic, ":")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for existing, receivers := range s.topics {
+		if _, ok := receivers[receiver]; ok && strings.HasPrefix(existing, kind+":") {
+			s.removeLocked(receiver, existing)
+		}
+	}
+
+	if s.topics[topic] == nil {
+		s.topics[topic] = make(map[string]struct{})
+	}
+
+	s.topics[topic][receiver] = struct{}{}
+}
+
+func (s *subscriptions) unsubscribeAll(receiver string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for topic := range s.topics {
+		s.removeLocked(receiver, topic)
+	}
+}
+
+func (s *subscriptions) removeLocked(receiver, topic string) {
+	delete(s.topics[topic], receiver)
+
+	if len(s.topics[topic]) == 0 {
+		delete(s.topics, topic)
+	}
+}
+
+// receivers returns the web clients following any of the given topics, each once.
+func (s *subscriptions) receivers(topics ...string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	receivers := make([]string, 0)
+
+	for _, topic := range topics {
+		for receiver := range s.topics[topic] {
+			if _, ok := seen[receiver]; !ok {
+				seen[receiver] = struct{}{}
+				receivers = append(receivers, receiver)
+			}
+		}
+	}
+
+	return receivers
+}
+
+// publish sends an event to every web client following any of the topics.
+func (b *Bot) publish(event interface{}, payload *wshub.WSPayload, topics ...string) {
+	for _, receiver := range b.subscriptions.receivers(topics...) {
+		b.sendJSONReponse(event, &wshub.WSPayload{Action: payload.Action, MessageID: payload.MessageID, Receiver: receiver})
+	}
+}
+
+// authorizeTopic checks that a grant may follow a topic.
+func (b *Bot) authorizeTopic(grant *auth.Grant, t string) error {
+	kind, id, ok := strings.Cut(t, ":")
+	if !ok || id == "" {
+		return fmt.Errorf("%w: %q", errInvalidTopic, t)
+	}
+
+	switch kind {
+	case topicGuild:
+		if !grant.CanAccessGuild(id) {
+			return fmt.Errorf("%w: no access to guild %s", ErrForbidden, id)
+		}
+	case topicPresence:
+		if !grant.CanAccessGuild(id) {
+			return fmt.Errorf("%w: no access to guild %s", ErrForbidden, id)
+		}
+
+		if b.presenceConfig.Mode(id) == PresenceOff {
+			return fmt.Errorf("%w: presences are not tracked in guild %s", ErrNotFound, id)
+		}
+	case topicDM:
+		if !grant.Admin() {
+			return fmt.Errorf("%w: DMs need an admin grant", ErrForbidden)
+		}
+	case topicGateway:
+		if !grant.Admin() {
+			return fmt.Errorf("%w: the gateway state needs an admin grant", ErrForbidden)
+		}
+	case topicThread:
+		if _, err := b.authorizeChannel(grant, id, 0); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", errInvalidTopic, kind)
+	}
+
+	return nil
+}
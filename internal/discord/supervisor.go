@@ -0,0 +1,158 @@
+package discord
+
+import (
+	"discord-go-connect/internal/metrics"
+	"discord-go-connect/internal/wshub"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ShardState is where a shard is in its connection lifecycle.
+type ShardState string
+
+const (
+	// ShardConnecting identifies with a new gateway session.
+	ShardConnecting ShardState = "connecting"
+	// ShardReady receives events.
+	ShardReady ShardState = "ready"
+	// ShardResuming reconnects and replays the events missed since the disconnect.
+	ShardResuming ShardState = "resuming"
+	// ShardDisconnected waits to reconnect, or is stopped.
+	ShardDisconnected ShardState = "disconnected"
+)
+
+// gatewayAll is the id of the gateway topic that follows every shard.
+const gatewayAll = "*"
+
+var shardStates = []ShardState{ShardConnecting, ShardReady, ShardResuming, ShardDisconnected}
+
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = 2 * time.Minute
+	// maxResumeAttempts and resumeWindow bound how long the supervisor tries to resume.
+	// Discord drops the events of a session it can't deliver for long, so past either
+	// limit the shard identifies again from scratch.
+	maxResumeAttempts = 3
+	resumeWindow      = 2 * time.Minute
+)
+
+// shardStateEvent is pushed to admin clients following the gateway topic.
+type shardStateEvent struct {
+	Since   time.Time  `json:"since"`
+	State   ShardState `json:"state"`
+	Shard   int        `json:"shard"`
+	Attempt int        `json:"attempt,omitempty"`
+}
+
+func (b *Bot) onResumed(s *discordgo.Session, _ *discordgo.Resumed) {
+	if shard := b.shardOf(s); shard != nil {
+		b.setShardState(shard, ShardReady, 0)
+	}
+}
+
+// onDisconnect hands a lost shard to its supervisor. discordgo's own reconnect loop is
+// turned off, so this is the only place a shard reconnects.
+func (b *Bot) onDisconnect(s *discordgo.Session, _ *discordgo.Disconnect) {
+	shard := b.shardOf(s)
+	if shard == nil || b.stopping.Load() || !shard.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+
+	b.logger.Error("Lost connection to Discord on shard %d. Reconnecting...", shard.id)
+
+	go b.superviseShard(shard)
+}
+
+// superviseShard reconnects a shard with exponential backoff and jitter. It resumes the
+// existing gateway session first and identifies with a fresh one once resuming failed
+// too often or for too long.
+func (b *Bot) superviseShard(shard *shard) {
+	defer shard.reconnecting.Store(false)
+
+	disconnectedAt := time.Now()
+	b.setShardState(shard, ShardDisconnected, 0)
+
+	for attempt := 1; ; attempt++ {
+		time.Sleep(reconnectDelay(attempt))
+
+		if b.stopping.Load() {
+			return
+		}
+
+		session := shard.current()
+		state := ShardResuming
+		kind := "resume"
+
+		if attempt > maxResumeAttempts || time.Since(disconnectedAt) > resumeWindow {
+			fresh, err := b.connectShard(shard.id)
+			if err != nil {
+				b.logger.Error("failed to create session for shard %d: %v", shard.id, err)
+				continue
+			}
+
+			shard.replace(fresh)
+
+			session, state, kind = fresh, ShardConnecting, "identify"
+		}
+
+		b.setShardState(shard, state, attempt)
+		metrics.AddCounter("discord_shard_reconnect_attempts_total", "Gateway reconnect attempts by kind.",
+			metrics.Labels{"shard": strconv.Itoa(shard.id), "kind": kind}, 1)
+
+		err := session.Open()
+		if err == nil || errors.Is(err, discordgo.ErrWSAlreadyOpen) {
+			b.logger.Info("Shard %d reconnected after %d attempt(s)", shard.id, attempt)
+			return
+		}
+
+		b.logger.Error("failed to reconnect shard %d (attempt %d): %v", shard.id, attempt, err)
+		b.setShardState(shard, ShardDisconnected, attempt)
+	}
+}
+
+// reconnectDelay doubles with every attempt up to reconnectMaxDelay. Half of it is random
+// so that shards disconnected together don't reconnect in lockstep.
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 20 {
+		if backoff := reconnectBaseDelay << (attempt - 1); backoff < reconnectMaxDelay {
+			delay = backoff
+		}
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// setShardState records a state change and reports it to metrics and admin clients.
+func (b *Bot) setShardState(shard *shard, state ShardState, attempt int) {
+	since := time.Now().UTC()
+
+	shard.mu.Lock()
+	changed := shard.state != state
+	shard.state, shard.since = state, since
+	shard.mu.Unlock()
+
+	if !changed && attempt == 0 {
+		return
+	}
+
+	id := strconv.Itoa(shard.id)
+
+	for _, s := range shardStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+
+		metrics.SetGauge("discord_shard_state", "Current connection state of each gateway shard.",
+			metrics.Labels{"shard": id, "state": string(s)}, value)
+	}
+
+	b.publish(shardStateEvent{Since: since, State: state, Shard: shard.id, Attempt: attempt},
+		&wshub.WSPayload{Action: wshub.ServerShardState, MessageID: id},
+		topic(topicGateway, id), topic(topicGateway, gatewayAll))
+}
@@ -0,0 +1,197 @@
+package discord
+
+import (
+	"discord-go-connect/internal/store"
+	"discord-go-connect/internal/wshub"
+	"errors"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// threadBackfillLimit caps how many messages are fetched from a single thread.
+	threadBackfillLimit = 1000
+	// discordPageSize is the largest page Discord returns for messages and archived threads.
+	discordPageSize = 100
+)
+
+func (b *Bot) onThreadCreate(_ *discordgo.Session, event *discordgo.ThreadCreate) {
+	b.storeThread(event.Channel, wshub.ServerThreadCreated)
+}
+
+func (b *Bot) onThreadUpdate(_ *discordgo.Session, event *discordgo.ThreadUpdate) {
+	b.storeThread(event.Channel, wshub.ServerThreadUpdated)
+}
+
+func (b *Bot) onThreadDelete(_ *discordgo.Session, event *discordgo.ThreadDelete) {
+	if err := b.store.DeleteThread(event.ID); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publish(event.Channel, &wshub.WSPayload{Action: wshub.ServerThreadDeleted, MessageID: event.ID},
+		topic(topicGuild, event.GuildID), topic(topicThread, event.ID))
+}
+
+// onThreadListSync stores the active threads Discord sends when the bot gains access
+// to channels. Threads of the synced channels that are missing from the list are no
+// longer active. Without channel IDs the whole guild is synced.
+func (b *Bot) onThreadListSync(_ *discordgo.Session, event *discordgo.ThreadListSync) {
+	if err := b.store.ArchiveThreads(event.GuildID, event.ChannelIDs); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	for _, thread := range event.Threads {
+		if err := b.CreateOrUpdateThread(thread); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+
+	for _, member := range event.Members {
+		if err := b.store.SaveThreadMember(member.ID, member.UserID, member.JoinTimestamp); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+}
+
+func (b *Bot) onThreadMembersUpdate(_ *discordgo.Session, event *discordgo.ThreadMembersUpdate) {
+	for _, member := range event.AddedMembers {
+		if err := b.store.SaveThreadMember(event.ID, member.UserID, member.JoinTimestamp); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+
+	for _, userID := range event.RemovedMembers {
+		if err := b.store.DeleteThreadMember(event.ID, userID); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+}
+
+func (b *Bot) storeThread(thread *discordgo.Channel, action wshub.Action[wshub.ServerAction]) {
+	if err := b.CreateOrUpdateThread(thread); err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publish(thread, &wshub.WSPayload{Action: action, MessageID: thread.ID},
+		topic(topicGuild, thread.GuildID), topic(topicThread, thread.ID))
+}
+
+// CreateOrUpdateThread stores a thread or forum post with its parent, archive state and tags.
+func (b *Bot) CreateOrUpdateThread(thread *discordgo.Channel) error {
+	return b.store.SaveThread(thread)
+}
+
+// CreateOrUpdateForumTags stores the tags a forum channel offers.
+func (b *Bot) CreateOrUpdateForumTags(channel *discordgo.Channel) error {
+	return b.store.SaveForumTags(channel)
+}
+
+// backfillThreads stores the active and archived threads of a guild, along with the
+// history of archived threads that were never backfilled before.
+func (b *Bot) backfillThreads(guild *discordgo.Guild) {
+	active, err := b.sessionFor(guild.ID).GuildThreadsActive(guild.ID)
+	if err != nil {
+		b.logger.Error("failed to fetch active threads of guild %s: %v", guild.ID, err)
+	} else {
+		for _, thread := range active.Threads {
+			if err := b.CreateOrUpdateThread(thread); err != nil {
+				b.logger.Error("%v", err)
+			}
+		}
+	}
+
+	for _, channel := range guild.Channels {
+		switch channel.Type {
+		case discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews, discordgo.ChannelTypeGuildForum:
+			b.backfillArchivedThreads(guild.ID, channel.ID)
+		}
+	}
+}
+
+func (b *Bot) backfillArchivedThreads(guildID, channelID string) {
+	var before *time.Time
+
+	for {
+		list, err := b.sessionFor(guildID).ThreadsArchived(channelID, before, discordPageSize)
+		if err != nil {
+			b.logger.Error("failed to fetch archived threads of %s: %v", channelID, err)
+			return
+		}
+
+		for _, thread := range list.Threads {
+			if thread.GuildID == "" {
+				thread.GuildID = guildID
+			}
+
+			if err := b.CreateOrUpdateThread(thread); err != nil {
+				b.logger.Error("%v", err)
+				continue
+			}
+
+			b.backfillThreadHistory(thread)
+
+			if metadata := thread.ThreadMetadata; metadata != nil {
+				archivedAt := metadata.ArchiveTimestamp
+				before = &archivedAt
+			}
+		}
+
+		if !list.HasMore || len(list.Threads) == 0 {
+			return
+		}
+	}
+}
+
+func (b *Bot) backfillThreadHistory(thread *discordgo.Channel) {
+	backfilled, err := b.store.ThreadBackfilled(thread.ID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			b.logger.Error("%v", err)
+		}
+
+		return
+	}
+
+	if backfilled {
+		return
+	}
+
+	beforeID := ""
+
+	for fetched := 0; fetched < threadBackfillLimit; {
+		messages, err := b.sessionFor(thread.GuildID).ChannelMessages(thread.ID, discordPageSize, beforeID, "", "")
+		if err != nil {
+			b.logger.Error("failed to fetch history of thread %s: %v", thread.ID, err)
+			return
+		}
+
+		if len(messages) == 0 {
+			break
+		}
+
+		batch := make([]*discordgo.MessageCreate, 0, len(messages))
+
+		for _, message := range messages {
+			// Messages fetched over REST carry neither their guild nor their member.
+			message.GuildID = thread.GuildID
+			if message.Member == nil {
+				message.Member = &discordgo.Member{}
+			}
+
+			batch = append(batch, &discordgo.MessageCreate{Message: message})
+		}
+
+		if err := b.CreateMessage(batch); err != nil {
+			b.logger.Error("failed to store history of thread %s: %v", thread.ID, err)
+			return
+		}
+
+		fetched += len(messages)
+		beforeID = messages[len(messages)-1].ID
+	}
+
+	if err := b.store.MarkThreadBackfilled(thread.ID, time.Now().UTC()); err != nil {
+		b.logger.Error("%v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package discord
+
+import (
+	"discord-go-connect/internal/auth"
+	"discord-go-connect/internal/wshub"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// typingEvent is pushed to the subscribers of a channel when someone starts typing in it.
+// Discord shows the indicator for about ten seconds unless it is renewed.
+type typingEvent struct {
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id,omitempty"`
+	UserID    string `json:"user_id"`
+	Timestamp int    `json:"timestamp"`
+}
+
+// onTypingStart reaches the same clients as the messages of the channel: the followers
+// of the guild or channel, and for DMs the followers of the DM or the DM inbox.
+func (b *Bot) onTypingStart(_ *discordgo.Session, event *discordgo.TypingStart) {
+	if event.UserID == b.UserID() {
+		return
+	}
+
+	topics := []string{topic(topicGuild, event.GuildID), topic(topicThread, event.ChannelID)}
+	if event.GuildID == "" {
+		topics = []string{topic(topicDM, event.ChannelID), topic(topicDM, dmInbox)}
+	}
+
+	b.publish(typingEvent{
+		ChannelID: event.ChannelID,
+		GuildID:   event.GuildID,
+		UserID:    event.UserID,
+		Timestamp: event.Timestamp,
+	}, &wshub.WSPayload{Action: wshub.ServerTyping, MessageID: event.ChannelID}, topics...)
+}
+
+// SendTyping shows the bot as typing in a channel.
+func (b *Bot) SendTyping(grant *auth.Grant, channelID string) error {
+	channel, err := b.authorizeChannel(grant, channelID, discordgo.PermissionSendMessages)
+	if err != nil {
+		return err
+	}
+
+	if err := b.sessionFor(channel.GuildID).ChannelTyping(channelID); err != nil {
+		return wrapRESTError(err, "channel "+channelID)
+	}
+
+	return nil
+}
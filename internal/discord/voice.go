@@ -0,0 +1,58 @@
+package discord
+
+import (
+	"discord-go-connect/internal/wshub"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// voiceEvent is pushed to guild subscribers whenever a member joins, leaves or moves
+// between voice channels, or changes its mute, deaf, stream or video state.
+type voiceEvent struct {
+	State             *discordgo.VoiceState `json:"state"`
+	PreviousChannelID string                `json:"previous_channel_id,omitempty"`
+}
+
+func (b *Bot) onVoiceStateUpdate(_ *discordgo.Session, event *discordgo.VoiceStateUpdate) {
+	state := event.VoiceState
+	if state.GuildID == "" {
+		return
+	}
+
+	previous, err := b.RecordVoiceState(state, time.Now().UTC())
+	if err != nil {
+		b.logger.Error("%v", err)
+	}
+
+	b.publish(voiceEvent{State: state, PreviousChannelID: previous},
+		&wshub.WSPayload{Action: wshub.ServerVoiceState, MessageID: state.UserID},
+		topic(topicGuild, state.GuildID))
+}
+
+// syncVoiceStates closes the sessions left open while the bot was away and opens one for
+// every user Discord reports in voice when a guild becomes available.
+func (b *Bot) syncVoiceStates(guild *discordgo.Guild) {
+	now := time.Now().UTC()
+
+	if err := b.store.CloseVoiceSessions(guild.ID, now); err != nil {
+		b.logger.Error("%v", err)
+		return
+	}
+
+	for _, state := range guild.VoiceStates {
+		if state.GuildID == "" {
+			state.GuildID = guild.ID
+		}
+
+		if _, err := b.RecordVoiceState(state, now); err != nil {
+			b.logger.Error("%v", err)
+		}
+	}
+}
+
+// RecordVoiceState closes the open voice session of a user and, unless the user left
+// voice, opens a new one for its current state. It returns the channel of the closed session.
+func (b *Bot) RecordVoiceState(state *discordgo.VoiceState, at time.Time) (string, error) {
+	return b.store.RecordVoiceState(state, at)
+}
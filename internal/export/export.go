@@ -0,0 +1,225 @@
+// Package export writes the stored history of a channel or a guild as newline-delimited
+// JSON, CSV, a plain-text transcript or a self-contained HTML page.
+package export
+
+import (
+	"bufio"
+	"discord-go-connect/internal/store"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Format is an export file format.
+type Format string
+
+const (
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+	Text   Format = "text"
+	HTML   Format = "html"
+)
+
+var (
+	// ErrInvalidRequest is returned for exports without a channel or a guild, or with an
+	// unknown format.
+	ErrInvalidRequest = errors.New("invalid export request")
+
+	formats = map[Format]struct {
+		contentType string
+		extension   string
+	}{
+		NDJSON: {"application/x-ndjson", "ndjson"},
+		CSV:    {"text/csv; charset=utf-8", "csv"},
+		Text:   {"text/plain; charset=utf-8", "txt"},
+		HTML:   {"text/html; charset=utf-8", "html"},
+	}
+)
+
+// ParseFormat reads a format name such as "html". "json" and "txt" are accepted too.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "json":
+		return NDJSON, nil
+	case "txt":
+		return Text, nil
+	}
+
+	if _, ok := formats[Format(name)]; !ok {
+		return "", fmt.Errorf("%w: unknown format %q", ErrInvalidRequest, name)
+	}
+
+	return Format(name), nil
+}
+
+// ContentType is the MIME type of an export in the format.
+func (f Format) ContentType() string {
+	return formats[f].contentType
+}
+
+// Extension is the file extension of an export in the format, without the dot.
+func (f Format) Extension() string {
+	return formats[f].extension
+}
+
+// ParseDate reads an RFC 3339 timestamp or a plain date such as 2024-01-31. Empty
+// values are the zero time.
+func ParseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q", ErrInvalidRequest, value)
+	}
+
+	return date, nil
+}
+
+// Request describes an export of a channel or, when ChannelID is empty, of a guild.
+// After and Before limit it to a time range.
+type Request struct {
+	After     time.Time `json:"after"`
+	Before    time.Time `json:"before"`
+	Format    Format    `json:"format"`
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+}
+
+// Name is a file name for the export.
+func (r *Request) Name() string {
+	if r.ChannelID != "" {
+		return fmt.Sprintf("channel-%s.%s", r.ChannelID, r.Format.Extension())
+	}
+
+	return fmt.Sprintf("guild-%s.%s", r.GuildID, r.Format.Extension())
+}
+
+// Validate checks that a request names a channel or a guild and a known format.
+func (r *Request) Validate() error {
+	if r.ChannelID == "" && r.GuildID == "" {
+		return fmt.Errorf("%w: a channel or a guild is required", ErrInvalidRequest)
+	}
+
+	if _, ok := formats[r.Format]; !ok {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidRequest, r.Format)
+	}
+
+	return nil
+}
+
+// formatWriter writes one format. header and footer frame the messages, which come
+// oldest first with the channel they were sent in.
+type formatWriter interface {
+	header(info *exportInfo) error
+	message(message *discordgo.Message, channel *discordgo.Channel) error
+	footer(count int) error
+}
+
+// exportInfo describes an export for its header.
+type exportInfo struct {
+	ExportedAt time.Time
+	Request    *Request
+	// Channel is the exported channel, or nil for guild exports.
+	Channel *discordgo.Channel
+}
+
+// Exporter writes exports from a store.
+type Exporter struct {
+	store store.Store
+	now   func() time.Time
+}
+
+func NewExporter(history store.Store) *Exporter {
+	return &Exporter{store: history, now: time.Now}
+}
+
+// Export streams the messages a request selects to w and returns how many it wrote.
+func (e *Exporter) Export(w io.Writer, request *Request) (int, error) {
+	if err := request.Validate(); err != nil {
+		return 0, err
+	}
+
+	info := &exportInfo{ExportedAt: e.now().UTC(), Request: request}
+	channels := make(map[string]*discordgo.Channel)
+
+	if request.ChannelID != "" {
+		channel, err := e.store.Channel(request.ChannelID)
+		if err != nil {
+			return 0, err
+		}
+
+		info.Channel, channels[channel.ID] = channel, channel
+	}
+
+	buffered := bufio.NewWriter(w)
+	out := newFormatWriter(request.Format, buffered)
+
+	if err := out.header(info); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	filter := &store.HistoryFilter{
+		After: request.After, Before: request.Before, GuildID: request.GuildID, ChannelID: request.ChannelID,
+	}
+
+	err := e.store.EachMessage(filter, func(message *discordgo.Message) error {
+		channel, ok := channels[message.ChannelID]
+		if !ok {
+			// Channels the store doesn't know, such as deleted ones, show up by ID.
+			if channel, _ = e.store.Channel(message.ChannelID); channel == nil {
+				channel = &discordgo.Channel{ID: message.ChannelID, Name: message.ChannelID}
+			}
+
+			channels[message.ChannelID] = channel
+		}
+
+		count++
+
+		return out.message(message, channel)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := out.footer(count); err != nil {
+		return count, err
+	}
+
+	return count, buffered.Flush()
+}
+
+func newFormatWriter(format Format, w io.Writer) formatWriter {
+	switch format {
+	case CSV:
+		return newCSVWriter(w)
+	case Text:
+		return &textWriter{w: w}
+	case HTML:
+		return &htmlWriter{w: w}
+	default:
+		return newNDJSONWriter(w)
+	}
+}
+
+// displayName is the nickname of a message's author, or else their username.
+func displayName(message *discordgo.Message) string {
+	if message.Member != nil && message.Member.Nick != "" {
+		return message.Member.Nick
+	}
+
+	if message.Author == nil {
+		return "unknown"
+	}
+
+	return message.Author.Username
+}
@@ -0,0 +1,225 @@
+package export
+
+import (
+	"bytes"
+	"discord-go-connect/internal/store"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var exportedAt = time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+func testExporter(t *testing.T) *Exporter {
+	t.Helper()
+
+	history := store.NewMemory()
+
+	for _, channel := range []*discordgo.Channel{{ID: "10", Name: "general"}, {ID: "11", Name: "random"}} {
+		if err := history.SaveChannel("1", channel); err != nil {
+			t.Fatalf("failed to store channel: %v", err)
+		}
+	}
+
+	author := &discordgo.User{ID: "42", Username: "carol"}
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	messages := []*discordgo.MessageCreate{
+		{Message: &discordgo.Message{
+			ID: "1", GuildID: "1", ChannelID: "10", Author: author, Member: &discordgo.Member{Nick: "Caz"},
+			Content: "<b>hello</b>, \"world\"", Timestamp: start,
+		}},
+		{Message: &discordgo.Message{
+			ID: "2", GuildID: "1", ChannelID: "10", Author: author, Content: "second\nline", Timestamp: start.Add(time.Minute),
+			Attachments: []*discordgo.MessageAttachment{{ID: "5", Filename: "cat.png", URL: "https://cdn.example/cat.png"}},
+			Embeds:      []*discordgo.MessageEmbed{{Title: "Docs", Color: 0x5865f2}},
+		}},
+		{Message: &discordgo.Message{
+			ID: "3", GuildID: "1", ChannelID: "11", Author: author, Content: "elsewhere", Timestamp: start.Add(2 * time.Minute),
+		}},
+		{Message: &discordgo.Message{
+			ID: "4", GuildID: "1", ChannelID: "10", Author: author, Content: "later", Timestamp: start.Add(24 * time.Hour),
+		}},
+	}
+
+	if _, err := history.SaveMessages(messages); err != nil {
+		t.Fatalf("failed to store messages: %v", err)
+	}
+
+	exporter := NewExporter(history)
+	exporter.now = func() time.Time { return exportedAt }
+
+	return exporter
+}
+
+func export(t *testing.T, exporter *Exporter, request *Request) (string, int) {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	count, err := exporter.Export(&out, request)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	return out.String(), count
+}
+
+func TestExportNDJSON(t *testing.T) {
+	out, count := export(t, testExporter(t), &Request{Format: NDJSON, ChannelID: "10"})
+	if count != 3 {
+		t.Fatalf("exported %d messages, want 3", count)
+	}
+
+	var ids []string
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var message discordgo.Message
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+
+		ids = append(ids, message.ID)
+	}
+
+	if strings.Join(ids, ",") != "1,2,4" {
+		t.Errorf("exported %v, want messages 1, 2 and 4 oldest first", ids)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	before := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	out, _ := export(t, testExporter(t), &Request{Format: CSV, GuildID: "1", Before: before})
+
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+
+	if len(rows) != 4 || strings.Join(rows[0], ",") != strings.Join(csvColumns, ",") {
+		t.Fatalf("got rows %q, want a header and 3 messages", rows)
+	}
+
+	if first := rows[1]; first[4] != "general" || first[6] != "Caz" || first[7] != "<b>hello</b>, \"world\"" {
+		t.Errorf("first row = %q", first)
+	}
+
+	if second := rows[2]; second[7] != "second\nline" || second[8] != "https://cdn.example/cat.png" || second[9] != "1" {
+		t.Errorf("second row = %q", second)
+	}
+
+	if third := rows[3]; third[4] != "random" {
+		t.Errorf("third row is in channel %q, want random", third[4])
+	}
+}
+
+func TestExportText(t *testing.T) {
+	out, _ := export(t, testExporter(t), &Request{Format: Text, GuildID: "1"})
+
+	for _, want := range []string{
+		"Guild: 1\n",
+		"[2024-01-01 09:00:00] #general Caz: <b>hello</b>, \"world\"\n",
+		"    Attachment: cat.png https://cdn.example/cat.png\n",
+		"[2024-01-01 09:02:00] #random carol: elsewhere\n",
+		"\n4 messages\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("transcript is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	out, _ := export(t, testExporter(t), &Request{Format: HTML, ChannelID: "10"})
+
+	for _, want := range []string{
+		"<title>#general</title>",
+		"&lt;b&gt;hello&lt;/b&gt;, &#34;world&#34;",
+		`<img src="https://cdn.example/cat.png" alt="cat.png"`,
+		`style="border-color: #5865f2"`,
+		// The second message follows the first within minutes and joins its group.
+		`<div class="message" id="m2">`,
+		`<div class="message first" id="m4">`,
+		"<footer>3 messages</footer>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("page is missing %q:\n%s", want, out)
+		}
+	}
+
+	if strings.Contains(out, "<b>hello") || strings.Contains(out, "ZgotmplZ") {
+		t.Errorf("page is not escaped correctly:\n%s", out)
+	}
+}
+
+func TestExportInvalidRequest(t *testing.T) {
+	exporter := testExporter(t)
+
+	for _, request := range []*Request{{Format: NDJSON}, {Format: "pdf", ChannelID: "10"}} {
+		if _, err := exporter.Export(&bytes.Buffer{}, request); err == nil {
+			t.Errorf("exporting %+v succeeded", request)
+		}
+	}
+
+	if _, err := exporter.Export(&bytes.Buffer{}, &Request{Format: NDJSON, ChannelID: "99"}); err == nil {
+		t.Error("exporting an unknown channel succeeded")
+	}
+}
+
+func TestJobsLimitInProgressPerOwner(t *testing.T) {
+	jobs := NewJobs(testExporter(t), t.TempDir())
+	request := Request{Format: NDJSON, ChannelID: "10"}
+
+	jobs.mu.Lock()
+	for i := 0; i < maxJobsPerOwner; i++ {
+		id := fmt.Sprintf("running-%d", i)
+		jobs.jobs[id] = &Job{ID: id, Owner: "busy", Status: JobRunning}
+	}
+	jobs.mu.Unlock()
+
+	if _, err := jobs.Start("busy", request); !errors.Is(err, ErrTooManyJobs) {
+		t.Fatalf("Start with %d jobs running = %v, want ErrTooManyJobs", maxJobsPerOwner, err)
+	}
+
+	job, err := jobs.Start("idle", request)
+	if err != nil {
+		t.Fatalf("Start of another owner failed: %v", err)
+	}
+
+	// Let the export finish before its directory is removed.
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if job, _ = jobs.Get(job.ID); job.FinishedAt != nil {
+			return
+		}
+	}
+
+	t.Fatalf("export %s did not finish", job.ID)
+}
+
+func TestNewJobsRemovesLeftoverExports(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"export-123.ndjson", "export-456.html", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	NewJobs(testExporter(t), dir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "notes.txt" {
+		t.Fatalf("left %v in the export directory, want only notes.txt", entries)
+	}
+}
@@ -0,0 +1,167 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// transcriptTime is how the text transcript shows timestamps.
+const transcriptTime = "2006-01-02 15:04:05"
+
+// ndjsonWriter writes every message as a line of JSON, as the API returns them.
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	return &ndjsonWriter{encoder: encoder}
+}
+
+func (w *ndjsonWriter) header(*exportInfo) error {
+	return nil
+}
+
+func (w *ndjsonWriter) message(message *discordgo.Message, _ *discordgo.Channel) error {
+	return w.encoder.Encode(message)
+}
+
+func (w *ndjsonWriter) footer(int) error {
+	return nil
+}
+
+// csvWriter writes a row per message. Attachments are listed by URL, separated by spaces.
+type csvWriter struct {
+	csv *csv.Writer
+}
+
+var csvColumns = []string{
+	"id", "timestamp", "edited_timestamp", "channel_id", "channel", "author_id", "author", "content",
+	"attachments", "embeds", "pinned",
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{csv: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) header(*exportInfo) error {
+	return w.csv.Write(csvColumns)
+}
+
+func (w *csvWriter) message(message *discordgo.Message, channel *discordgo.Channel) error {
+	edited := ""
+	if message.EditedTimestamp != nil {
+		edited = message.EditedTimestamp.UTC().Format(time.RFC3339Nano)
+	}
+
+	urls := make([]string, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		urls = append(urls, attachment.URL)
+	}
+
+	authorID := ""
+	if message.Author != nil {
+		authorID = message.Author.ID
+	}
+
+	return w.csv.Write([]string{
+		message.ID,
+		message.Timestamp.UTC().Format(time.RFC3339Nano),
+		edited,
+		message.ChannelID,
+		channel.Name,
+		authorID,
+		displayName(message),
+		message.Content,
+		strings.Join(urls, " "),
+		strconv.Itoa(len(message.Embeds)),
+		strconv.FormatBool(message.Pinned),
+	})
+}
+
+func (w *csvWriter) footer(int) error {
+	w.csv.Flush()
+
+	return w.csv.Error()
+}
+
+// textWriter writes a transcript to read or to paste into a ticket.
+type textWriter struct {
+	w io.Writer
+	// err is the first write error, so the formatting code doesn't have to check each.
+	err error
+	// channels is set for guild exports, whose lines name the channel.
+	channels bool
+}
+
+func (w *textWriter) printf(format string, args ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+func (w *textWriter) header(info *exportInfo) error {
+	if info.Channel != nil {
+		w.printf("Channel: #%s (%s)\n", info.Channel.Name, info.Channel.ID)
+	} else {
+		w.printf("Guild: %s\n", info.Request.GuildID)
+		w.channels = true
+	}
+
+	if after, before := info.Request.After, info.Request.Before; !after.IsZero() || !before.IsZero() {
+		w.printf("Range: %s to %s\n", rangeEnd(after, "the beginning"), rangeEnd(before, "now"))
+	}
+
+	w.printf("Exported: %s UTC\n%s\n\n", info.ExportedAt.Format(transcriptTime), strings.Repeat("=", 60))
+
+	return w.err
+}
+
+func (w *textWriter) message(message *discordgo.Message, channel *discordgo.Channel) error {
+	w.printf("[%s] ", message.Timestamp.UTC().Format(transcriptTime))
+
+	if w.channels {
+		w.printf("#%s ", channel.Name)
+	}
+
+	w.printf("%s: %s", displayName(message), message.Content)
+
+	if message.EditedTimestamp != nil {
+		w.printf(" (edited)")
+	}
+
+	w.printf("\n")
+
+	for _, attachment := range message.Attachments {
+		w.printf("    Attachment: %s %s\n", attachment.Filename, attachment.URL)
+	}
+
+	for _, embed := range message.Embeds {
+		w.printf("    Embed: %s\n", strings.TrimSpace(strings.Join([]string{embed.Title, embed.URL}, " ")))
+	}
+
+	return w.err
+}
+
+func (w *textWriter) footer(count int) error {
+	w.printf("\n%s\n%d messages\n", strings.Repeat("=", 60), count)
+
+	return w.err
+}
+
+func rangeEnd(t time.Time, open string) string {
+	if t.IsZero() {
+		return open
+	}
+
+	return t.UTC().Format(transcriptTime)
+}
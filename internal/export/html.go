@@ -0,0 +1,250 @@
+package export
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// groupWindow is how long after a message the same author's next one joins its group,
+// as in the Discord client.
+const groupWindow = 7 * time.Minute
+
+// htmlWriter writes a single page styled like the Discord client. Images come from
+// Discord's CDN; everything else is inline.
+type htmlWriter struct {
+	w    io.Writer
+	last *discordgo.Message
+	// channels is set for guild exports, which note when the channel changes.
+	channels bool
+}
+
+type htmlMessage struct {
+	Channel     *discordgo.Channel
+	Time        time.Time
+	ID          string
+	Author      string
+	AvatarURL   string
+	Content     string
+	Attachments []htmlAttachment
+	Embeds      []htmlEmbed
+	// Grouped messages follow one by the same author and are shown without a header.
+	Grouped    bool
+	NewChannel bool
+	Edited     bool
+	Bot        bool
+	Pinned     bool
+}
+
+type htmlAttachment struct {
+	URL      string
+	Filename string
+	Size     string
+	Image    bool
+}
+
+type htmlEmbed struct {
+	Color       string
+	Title       string
+	URL         string
+	Description string
+	Fields      []*discordgo.MessageEmbedField
+	Image       string
+	Footer      string
+}
+
+func (w *htmlWriter) header(info *exportInfo) error {
+	title := "Guild " + info.Request.GuildID
+	if info.Channel != nil {
+		title = "#" + info.Channel.Name
+	} else {
+		w.channels = true
+	}
+
+	return htmlTemplates.ExecuteTemplate(w.w, "header", struct {
+		Title      string
+		After      string
+		Before     string
+		ExportedAt string
+	}{
+		Title:      title,
+		After:      rangeEnd(info.Request.After, "the beginning"),
+		Before:     rangeEnd(info.Request.Before, "now"),
+		ExportedAt: info.ExportedAt.Format(transcriptTime),
+	})
+}
+
+func (w *htmlWriter) message(message *discordgo.Message, channel *discordgo.Channel) error {
+	data := htmlMessage{
+		Channel:    channel,
+		Time:       message.Timestamp.UTC(),
+		ID:         message.ID,
+		Author:     displayName(message),
+		Content:    message.Content,
+		NewChannel: w.channels && (w.last == nil || w.last.ChannelID != message.ChannelID),
+		Edited:     message.EditedTimestamp != nil,
+		Pinned:     message.Pinned,
+	}
+
+	if message.Author != nil {
+		data.AvatarURL = message.Author.AvatarURL("64")
+		data.Bot = message.Author.Bot
+		data.Grouped = !data.NewChannel && w.last != nil && w.last.Author != nil &&
+			w.last.Author.ID == message.Author.ID && w.last.ChannelID == message.ChannelID &&
+			message.Timestamp.Sub(w.last.Timestamp) < groupWindow
+	}
+
+	for _, attachment := range message.Attachments {
+		data.Attachments = append(data.Attachments, htmlAttachment{
+			URL:      attachment.URL,
+			Filename: attachment.Filename,
+			Size:     fileSize(attachment.Size),
+			Image:    isImage(attachment),
+		})
+	}
+
+	for _, embed := range message.Embeds {
+		data.Embeds = append(data.Embeds, newHTMLEmbed(embed))
+	}
+
+	w.last = message
+
+	return htmlTemplates.ExecuteTemplate(w.w, "message", data)
+}
+
+func (w *htmlWriter) footer(count int) error {
+	return htmlTemplates.ExecuteTemplate(w.w, "footer", count)
+}
+
+func newHTMLEmbed(embed *discordgo.MessageEmbed) htmlEmbed {
+	data := htmlEmbed{
+		Color:       "#202225",
+		Title:       embed.Title,
+		URL:         embed.URL,
+		Description: embed.Description,
+		Fields:      embed.Fields,
+	}
+
+	if embed.Color != 0 {
+		data.Color = fmt.Sprintf("#%06x", embed.Color)
+	}
+
+	if embed.Image != nil {
+		data.Image = embed.Image.URL
+	} else if embed.Thumbnail != nil {
+		data.Image = embed.Thumbnail.URL
+	}
+
+	if embed.Footer != nil {
+		data.Footer = embed.Footer.Text
+	}
+
+	return data
+}
+
+func isImage(attachment *discordgo.MessageAttachment) bool {
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		return true
+	}
+
+	switch strings.ToLower(path.Ext(attachment.Filename)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return true
+	default:
+		return false
+	}
+}
+
+func fileSize(bytes int) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+}
+
+var htmlTemplates = template.Must(template.New("export").Funcs(template.FuncMap{
+	"clock": func(t time.Time) string { return t.Format("15:04") },
+	"stamp": func(t time.Time) string { return t.Format(transcriptTime) },
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; background: #313338; color: #dbdee1; font: 16px/1.375 "gg sans", "Noto Sans", "Helvetica Neue", Helvetica, Arial, sans-serif; }
+header { position: sticky; top: 0; padding: 12px 16px; background: #2b2d31; box-shadow: 0 1px 0 #1f2023; }
+header h1 { margin: 0; font-size: 16px; color: #f2f3f5; }
+header p { margin: 2px 0 0; font-size: 12px; color: #949ba4; }
+main { padding: 16px 0; }
+.channel { margin: 24px 16px 8px; padding-top: 8px; border-top: 1px solid #3f4147; font-size: 12px; font-weight: 600; color: #949ba4; text-transform: uppercase; }
+.message { position: relative; padding: 2px 48px 2px 72px; }
+.message:hover { background: #2e3035; }
+.message.first { margin-top: 17px; }
+.avatar { position: absolute; left: 16px; top: 4px; width: 40px; height: 40px; border-radius: 50%; }
+.author { font-weight: 500; color: #f2f3f5; }
+.bot { margin-left: 4px; padding: 0 4px; border-radius: 3px; background: #5865f2; color: #fff; font-size: 10px; vertical-align: 2px; }
+.time { margin-left: 4px; font-size: 12px; color: #949ba4; }
+.gutter { position: absolute; left: 16px; width: 48px; font-size: 11px; color: transparent; }
+.message:hover .gutter { color: #949ba4; }
+.content { white-space: pre-wrap; word-wrap: break-word; }
+.edited { font-size: 10px; color: #949ba4; }
+.pinned { font-size: 12px; color: #949ba4; }
+.attachment { display: block; margin-top: 4px; }
+.attachment img { max-width: 400px; max-height: 300px; border-radius: 4px; }
+.file { display: inline-block; padding: 10px; border: 1px solid #2b2d31; border-radius: 4px; background: #2b2d31; }
+.file a { color: #00a8fc; }
+.file span { display: block; font-size: 12px; color: #949ba4; }
+.embed { max-width: 520px; margin-top: 4px; padding: 8px 16px 16px 12px; border-left: 4px solid; border-radius: 4px; background: #2b2d31; }
+.embed-title { margin-top: 8px; font-weight: 600; color: #f2f3f5; }
+.embed-title a { color: #00a8fc; text-decoration: none; }
+.embed-description, .embed-field { margin-top: 8px; font-size: 14px; white-space: pre-wrap; }
+.embed-field b { display: block; color: #f2f3f5; }
+.embed img { max-width: 100%; margin-top: 16px; border-radius: 4px; }
+.embed-footer { margin-top: 8px; font-size: 12px; color: #949ba4; }
+footer { padding: 16px; font-size: 12px; color: #949ba4; }
+a { color: #00a8fc; }
+</style>
+</head>
+<body>
+<header><h1>{{.Title}}</h1><p>{{.After}} to {{.Before}} · exported {{.ExportedAt}} UTC</p></header>
+<main>
+{{end}}
+
+{{define "message"}}{{if .NewChannel}}<div class="channel">#{{.Channel.Name}}</div>
+{{end}}<div class="message{{if not .Grouped}} first{{end}}" id="m{{.ID}}">
+{{- if .Grouped}}<span class="gutter" title="{{stamp .Time}}">{{clock .Time}}</span>
+{{- else}}<img class="avatar" src="{{.AvatarURL}}" alt="" loading="lazy"><div><span class="author">{{.Author}}</span>{{if .Bot}}<span class="bot">BOT</span>{{end}}<span class="time">{{stamp .Time}}</span>{{if .Pinned}} <span class="pinned">pinned</span>{{end}}</div>
+{{- end}}
+<div class="content">{{.Content}}{{if .Edited}} <span class="edited">(edited)</span>{{end}}</div>
+{{- range .Attachments}}
+<div class="attachment">{{if .Image}}<a href="{{.URL}}"><img src="{{.URL}}" alt="{{.Filename}}" loading="lazy"></a>{{else}}<div class="file"><a href="{{.URL}}">{{.Filename}}</a><span>{{.Size}}</span></div>{{end}}</div>
+{{- end}}
+{{- range .Embeds}}
+<div class="embed" style="border-color: {{.Color}}">
+{{- if .Title}}<div class="embed-title">{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</div>{{end}}
+{{- if .Description}}<div class="embed-description">{{.Description}}</div>{{end}}
+{{- range .Fields}}<div class="embed-field"><b>{{.Name}}</b>{{.Value}}</div>{{end}}
+{{- if .Image}}<img src="{{.Image}}" alt="" loading="lazy">{{end}}
+{{- if .Footer}}<div class="embed-footer">{{.Footer}}</div>{{end}}
+</div>
+{{- end}}
+</div>
+{{end}}
+
+{{define "footer"}}</main>
+<footer>{{.}} messages</footer>
+</body>
+</html>
+{{end}}
+`))
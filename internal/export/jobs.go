@@ -0,0 +1,250 @@
+package export
+
+import (
+	"discord-go-connect/internal/logger"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobStatus is the state of an export job.
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+const (
+	// jobTTL is how long finished jobs and their files are kept.
+	jobTTL = 24 * time.Hour
+	// maxJobsPerOwner is how many exports one API key may have pending or running.
+	maxJobsPerOwner = 2
+	// filePattern names the export files, so leftovers can be told apart.
+	filePattern = "export-*"
+)
+
+var (
+	// ErrJobNotFound is returned for unknown or expired jobs.
+	ErrJobNotFound = errors.New("export job not found")
+	// ErrJobNotDone is returned when downloading a job that hasn't finished.
+	ErrJobNotDone = errors.New("export job has not finished")
+	// ErrTooManyJobs is returned when a key starts an export while others of its own are
+	// still running.
+	ErrTooManyJobs = errors.New("too many export jobs in progress")
+)
+
+// Job is an export running in the background. Its file can be downloaded once it is done.
+type Job struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Request    Request    `json:"request"`
+	ID         string     `json:"id"`
+	Status     JobStatus  `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	// Owner is the name of the API key that started the job.
+	Owner    string `json:"-"`
+	Messages int    `json:"messages"`
+	path     string
+}
+
+// Jobs runs exports in the background and keeps their files in a directory for a day.
+type Jobs struct {
+	exporter *Exporter
+	jobs     map[string]*Job
+	logger   *logger.StandardLoggerHandler
+	dir      string
+	mu       sync.Mutex
+}
+
+// NewJobs keeps exports in dir. Jobs only live in memory, so the files earlier processes
+// left in dir can't be downloaded anymore and are removed.
+func NewJobs(exporter *Exporter, dir string) *Jobs {
+	j := &Jobs{
+		exporter: exporter,
+		jobs:     make(map[string]*Job),
+		logger:   logger.NewLogger(os.Stderr),
+		dir:      dir,
+	}
+
+	j.removeLeftovers()
+
+	return j
+}
+
+// Dir is the directory the export files are written to.
+func (j *Jobs) Dir() string {
+	return j.dir
+}
+
+// Start queues an export and returns the job. An owner may only have maxJobsPerOwner
+// jobs in progress at a time.
+func (j *Jobs) Start(owner string, request Request) (Job, error) {
+	if err := request.Validate(); err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		CreatedAt: time.Now().UTC(),
+		Request:   request,
+		ID:        uuid.NewString(),
+		Status:    JobPending,
+		Owner:     owner,
+	}
+
+	j.mu.Lock()
+	j.pruneLocked()
+
+	if j.inProgressLocked(owner) >= maxJobsPerOwner {
+		j.mu.Unlock()
+		return Job{}, fmt.Errorf("%w: %s has %d", ErrTooManyJobs, owner, maxJobsPerOwner)
+	}
+
+	j.jobs[job.ID] = job
+	snapshot := *job
+	j.mu.Unlock()
+
+	go j.run(job)
+
+	return snapshot, nil
+}
+
+// Get returns a job by ID.
+func (j *Jobs) Get(id string) (Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	return *job, nil
+}
+
+// Open opens the file of a finished job. The caller closes it.
+func (j *Jobs) Open(id string) (*os.File, Job, error) {
+	job, err := j.Get(id)
+	if err != nil {
+		return nil, job, err
+	}
+
+	if job.Status != JobDone {
+		return nil, job, fmt.Errorf("%w: %s is %s", ErrJobNotDone, id, job.Status)
+	}
+
+	file, err := os.Open(job.path)
+	if err != nil {
+		return nil, job, fmt.Errorf("failed to open export %s: %w", id, err)
+	}
+
+	return file, job, nil
+}
+
+func (j *Jobs) run(job *Job) {
+	j.update(func() { job.Status = JobRunning })
+
+	path, count, err := j.write(job)
+
+	j.update(func() {
+		finished := time.Now().UTC()
+		job.FinishedAt, job.Messages, job.path = &finished, count, path
+		job.Status = JobDone
+
+		if err != nil {
+			job.Status, job.Error = JobFailed, err.Error()
+		}
+	})
+
+	if err != nil {
+		j.logger.Error("export %s failed: %v", job.ID, err)
+	}
+}
+
+func (j *Jobs) write(job *Job) (string, int, error) {
+	if err := os.MkdirAll(j.dir, 0o700); err != nil {
+		return "", 0, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(j.dir, filePattern+"."+job.Request.Format.Extension())
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	count, err := j.exporter.Export(file, &job.Request)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", count, err
+	}
+
+	return file.Name(), count, nil
+}
+
+func (j *Jobs) update(change func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	change()
+}
+
+// pruneLocked forgets jobs that finished more than a day ago and removes their files.
+// The caller holds mu.
+func (j *Jobs) pruneLocked() {
+	for id, job := range j.jobs {
+		if job.FinishedAt == nil || time.Since(*job.FinishedAt) < jobTTL {
+			continue
+		}
+
+		if job.path != "" {
+			if err := os.Remove(job.path); err != nil && !os.IsNotExist(err) {
+				j.logger.Error("failed to remove export %s: %v", id, err)
+			}
+		}
+
+		delete(j.jobs, id)
+	}
+}
+
+// inProgressLocked counts the jobs of an owner that haven't finished. The caller holds mu.
+func (j *Jobs) inProgressLocked(owner string) int {
+	count := 0
+
+	for _, job := range j.jobs {
+		if job.Owner == owner && job.FinishedAt == nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// removeLeftovers removes the export files in the directory.
+func (j *Jobs) removeLeftovers() {
+	paths, err := filepath.Glob(filepath.Join(j.dir, filePattern))
+	if err != nil {
+		j.logger.Error("failed to list leftover exports: %v", err)
+		return
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			j.logger.Error("failed to remove leftover export %s: %v", path, err)
+		}
+	}
+}
+
+// DefaultDir is where exports are kept unless configured otherwise.
+func DefaultDir() string {
+	return filepath.Join(os.TempDir(), "discord-go-connect-exports")
+}
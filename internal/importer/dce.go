@@ -0,0 +1,361 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// dceDMGuild is the guild DiscordChatExporter puts direct messages in.
+const dceDMGuild = "0"
+
+// dceArchive reads a DiscordChatExporter JSON export of a channel. The file is one object
+// with the guild and the channel first and then the messages, which are read one at a
+// time, so exports of any size can be imported.
+type dceArchive struct {
+	decoder   *json.Decoder
+	guildID   string
+	channelID string
+	read      int
+}
+
+type dceGuild struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IconURL string `json:"iconUrl"`
+}
+
+type dceChannel struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	CategoryID string `json:"categoryId"`
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+}
+
+type dceUser struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Discriminator string `json:"discriminator"`
+	Nickname      string `json:"nickname"`
+	IsBot         bool   `json:"isBot"`
+	AvatarURL     string `json:"avatarUrl"`
+}
+
+type dceAttachment struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	FileName      string `json:"fileName"`
+	FileSizeBytes int    `json:"fileSizeBytes"`
+}
+
+type dceImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type dceEmbed struct {
+	Title       string     `json:"title"`
+	URL         string     `json:"url"`
+	Timestamp   string     `json:"timestamp"`
+	Description string     `json:"description"`
+	Color       string     `json:"color"`
+	Thumbnail   *dceImage  `json:"thumbnail"`
+	Images      []dceImage `json:"images"`
+	Author      *struct {
+		Name    string `json:"name"`
+		URL     string `json:"url"`
+		IconURL string `json:"iconUrl"`
+	} `json:"author"`
+	Fields []struct {
+		Name     string `json:"name"`
+		Value    string `json:"value"`
+		IsInline bool   `json:"isInline"`
+	} `json:"fields"`
+	Footer *struct {
+		Text    string `json:"text"`
+		IconURL string `json:"iconUrl"`
+	} `json:"footer"`
+}
+
+type dceMessage struct {
+	Timestamp       time.Time       `json:"timestamp"`
+	TimestampEdited *time.Time      `json:"timestampEdited"`
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Content         string          `json:"content"`
+	Author          dceUser         `json:"author"`
+	Attachments     []dceAttachment `json:"attachments"`
+	Embeds          []dceEmbed      `json:"embeds"`
+	Mentions        []dceUser       `json:"mentions"`
+	Reference       *struct {
+		MessageID string `json:"messageId"`
+		ChannelID string `json:"channelId"`
+		GuildID   string `json:"guildId"`
+	} `json:"reference"`
+	IsPinned bool `json:"isPinned"`
+}
+
+var (
+	dceChannelTypes = map[string]discordgo.ChannelType{
+		"GuildTextChat":       discordgo.ChannelTypeGuildText,
+		"DirectTextChat":      discordgo.ChannelTypeDM,
+		"DirectGroupTextChat": discordgo.ChannelTypeGroupDM,
+		"GuildVoiceChat":      discordgo.ChannelTypeGuildVoice,
+		"GuildCategory":       discordgo.ChannelTypeGuildCategory,
+		"GuildNews":           discordgo.ChannelTypeGuildNews,
+		"GuildNewsThread":     discordgo.ChannelTypeGuildNewsThread,
+		"GuildPublicThread":   discordgo.ChannelTypeGuildPublicThread,
+		"GuildPrivateThread":  discordgo.ChannelTypeGuildPrivateThread,
+		"GuildStageVoice":     discordgo.ChannelTypeGuildStageVoice,
+		"GuildForum":          discordgo.ChannelTypeGuildForum,
+	}
+
+	dceMessageTypes = map[string]discordgo.MessageType{
+		"Default":              discordgo.MessageTypeDefault,
+		"RecipientAdd":         discordgo.MessageTypeRecipientAdd,
+		"RecipientRemove":      discordgo.MessageTypeRecipientRemove,
+		"Call":                 discordgo.MessageTypeCall,
+		"ChannelNameChange":    discordgo.MessageTypeChannelNameChange,
+		"ChannelIconChange":    discordgo.MessageTypeChannelIconChange,
+		"ChannelPinnedMessage": discordgo.MessageTypeChannelPinnedMessage,
+		"GuildMemberJoin":      discordgo.MessageTypeGuildMemberJoin,
+		"ThreadCreated":        discordgo.MessageTypeThreadCreated,
+		"Reply":                discordgo.MessageTypeReply,
+	}
+)
+
+// openDCE reads the header of a DiscordChatExporter export, stores its guild and channel
+// and leaves the decoder at the first message.
+func (i *Importer) openDCE(r io.Reader) (*dceArchive, error) {
+	archive := &dceArchive{decoder: json.NewDecoder(r)}
+
+	if err := archive.expect(json.Delim('{')); err != nil {
+		return nil, err
+	}
+
+	var (
+		guild   *dceGuild
+		channel *dceChannel
+	)
+
+	for {
+		if !archive.decoder.More() {
+			return nil, fmt.Errorf("invalid DiscordChatExporter export: no messages")
+		}
+
+		token, err := archive.decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid DiscordChatExporter export: %w", err)
+		}
+
+		switch token {
+		case "guild":
+			err = archive.decoder.Decode(&guild)
+		case "channel":
+			err = archive.decoder.Decode(&channel)
+		case "messages":
+			err = archive.expect(json.Delim('['))
+		default:
+			err = archive.decoder.Decode(&json.RawMessage{})
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid DiscordChatExporter export: %w", err)
+		}
+
+		if token == "messages" {
+			break
+		}
+	}
+
+	if guild == nil || channel == nil || channel.ID == "" {
+		return nil, fmt.Errorf("invalid DiscordChatExporter export: the guild or the channel is missing")
+	}
+
+	if guild.ID != dceDMGuild {
+		archive.guildID = guild.ID
+
+		err := i.ensureGuild(&discordgo.Guild{ID: guild.ID, Name: guild.Name, Icon: cdnHash(guild.IconURL)})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	archive.channelID = channel.ID
+
+	if err := i.ensureChannel(archive.guildID, channel.convert()); err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (a *dceArchive) expect(delim json.Delim) error {
+	token, err := a.decoder.Token()
+	if err != nil {
+		return fmt.Errorf("invalid DiscordChatExporter export: %w", err)
+	}
+
+	if token != delim {
+		return fmt.Errorf("invalid DiscordChatExporter export: expected %v, found %v", delim, token)
+	}
+
+	return nil
+}
+
+func (a *dceArchive) next() (*discordgo.MessageCreate, error) {
+	if !a.decoder.More() {
+		// What follows the messages, such as their count, isn't needed.
+		return nil, io.EOF
+	}
+
+	var message dceMessage
+
+	if err := a.decoder.Decode(&message); err != nil {
+		return nil, fmt.Errorf("invalid message after message %d: %w", a.read, err)
+	}
+
+	a.read++
+
+	if message.ID == "" || message.Author.ID == "" {
+		return nil, fmt.Errorf("message %d has no ID or author", a.read)
+	}
+
+	return message.convert(a.guildID, a.channelID), nil
+}
+
+func (c *dceChannel) convert() *discordgo.Channel {
+	channelType, ok := dceChannelTypes[c.Type]
+	if !ok {
+		channelType = discordgo.ChannelTypeGuildText
+	}
+
+	return &discordgo.Channel{
+		ID:       c.ID,
+		Name:     c.Name,
+		Topic:    c.Topic,
+		Type:     channelType,
+		ParentID: c.CategoryID,
+	}
+}
+
+func (m *dceMessage) convert(guildID, channelID string) *discordgo.MessageCreate {
+	message := &discordgo.Message{
+		ID:        m.ID,
+		ChannelID: channelID,
+		GuildID:   guildID,
+		Content:   m.Content,
+		Timestamp: m.Timestamp.UTC(),
+		Author:    m.Author.convert(),
+		Pinned:    m.IsPinned,
+		Type:      dceMessageTypes[m.Type],
+	}
+
+	if m.TimestampEdited != nil {
+		edited := m.TimestampEdited.UTC()
+		message.EditedTimestamp = &edited
+	}
+
+	// DiscordChatExporter repeats the username as the nickname of members without one.
+	if guildID != "" {
+		message.Member = &discordgo.Member{GuildID: guildID}
+
+		if m.Author.Nickname != m.Author.Name {
+			message.Member.Nick = m.Author.Nickname
+		}
+	}
+
+	for _, attachment := range m.Attachments {
+		message.Attachments = append(message.Attachments, &discordgo.MessageAttachment{
+			ID: attachment.ID, URL: attachment.URL, Filename: attachment.FileName, Size: attachment.FileSizeBytes,
+		})
+	}
+
+	for i := range m.Embeds {
+		message.Embeds = append(message.Embeds, m.Embeds[i].convert())
+	}
+
+	for _, user := range m.Mentions {
+		message.Mentions = append(message.Mentions, user.convert())
+	}
+
+	if m.Reference != nil && m.Reference.MessageID != "" {
+		message.MessageReference = &discordgo.MessageReference{
+			MessageID: m.Reference.MessageID, ChannelID: m.Reference.ChannelID, GuildID: m.Reference.GuildID,
+		}
+	}
+
+	return &discordgo.MessageCreate{Message: message}
+}
+
+func (u *dceUser) convert() *discordgo.User {
+	return &discordgo.User{
+		ID:            u.ID,
+		Username:      u.Name,
+		Discriminator: u.Discriminator,
+		Avatar:        cdnHash(u.AvatarURL),
+		Bot:           u.IsBot,
+	}
+}
+
+func (e *dceEmbed) convert() *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       e.Title,
+		URL:         e.URL,
+		Timestamp:   e.Timestamp,
+		Description: e.Description,
+	}
+
+	if color, err := strconv.ParseInt(strings.TrimPrefix(e.Color, "#"), 16, 32); err == nil {
+		embed.Color = int(color)
+	}
+
+	if e.Thumbnail != nil {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: e.Thumbnail.URL, Width: e.Thumbnail.Width, Height: e.Thumbnail.Height}
+	}
+
+	if len(e.Images) > 0 {
+		embed.Image = &discordgo.MessageEmbedImage{URL: e.Images[0].URL, Width: e.Images[0].Width, Height: e.Images[0].Height}
+	}
+
+	if e.Author != nil {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: e.Author.Name, URL: e.Author.URL, IconURL: e.Author.IconURL}
+	}
+
+	for _, field := range e.Fields {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: field.Name, Value: field.Value, Inline: field.IsInline})
+	}
+
+	if e.Footer != nil {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: e.Footer.Text, IconURL: e.Footer.IconURL}
+	}
+
+	return embed
+}
+
+// cdnHash returns the image hash in a Discord CDN URL such as
+// https://cdn.discordapp.com/avatars/42/a_1b2c.gif?size=512. Default avatars and images
+// the export downloaded have none.
+func cdnHash(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || !strings.HasSuffix(parsed.Host, "discordapp.com") {
+		return ""
+	}
+
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(parts) != 3 || (parts[0] != "avatars" && parts[0] != "icons") {
+		return ""
+	}
+
+	return strings.TrimSuffix(parts[2], path.Ext(parts[2]))
+}
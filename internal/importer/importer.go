@@ -0,0 +1,260 @@
+// Package importer reads history archived by other tools, or exported by the bot, and
+// writes it through the store, so the database covers time the bot wasn't online for.
+package importer
+
+import (
+	"bufio"
+	"discord-go-connect/internal/store"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// batchSize is how many messages are checked for duplicates and stored at once.
+	batchSize = 500
+	// progressInterval is how many messages are read between progress reports.
+	progressInterval = 5000
+)
+
+// dcePrefix matches the start of a DiscordChatExporter JSON file, which is one object
+// beginning with the guild. Anything else is read as the bot's NDJSON export.
+var dcePrefix = regexp.MustCompile(`^\s*\{\s*"guild"\s*:`)
+
+// Report counts what an import wrote, or would have written in a dry run.
+type Report struct {
+	Files    int `json:"files"`
+	Guilds   int `json:"guilds"`
+	Channels int `json:"channels"`
+	// Messages is how many messages were read; they were imported, duplicates or failed.
+	Messages   int `json:"messages"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	// Failed counts the messages the store couldn't write. The others are imported anyway.
+	Failed int `json:"failed"`
+}
+
+// Options configures an Importer.
+type Options struct {
+	// DryRun reads and checks everything but writes nothing.
+	DryRun bool
+	// Progress, if set, is called with the running totals while a file is read and once
+	// it is done.
+	Progress func(file string, report Report)
+}
+
+// Importer writes archives to a store. Guilds and channels the store already has are
+// left alone, and messages it already has are skipped, so archives can be imported again
+// or overlap each other.
+type Importer struct {
+	store   store.Store
+	options Options
+	report  Report
+	// guilds, channels and seen remember what this run handled, which the store doesn't
+	// know about in dry runs.
+	guilds   map[string]bool
+	channels map[string]bool
+	seen     map[string]bool
+}
+
+// archive is a file being read.
+type archive interface {
+	// next returns the next message, or io.EOF after the last one.
+	next() (*discordgo.MessageCreate, error)
+}
+
+func NewImporter(history store.Store, options Options) *Importer {
+	return &Importer{
+		store:    history,
+		options:  options,
+		guilds:   make(map[string]bool),
+		channels: make(map[string]bool),
+		seen:     make(map[string]bool),
+	}
+}
+
+// Report returns the totals of everything imported so far.
+func (i *Importer) Report() Report {
+	return i.report
+}
+
+// ImportFile imports a DiscordChatExporter JSON export or an NDJSON export of the bot.
+func (i *Importer) ImportFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if err := i.Import(path, file); err != nil {
+		return fmt.Errorf("failed to import %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Import imports an archive read from r. name identifies it in progress reports.
+func (i *Importer) Import(name string, r io.Reader) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+
+	// Peek returns what it has when the file is shorter.
+	start, _ := reader.Peek(512)
+
+	var (
+		source archive
+		err    error
+	)
+
+	if dcePrefix.Match(start) {
+		source, err = i.openDCE(reader)
+	} else {
+		source = newNDJSONArchive(reader)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	i.report.Files++
+
+	batch := make([]*discordgo.MessageCreate, 0, batchSize)
+
+	for {
+		message, err := source.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, message)
+		if len(batch) < batchSize {
+			continue
+		}
+
+		if err := i.save(name, batch); err != nil {
+			return err
+		}
+
+		batch = batch[:0]
+	}
+
+	if err := i.save(name, batch); err != nil {
+		return err
+	}
+
+	if i.options.Progress != nil {
+		i.options.Progress(name, i.report)
+	}
+
+	return nil
+}
+
+// save stores the messages of a batch that neither the store nor this run has seen.
+func (i *Importer) save(name string, batch []*discordgo.MessageCreate) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(batch))
+	for _, message := range batch {
+		ids = append(ids, message.ID)
+	}
+
+	known, err := i.store.KnownMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	fresh := make([]*discordgo.MessageCreate, 0, len(batch))
+
+	for _, message := range batch {
+		if known[message.ID] || i.seen[message.ID] {
+			i.report.Duplicates++
+			continue
+		}
+
+		if err := i.ensureChannel(message.GuildID, &discordgo.Channel{ID: message.ChannelID, Name: message.ChannelID}); err != nil {
+			return err
+		}
+
+		i.seen[message.ID] = true
+		fresh = append(fresh, message)
+	}
+
+	failed := 0
+
+	if !i.options.DryRun {
+		result, err := i.store.SaveMessages(fresh)
+		if err != nil {
+			return err
+		}
+
+		failed = len(result.Failed)
+	}
+
+	before := i.report.Messages
+	i.report.Messages += len(batch)
+	i.report.Imported += len(fresh) - failed
+	i.report.Failed += failed
+
+	if i.options.Progress != nil && before/progressInterval != i.report.Messages/progressInterval {
+		i.options.Progress(name, i.report)
+	}
+
+	return nil
+}
+
+// ensureGuild stores a guild unless the store already has it. Guilds the bot is in are
+// kept up to date by the bot, and archives only know their name and icon.
+func (i *Importer) ensureGuild(guild *discordgo.Guild) error {
+	if guild.ID == "" || i.guilds[guild.ID] {
+		return nil
+	}
+
+	i.guilds[guild.ID] = true
+
+	if _, err := i.store.Guild(guild.ID); !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	i.report.Guilds++
+
+	if i.options.DryRun {
+		return nil
+	}
+
+	return i.store.SaveGuild(guild)
+}
+
+// ensureChannel stores a channel, and its guild, unless the store already has it.
+// Channels only known from the messages in them are named by their ID.
+func (i *Importer) ensureChannel(guildID string, channel *discordgo.Channel) error {
+	if i.channels[channel.ID] {
+		return nil
+	}
+
+	if err := i.ensureGuild(&discordgo.Guild{ID: guildID, Name: guildID}); err != nil {
+		return err
+	}
+
+	i.channels[channel.ID] = true
+
+	if _, err := i.store.Channel(channel.ID); !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	i.report.Channels++
+
+	if i.options.DryRun {
+		return nil
+	}
+
+	return i.store.SaveChannel(guildID, channel)
+}
@@ -0,0 +1,207 @@
+package importer
+
+import (
+	"bytes"
+	"discord-go-connect/internal/export"
+	"discord-go-connect/internal/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const dceExport = `{
+  "guild": {"id": "1", "name": "Old Guild", "iconUrl": "https://cdn.discordapp.com/icons/1/abc123.png?size=512"},
+  "channel": {"id": "10", "type": "GuildTextChat", "categoryId": "9", "category": "Text", "name": "general", "topic": null},
+  "dateRange": {"after": null, "before": null},
+  "exportedAt": "2021-06-01T00:00:00+00:00",
+  "messages": [
+    {
+      "id": "100", "type": "Default", "timestamp": "2020-01-01T10:00:00.123+00:00", "timestampEdited": null,
+      "callEndedTimestamp": null, "isPinned": true, "content": "first message",
+      "author": {"id": "42", "name": "carol", "discriminator": "0001", "nickname": "Caz", "color": null, "isBot": false,
+        "avatarUrl": "https://cdn.discordapp.com/avatars/42/a_deadbeef.gif?size=512"},
+      "attachments": [{"id": "7", "url": "https://cdn.discordapp.com/attachments/10/7/cat.png", "fileName": "cat.png", "fileSizeBytes": 1024}],
+      "embeds": [{"title": "Docs", "url": "https://example.com", "timestamp": null, "description": "read me", "color": "#5865F2",
+        "thumbnail": {"url": "https://example.com/t.png", "width": 80, "height": 80}, "images": [],
+        "fields": [{"name": "a", "value": "b", "isInline": true}], "footer": {"text": "footer"}}],
+      "stickers": [], "reactions": [], "mentions": [{"id": "43", "name": "dave", "discriminator": "0002", "nickname": "dave", "isBot": false}]
+    },
+    {
+      "id": "101", "type": "Reply", "timestamp": "2020-01-01T12:05:00+02:00", "timestampEdited": "2020-01-01T12:06:00+02:00",
+      "isPinned": false, "content": "a reply",
+      "author": {"id": "43", "name": "dave", "discriminator": "0002", "nickname": "dave", "isBot": false,
+        "avatarUrl": "https://cdn.discordapp.com/embed/avatars/2.png"},
+      "attachments": [], "embeds": [], "mentions": [],
+      "reference": {"messageId": "100", "channelId": "10", "guildId": "1"}
+    }
+  ],
+  "messageCount": 2
+}`
+
+func TestImportDiscordChatExporter(t *testing.T) {
+	history := store.NewMemory()
+
+	var progress []Report
+
+	importer := NewImporter(history, Options{Progress: func(file string, report Report) {
+		progress = append(progress, report)
+	}})
+
+	if err := importer.Import("general.json", strings.NewReader(dceExport)); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	want := Report{Files: 1, Guilds: 1, Channels: 1, Messages: 2, Imported: 2}
+	if report := importer.Report(); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	if len(progress) != 1 || progress[0] != want {
+		t.Errorf("progress = %+v, want the final report", progress)
+	}
+
+	guild, err := history.Guild("1")
+	if err != nil || guild.Name != "Old Guild" || guild.Icon != "abc123" {
+		t.Errorf("guild = %+v, %v", guild, err)
+	}
+
+	channel, err := history.Channel("10")
+	if err != nil || channel.Name != "general" || channel.GuildID != "1" || channel.ParentID != "9" {
+		t.Errorf("channel = %+v, %v", channel, err)
+	}
+
+	messages, err := history.ChannelMessages("10", 10, 0)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("stored %d messages, %v; want 2", len(messages), err)
+	}
+
+	reply, first := messages[0], messages[1]
+
+	if !first.Pinned || first.Author.Avatar != "a_deadbeef" || first.Member == nil || first.Member.Nick != "Caz" ||
+		len(first.Attachments) != 1 || first.Attachments[0].Filename != "cat.png" || len(first.Mentions) != 1 ||
+		len(first.Embeds) != 1 || first.Embeds[0].Color != 0x5865f2 || first.Embeds[0].Fields[0].Value != "b" {
+		t.Errorf("first message = %+v", first)
+	}
+
+	if reply.Timestamp != time.Date(2020, 1, 1, 10, 5, 0, 0, time.UTC) || reply.EditedTimestamp == nil ||
+		reply.Type != discordgo.MessageTypeReply || reply.Author.Avatar != "" || (reply.Member != nil && reply.Member.Nick != "") {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestImportSkipsDuplicates(t *testing.T) {
+	history := store.NewMemory()
+
+	// The bot already knows the guild and has seen the first message.
+	if err := history.SaveGuild(&discordgo.Guild{ID: "1", Name: "Current Name", OwnerID: "42"}); err != nil {
+		t.Fatalf("failed to store guild: %v", err)
+	}
+
+	_, err := history.SaveMessages([]*discordgo.MessageCreate{{Message: &discordgo.Message{
+		ID: "100", ChannelID: "10", GuildID: "1", Author: &discordgo.User{ID: "42"}, Content: "seen live",
+	}}})
+	if err != nil {
+		t.Fatalf("failed to store message: %v", err)
+	}
+
+	importer := NewImporter(history, Options{})
+
+	for i := 0; i < 2; i++ {
+		if err := importer.Import("general.json", strings.NewReader(dceExport)); err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+	}
+
+	want := Report{Files: 2, Channels: 1, Messages: 4, Imported: 1, Duplicates: 3}
+	if report := importer.Report(); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	if guild, _ := history.Guild("1"); guild.Name != "Current Name" || guild.OwnerID != "42" {
+		t.Errorf("the import replaced the known guild with %+v", guild)
+	}
+
+	if known, _ := history.KnownMessages([]string{"100", "101"}); len(known) != 2 {
+		t.Errorf("known messages = %v, want both", known)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	history := store.NewMemory()
+	importer := NewImporter(history, Options{DryRun: true})
+
+	if err := importer.Import("general.json", strings.NewReader(dceExport)); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	// Running the same file again in one dry run still finds the duplicates.
+	if err := importer.Import("copy.json", strings.NewReader(dceExport)); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	want := Report{Files: 2, Guilds: 1, Channels: 1, Messages: 4, Imported: 2, Duplicates: 2}
+	if report := importer.Report(); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	if _, err := history.Channel("10"); err == nil {
+		t.Error("the dry run stored the channel")
+	}
+
+	if known, _ := history.KnownMessages([]string{"100", "101"}); len(known) != 0 {
+		t.Errorf("the dry run stored messages %v", known)
+	}
+}
+
+func TestImportBotExport(t *testing.T) {
+	source := store.NewMemory()
+
+	if err := NewImporter(source, Options{}).Import("general.json", strings.NewReader(dceExport)); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	var exported bytes.Buffer
+
+	_, err := export.NewExporter(source).Export(&exported, &export.Request{Format: export.NDJSON, ChannelID: "10"})
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	history := store.NewMemory()
+	importer := NewImporter(history, Options{})
+
+	if err := importer.Import("channel-10.ndjson", &exported); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	want := Report{Files: 1, Guilds: 1, Channels: 1, Messages: 2, Imported: 2}
+	if report := importer.Report(); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	messages, err := history.ChannelMessages("10", 10, 0)
+	if err != nil || len(messages) != 2 || messages[1].Content != "first message" || messages[1].Member.Nick != "Caz" {
+		t.Errorf("stored %+v, %v", messages, err)
+	}
+
+	// NDJSON exports don't name channels.
+	if channel, err := history.Channel("10"); err != nil || channel.Name != "10" || channel.GuildID != "1" {
+		t.Errorf("channel = %+v, %v", channel, err)
+	}
+}
+
+func TestImportInvalidFiles(t *testing.T) {
+	for name, content := range map[string]string{
+		"truncated":   `{"guild": {"id": "1"}, "channel": {"id": "10"}, "messages": [{"id": "1", `,
+		"no channel":  `{"guild": {"id": "1"}, "messages": []}`,
+		"no messages": `{"guild": {"id": "1"}, "channel": {"id": "10"}}`,
+		"not json":    "id,content\n1,hello\n",
+		"no author":   `{"id": "1", "channel_id": "10"}`,
+	} {
+		if err := NewImporter(store.NewMemory(), Options{}).Import(name, strings.NewReader(content)); err == nil {
+			t.Errorf("importing %s succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ndjsonArchive reads the bot's own NDJSON export, a message per line as the API returns
+// them. It names no guilds or channels, so those are only known by ID.
+type ndjsonArchive struct {
+	decoder *json.Decoder
+	line    int
+}
+
+func newNDJSONArchive(r io.Reader) *ndjsonArchive {
+	return &ndjsonArchive{decoder: json.NewDecoder(r)}
+}
+
+func (a *ndjsonArchive) next() (*discordgo.MessageCreate, error) {
+	var message discordgo.Message
+
+	if err := a.decoder.Decode(&message); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("invalid message after line %d: %w", a.line, err)
+	}
+
+	a.line++
+
+	if message.ID == "" || message.ChannelID == "" || message.Author == nil {
+		return nil, fmt.Errorf("message on line %d has no ID, channel or author", a.line)
+	}
+
+	return &discordgo.MessageCreate{Message: &message}, nil
+}
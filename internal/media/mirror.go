@@ -0,0 +1,442 @@
+// Package media mirrors attachments, and optionally avatars and custom emoji, from
+// Discord's CDN, whose attachment URLs expire, into a blob store. Files are stored once
+// by the SHA-256 of their content, under media/HASH.
+package media
+
+import (
+	"crypto/sha256"
+	"discord-go-connect/internal/blob"
+	"discord-go-connect/internal/logger"
+	"discord-go-connect/internal/metrics"
+	"discord-go-connect/internal/store"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// queueSize is how many downloads can wait; more are dropped until it drains.
+	queueSize = 1000
+	// workers is how many downloads run at once.
+	workers = 4
+	// downloadTimeout bounds a single download.
+	downloadTimeout = 2 * time.Minute
+	// defaultMaxSize is the largest file mirrored unless configured, the upload limit of
+	// servers without boosts.
+	defaultMaxSize = 25 << 20
+	// defaultCDN is where avatars and emoji are downloaded from.
+	defaultCDN = "https://cdn.discordapp.com/"
+)
+
+var (
+	// ErrTooLarge is returned for files above the size limit.
+	ErrTooLarge = errors.New("file is too large to mirror")
+	// ErrUnsupportedType is returned for files of types that aren't mirrored.
+	ErrUnsupportedType = errors.New("file type is not mirrored")
+)
+
+// customEmoji matches the custom emoji in message content, <:name:id> or <a:name:id>
+// when animated.
+var customEmoji = regexp.MustCompile(`<(a?):\w+:(\d+)>`)
+
+// Options limits what the mirror downloads.
+type Options struct {
+	// Types are the content types mirrored. "image/*" matches every image type.
+	Types []string
+	// MaxSize is the size of the largest file mirrored, in bytes.
+	MaxSize int64
+	// Avatars also mirrors the avatars of message authors.
+	Avatars bool
+	// Emoji also mirrors the custom emoji used in messages.
+	Emoji bool
+}
+
+// DefaultOptions mirrors images, videos and audio of up to 25 MiB, without avatars or
+// emoji.
+func DefaultOptions() Options {
+	return Options{Types: []string{"image/*", "video/*", "audio/*"}, MaxSize: defaultMaxSize}
+}
+
+// ParseOptions reads the MEDIA_MAX_MB, MEDIA_TYPES and MEDIA_EXTRAS settings. Empty
+// settings keep the defaults. MEDIA_TYPES and MEDIA_EXTRAS are comma-separated; the
+// extras are avatars and emoji.
+func ParseOptions(maxMB, types, extras string) (Options, error) {
+	options := DefaultOptions()
+
+	if maxMB != "" {
+		size, err := strconv.Atoi(maxMB)
+		if err != nil || size < 1 {
+			return options, fmt.Errorf("invalid size limit %q", maxMB)
+		}
+
+		options.MaxSize = int64(size) << 20
+	}
+
+	if types != "" {
+		options.Types = nil
+
+		for _, contentType := range strings.Split(types, ",") {
+			if contentType = strings.TrimSpace(contentType); contentType != "" {
+				options.Types = append(options.Types, strings.ToLower(contentType))
+			}
+		}
+	}
+
+	for _, extra := range strings.Split(extras, ",") {
+		switch strings.TrimSpace(extra) {
+		case "":
+		case "avatars":
+			options.Avatars = true
+		case "emoji":
+			options.Emoji = true
+		default:
+			return options, fmt.Errorf("unknown media extra %q", extra)
+		}
+	}
+
+	return options, nil
+}
+
+// allows reports whether a content type is mirrored.
+func (o *Options) allows(contentType string) bool {
+	for _, allowed := range o.Types {
+		if allowed == contentType || allowed == "*/*" ||
+			(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// download is a file waiting to be mirrored.
+type download struct {
+	source  string
+	url     string
+	guildID string
+	// messageID is set for attachments, which are forgotten with their message.
+	messageID string
+	// size is the size Discord reports, if it does, so larger files are skipped early.
+	size int64
+}
+
+// Mirror downloads media in the background and records it in the store.
+type Mirror struct {
+	store   store.Store
+	blobs   blob.Store
+	options Options
+	client  *http.Client
+	cdn     string
+	queue   chan download
+	logger  *logger.StandardLoggerHandler
+	// saving keeps garbage collection from deleting a file a download is recording.
+	saving sync.Mutex
+}
+
+// NewMirror starts the workers of a mirror.
+func NewMirror(history store.Store, blobs blob.Store, options Options) *Mirror {
+	m := &Mirror{
+		store:   history,
+		blobs:   blobs,
+		options: options,
+		client:  &http.Client{Timeout: downloadTimeout},
+		cdn:     defaultCDN,
+		queue:   make(chan download, queueSize),
+		logger:  logger.NewLogger(os.Stderr),
+	}
+
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+
+	return m
+}
+
+// MirrorMessages queues the attachments of stored messages, and the avatars of their
+// authors and their custom emoji if enabled, unless they are mirrored already.
+func (m *Mirror) MirrorMessages(messages []*discordgo.Message) {
+	downloads := make([]download, 0)
+
+	for _, message := range messages {
+		for _, attachment := range message.Attachments {
+			downloads = append(downloads, download{
+				source: "attachment/" + attachment.ID, url: attachment.URL, size: int64(attachment.Size),
+				guildID: message.GuildID, messageID: message.ID,
+			})
+		}
+
+		if m.options.Avatars && message.Author != nil && message.Author.Avatar != "" {
+			downloads = append(downloads, download{
+				source:  "avatar/" + message.Author.ID + "/" + message.Author.Avatar,
+				guildID: message.GuildID,
+				url:     m.cdn + "avatars/" + message.Author.ID + "/" + message.Author.Avatar + imageExtension(strings.HasPrefix(message.Author.Avatar, "a_")),
+			})
+		}
+
+		if m.options.Emoji {
+			for _, match := range customEmoji.FindAllStringSubmatch(message.Content, -1) {
+				downloads = append(downloads, download{
+					source:  "emoji/" + match[2],
+					guildID: message.GuildID,
+					url:     m.cdn + "emojis/" + match[2] + imageExtension(match[1] == "a"),
+				})
+			}
+		}
+	}
+
+	if len(downloads) == 0 {
+		return
+	}
+
+	sources := make([]string, 0, len(downloads))
+	for _, pending := range downloads {
+		sources = append(sources, pending.source)
+	}
+
+	mirrored, err := m.store.MirroredMedia(sources)
+	if err != nil {
+		m.logger.Error("failed to look up mirrored media: %v", err)
+		return
+	}
+
+	queued := make(map[string]bool, len(downloads))
+
+	for _, pending := range downloads {
+		if _, ok := mirrored[pending.source]; ok || queued[pending.source] || pending.url == "" {
+			continue
+		}
+
+		queued[pending.source] = true
+
+		select {
+		case m.queue <- pending:
+		default:
+			recordMirror("dropped")
+		}
+	}
+}
+
+func imageExtension(animated bool) string {
+	if animated {
+		return ".gif"
+	}
+
+	return ".png"
+}
+
+func (m *Mirror) work() {
+	for pending := range m.queue {
+		object, err := m.mirror(pending)
+
+		switch {
+		case errors.Is(err, ErrTooLarge), errors.Is(err, ErrUnsupportedType):
+			recordMirror("skipped")
+		case err != nil:
+			m.logger.Error("failed to mirror %s: %v", pending.source, err)
+			recordMirror("failed")
+		default:
+			m.logger.Info("mirrored %s as %s", pending.source, object.Hash)
+			recordMirror("stored")
+		}
+	}
+}
+
+func recordMirror(outcome string) {
+	metrics.AddCounter("discord_media_mirrored_total", "Media files handled by the mirror by outcome.",
+		metrics.Labels{"outcome": outcome}, 1)
+}
+
+// mirror downloads a file, stores it unless an identical file is stored already and
+// records its source.
+func (m *Mirror) mirror(pending download) (*store.MediaObject, error) {
+	if pending.size > m.options.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	response, err := m.client.Get(pending.url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CDN returned %d", response.StatusCode)
+	}
+
+	if response.ContentLength > m.options.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	spool, err := os.CreateTemp("", "discord-media-*")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(spool, hash), io.LimitReader(response.Body, m.options.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if size > m.options.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType, err := m.contentType(response.Header.Get("Content-Type"), spool)
+	if err != nil {
+		return nil, err
+	}
+
+	object := &store.MediaObject{
+		CreatedAt:   time.Now().UTC(),
+		Hash:        hex.EncodeToString(hash.Sum(nil)),
+		ContentType: contentType,
+		Size:        size,
+	}
+
+	m.saving.Lock()
+	defer m.saving.Unlock()
+
+	if _, err := m.store.MediaObject(object.Hash); errors.Is(err, store.ErrNotFound) {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		if err := m.blobs.Put(Key(object.Hash), spool); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.SaveMedia(pending.source, pending.guildID, pending.messageID, object); err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// CollectGarbage deletes the files no source refers to anymore, such as the attachments
+// of pruned and archived messages, and returns how many it deleted.
+func (m *Mirror) CollectGarbage() (int, error) {
+	m.saving.Lock()
+	defer m.saving.Unlock()
+
+	hashes, err := m.store.UnreferencedMedia()
+	if err != nil {
+		return 0, err
+	}
+
+	collected := 0
+
+	for _, hash := range hashes {
+		deleted, err := m.store.DeleteMediaObject(hash)
+		if err != nil {
+			return collected, err
+		}
+
+		if !deleted {
+			continue
+		}
+
+		if err := m.blobs.Delete(Key(hash)); err != nil {
+			return collected, fmt.Errorf("failed to delete media object %s: %w", hash, err)
+		}
+
+		collected++
+	}
+
+	return collected, nil
+}
+
+// contentType returns the type of a downloaded file, as the CDN reports it or sniffed
+// from its start, and checks that it is mirrored.
+func (m *Mirror) contentType(header string, file *os.File) (string, error) {
+	contentType, _, err := mime.ParseMediaType(header)
+
+	if err != nil || contentType == "application/octet-stream" {
+		start := make([]byte, 512)
+
+		n, err := file.ReadAt(start, 0)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(start[:n]))
+	}
+
+	if !m.options.allows(contentType) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	return contentType, nil
+}
+
+// Key names the blob of a mirrored file.
+func Key(hash string) string {
+	return "media/" + hash
+}
+
+// Open opens a mirrored file by its hash, or by its source such as attachment/ID if
+// canAccessGuild allows the guild it was mirrored for. It returns store.ErrNotFound for
+// files that aren't mirrored or that the caller can't look up by source.
+func (m *Mirror) Open(name string, canAccessGuild func(guildID string) bool) (io.ReadSeekCloser, *store.MediaObject, error) {
+	var (
+		object *store.MediaObject
+		err    error
+	)
+
+	if isHash(name) {
+		object, err = m.store.MediaObject(name)
+	} else {
+		var guildID string
+
+		object, guildID, err = m.store.MediaSource(name)
+		if err == nil && !canAccessGuild(guildID) {
+			err = fmt.Errorf("%w: media source %s", store.ErrNotFound, name)
+		}
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := m.blobs.Open(Key(object.Hash))
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, fmt.Errorf("%w: media object %s", store.ErrNotFound, object.Hash)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, object, nil
+}
+
+// isHash reports whether name is a hex-encoded SHA-256.
+func isHash(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+
+	return err == nil && strings.ToLower(name) == name
+}
@@ -0,0 +1,282 @@
+package media
+
+import (
+	"bytes"
+	"discord-go-connect/internal/blob"
+	"discord-go-connect/internal/store"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	pngImage = []byte("\x89PNG\r\n\x1a\n picture")
+	gifImage = []byte("GIF89a animation")
+)
+
+// cdn serves files by path and counts the requests for them.
+func cdn(t *testing.T, files map[string][]byte, requests *int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.URL.Path == "/notes.txt" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			// Sniffed from the content.
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+
+		_, _ = w.Write(file)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestMirror(t *testing.T) {
+	var requests int32
+
+	server := cdn(t, map[string][]byte{
+		"/a.png":            pngImage,
+		"/copy.png":         pngImage,
+		"/big.png":          append(append([]byte{}, pngImage...), bytes.Repeat([]byte{0}, 2048)...),
+		"/notes.txt":        []byte("just text"),
+		"/avatars/42/a.png": append(append([]byte{}, pngImage...), " avatar"...),
+		"/emojis/99.gif":    gifImage,
+	}, &requests)
+
+	blobs, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	history := store.NewMemory()
+
+	mirror := NewMirror(history, blobs, Options{Types: []string{"image/*"}, MaxSize: 1024, Avatars: true, Emoji: true})
+	mirror.cdn = server.URL + "/"
+
+	message := &discordgo.Message{
+		ID: "1", GuildID: "1", ChannelID: "10", Content: "nice <a:party:99>",
+		Author: &discordgo.User{ID: "42", Avatar: "a"},
+		Attachments: []*discordgo.MessageAttachment{
+			{ID: "100", URL: server.URL + "/a.png"},
+			{ID: "101", URL: server.URL + "/copy.png"},
+			{ID: "102", URL: server.URL + "/big.png"},
+			{ID: "103", URL: server.URL + "/notes.txt"},
+			{ID: "104", URL: server.URL + "/huge.png", Size: 1 << 30},
+		},
+	}
+
+	mirror.MirrorMessages([]*discordgo.Message{message})
+
+	sources := []string{"attachment/100", "attachment/101", "avatar/42/a", "emoji/99"}
+	deadline := time.Now().Add(3 * time.Second)
+
+	var mirrored map[string]store.MediaObject
+
+	for {
+		mirrored, _ = history.MirroredMedia(append(sources, "attachment/102", "attachment/103", "attachment/104"))
+		if len(mirrored) >= len(sources) && atomic.LoadInt32(&requests) == 6 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("mirrored %v after %d requests, want %v", mirrored, atomic.LoadInt32(&requests), sources)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Give the skipped downloads a moment to be recorded, if they wrongly were.
+	time.Sleep(50 * time.Millisecond)
+
+	mirrored, _ = history.MirroredMedia(append(sources, "attachment/102", "attachment/103", "attachment/104"))
+	if len(mirrored) != len(sources) {
+		t.Fatalf("mirrored %v, want only %v", mirrored, sources)
+	}
+
+	if mirrored["attachment/100"].Hash != mirrored["attachment/101"].Hash || mirrored["attachment/100"].ContentType != "image/png" {
+		t.Errorf("identical attachments = %+v and %+v, want one PNG", mirrored["attachment/100"], mirrored["attachment/101"])
+	}
+
+	if mirrored["emoji/99"].ContentType != "image/gif" {
+		t.Errorf("emoji = %+v, want a GIF", mirrored["emoji/99"])
+	}
+
+	if keys, _ := blobs.List("media/"); len(keys) != 3 {
+		t.Errorf("stored %v, want the picture, the avatar and the emoji", keys)
+	}
+
+	// Mirrored files aren't downloaded again.
+	mirror.MirrorMessages([]*discordgo.Message{{ID: "2", Attachments: message.Attachments[:2]}})
+	time.Sleep(50 * time.Millisecond)
+
+	if count := atomic.LoadInt32(&requests); count != 6 {
+		t.Errorf("the CDN got %d requests, want 6", count)
+	}
+
+	guild := func(id string) func(guildID string) bool {
+		return func(guildID string) bool { return guildID == id }
+	}
+
+	for _, name := range []string{mirrored["attachment/100"].Hash, "attachment/101"} {
+		file, object, err := mirror.Open(name, guild("1"))
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", name, err)
+		}
+
+		content, _ := io.ReadAll(file)
+		file.Close()
+
+		if !bytes.Equal(content, pngImage) || object.Size != int64(len(pngImage)) {
+			t.Errorf("%s holds %q (%+v), want the picture", name, content, object)
+		}
+	}
+
+	if _, _, err := mirror.Open("attachment/103", guild("1")); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("opening a skipped attachment: %v, want ErrNotFound", err)
+	}
+
+	// Other guilds only get the file by hash.
+	if _, _, err := mirror.Open("attachment/101", guild("2")); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("opening another guild's attachment by source: %v, want ErrNotFound", err)
+	}
+
+	file, _, err := mirror.Open(mirrored["attachment/101"].Hash, guild("2"))
+	if err != nil {
+		t.Fatalf("failed to open by hash: %v", err)
+	}
+
+	file.Close()
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions("8", "image/png, video/*", "avatars,emoji")
+	if err != nil {
+		t.Fatalf("failed to parse options: %v", err)
+	}
+
+	want := Options{Types: []string{"image/png", "video/*"}, MaxSize: 8 << 20, Avatars: true, Emoji: true}
+	if !reflect.DeepEqual(options, want) {
+		t.Errorf("options = %+v, want %+v", options, want)
+	}
+
+	if options, _ := ParseOptions("", "", ""); !reflect.DeepEqual(options, DefaultOptions()) {
+		t.Errorf("empty settings = %+v, want the defaults", options)
+	}
+
+	for _, settings := range [][3]string{{"0", "", ""}, {"lots", "", ""}, {"", "", "stickers"}} {
+		if _, err := ParseOptions(settings[0], settings[1], settings[2]); err == nil {
+			t.Errorf("parsing %q succeeded", settings)
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	blobs, err := blob.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	history := store.NewMemory()
+	mirror := NewMirror(history, blobs, DefaultOptions())
+
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	batch := make([]*discordgo.MessageCreate, 0, 2)
+	for i, id := range []string{"1", "2"} {
+		batch = append(batch, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID: id, ChannelID: "10", GuildID: "1", Author: &discordgo.User{ID: "42"},
+			Timestamp: sent.Add(time.Duration(i) * time.Hour),
+		}})
+	}
+
+	if _, err := history.SaveMessages(batch); err != nil {
+		t.Fatalf("failed to save messages: %v", err)
+	}
+
+	// Both messages share a picture, the first also has a file of its own, and the
+	// avatar doesn't belong to a message.
+	for _, saved := range []struct{ source, messageID, hash string }{
+		{"attachment/100", "1", "shared"},
+		{"attachment/101", "1", "first"},
+		{"attachment/200", "2", "shared"},
+		{"avatar/42/a", "", "avatar"},
+	} {
+		if err := history.SaveMedia(saved.source, "1", saved.messageID, &store.MediaObject{Hash: saved.hash}); err != nil {
+			t.Fatalf("failed to save %s: %v", saved.source, err)
+		}
+
+		if err := blobs.Put(Key(saved.hash), bytes.NewReader(pngImage)); err != nil {
+			t.Fatalf("failed to store %s: %v", saved.hash, err)
+		}
+	}
+
+	collect := func(want int, kept ...string) {
+		t.Helper()
+
+		collected, err := mirror.CollectGarbage()
+		if err != nil {
+			t.Fatalf("failed to collect garbage: %v", err)
+		}
+
+		if collected != want {
+			t.Errorf("collected %d files, want %d", collected, want)
+		}
+
+		var wantKeys []string
+		for _, hash := range kept {
+			wantKeys = append(wantKeys, Key(hash))
+		}
+
+		if keys, _ := blobs.List("media/"); !reflect.DeepEqual(keys, wantKeys) {
+			t.Errorf("stored %v, want %v", keys, wantKeys)
+		}
+	}
+
+	collect(0, "avatar", "first", "shared")
+
+	if _, err := history.PruneMessages("10", time.Time{}, 1, 10); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+
+	if _, _, err := history.MediaSource("attachment/100"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("source of a pruned message: %v, want ErrNotFound", err)
+	}
+
+	collect(1, "avatar", "shared")
+
+	// Archived messages keep their files until they are pruned from the archive.
+	partition := &store.ArchivedPartition{ChannelID: "10", GuildID: "1", Month: "2024-01", Messages: 1}
+	if err := history.ArchivePartition(partition, []string{"2"}); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	collect(0, "avatar", "shared")
+
+	partition.Messages = 0
+	if err := history.PruneArchivedPartition(partition, []string{"2"}, time.Now()); err != nil {
+		t.Fatalf("failed to prune the archive: %v", err)
+	}
+
+	collect(1, "avatar")
+
+	if _, err := history.MediaObject("shared"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("collected object: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,117 @@
+// Package metrics keeps process wide gauges and counters and serves them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type series struct {
+	labels string
+	value  float64
+}
+
+type family struct {
+	series map[string]*series
+	help   string
+	kind   string
+}
+
+var (
+	families = make(map[string]*family)
+	mu       sync.Mutex
+)
+
+// Labels are the label names and values of one series.
+type Labels map[string]string
+
+// SetGauge sets the current value of a gauge.
+func SetGauge(name, help string, labels Labels, value float64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lookup(name, help, "gauge", labels).value = value
+}
+
+// AddCounter increases a counter.
+func AddCounter(name, help string, labels Labels, delta float64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lookup(name, help, "counter", labels).value += delta
+}
+
+func lookup(name, help, kind string, labels Labels) *series {
+	f, ok := families[name]
+	if !ok {
+		f = &family{help: help, kind: kind, series: make(map[string]*series)}
+		families[name] = f
+	}
+
+	key := formatLabels(labels)
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: key}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler serves every metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			f := families[name]
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+
+			keys := make([]string, 0, len(f.series))
+			for key := range f.series {
+				keys = append(keys, key)
+			}
+
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s%s %g\n", name, key, f.series[key].value)
+			}
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package store
+
+import "time"
+
+// ArchivedPartition is a month of a channel's messages moved out of the store into a
+// blob of the cold archive.
+type ArchivedPartition struct {
+	// First and Last are the times of the oldest and the newest message in it.
+	First      time.Time `json:"first"`
+	Last       time.Time `json:"last"`
+	ArchivedAt time.Time `json:"archived_at"`
+	ChannelID  string    `json:"channel_id"`
+	GuildID    string    `json:"guild_id,omitempty"`
+	// Month is when the messages were sent, as 2006-01.
+	Month string `json:"month"`
+	// Key names the blob holding the messages.
+	Key      string `json:"key"`
+	Messages int    `json:"messages"`
+}
+
+// ArchiveIndex tracks which messages moved to the cold archive.
+type ArchiveIndex interface {
+	// ArchivableChannels returns the channels with messages sent before before.
+	ArchivableChannels(before time.Time) ([]string, error)
+	// ArchivedPartitions returns the partitions of a channel, newest first.
+	ArchivedPartitions(channelID string) ([]ArchivedPartition, error)
+	// ArchivePartition records a partition, replacing the one of the same channel and
+	// month, and deletes the messages it holds, with their mentions, from the store.
+	// Their reactions and mirrored media stay, so archived messages keep showing them.
+	ArchivePartition(partition *ArchivedPartition, messageIDs []string) error
+	// PruneArchivedPartition records that retention rules pruned the oldest messages of
+	// a partition: it is replaced with what is left of it, or forgotten when Messages is
+	// 0, and the pruned messages, the newest of them sent at newest, are added to the
+	// channel's PrunedHistory. Their reactions and mirrored media are forgotten.
+	PruneArchivedPartition(partition *ArchivedPartition, messageIDs []string, newest time.Time) error
+	// ChannelMessageCount counts the messages of a channel the store holds.
+	ChannelMessageCount(channelID string) (int, error)
+}
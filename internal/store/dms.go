@@ -0,0 +1,25 @@
+package store
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// DMChannel is a conversation in the bot's DM inbox.
+type DMChannel struct {
+	LastMessageAt   *time.Time `json:"last_message_at"`
+	ID              string     `json:"id"`
+	RecipientID     string     `json:"recipient_id"`
+	RecipientName   string     `json:"recipient_name"`
+	RecipientAvatar string     `json:"recipient_avatar"`
+}
+
+// DirectMessages stores the bot's DM channels.
+type DirectMessages interface {
+	// SaveDMChannel stores a DM channel with its recipient, if it has one. A channel
+	// saved again without a recipient keeps the stored one.
+	SaveDMChannel(channel *discordgo.Channel) error
+	// DMChannels returns the DM channels, most recently active first.
+	DMChannels() ([]DMChannel, error)
+}
@@ -0,0 +1,346 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// compactSlack is how many journal entries beyond the live messages an index tolerates
+// before it rewrites its journal when opened.
+const compactSlack = 1024
+
+// indexEntry is a line of a FileIndex journal: an indexed message or a removed ID.
+type indexEntry struct {
+	Message *discordgo.Message `json:"message,omitempty"`
+	Removed string             `json:"removed,omitempty"`
+}
+
+// FileIndex is an embedded full-text Index for installs whose database has no full-text
+// search. It keeps the messages and an inverted index of their words in memory and
+// journals every change to a file, which it replays when opened. It matches and ranks
+// messages like Memory does.
+type FileIndex struct {
+	mu       sync.RWMutex
+	messages map[string]discordgo.Message
+	// postings maps each word to the IDs of the messages containing it.
+	postings map[string]map[string]struct{}
+	journal  *os.File
+	encoder  *json.Encoder
+	entries  int
+	// torn is set when the journal ends in a line cut short, which compacting drops.
+	torn bool
+}
+
+// OpenFileIndex opens the index journaled at path, creating it if it doesn't exist.
+// A journal that grew well beyond the messages it holds, or that ends in a line cut
+// short, is compacted first.
+func OpenFileIndex(path string) (*FileIndex, error) {
+	index := &FileIndex{
+		messages: make(map[string]discordgo.Message),
+		postings: make(map[string]map[string]struct{}),
+	}
+
+	if err := index.replay(path); err != nil {
+		return nil, err
+	}
+
+	if index.torn || index.entries > 2*len(index.messages)+compactSlack {
+		if err := index.compact(path); err != nil {
+			return nil, err
+		}
+	}
+
+	journal, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open search index: %w", err)
+	}
+
+	index.journal = journal
+	index.encoder = json.NewEncoder(journal)
+
+	return index, nil
+}
+
+// replay loads the journal at path, if there is one.
+func (f *FileIndex) replay(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+
+	for {
+		var entry indexEntry
+
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		// A line cut short by a crash ends the journal; the entries before it still count.
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			f.torn = true
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read search index: %w", err)
+		}
+
+		f.entries++
+
+		if entry.Message != nil {
+			f.putLocked(entry.Message)
+		} else {
+			f.removeLocked(entry.Removed)
+		}
+	}
+}
+
+// compact rewrites the journal at path with only the live messages.
+func (f *FileIndex) compact(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".search-index-*")
+	if err != nil {
+		return fmt.Errorf("failed to compact search index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+
+	for _, id := range f.sortedIDsLocked() {
+		message := f.messages[id]
+		if err := encoder.Encode(indexEntry{Message: &message}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact search index: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact search index: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact search index: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact search index: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to compact search index: %w", err)
+	}
+
+	f.entries = len(f.messages)
+	f.torn = false
+
+	return nil
+}
+
+// Close flushes the journal to disk and closes it.
+func (f *FileIndex) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.journal.Sync(); err != nil {
+		f.journal.Close()
+		return fmt.Errorf("failed to close search index: %w", err)
+	}
+
+	if err := f.journal.Close(); err != nil {
+		return fmt.Errorf("failed to close search index: %w", err)
+	}
+
+	return nil
+}
+
+func (f *FileIndex) IndexMessages(messages []*discordgo.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, message := range messages {
+		if message == nil || message.Author == nil {
+			continue
+		}
+
+		// Edits may leave out the guild, which the guild filters need.
+		if message.GuildID == "" {
+			if old, ok := f.messages[message.ID]; ok && old.GuildID != "" {
+				edited := *message
+				edited.GuildID = old.GuildID
+				message = &edited
+			}
+		}
+
+		if err := f.encoder.Encode(indexEntry{Message: message}); err != nil {
+			return fmt.Errorf("failed to index message %s: %w", message.ID, err)
+		}
+
+		f.entries++
+		f.putLocked(message)
+	}
+
+	return nil
+}
+
+func (f *FileIndex) RemoveMessage(messageID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.messages[messageID]; !ok {
+		return nil
+	}
+
+	if err := f.encoder.Encode(indexEntry{Removed: messageID}); err != nil {
+		return fmt.Errorf("failed to remove message %s from the search index: %w", messageID, err)
+	}
+
+	f.entries++
+	f.removeLocked(messageID)
+
+	return nil
+}
+
+func (f *FileIndex) Search(query *SearchQuery) ([]SearchHit, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	terms := query.matchTerms()
+	hits := make([]SearchHit, 0)
+
+	for _, id := range f.candidatesLocked(terms) {
+		message := f.messages[id]
+
+		score, ok := matchMessage(query, terms, &message)
+		if !ok {
+			continue
+		}
+
+		hits = append(hits, SearchHit{Message: message, Score: score})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+
+		return hits[i].Message.Timestamp.After(hits[j].Message.Timestamp)
+	})
+
+	if query.Offset >= len(hits) {
+		return []SearchHit{}, nil
+	}
+
+	hits = hits[query.Offset:]
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+
+	for i := range hits {
+		hits[i].Snippet = Snippet(hits[i].Message.Content, terms)
+	}
+
+	return hits, nil
+}
+
+// candidatesLocked returns the IDs of the messages containing every word of the terms,
+// or of every message when there are no terms, in a stable order.
+func (f *FileIndex) candidatesLocked(terms []string) []string {
+	var words []string
+	for _, term := range terms {
+		words = append(words, SearchTerms(term)...)
+	}
+
+	if len(words) == 0 {
+		return f.sortedIDsLocked()
+	}
+
+	// Intersecting from the rarest word keeps the candidate set small.
+	sort.Slice(words, func(i, j int) bool {
+		return len(f.postings[words[i]]) < len(f.postings[words[j]])
+	})
+
+	ids := make([]string, 0, len(f.postings[words[0]]))
+
+	for id := range f.postings[words[0]] {
+		found := true
+
+		for _, word := range words[1:] {
+			if _, ok := f.postings[word][id]; !ok {
+				found = false
+				break
+			}
+		}
+
+		if found {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (f *FileIndex) sortedIDsLocked() []string {
+	ids := make([]string, 0, len(f.messages))
+	for id := range f.messages {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// putLocked stores a message, replacing an earlier version of it and its words.
+func (f *FileIndex) putLocked(message *discordgo.Message) {
+	f.removeLocked(message.ID)
+
+	f.messages[message.ID] = *message
+
+	for _, word := range SearchTerms(message.Content) {
+		ids, ok := f.postings[word]
+		if !ok {
+			ids = make(map[string]struct{})
+			f.postings[word] = ids
+		}
+
+		ids[message.ID] = struct{}{}
+	}
+}
+
+func (f *FileIndex) removeLocked(messageID string) {
+	message, ok := f.messages[messageID]
+	if !ok {
+		return
+	}
+
+	delete(f.messages, messageID)
+
+	for _, word := range SearchTerms(message.Content) {
+		delete(f.postings[word], messageID)
+
+		if len(f.postings[word]) == 0 {
+			delete(f.postings, word)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestFileIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.jsonl")
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	message := func(id, guildID, content string, minutes int) *discordgo.Message {
+		return &discordgo.Message{
+			ID: id, GuildID: guildID, ChannelID: guildID + "0", Author: &discordgo.User{ID: "42", Username: "carol"},
+			Content: content, Timestamp: sent.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+
+	index, err := OpenFileIndex(path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+
+	err = index.IndexMessages([]*discordgo.Message{
+		message("1", "1", "the deploy failed", 1),
+		message("2", "1", "deploy deploy, failed", 2),
+		message("3", "1", "unrelated", 3),
+		message("4", "2", "deploy failed elsewhere", 4),
+		message("5", "1", "deploy is done", 5),
+	})
+	if err != nil {
+		t.Fatalf("failed to index: %v", err)
+	}
+
+	search := func(index *FileIndex, query SearchQuery) []string {
+		t.Helper()
+
+		query.Limit = 10
+
+		hits, err := index.Search(&query)
+		if err != nil {
+			t.Fatalf("failed to search: %v", err)
+		}
+
+		ids := make([]string, 0, len(hits))
+		for _, hit := range hits {
+			ids = append(ids, hit.Message.ID)
+		}
+
+		return ids
+	}
+
+	for _, test := range []struct {
+		query SearchQuery
+		want  []string
+	}{
+		{SearchQuery{Text: "Deploy failed"}, []string{"2", "4", "1"}},
+		{SearchQuery{Text: "deploy failed", GuildID: "1"}, []string{"2", "1"}},
+		{SearchQuery{Text: "deploy", Guilds: []string{"2"}}, []string{"4"}},
+		{SearchQuery{Phrases: []string{"failed elsewhere"}}, []string{"4"}},
+		{SearchQuery{Text: "missing deploy"}, []string{}},
+		{SearchQuery{GuildID: "1", Before: sent.Add(3 * time.Minute)}, []string{"2", "1"}},
+	} {
+		if got := search(index, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("search %+v = %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	hits, _ := index.Search(&SearchQuery{Text: "the", Limit: 1})
+	if len(hits) != 1 || hits[0].Snippet != "<mark>the</mark> deploy failed" || hits[0].Message.Author.Username != "carol" {
+		t.Fatalf("hits = %+v, want message 1 with a snippet and its author", hits)
+	}
+
+	// An edit replaces the words of a message and keeps its guild when it leaves it out.
+	edit := message("1", "", "the deploy succeeded", 1)
+	if err := index.IndexMessages([]*discordgo.Message{edit}); err != nil {
+		t.Fatalf("failed to index the edit: %v", err)
+	}
+
+	if err := index.RemoveMessage("2"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+
+	if got := search(index, SearchQuery{Text: "failed"}); !reflect.DeepEqual(got, []string{"4"}) {
+		t.Errorf("after the edit and the removal, failed = %v, want [4]", got)
+	}
+
+	if got := search(index, SearchQuery{Text: "succeeded", GuildID: "1"}); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("after the edit, succeeded = %v, want [1]", got)
+	}
+
+	if err := index.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// A crash may cut the last line short; reopening drops it and keeps the rest.
+	journal, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open the journal: %v", err)
+	}
+
+	if _, err := journal.WriteString(`{"message":{"id":"6","content":"deploy`); err != nil {
+		t.Fatalf("failed to write to the journal: %v", err)
+	}
+
+	journal.Close()
+
+	reopened, err := OpenFileIndex(path)
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	if got := search(reopened, SearchQuery{Text: "deploy"}); !reflect.DeepEqual(got, []string{"5", "4", "1"}) {
+		t.Errorf("after reopening, deploy = %v, want [5 4 1]", got)
+	}
+
+	if err := reopened.IndexMessages([]*discordgo.Message{message("6", "1", "deploy again", 6)}); err != nil {
+		t.Fatalf("failed to index after reopening: %v", err)
+	}
+
+	if got := search(reopened, SearchQuery{Text: "again"}); !reflect.DeepEqual(got, []string{"6"}) {
+		t.Errorf("after reopening, again = %v, want [6]", got)
+	}
+}
@@ -0,0 +1,18 @@
+package store
+
+import "time"
+
+// InteractionStates keeps the state of message components, so they keep working across
+// restarts.
+type InteractionStates interface {
+	// SaveInteractionState stores the state of a component routed to route. Zero
+	// expiresAt keeps it until it is deleted.
+	SaveInteractionState(id, route string, data []byte, expiresAt time.Time) error
+	// InteractionState returns the state stored under id, or ErrNotFound if there is
+	// none or it expired.
+	InteractionState(id string) ([]byte, error)
+	// DeleteInteractionState removes the state stored under id.
+	DeleteInteractionState(id string) error
+	// DeleteExpiredInteractionState removes the state that expired.
+	DeleteExpiredInteractionState() error
+}
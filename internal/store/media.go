@@ -0,0 +1,33 @@
+package store
+
+import "time"
+
+// MediaObject is a file mirrored from Discord's CDN, stored by the SHA-256 of its content.
+type MediaObject struct {
+	CreatedAt   time.Time `json:"created_at"`
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+}
+
+// MediaIndex tracks which attachments, avatars and emoji are mirrored, and as which
+// object. Sources are named like attachment/ID, avatar/USER_ID/AVATAR and emoji/ID.
+type MediaIndex interface {
+	// MirroredMedia returns the objects mirrored from sources, by source. Sources that
+	// aren't mirrored are left out.
+	MirroredMedia(sources []string) (map[string]MediaObject, error)
+	// SaveMedia records an object, unless it is known, and that source was mirrored as it
+	// for a message of a guild, or of a DM when guildID is empty. Sources of a messageID
+	// are forgotten when the message is pruned or deleted.
+	SaveMedia(source, guildID, messageID string, object *MediaObject) error
+	// MediaSource returns the object mirrored from a source and the guild it was
+	// mirrored for, or ErrNotFound.
+	MediaSource(source string) (*MediaObject, string, error)
+	// MediaObject returns a mirrored object by hash, or ErrNotFound.
+	MediaObject(hash string) (*MediaObject, error)
+	// UnreferencedMedia returns the hashes of the objects no source refers to anymore.
+	UnreferencedMedia() ([]string, error)
+	// DeleteMediaObject forgets an object unless a source refers to it again, and
+	// reports whether it did.
+	DeleteMediaObject(hash string) (bool, error)
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Member is a stored guild member with its user and the roles it holds.
+type Member struct {
+	JoinedAt     *time.Time `json:"joined_at"`
+	PremiumSince *time.Time `json:"premium_since"`
+	UserID       string     `json:"user_id"`
+	Username     string     `json:"username"`
+	Avatar       string     `json:"avatar"`
+	Nick         string     `json:"nick"`
+	GuildAvatar  string     `json:"guild_avatar"`
+	Roles        []string   `json:"roles"`
+	// Color is the color of the highest role that has one, or 0.
+	Color   int  `json:"color"`
+	Bot     bool `json:"bot"`
+	Pending bool `json:"pending"`
+}
+
+// Members stores the members of guilds, their roles and samples of their presences.
+type Members interface {
+	// SaveUser stores a user, such as the recipient of a DM channel.
+	SaveUser(user *discordgo.User) error
+	// SaveMember stores a guild member with its user and replaces the roles it holds.
+	// Members that aren't saved again during a sync are removed by DeleteStaleMembers.
+	SaveMember(member *discordgo.Member) error
+	// DeleteMember removes a member that left a guild, with its roles.
+	DeleteMember(guildID, userID string) error
+	// DeleteStaleMembers removes the members of a guild, with their roles, that weren't
+	// saved by SaveMember since syncedAt.
+	DeleteStaleMembers(guildID string, syncedAt time.Time) error
+	// Members returns up to limit members of a guild with user IDs after afterID,
+	// ordered by user ID.
+	Members(guildID, afterID string, limit int) ([]Member, error)
+	// SaveRole stores a role of a guild.
+	SaveRole(guildID string, role *discordgo.Role) error
+	// ReplaceRoles replaces the stored roles of a guild with the given ones.
+	ReplaceRoles(guildID string, roles []*discordgo.Role) error
+	// DeleteRole removes a role and takes it from the members holding it.
+	DeleteRole(roleID string) error
+	// Roles returns the roles of a guild, highest first.
+	Roles(guildID string) ([]discordgo.Role, error)
+	// SavePresenceSample records the status and first activity of a member at sampledAt.
+	SavePresenceSample(guildID string, presence *discordgo.Presence, sampledAt time.Time) error
+}
@@ -0,0 +1,1815 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Memory keeps everything in process memory. Messages are copied on the way in and out,
+// so callers can't change stored ones.
+type Memory struct {
+	guilds   map[string]discordgo.Guild
+	channels map[string]discordgo.Channel
+	authors  map[string]discordgo.User
+	members  map[memberKey]discordgo.Member
+	messages map[string][]discordgo.Message
+	rules    map[ruleKey]RetentionRule
+	pruned   map[string]PrunedHistory
+	archived map[string][]ArchivedPartition
+	media    map[string]MediaObject
+	sources  map[string]mediaSource
+
+	// synced is when members were last saved by SaveMember.
+	synced        map[memberKey]time.Time
+	roles         map[string]guildRole
+	presences     map[presenceKey]discordgo.Presence
+	threadMembers map[threadMemberKey]time.Time
+	backfilled    map[string]time.Time
+	forumTags     map[string]forumTag
+	reactions     []reaction
+	voice         []voiceSession
+	states        map[string]interactionState
+	mu            sync.RWMutex
+}
+
+type ruleKey struct {
+	guildID   string
+	channelID string
+}
+
+type mediaSource struct {
+	hash      string
+	guildID   string
+	messageID string
+}
+
+type memberKey struct {
+	guildID string
+	userID  string
+}
+
+type guildRole struct {
+	guildID string
+	role    discordgo.Role
+}
+
+type presenceKey struct {
+	guildID   string
+	userID    string
+	sampledAt time.Time
+}
+
+type threadMemberKey struct {
+	threadID string
+	userID   string
+}
+
+type forumTag struct {
+	channelID string
+	tag       discordgo.ForumTag
+}
+
+type reaction struct {
+	createdAt time.Time
+	messageID string
+	userID    string
+	emoji     string
+	emojiID   string
+	emojiName string
+	channelID string
+	guildID   string
+}
+
+type voiceSession struct {
+	state     discordgo.VoiceState
+	joinedAt  time.Time
+	startedAt time.Time
+	// endedAt is zero while the session is open.
+	endedAt time.Time
+}
+
+type interactionState struct {
+	expiresAt time.Time
+	route     string
+	data      []byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		guilds:   make(map[string]discordgo.Guild),
+		channels: make(map[string]discordgo.Channel),
+		authors:  make(map[string]discordgo.User),
+		members:  make(map[memberKey]discordgo.Member),
+		messages: make(map[string][]discordgo.Message),
+		rules:    make(map[ruleKey]RetentionRule),
+		pruned:   make(map[string]PrunedHistory),
+		archived: make(map[string][]ArchivedPartition),
+		media:    make(map[string]MediaObject),
+		sources:  make(map[string]mediaSource),
+
+		synced:        make(map[memberKey]time.Time),
+		roles:         make(map[string]guildRole),
+		presences:     make(map[presenceKey]discordgo.Presence),
+		threadMembers: make(map[threadMemberKey]time.Time),
+		backfilled:    make(map[string]time.Time),
+		forumTags:     make(map[string]forumTag),
+		states:        make(map[string]interactionState),
+	}
+}
+
+func (m *Memory) SaveGuild(guild *discordgo.Guild) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.guilds[guild.ID] = discordgo.Guild{
+		ID: guild.ID, Name: guild.Name, Icon: guild.Icon, Region: guild.Region, OwnerID: guild.OwnerID,
+	}
+
+	return nil
+}
+
+func (m *Memory) SaveChannel(guildID string, channel *discordgo.Channel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *channel
+	stored.GuildID = guildID
+	stored.Messages = nil
+	stored.PermissionOverwrites = nil
+
+	for _, overwrite := range channel.PermissionOverwrites {
+		copied := *overwrite
+		stored.PermissionOverwrites = append(stored.PermissionOverwrites, &copied)
+	}
+
+	m.channels[channel.ID] = stored
+
+	return nil
+}
+
+func (m *Memory) SaveMessages(messages []*discordgo.MessageCreate) (*SaveResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := &SaveResult{Failed: make(map[string]error)}
+
+	for _, message := range messages {
+		if message == nil || message.Author == nil {
+			continue
+		}
+
+		// Authors are only inserted once, like the MySQL store does.
+		if _, ok := m.authors[message.Author.ID]; !ok {
+			m.authors[message.Author.ID] = *message.Author
+		}
+
+		stored := *message.Message
+		stored.Author = &discordgo.User{ID: message.Author.ID}
+		stored.Member = nil
+
+		if message.GuildID != "" && message.Member != nil {
+			key := memberKey{guildID: message.GuildID, userID: message.Author.ID}
+			member := m.members[key]
+
+			if message.Member.Nick != "" {
+				member.Nick = message.Member.Nick
+			}
+
+			if message.Member.Avatar != "" {
+				member.Avatar = message.Member.Avatar
+			}
+
+			m.members[key] = member
+			stored.Member = &discordgo.Member{GuildID: message.GuildID}
+		}
+
+		if m.update(&stored) {
+			result.Duplicates++
+			continue
+		}
+
+		m.messages[message.ChannelID] = append(m.messages[message.ChannelID], stored)
+		result.Inserted++
+	}
+
+	return result, nil
+}
+
+// update applies a message to its stored version, unless that is as new or newer, like
+// the MySQL store does. It reports whether the message was stored. The caller holds mu.
+func (m *Memory) update(message *discordgo.Message) bool {
+	channel := m.messages[message.ChannelID]
+
+	for i := range channel {
+		stored := &channel[i]
+		if stored.ID != message.ID {
+			continue
+		}
+
+		if !version(message).After(version(stored)) {
+			return true
+		}
+
+		stored.Pinned, stored.Content, stored.EditedTimestamp = message.Pinned, message.Content, message.EditedTimestamp
+		stored.Attachments, stored.Embeds = message.Attachments, message.Embeds
+
+		return true
+	}
+
+	return false
+}
+
+// version is when a message last changed.
+func version(message *discordgo.Message) time.Time {
+	if message.EditedTimestamp != nil {
+		return *message.EditedTimestamp
+	}
+
+	return message.Timestamp
+}
+
+func (m *Memory) Guild(guildID string) (*discordgo.Guild, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	guild, ok := m.guilds[guildID]
+	if !ok {
+		return nil, fmt.Errorf("%w: guild %s", ErrNotFound, guildID)
+	}
+
+	return &guild, nil
+}
+
+func (m *Memory) ChannelGuild(channelID string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channel, ok := m.channels[channelID]
+	if !ok {
+		return "", fmt.Errorf("%w: channel %s", ErrNotFound, channelID)
+	}
+
+	return channel.GuildID, nil
+}
+
+func (m *Memory) Channel(channelID string) (*discordgo.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channel, ok := m.channels[channelID]
+	if !ok {
+		return nil, fmt.Errorf("%w: channel %s", ErrNotFound, channelID)
+	}
+
+	return &channel, nil
+}
+
+func (m *Memory) Guilds() ([]discordgo.Guild, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	guilds := make([]discordgo.Guild, 0, len(m.guilds))
+	for _, guild := range m.guilds {
+		guilds = append(guilds, guild)
+	}
+
+	sort.Slice(guilds, func(i, j int) bool {
+		if guilds[i].Name != guilds[j].Name {
+			return guilds[i].Name < guilds[j].Name
+		}
+
+		return guilds[i].ID < guilds[j].ID
+	})
+
+	return guilds, nil
+}
+
+func (m *Memory) GuildChannels(guildID string) ([]discordgo.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channels := make([]discordgo.Channel, 0)
+
+	for _, channel := range m.channels {
+		if channel.GuildID == guildID && !channel.IsThread() {
+			channels = append(channels, channel)
+		}
+	}
+
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Position != channels[j].Position {
+			return channels[i].Position < channels[j].Position
+		}
+
+		return channels[i].ID < channels[j].ID
+	})
+
+	return channels, nil
+}
+
+func (m *Memory) Message(messageID string) (*discordgo.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, messages := range m.messages {
+		for _, message := range messages {
+			if message.ID == messageID {
+				joined := m.joined(message)
+				return &joined, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: message %s", ErrNotFound, messageID)
+}
+
+func (m *Memory) User(userID string) (*discordgo.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	author, ok := m.authors[userID]
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", ErrNotFound, userID)
+	}
+
+	return &discordgo.User{
+		ID: author.ID, Username: author.Username, Avatar: author.Avatar, Bot: author.Bot, System: author.System,
+	}, nil
+}
+
+func (m *Memory) UserGuilds(userID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	guilds := make([]string, 0)
+
+	for key := range m.members {
+		if key.userID == userID {
+			guilds = append(guilds, key.guildID)
+		}
+	}
+
+	sort.Strings(guilds)
+
+	return guilds, nil
+}
+
+func (m *Memory) KnownMessages(ids []string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	known := make(map[string]bool)
+
+	for _, messages := range m.messages {
+		for _, message := range messages {
+			if wanted[message.ID] {
+				known[message.ID] = true
+			}
+		}
+	}
+
+	return known, nil
+}
+
+func (m *Memory) EachMessage(filter *HistoryFilter, fn func(message *discordgo.Message) error) error {
+	m.mu.RLock()
+
+	matched := make([]discordgo.Message, 0)
+
+	for channelID, messages := range m.messages {
+		if filter.ChannelID != "" && channelID != filter.ChannelID {
+			continue
+		}
+
+		for _, message := range messages {
+			if (filter.GuildID != "" && message.GuildID != filter.GuildID) ||
+				(!filter.After.IsZero() && message.Timestamp.Before(filter.After)) ||
+				(!filter.Before.IsZero() && !message.Timestamp.Before(filter.Before)) {
+				continue
+			}
+
+			matched = append(matched, m.joined(message))
+		}
+	}
+
+	m.mu.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].Timestamp.Equal(matched[j].Timestamp) {
+			return matched[i].Timestamp.Before(matched[j].Timestamp)
+		}
+
+		return matched[i].ID < matched[j].ID
+	})
+
+	for i := range matched {
+		if err := fn(&matched[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) ChannelMessages(channelID string, limit, offset int) ([]discordgo.Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored := append([]discordgo.Message(nil), m.messages[channelID]...)
+
+	sort.SliceStable(stored, func(i, j int) bool {
+		return stored[i].Timestamp.After(stored[j].Timestamp)
+	})
+
+	if offset >= len(stored) {
+		return []discordgo.Message{}, nil
+	}
+
+	stored = stored[offset:]
+	if len(stored) > limit {
+		stored = stored[:limit]
+	}
+
+	messages := make([]discordgo.Message, 0, len(stored))
+
+	for _, message := range stored {
+		messages = append(messages, m.joined(message))
+	}
+
+	return messages, nil
+}
+
+// Search scores messages by how often the words and phrases occur in them. Every word
+// and phrase has to occur.
+func (m *Memory) Search(query *SearchQuery) ([]SearchHit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	terms := query.matchTerms()
+	hits := make([]SearchHit, 0)
+
+	for _, messages := range m.messages {
+		for _, message := range messages {
+			score, ok := matchMessage(query, terms, &message)
+			if !ok {
+				continue
+			}
+
+			hits = append(hits, SearchHit{Message: message, Score: score})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+
+		return hits[i].Message.Timestamp.After(hits[j].Message.Timestamp)
+	})
+
+	if query.Offset >= len(hits) {
+		return []SearchHit{}, nil
+	}
+
+	hits = hits[query.Offset:]
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+
+	for i := range hits {
+		hits[i].Message = m.joined(hits[i].Message)
+		hits[i].Snippet = Snippet(hits[i].Message.Content, terms)
+	}
+
+	return hits, nil
+}
+
+// matchMessage applies the filters of a query to a message and scores it.
+func matchMessage(query *SearchQuery, terms []string, message *discordgo.Message) (float64, bool) {
+	if len(query.Guilds) > 0 && !contains(query.Guilds, message.GuildID) {
+		return 0, false
+	}
+
+	if (query.GuildID != "" && message.GuildID != query.GuildID) ||
+		(query.ChannelID != "" && message.ChannelID != query.ChannelID) ||
+		(query.AuthorID != "" && message.Author.ID != query.AuthorID) {
+		return 0, false
+	}
+
+	if query.Mentions != "" && !mentions(message, query.Mentions) {
+		return 0, false
+	}
+
+	if (!query.After.IsZero() && message.Timestamp.Before(query.After)) ||
+		(!query.Before.IsZero() && !message.Timestamp.Before(query.Before)) {
+		return 0, false
+	}
+
+	content := strings.ToLower(message.Content)
+
+	if (query.HasAttachment && len(message.Attachments) == 0) ||
+		(query.HasLink && !strings.Contains(content, "http://") && !strings.Contains(content, "https://")) ||
+		(query.Pinned != nil && message.Pinned != *query.Pinned) {
+		return 0, false
+	}
+
+	var score float64
+
+	// Terms match whole words, like a full-text index does.
+	words := SearchTerms(content)
+
+	for _, term := range terms {
+		count := countWords(words, strings.Fields(term))
+		if count == 0 {
+			return 0, false
+		}
+
+		score += float64(count)
+	}
+
+	return score, true
+}
+
+// countWords counts where a sequence of words occurs in words.
+func countWords(words, sequence []string) int {
+	count := 0
+
+	for i := 0; i+len(sequence) <= len(words); i++ {
+		match := true
+
+		for j, word := range sequence {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			count++
+		}
+	}
+
+	return count
+}
+
+func mentions(message *discordgo.Message, userID string) bool {
+	for _, user := range message.Mentions {
+		if user.ID == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joined fills in the author and the member of a stored message.
+func (m *Memory) joined(message discordgo.Message) discordgo.Message {
+	author := m.authors[message.Author.ID]
+	message.Author = &discordgo.User{
+		ID: author.ID, Username: author.Username, Avatar: author.Avatar, Bot: author.Bot,
+	}
+
+	if message.Member != nil {
+		member := m.members[memberKey{guildID: message.GuildID, userID: author.ID}]
+		message.Member = &discordgo.Member{Nick: member.Nick, Avatar: member.Avatar}
+	}
+
+	return message
+}
+
+func (m *Memory) RetentionRules() ([]RetentionRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rules := make([]RetentionRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].GuildID != rules[j].GuildID {
+			return rules[i].GuildID < rules[j].GuildID
+		}
+
+		return rules[i].ChannelID < rules[j].ChannelID
+	})
+
+	return rules, nil
+}
+
+func (m *Memory) SaveRetentionRule(rule *RetentionRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rules[ruleKey{guildID: rule.GuildID, channelID: rule.ChannelID}] = *rule
+
+	return nil
+}
+
+func (m *Memory) DeleteRetentionRule(guildID, channelID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := ruleKey{guildID: guildID, channelID: channelID}
+	if _, ok := m.rules[key]; !ok {
+		return fmt.Errorf("%w: retention rule", ErrNotFound)
+	}
+
+	delete(m.rules, key)
+
+	return nil
+}
+
+func (m *Memory) StoredChannels(guildID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channels := make([]string, 0)
+
+	for channelID, messages := range m.messages {
+		if len(messages) > 0 && messages[0].GuildID == guildID {
+			channels = append(channels, channelID)
+		}
+	}
+
+	sort.Strings(channels)
+
+	return channels, nil
+}
+
+func (m *Memory) PruneMessages(channelID string, before time.Time, keep, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := m.messages[channelID]
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].Timestamp.Equal(messages[j].Timestamp) {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		}
+
+		return messages[i].ID < messages[j].ID
+	})
+
+	if keep > 0 && len(messages)-keep < limit {
+		limit = len(messages) - keep
+	}
+
+	pruned := 0
+	for pruned < limit && pruned < len(messages) && (before.IsZero() || messages[pruned].Timestamp.Before(before)) {
+		pruned++
+	}
+
+	if pruned <= 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, pruned)
+	prunedIDs := make(map[string]bool, pruned)
+
+	for _, message := range messages[:pruned] {
+		ids = append(ids, message.ID)
+		prunedIDs[message.ID] = true
+	}
+
+	m.deleteMediaSourcesLocked(prunedIDs)
+	m.deleteReactionsLocked(prunedIDs)
+
+	history := m.pruned[channelID]
+	history.ChannelID, history.GuildID, history.PrunedAt = channelID, messages[0].GuildID, time.Now().UTC()
+	history.Messages += pruned
+
+	if newest := messages[pruned-1].Timestamp; newest.After(history.Before) {
+		history.Before = newest
+	}
+
+	m.pruned[channelID] = history
+	m.messages[channelID] = append([]discordgo.Message(nil), messages[pruned:]...)
+
+	return ids, nil
+}
+
+func (m *Memory) PrunedHistory(channelID string) (*PrunedHistory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history, ok := m.pruned[channelID]
+	if !ok {
+		return nil, fmt.Errorf("%w: pruned history of channel %s", ErrNotFound, channelID)
+	}
+
+	return &history, nil
+}
+
+func (m *Memory) ArchivableChannels(before time.Time) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channels := make([]string, 0)
+
+	for channelID, messages := range m.messages {
+		for _, message := range messages {
+			if message.Timestamp.Before(before) {
+				channels = append(channels, channelID)
+				break
+			}
+		}
+	}
+
+	sort.Strings(channels)
+
+	return channels, nil
+}
+
+func (m *Memory) ArchivedPartitions(channelID string) ([]ArchivedPartition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	partitions := append([]ArchivedPartition{}, m.archived[channelID]...)
+
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Month > partitions[j].Month })
+
+	return partitions, nil
+}
+
+func (m *Memory) ArchivePartition(partition *ArchivedPartition, messageIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partitions := m.archived[partition.ChannelID]
+	replaced := false
+
+	for i := range partitions {
+		if partitions[i].Month == partition.Month {
+			partitions[i], replaced = *partition, true
+		}
+	}
+
+	if !replaced {
+		partitions = append(partitions, *partition)
+	}
+
+	m.archived[partition.ChannelID] = partitions
+
+	archived := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		archived[id] = true
+	}
+
+	kept := make([]discordgo.Message, 0, len(m.messages[partition.ChannelID]))
+
+	for _, message := range m.messages[partition.ChannelID] {
+		if !archived[message.ID] {
+			kept = append(kept, message)
+		}
+	}
+
+	m.messages[partition.ChannelID] = kept
+
+	return nil
+}
+
+func (m *Memory) PruneArchivedPartition(partition *ArchivedPartition, messageIDs []string, newest time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partitions := make([]ArchivedPartition, 0, len(m.archived[partition.ChannelID]))
+
+	for _, archived := range m.archived[partition.ChannelID] {
+		if archived.Month != partition.Month {
+			partitions = append(partitions, archived)
+		}
+	}
+
+	if partition.Messages > 0 {
+		partitions = append(partitions, *partition)
+	}
+
+	m.archived[partition.ChannelID] = partitions
+
+	history := m.pruned[partition.ChannelID]
+	history.ChannelID, history.GuildID, history.PrunedAt = partition.ChannelID, partition.GuildID, time.Now().UTC()
+	history.Messages += len(messageIDs)
+
+	if newest.After(history.Before) {
+		history.Before = newest
+	}
+
+	m.pruned[partition.ChannelID] = history
+
+	pruned := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		pruned[id] = true
+	}
+
+	m.deleteReactionsLocked(pruned)
+	m.deleteMediaSourcesLocked(pruned)
+
+	return nil
+}
+
+func (m *Memory) ChannelMessageCount(channelID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.messages[channelID]), nil
+}
+
+func (m *Memory) MirroredMedia(sources []string) (map[string]MediaObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mirrored := make(map[string]MediaObject)
+
+	for _, source := range sources {
+		if mirroredAs, ok := m.sources[source]; ok {
+			mirrored[source] = m.media[mirroredAs.hash]
+		}
+	}
+
+	return mirrored, nil
+}
+
+func (m *Memory) SaveMedia(source, guildID, messageID string, object *MediaObject) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.media[object.Hash]; !ok {
+		m.media[object.Hash] = *object
+	}
+
+	if existing, ok := m.sources[source]; ok {
+		guildID, messageID = existing.guildID, existing.messageID
+	}
+
+	m.sources[source] = mediaSource{hash: object.Hash, guildID: guildID, messageID: messageID}
+
+	return nil
+}
+
+func (m *Memory) MediaSource(source string) (*MediaObject, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mirroredAs, ok := m.sources[source]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: media source %s", ErrNotFound, source)
+	}
+
+	object := m.media[mirroredAs.hash]
+
+	return &object, mirroredAs.guildID, nil
+}
+
+func (m *Memory) UnreferencedMedia() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	referenced := make(map[string]bool, len(m.sources))
+	for _, mirroredAs := range m.sources {
+		referenced[mirroredAs.hash] = true
+	}
+
+	hashes := make([]string, 0)
+
+	for hash := range m.media {
+		if !referenced[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	sort.Strings(hashes)
+
+	return hashes, nil
+}
+
+func (m *Memory) DeleteMediaObject(hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.media[hash]; !ok {
+		return false, nil
+	}
+
+	for _, mirroredAs := range m.sources {
+		if mirroredAs.hash == hash {
+			return false, nil
+		}
+	}
+
+	delete(m.media, hash)
+
+	return true, nil
+}
+
+// deleteMediaSourcesLocked forgets the sources mirrored for the given messages.
+func (m *Memory) deleteMediaSourcesLocked(messageIDs map[string]bool) {
+	for source, mirroredAs := range m.sources {
+		if mirroredAs.messageID != "" && messageIDs[mirroredAs.messageID] {
+			delete(m.sources, source)
+		}
+	}
+}
+
+func (m *Memory) MediaObject(hash string) (*MediaObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	object, ok := m.media[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: media object %s", ErrNotFound, hash)
+	}
+
+	return &object, nil
+}
+
+func (m *Memory) DeleteGuild(guildID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.guilds, guildID)
+
+	for id, channel := range m.channels {
+		if channel.GuildID == guildID {
+			delete(m.channels, id)
+		}
+	}
+
+	for key := range m.members {
+		if key.guildID == guildID {
+			delete(m.members, key)
+			delete(m.synced, key)
+		}
+	}
+
+	for id, role := range m.roles {
+		if role.guildID == guildID {
+			delete(m.roles, id)
+		}
+	}
+
+	m.closeVoiceSessionsLocked(guildID, "", time.Now().UTC())
+
+	return nil
+}
+
+func (m *Memory) DeleteChannel(channelID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.channels, channelID)
+
+	return nil
+}
+
+func (m *Memory) UpdateMessage(message *discordgo.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, messages := range m.messages {
+		for i := range messages {
+			if messages[i].ID == message.ID {
+				messages[i].Content, messages[i].Pinned = message.Content, message.Pinned
+				messages[i].EditedTimestamp = message.EditedTimestamp
+
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) DeleteMessage(messageID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for channelID, messages := range m.messages {
+		for i := range messages {
+			if messages[i].ID == messageID {
+				m.messages[channelID] = append(messages[:i:i], messages[i+1:]...)
+				break
+			}
+		}
+	}
+
+	deleted := map[string]bool{messageID: true}
+	m.deleteReactionsLocked(deleted)
+	m.deleteMediaSourcesLocked(deleted)
+
+	return nil
+}
+
+func (m *Memory) SaveUser(user *discordgo.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveUserLocked(user)
+
+	return nil
+}
+
+// saveUserLocked updates the name, avatar and bot flag of a stored user, like the MySQL
+// store does.
+func (m *Memory) saveUserLocked(user *discordgo.User) {
+	stored, ok := m.authors[user.ID]
+	if !ok {
+		stored = discordgo.User{ID: user.ID, System: user.System}
+	}
+
+	stored.Username, stored.Avatar, stored.Bot = user.Username, user.Avatar, user.Bot
+	m.authors[user.ID] = stored
+}
+
+func (m *Memory) SaveMember(member *discordgo.Member) error {
+	if member.User == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveUserLocked(member.User)
+
+	key := memberKey{guildID: member.GuildID, userID: member.User.ID}
+	m.members[key] = discordgo.Member{
+		GuildID:      member.GuildID,
+		JoinedAt:     member.JoinedAt,
+		Nick:         member.Nick,
+		Avatar:       member.Avatar,
+		Roles:        append([]string(nil), member.Roles...),
+		PremiumSince: member.PremiumSince,
+		Pending:      member.Pending,
+	}
+	m.synced[key] = time.Now().UTC()
+
+	return nil
+}
+
+func (m *Memory) DeleteMember(guildID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memberKey{guildID: guildID, userID: userID}
+	delete(m.members, key)
+	delete(m.synced, key)
+
+	return nil
+}
+
+func (m *Memory) DeleteStaleMembers(guildID string, syncedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.members {
+		if key.guildID == guildID && m.synced[key].Before(syncedAt) {
+			delete(m.members, key)
+			delete(m.synced, key)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Members(guildID, afterID string, limit int) ([]Member, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	members := make([]Member, 0)
+
+	for key, stored := range m.members {
+		if key.guildID != guildID || key.userID <= afterID {
+			continue
+		}
+
+		author := m.authors[key.userID]
+		member := Member{
+			PremiumSince: stored.PremiumSince,
+			UserID:       key.userID,
+			Username:     author.Username,
+			Avatar:       author.Avatar,
+			Nick:         stored.Nick,
+			GuildAvatar:  stored.Avatar,
+			Roles:        append([]string{}, stored.Roles...),
+			Bot:          author.Bot,
+			Pending:      stored.Pending,
+		}
+
+		if !stored.JoinedAt.IsZero() {
+			joinedAt := stored.JoinedAt
+			member.JoinedAt = &joinedAt
+		}
+
+		// The color of a member is the one of its highest colored role.
+		position := 0
+
+		for _, roleID := range stored.Roles {
+			role, ok := m.roles[roleID]
+			if ok && role.guildID == guildID && role.role.Color != 0 && (member.Color == 0 || role.role.Position > position) {
+				member.Color, position = role.role.Color, role.role.Position
+			}
+		}
+
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+
+	if len(members) > limit {
+		members = members[:limit]
+	}
+
+	return members, nil
+}
+
+func (m *Memory) SaveRole(guildID string, role *discordgo.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roles[role.ID] = guildRole{guildID: guildID, role: *role}
+
+	return nil
+}
+
+func (m *Memory) ReplaceRoles(guildID string, roles []*discordgo.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, role := range m.roles {
+		if role.guildID == guildID {
+			delete(m.roles, id)
+		}
+	}
+
+	for _, role := range roles {
+		m.roles[role.ID] = guildRole{guildID: guildID, role: *role}
+	}
+
+	return nil
+}
+
+func (m *Memory) DeleteRole(roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.roles, roleID)
+
+	for key, member := range m.members {
+		for i, id := range member.Roles {
+			if id == roleID {
+				member.Roles = append(member.Roles[:i:i], member.Roles[i+1:]...)
+				m.members[key] = member
+
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Roles(guildID string) ([]discordgo.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles := make([]discordgo.Role, 0)
+
+	for _, role := range m.roles {
+		if role.guildID == guildID {
+			roles = append(roles, role.role)
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Position != roles[j].Position {
+			return roles[i].Position > roles[j].Position
+		}
+
+		return roles[i].ID < roles[j].ID
+	})
+
+	return roles, nil
+}
+
+func (m *Memory) SavePresenceSample(guildID string, presence *discordgo.Presence, sampledAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := presenceKey{guildID: guildID, userID: presence.User.ID, sampledAt: sampledAt}
+	if _, ok := m.presences[key]; !ok {
+		m.presences[key] = discordgo.Presence{
+			User: &discordgo.User{ID: presence.User.ID}, Status: presence.Status, Activities: presence.Activities,
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) SaveThread(thread *discordgo.Channel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := discordgo.Channel{
+		ID: thread.ID, GuildID: thread.GuildID, Name: thread.Name, NSFW: thread.NSFW, Type: thread.Type,
+		ParentID: thread.ParentID, OwnerID: thread.OwnerID, AppliedTags: append([]string(nil), thread.AppliedTags...),
+		Position: thread.Position,
+	}
+
+	if existing, ok := m.channels[thread.ID]; ok {
+		stored.Position = existing.Position
+	}
+
+	if thread.ThreadMetadata != nil {
+		metadata := *thread.ThreadMetadata
+		stored.ThreadMetadata = &metadata
+	}
+
+	m.channels[thread.ID] = stored
+
+	return nil
+}
+
+func (m *Memory) DeleteThread(threadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.channels, threadID)
+	delete(m.backfilled, threadID)
+
+	for key := range m.threadMembers {
+		if key.threadID == threadID {
+			delete(m.threadMembers, key)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) ArchiveThreads(guildID string, parentIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, channel := range m.channels {
+		if !channel.IsThread() || (channel.ThreadMetadata != nil && channel.ThreadMetadata.Archived) {
+			continue
+		}
+
+		if len(parentIDs) == 0 && channel.GuildID != guildID || len(parentIDs) > 0 && !contains(parentIDs, channel.ParentID) {
+			continue
+		}
+
+		metadata := discordgo.ThreadMetadata{}
+		if channel.ThreadMetadata != nil {
+			metadata = *channel.ThreadMetadata
+		}
+
+		metadata.Archived = true
+		channel.ThreadMetadata = &metadata
+		m.channels[id] = channel
+	}
+
+	return nil
+}
+
+func (m *Memory) SaveThreadMember(threadID, userID string, joinedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.threadMembers[threadMemberKey{threadID: threadID, userID: userID}] = joinedAt
+
+	return nil
+}
+
+func (m *Memory) DeleteThreadMember(threadID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.threadMembers, threadMemberKey{threadID: threadID, userID: userID})
+
+	return nil
+}
+
+func (m *Memory) SaveForumTags(channel *discordgo.Channel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tag := range channel.AvailableTags {
+		m.forumTags[tag.ID] = forumTag{channelID: channel.ID, tag: tag}
+	}
+
+	return nil
+}
+
+func (m *Memory) ThreadBackfilled(threadID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.channels[threadID]; !ok {
+		return false, fmt.Errorf("%w: thread %s", ErrNotFound, threadID)
+	}
+
+	_, backfilled := m.backfilled[threadID]
+
+	return backfilled, nil
+}
+
+func (m *Memory) MarkThreadBackfilled(threadID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.channels[threadID]; ok {
+		m.backfilled[threadID] = at
+	}
+
+	return nil
+}
+
+func (m *Memory) Threads(parentID string, archived *bool) ([]Thread, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	threads := make([]Thread, 0)
+
+	for _, channel := range m.channels {
+		if channel.ParentID != parentID || !channel.IsThread() {
+			continue
+		}
+
+		thread := Thread{
+			ID: channel.ID, GuildID: channel.GuildID, ParentID: channel.ParentID, OwnerID: channel.OwnerID,
+			Name: channel.Name, AppliedTags: append([]string{}, channel.AppliedTags...), Type: int(channel.Type),
+		}
+
+		if metadata := channel.ThreadMetadata; metadata != nil {
+			thread.Archived, thread.Locked = metadata.Archived, metadata.Locked
+
+			if !metadata.ArchiveTimestamp.IsZero() {
+				archivedAt := metadata.ArchiveTimestamp
+				thread.ArchiveTimestamp = &archivedAt
+			}
+		}
+
+		if archived == nil || thread.Archived == *archived {
+			threads = append(threads, thread)
+		}
+	}
+
+	// Threads that were never archived come first, like the MySQL store orders them.
+	sort.Slice(threads, func(i, j int) bool {
+		a, b := threads[i].ArchiveTimestamp, threads[j].ArchiveTimestamp
+		if (a == nil) != (b == nil) {
+			return a == nil
+		}
+
+		if a != nil && !a.Equal(*b) {
+			return a.After(*b)
+		}
+
+		return threads[i].ID > threads[j].ID
+	})
+
+	return threads, nil
+}
+
+func (m *Memory) SaveReaction(messageReaction *discordgo.MessageReaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	emoji := messageReaction.Emoji.APIName()
+
+	for _, stored := range m.reactions {
+		if stored.messageID == messageReaction.MessageID && stored.userID == messageReaction.UserID && stored.emoji == emoji {
+			return nil
+		}
+	}
+
+	m.reactions = append(m.reactions, reaction{
+		createdAt: time.Now().UTC(),
+		messageID: messageReaction.MessageID,
+		userID:    messageReaction.UserID,
+		emoji:     emoji,
+		emojiID:   messageReaction.Emoji.ID,
+		emojiName: messageReaction.Emoji.Name,
+		channelID: messageReaction.ChannelID,
+		guildID:   messageReaction.GuildID,
+	})
+
+	return nil
+}
+
+func (m *Memory) DeleteReaction(messageID, userID, emoji string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.filterReactionsLocked(func(stored *reaction) bool {
+		return stored.messageID == messageID && stored.userID == userID && stored.emoji == emoji
+	})
+
+	return nil
+}
+
+func (m *Memory) DeleteReactions(messageID, emoji string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.filterReactionsLocked(func(stored *reaction) bool {
+		return stored.messageID == messageID && (emoji == "" || stored.emoji == emoji)
+	})
+
+	return nil
+}
+
+// deleteReactionsLocked removes the reactions to the given messages.
+func (m *Memory) deleteReactionsLocked(messageIDs map[string]bool) {
+	m.filterReactionsLocked(func(stored *reaction) bool { return messageIDs[stored.messageID] })
+}
+
+// filterReactionsLocked removes the reactions matching deleted.
+func (m *Memory) filterReactionsLocked(deleted func(stored *reaction) bool) {
+	kept := m.reactions[:0]
+
+	for i := range m.reactions {
+		if !deleted(&m.reactions[i]) {
+			kept = append(kept, m.reactions[i])
+		}
+	}
+
+	m.reactions = kept
+}
+
+func (m *Memory) ReactionCounts(messageIDs []string, userID string) (map[string][]*discordgo.MessageReactions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	counts := make(map[string][]*discordgo.MessageReactions)
+
+	// Reactions are kept in the order they were added, so every emoji is counted in the
+	// order it was first used.
+	for _, stored := range m.reactions {
+		if !wanted[stored.messageID] {
+			continue
+		}
+
+		var counted *discordgo.MessageReactions
+
+		for _, count := range counts[stored.messageID] {
+			if count.Emoji.APIName() == stored.emoji && count.Emoji.ID == stored.emojiID && count.Emoji.Name == stored.emojiName {
+				counted = count
+				break
+			}
+		}
+
+		if counted == nil {
+			counted = &discordgo.MessageReactions{Emoji: &discordgo.Emoji{ID: stored.emojiID, Name: stored.emojiName}}
+			counts[stored.messageID] = append(counts[stored.messageID], counted)
+		}
+
+		counted.Count++
+		counted.Me = counted.Me || stored.userID == userID
+	}
+
+	return counts, nil
+}
+
+func (m *Memory) TopReacted(guildID, channelID string, limit int) ([]ReactedMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byID := make(map[string]*ReactedMessage)
+	messages := make([]ReactedMessage, 0)
+
+	for _, stored := range m.reactions {
+		if (guildID != "" && stored.guildID != guildID) || (guildID == "" && stored.channelID != channelID) {
+			continue
+		}
+
+		message, ok := byID[stored.messageID]
+		if !ok {
+			message = &ReactedMessage{MessageID: stored.messageID, ChannelID: stored.channelID}
+			byID[stored.messageID] = message
+
+			for _, candidate := range m.messages[stored.channelID] {
+				if candidate.ID == stored.messageID {
+					message.Content = candidate.Content
+					break
+				}
+			}
+		}
+
+		message.Total++
+	}
+
+	for _, message := range byID {
+		messages = append(messages, *message)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Total != messages[j].Total {
+			return messages[i].Total > messages[j].Total
+		}
+
+		return messages[i].MessageID < messages[j].MessageID
+	})
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+func (m *Memory) RecordVoiceState(state *discordgo.VoiceState, at time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var previous *voiceSession
+
+	for i := range m.voice {
+		session := &m.voice[i]
+		if session.state.GuildID == state.GuildID && session.state.UserID == state.UserID && session.endedAt.IsZero() {
+			previous = session
+		}
+	}
+
+	var previousChannelID string
+	joinedAt := at
+
+	if previous != nil {
+		previousChannelID = previous.state.ChannelID
+
+		if previousChannelID == state.ChannelID {
+			joinedAt = previous.joinedAt
+		}
+	}
+
+	m.closeVoiceSessionsLocked(state.GuildID, state.UserID, at)
+
+	if state.ChannelID != "" {
+		m.voice = append(m.voice, voiceSession{state: *state, joinedAt: joinedAt, startedAt: at})
+	}
+
+	return previousChannelID, nil
+}
+
+func (m *Memory) CloseVoiceSessions(guildID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closeVoiceSessionsLocked(guildID, "", at)
+
+	return nil
+}
+
+// closeVoiceSessionsLocked ends the open sessions of a guild, or only those of a user
+// unless userID is empty.
+func (m *Memory) closeVoiceSessionsLocked(guildID, userID string, at time.Time) {
+	for i := range m.voice {
+		session := &m.voice[i]
+
+		if session.state.GuildID == guildID && (userID == "" || session.state.UserID == userID) && session.endedAt.IsZero() {
+			session.endedAt = at
+		}
+	}
+}
+
+func (m *Memory) VoiceOccupancy(guildID string) ([]VoiceChannel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	open := make([]voiceSession, 0)
+
+	for _, session := range m.voice {
+		if session.state.GuildID == guildID && session.endedAt.IsZero() {
+			open = append(open, session)
+		}
+	}
+
+	sort.SliceStable(open, func(i, j int) bool {
+		if open[i].state.ChannelID != open[j].state.ChannelID {
+			return open[i].state.ChannelID < open[j].state.ChannelID
+		}
+
+		return open[i].joinedAt.Before(open[j].joinedAt)
+	})
+
+	channels := make([]VoiceChannel, 0)
+
+	for _, session := range open {
+		state := session.state
+		member := VoiceMember{
+			JoinedAt:   session.joinedAt,
+			UserID:     state.UserID,
+			Username:   m.authors[state.UserID].Username,
+			Nick:       m.members[memberKey{guildID: guildID, userID: state.UserID}].Nick,
+			Mute:       state.Mute,
+			Deaf:       state.Deaf,
+			SelfMute:   state.SelfMute,
+			SelfDeaf:   state.SelfDeaf,
+			SelfStream: state.SelfStream,
+			SelfVideo:  state.SelfVideo,
+			Suppress:   state.Suppress,
+		}
+
+		if len(channels) == 0 || channels[len(channels)-1].ChannelID != state.ChannelID {
+			channels = append(channels, VoiceChannel{ChannelID: state.ChannelID, Members: []VoiceMember{}})
+		}
+
+		last := &channels[len(channels)-1]
+		last.Members = append(last.Members, member)
+	}
+
+	return channels, nil
+}
+
+func (m *Memory) VoiceTimes(guildID string, since, until time.Time) ([]VoiceTime, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now().UTC()
+	byUser := make(map[string]*VoiceTime)
+
+	for _, session := range m.voice {
+		ended := session.endedAt
+		if ended.IsZero() {
+			ended = now
+		}
+
+		if session.state.GuildID != guildID || !session.startedAt.Before(until) || !ended.After(since) {
+			continue
+		}
+
+		start, end := session.startedAt, ended
+		if start.Before(since) {
+			start = since
+		}
+
+		if end.After(until) {
+			end = until
+		}
+
+		total, ok := byUser[session.state.UserID]
+		if !ok {
+			total = &VoiceTime{UserID: session.state.UserID, Username: m.authors[session.state.UserID].Username}
+			byUser[session.state.UserID] = total
+		}
+
+		seconds := int64(end.Sub(start) / time.Second)
+		total.Seconds += seconds
+
+		if session.state.SelfStream || session.state.SelfVideo {
+			total.StreamedSeconds += seconds
+		}
+	}
+
+	times := make([]VoiceTime, 0, len(byUser))
+	for _, total := range byUser {
+		times = append(times, *total)
+	}
+
+	sort.Slice(times, func(i, j int) bool {
+		if times[i].Seconds != times[j].Seconds {
+			return times[i].Seconds > times[j].Seconds
+		}
+
+		return times[i].UserID < times[j].UserID
+	})
+
+	return times, nil
+}
+
+func (m *Memory) SaveInteractionState(id, route string, data []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.states[id] = interactionState{expiresAt: expiresAt, route: route, data: append([]byte(nil), data...)}
+
+	return nil
+}
+
+func (m *Memory) InteractionState(id string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state, ok := m.states[id]
+	if !ok || (!state.expiresAt.IsZero() && !state.expiresAt.After(time.Now())) {
+		return nil, fmt.Errorf("%w: interaction state %s", ErrNotFound, id)
+	}
+
+	return append([]byte(nil), state.data...), nil
+}
+
+func (m *Memory) DeleteInteractionState(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.states, id)
+
+	return nil
+}
+
+func (m *Memory) DeleteExpiredInteractionState() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	for id, state := range m.states {
+		if !state.expiresAt.IsZero() && !state.expiresAt.After(now) {
+			delete(m.states, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) SaveDMChannel(channel *discordgo.Channel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := discordgo.Channel{ID: channel.ID, Type: channel.Type}
+
+	if len(channel.Recipients) > 0 {
+		m.saveUserLocked(channel.Recipients[0])
+		stored.Recipients = []*discordgo.User{{ID: channel.Recipients[0].ID}}
+	} else if existing, ok := m.channels[channel.ID]; ok {
+		stored.Recipients = existing.Recipients
+	}
+
+	m.channels[channel.ID] = stored
+
+	return nil
+}
+
+func (m *Memory) DMChannels() ([]DMChannel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channels := make([]DMChannel, 0)
+
+	for _, channel := range m.channels {
+		if channel.Type != discordgo.ChannelTypeDM || channel.GuildID != "" {
+			continue
+		}
+
+		dm := DMChannel{ID: channel.ID}
+
+		if len(channel.Recipients) > 0 {
+			recipient := m.authors[channel.Recipients[0].ID]
+			dm.RecipientID, dm.RecipientName, dm.RecipientAvatar = channel.Recipients[0].ID, recipient.Username, recipient.Avatar
+		}
+
+		for _, message := range m.messages[channel.ID] {
+			if dm.LastMessageAt == nil || message.Timestamp.After(*dm.LastMessageAt) {
+				sent := message.Timestamp
+				dm.LastMessageAt = &sent
+			}
+		}
+
+		channels = append(channels, dm)
+	}
+
+	// Channels without messages come last, like the MySQL store orders them.
+	sort.Slice(channels, func(i, j int) bool {
+		a, b := channels[i].LastMessageAt, channels[j].LastMessageAt
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+
+		if a != nil && !a.Equal(*b) {
+			return a.After(*b)
+		}
+
+		return channels[i].ID < channels[j].ID
+	})
+
+	return channels, nil
+}
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMemorySaveMessagesUpserts(t *testing.T) {
+	memory := NewMemory()
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	edited := sent.Add(time.Minute)
+
+	message := func(content string, editedAt *time.Time) []*discordgo.MessageCreate {
+		return []*discordgo.MessageCreate{{Message: &discordgo.Message{
+			ID: "1", ChannelID: "10", Author: &discordgo.User{ID: "42"}, Content: content,
+			Timestamp: sent, EditedTimestamp: editedAt,
+		}}}
+	}
+
+	for _, save := range []struct {
+		batch    []*discordgo.MessageCreate
+		inserted int
+		content  string
+	}{
+		{message("draft", nil), 1, "draft"},
+		{message("final", &edited), 0, "final"},
+		// A replay of the original doesn't undo the edit.
+		{message("draft", nil), 0, "final"},
+	} {
+		result, err := memory.SaveMessages(save.batch)
+		if err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+
+		if result.Inserted != save.inserted || result.Duplicates != 1-save.inserted || len(result.Failed) != 0 {
+			t.Errorf("result = %+v, want %d inserted", result, save.inserted)
+		}
+
+		messages, _ := memory.ChannelMessages("10", 10, 0)
+		if len(messages) != 1 || messages[0].Content != save.content {
+			t.Fatalf("stored %+v, want one message saying %q", messages, save.content)
+		}
+	}
+}
+
+func TestMemorySaveMessagesKeepsEqualVersion(t *testing.T) {
+	memory := NewMemory()
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	original := &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID: "1", ChannelID: "10", Author: &discordgo.User{ID: "42"}, Content: "hello", Timestamp: sent,
+	}}
+
+	if _, err := memory.SaveMessages([]*discordgo.MessageCreate{original}); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	// Pinning doesn't change the version of a message.
+	pinned := *original.Message
+	pinned.Pinned = true
+
+	if err := memory.UpdateMessage(&pinned); err != nil {
+		t.Fatalf("failed to pin: %v", err)
+	}
+
+	replay := *original.Message
+	replay.Content = "replayed"
+
+	result, err := memory.SaveMessages([]*discordgo.MessageCreate{{Message: &replay}})
+	if err != nil {
+		t.Fatalf("failed to save the replay: %v", err)
+	}
+
+	if result.Inserted != 0 || result.Duplicates != 1 {
+		t.Errorf("result = %+v, want a duplicate", result)
+	}
+
+	messages, _ := memory.ChannelMessages("10", 10, 0)
+	if len(messages) != 1 || messages[0].Content != "hello" || !messages[0].Pinned {
+		t.Fatalf("stored %+v, want the pinned original", messages)
+	}
+}
+
+func TestMemoryPruneMessages(t *testing.T) {
+	memory := NewMemory()
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	batch := make([]*discordgo.MessageCreate, 0, 5)
+	for i := 1; i <= 5; i++ {
+		batch = append(batch, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID: strconv.Itoa(i), ChannelID: "10", GuildID: "1", Author: &discordgo.User{ID: "42"},
+			Timestamp: sent.Add(time.Duration(i) * time.Hour),
+		}})
+	}
+
+	if _, err := memory.SaveMessages(batch); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if _, err := memory.PrunedHistory("10"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("pruned history before pruning: %v, want ErrNotFound", err)
+	}
+
+	for _, prune := range []struct {
+		before      time.Time
+		keep, limit int
+		want        []string
+	}{
+		// Older than the third message, one at a time.
+		{sent.Add(3 * time.Hour), 0, 1, []string{"1"}},
+		{sent.Add(3 * time.Hour), 0, 10, []string{"2"}},
+		// All but the newest two.
+		{time.Time{}, 2, 10, []string{"3"}},
+		{time.Time{}, 2, 10, nil},
+	} {
+		ids, err := memory.PruneMessages("10", prune.before, prune.keep, prune.limit)
+		if err != nil {
+			t.Fatalf("failed to prune: %v", err)
+		}
+
+		if !reflect.DeepEqual(ids, prune.want) {
+			t.Errorf("pruned %v, want %v", ids, prune.want)
+		}
+	}
+
+	history, err := memory.PrunedHistory("10")
+	if err != nil {
+		t.Fatalf("failed to get pruned history: %v", err)
+	}
+
+	if history.Messages != 3 || !history.Before.Equal(sent.Add(3*time.Hour)) || history.GuildID != "1" {
+		t.Errorf("pruned history = %+v, want 3 messages before message 4", history)
+	}
+
+	if known, _ := memory.KnownMessages([]string{"3", "4"}); known["3"] || !known["4"] {
+		t.Errorf("known messages = %v, want only 4", known)
+	}
+}
+
+func TestMemoryMembers(t *testing.T) {
+	memory := NewMemory()
+
+	_ = memory.ReplaceRoles("1", []*discordgo.Role{
+		{ID: "r1", Color: 0xff0000, Position: 1},
+		{ID: "r2", Color: 0x00ff00, Position: 2},
+	})
+
+	for _, member := range []*discordgo.Member{
+		{GuildID: "1", User: &discordgo.User{ID: "42", Username: "alice"}, Roles: []string{"r1", "r2"}},
+		{GuildID: "1", User: &discordgo.User{ID: "43", Username: "bob"}, Roles: []string{"r1"}},
+	} {
+		if err := memory.SaveMember(member); err != nil {
+			t.Fatalf("failed to save member: %v", err)
+		}
+	}
+
+	members, err := memory.Members("1", "", 10)
+	if err != nil {
+		t.Fatalf("failed to list members: %v", err)
+	}
+
+	// The color of the highest colored role wins.
+	if len(members) != 2 || members[0].Username != "alice" || members[0].Color != 0x00ff00 || members[1].Color != 0xff0000 {
+		t.Fatalf("members = %+v, want alice in green and bob in red", members)
+	}
+
+	_ = memory.DeleteRole("r2")
+
+	if members, _ := memory.Members("1", "42", 10); len(members) != 1 || members[0].UserID != "43" {
+		t.Errorf("members after 42 = %+v, want only bob", members)
+	}
+
+	if members, _ := memory.Members("1", "", 1); members[0].Color != 0xff0000 || len(members[0].Roles) != 1 {
+		t.Errorf("alice after deleting her green role = %+v, want red with one role", members[0])
+	}
+
+	// Only members stored since the sync started survive it.
+	time.Sleep(time.Millisecond)
+	syncStarted := time.Now().UTC()
+
+	_ = memory.SaveMember(&discordgo.Member{GuildID: "1", User: &discordgo.User{ID: "43", Username: "bob"}})
+	if err := memory.DeleteStaleMembers("1", syncStarted); err != nil {
+		t.Fatalf("failed to delete stale members: %v", err)
+	}
+
+	if members, _ := memory.Members("1", "", 10); len(members) != 1 || members[0].UserID != "43" {
+		t.Errorf("members after the sync = %+v, want only bob", members)
+	}
+}
+
+func TestMemoryReactions(t *testing.T) {
+	memory := NewMemory()
+
+	_, _ = memory.SaveMessages([]*discordgo.MessageCreate{
+		{Message: &discordgo.Message{ID: "1", ChannelID: "10", GuildID: "1", Author: &discordgo.User{ID: "42"}, Content: "hi"}},
+		{Message: &discordgo.Message{ID: "2", ChannelID: "10", GuildID: "1", Author: &discordgo.User{ID: "42"}}},
+	})
+
+	for _, reaction := range []*discordgo.MessageReaction{
+		{MessageID: "1", ChannelID: "10", GuildID: "1", UserID: "42", Emoji: discordgo.Emoji{Name: "👍"}},
+		{MessageID: "1", ChannelID: "10", GuildID: "1", UserID: "43", Emoji: discordgo.Emoji{Name: "👍"}},
+		{MessageID: "1", ChannelID: "10", GuildID: "1", UserID: "43", Emoji: discordgo.Emoji{ID: "7", Name: "party"}},
+		{MessageID: "2", ChannelID: "10", GuildID: "1", UserID: "43", Emoji: discordgo.Emoji{Name: "👍"}},
+	} {
+		if err := memory.SaveReaction(reaction); err != nil {
+			t.Fatalf("failed to save reaction: %v", err)
+		}
+	}
+
+	counts, err := memory.ReactionCounts([]string{"1", "2"}, "42")
+	if err != nil {
+		t.Fatalf("failed to count reactions: %v", err)
+	}
+
+	if first := counts["1"]; len(first) != 2 || first[0].Count != 2 || !first[0].Me || first[1].Emoji.ID != "7" || first[1].Me {
+		t.Errorf("reactions to 1 = %+v, want two 👍 with mine, then party", first)
+	}
+
+	top, _ := memory.TopReacted("1", "", 10)
+	if len(top) != 2 || top[0].MessageID != "1" || top[0].Total != 3 || top[0].Content != "hi" {
+		t.Errorf("top reacted = %+v, want message 1 with 3 reactions first", top)
+	}
+
+	_ = memory.DeleteReactions("1", "👍")
+	_ = memory.DeleteMessage("2")
+
+	if counts, _ := memory.ReactionCounts([]string{"1", "2"}, "42"); len(counts["1"]) != 1 || len(counts["2"]) != 0 {
+		t.Errorf("reactions after deleting = %+v, want only party on 1", counts)
+	}
+
+	if messages, _ := memory.ChannelMessages("10", 10, 0); len(messages) != 1 {
+		t.Errorf("messages after deleting = %+v, want only 1", messages)
+	}
+}
+
+func TestMemoryVoice(t *testing.T) {
+	memory := NewMemory()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, change := range []struct {
+		state    discordgo.VoiceState
+		at       time.Duration
+		previous string
+	}{
+		{discordgo.VoiceState{GuildID: "1", ChannelID: "v1", UserID: "42"}, 0, ""},
+		// Muting opens a new session but keeps the join time.
+		{discordgo.VoiceState{GuildID: "1", ChannelID: "v1", UserID: "42", SelfStream: true}, time.Minute, "v1"},
+		{discordgo.VoiceState{GuildID: "1", ChannelID: "v2", UserID: "43"}, 2 * time.Minute, ""},
+		{discordgo.VoiceState{GuildID: "1", UserID: "43"}, 5 * time.Minute, "v2"},
+	} {
+		previous, err := memory.RecordVoiceState(&change.state, start.Add(change.at))
+		if err != nil {
+			t.Fatalf("failed to record voice state: %v", err)
+		}
+
+		if previous != change.previous {
+			t.Errorf("previous channel = %q, want %q", previous, change.previous)
+		}
+	}
+
+	channels, _ := memory.VoiceOccupancy("1")
+	if len(channels) != 1 || len(channels[0].Members) != 1 || !channels[0].Members[0].JoinedAt.Equal(start) {
+		t.Fatalf("occupancy = %+v, want 42 in v1 since the start", channels)
+	}
+
+	_ = memory.CloseVoiceSessions("1", start.Add(10*time.Minute))
+
+	times, _ := memory.VoiceTimes("1", start.Add(30*time.Second), start.Add(time.Hour))
+	want := []VoiceTime{{UserID: "42", Seconds: 570, StreamedSeconds: 540}, {UserID: "43", Seconds: 180}}
+
+	if !reflect.DeepEqual(times, want) {
+		t.Errorf("voice times = %+v, want %+v", times, want)
+	}
+}
+
+func TestMemoryInteractionState(t *testing.T) {
+	memory := NewMemory()
+
+	_ = memory.SaveInteractionState("kept", "vote", []byte(`{}`), time.Time{})
+	_ = memory.SaveInteractionState("expired", "vote", []byte(`{}`), time.Now().Add(-time.Second))
+
+	if _, err := memory.InteractionState("expired"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired state: %v, want ErrNotFound", err)
+	}
+
+	if err := memory.DeleteExpiredInteractionState(); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+
+	if data, err := memory.InteractionState("kept"); err != nil || string(data) != `{}` {
+		t.Errorf("state = %q, %v, want the stored state", data, err)
+	}
+}
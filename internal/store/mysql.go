@@ -0,0 +1,2753 @@
+package store
+
+import (
+	"database/sql"
+	"discord-go-connect/internal/db"
+	"discord-go-connect/internal/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// deleteBatchSize is how many messages one delete by ID names.
+const deleteBatchSize = 500
+
+const (
+	insertGuild = `
+			INSERT INTO Guild (id, name, icon, region, owner_id)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			name = VALUES(name),
+			icon = VALUES(icon),
+			region = VALUES(region),
+			owner_id = VALUES(owner_id)
+		`
+	insertChannel = `
+			INSERT INTO Channel (id, guild_id, name, nsfw, position, type, parent_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			guild_id = VALUES(guild_id),
+			name = VALUES(name),
+			nsfw = VALUES(nsfw),
+			position = VALUES(position),
+			type = VALUES(type),
+			parent_id = VALUES(parent_id)
+		`
+	deleteChannelOverwrites = `
+			DELETE FROM ChannelOverwrite WHERE channel_id = ?
+		`
+	insertChannelOverwrite = `
+			INSERT INTO ChannelOverwrite (channel_id, id, type, allow, deny)
+			VALUES (?, ?, ?, ?, ?)
+		`
+	insertAuthor = `
+			INSERT IGNORE INTO Author (id, email, username, avatar, bot, system)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`
+	insertMember = `
+			INSERT INTO Member (id, guild_id, author_id, nick, avatar)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			nick = COALESCE(VALUES(nick), nick),
+			avatar = COALESCE(VALUES(avatar), avatar)
+		`
+	// insertMessage keeps the stored version of a message when the one being saved is no
+	// newer, such as a replay of the original after an edit or a pin. edited_timestamp is
+	// updated last because the other columns compare against it.
+	insertMessage = `
+			INSERT INTO Message (id, channel_id, guild_id, author_id, member_id, pinned, type, content, timestamp, edited_timestamp, attachment_count, attachments, embeds)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			pinned = IF(COALESCE(VALUES(edited_timestamp), VALUES(timestamp)) <= COALESCE(edited_timestamp, timestamp), pinned, VALUES(pinned)),
+			content = IF(COALESCE(VALUES(edited_timestamp), VALUES(timestamp)) <= COALESCE(edited_timestamp, timestamp), content, VALUES(content)),
+			attachment_count = IF(COALESCE(VALUES(edited_timestamp), VALUES(timestamp)) <= COALESCE(edited_timestamp, timestamp), attachment_count, VALUES(attachment_count)),
+			attachments = IF(COALESCE(VALUES(edited_timestamp), VALUES(timestamp)) <= COALESCE(edited_timestamp, timestamp), attachments, VALUES(attachments)),
+			embeds = IF(COALESCE(VALUES(edited_timestamp), VALUES(timestamp)) <= COALESCE(edited_timestamp, timestamp), embeds, VALUES(embeds)),
+			edited_timestamp = IF(COALESCE(VALUES(edited_timestamp), VALUES(timestamp)) <= COALESCE(edited_timestamp, timestamp), edited_timestamp, VALUES(edited_timestamp))
+		`
+	insertMention = `
+			INSERT IGNORE INTO MessageMention (message_id, user_id)
+			VALUES (?, ?)
+		`
+	selectGuild = `
+			SELECT id, name, icon, region, owner_id FROM Guild WHERE id = ?
+		`
+	// selectKnownMessages is completed with a placeholder per ID.
+	selectKnownMessages = `
+			SELECT id FROM Message WHERE id IN (%s)
+		`
+	selectChannelGuild = `
+			SELECT guild_id FROM Channel WHERE id = ?
+		`
+	selectChannel = `
+			SELECT id, guild_id, name, type, parent_id FROM Channel WHERE id = ?
+		`
+	selectChannelOverwrites = `
+			SELECT channel_id, id, type, allow, deny FROM ChannelOverwrite WHERE channel_id = ?
+		`
+	selectGuildChannelOverwrites = `
+			SELECT ChannelOverwrite.channel_id, ChannelOverwrite.id, ChannelOverwrite.type, ChannelOverwrite.allow, ChannelOverwrite.deny
+			FROM ChannelOverwrite
+			JOIN Channel ON Channel.id = ChannelOverwrite.channel_id
+			WHERE Channel.guild_id = ?
+		`
+	selectGuilds = `
+			SELECT id, name, icon, region, owner_id FROM Guild ORDER BY name, id
+		`
+	// selectGuildChannels leaves out threads, which belong to their parent channel.
+	selectGuildChannels = `
+			SELECT id, guild_id, name, type, parent_id, position, nsfw
+			FROM Channel
+			WHERE guild_id = ? AND type NOT IN (10, 11, 12)
+			ORDER BY position, id
+		`
+	selectMessage = `
+			SELECT
+				Message.id,
+				Message.channel_id,
+				Message.guild_id,
+				Message.author_id,
+				Message.pinned,
+				Message.type AS message_type,
+				Message.content,
+				Message.timestamp AS message_timestamp,
+				Message.edited_timestamp,
+				Author.username,
+				Author.avatar,
+				Author.bot,
+				Member.nick,
+				Member.avatar,
+				Message.attachments,
+				Message.embeds
+			FROM Message
+			JOIN Author ON Message.author_id = Author.id
+			LEFT JOIN Member ON Member.guild_id = Message.guild_id AND Member.id = Message.member_id
+			WHERE Message.id = ?
+		`
+	selectUser = `
+			SELECT id, username, avatar, bot, system FROM Author WHERE id = ?
+		`
+	selectUserGuilds = `
+			SELECT guild_id FROM Member WHERE id = ? ORDER BY guild_id
+		`
+	// selectHistory is completed with the filters.
+	selectHistory = `
+			SELECT
+				Message.id,
+				Message.channel_id,
+				Message.guild_id,
+				Message.author_id,
+				Message.pinned,
+				Message.type AS message_type,
+				Message.content,
+				Message.timestamp AS message_timestamp,
+				Message.edited_timestamp,
+				Author.username,
+				Author.avatar,
+				Author.bot,
+				Member.nick,
+				Member.avatar,
+				Message.attachments,
+				Message.embeds
+			FROM Message
+			JOIN Author ON Message.author_id = Author.id
+			LEFT JOIN Member ON Member.guild_id = Message.guild_id AND Member.id = Message.member_id
+			WHERE %s
+			ORDER BY Message.timestamp, Message.id
+		`
+	selectMessages = `
+			SELECT
+				Message.id,
+				Message.channel_id,
+				Message.guild_id,
+				Message.author_id,
+				Message.pinned,
+				Message.type AS message_type,
+				Message.content,
+				Message.timestamp AS message_timestamp,
+				Message.edited_timestamp,
+				Author.username,
+				Author.avatar,
+				Author.bot,
+				Member.nick,
+				Member.avatar,
+				Message.attachments,
+				Message.embeds
+			FROM Message
+			JOIN Author ON Message.author_id = Author.id
+			LEFT JOIN Member ON Member.guild_id = Message.guild_id AND Member.id = Message.member_id
+			WHERE Message.channel_id = ?
+			ORDER BY Message.timestamp DESC
+			LIMIT ? OFFSET ?;
+		`
+	// searchMessages is completed with the score expression and the filters.
+	searchMessages = `
+			SELECT
+				Message.id,
+				Message.channel_id,
+				Message.guild_id,
+				Message.author_id,
+				Message.pinned,
+				Message.type AS message_type,
+				Message.content,
+				Message.timestamp AS message_timestamp,
+				Message.edited_timestamp,
+				Author.username,
+				Author.avatar,
+				Author.bot,
+				Member.nick,
+				Member.avatar,
+				Message.attachments,
+				Message.embeds,
+				%s AS score
+			FROM Message
+			JOIN Author ON Message.author_id = Author.id
+			LEFT JOIN Member ON Member.guild_id = Message.guild_id AND Member.id = Message.member_id
+			WHERE %s
+			ORDER BY score DESC, Message.timestamp DESC
+			LIMIT ? OFFSET ?
+		`
+	selectRetentionRules = `
+			SELECT guild_id, channel_id, mode, amount, updated_at
+			FROM RetentionRule
+			ORDER BY guild_id, channel_id
+		`
+	insertRetentionRule = `
+			INSERT INTO RetentionRule (guild_id, channel_id, mode, amount, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			mode = VALUES(mode),
+			amount = VALUES(amount),
+			updated_at = VALUES(updated_at)
+		`
+	deleteRetentionRule = `
+			DELETE FROM RetentionRule WHERE guild_id = ? AND channel_id = ?
+		`
+	selectStoredChannels = `
+			SELECT DISTINCT channel_id FROM Message WHERE guild_id = ?
+		`
+	countChannelMessages = `
+			SELECT COUNT(*) FROM Message WHERE channel_id = ?
+		`
+	// selectPrunable is completed with the time filter.
+	selectPrunable = `
+			SELECT id, guild_id, timestamp
+			FROM Message
+			WHERE channel_id = ? %s
+			ORDER BY timestamp, id
+			LIMIT ?
+		`
+	// The deletes by message ID are completed with a placeholder per message.
+	deleteMessageReactions = `
+			DELETE FROM Reaction WHERE message_id IN (%s)
+		`
+	deleteMessageMentions = `
+			DELETE FROM MessageMention WHERE message_id IN (%s)
+		`
+	deleteMessageMediaSources = `
+			DELETE FROM MediaSource WHERE message_id IN (%s)
+		`
+	deleteMessagesByID = `
+			DELETE FROM Message WHERE id IN (%s)
+		`
+	insertPrunedHistory = `
+			INSERT INTO PrunedHistory (channel_id, guild_id, pruned_before, messages, pruned_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			pruned_before = GREATEST(pruned_before, VALUES(pruned_before)),
+			messages = messages + VALUES(messages),
+			pruned_at = VALUES(pruned_at)
+		`
+	selectPrunedHistory = `
+			SELECT channel_id, guild_id, pruned_before, messages, pruned_at
+			FROM PrunedHistory
+			WHERE channel_id = ?
+		`
+	selectArchivableChannels = `
+			SELECT DISTINCT channel_id FROM Message WHERE timestamp < ?
+		`
+	selectArchivedPartitions = `
+			SELECT channel_id, guild_id, month, object_key, messages, first_timestamp, last_timestamp, archived_at
+			FROM ArchivedPartition
+			WHERE channel_id = ?
+			ORDER BY month DESC
+		`
+	insertArchivedPartition = `
+			INSERT INTO ArchivedPartition
+			(channel_id, guild_id, month, object_key, messages, first_timestamp, last_timestamp, archived_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			object_key = VALUES(object_key),
+			messages = VALUES(messages),
+			first_timestamp = VALUES(first_timestamp),
+			last_timestamp = VALUES(last_timestamp),
+			archived_at = VALUES(archived_at)
+		`
+	deleteArchivedPartition = `
+			DELETE FROM ArchivedPartition WHERE channel_id = ? AND month = ?
+		`
+	// selectMirroredMedia is completed with a placeholder per source.
+	selectMirroredMedia = `
+			SELECT MediaSource.source, MediaObject.hash, MediaObject.content_type, MediaObject.size, MediaObject.created_at
+			FROM MediaSource
+			JOIN MediaObject ON MediaObject.hash = MediaSource.hash
+			WHERE MediaSource.source IN (%s)
+		`
+	insertMediaObject = `
+			INSERT IGNORE INTO MediaObject (hash, content_type, size, created_at)
+			VALUES (?, ?, ?, ?)
+		`
+	// insertMediaSource keeps the guild a source was first mirrored for. Avatars and
+	// emoji show up in several guilds, and keys of the others still get them by hash.
+	insertMediaSource = `
+			INSERT INTO MediaSource (source, guild_id, message_id, hash, mirrored_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			hash = VALUES(hash),
+			mirrored_at = VALUES(mirrored_at)
+		`
+	selectMediaSource = `
+			SELECT MediaSource.guild_id, MediaObject.hash, MediaObject.content_type, MediaObject.size, MediaObject.created_at
+			FROM MediaSource
+			JOIN MediaObject ON MediaObject.hash = MediaSource.hash
+			WHERE MediaSource.source = ?
+		`
+	selectUnreferencedMedia = `
+			SELECT MediaObject.hash
+			FROM MediaObject
+			LEFT JOIN MediaSource ON MediaSource.hash = MediaObject.hash
+			WHERE MediaSource.hash IS NULL
+		`
+	deleteUnreferencedMediaObject = `
+			DELETE FROM MediaObject
+			WHERE hash = ? AND NOT EXISTS (SELECT 1 FROM MediaSource WHERE MediaSource.hash = ?)
+		`
+	selectMediaObject = `
+			SELECT hash, content_type, size, created_at FROM MediaObject WHERE hash = ?
+		`
+	updateMessage = `
+			UPDATE Message SET content = ?, pinned = ?, edited_timestamp = ?
+			WHERE id = ?
+		`
+	deleteGuild = `
+			DELETE FROM Guild WHERE id = ?
+		`
+	deleteGuildChannelOverwrites = `
+			DELETE ChannelOverwrite FROM ChannelOverwrite
+			JOIN Channel ON Channel.id = ChannelOverwrite.channel_id
+			WHERE Channel.guild_id = ?
+		`
+	deleteGuildChannels = `
+			DELETE FROM Channel WHERE guild_id = ?
+		`
+	deleteGuildMembers = `
+			DELETE FROM Member WHERE guild_id = ?
+		`
+	deleteGuildMemberRoles = `
+			DELETE FROM MemberRole WHERE guild_id = ?
+		`
+	deleteChannel = `
+			DELETE FROM Channel WHERE id = ?
+		`
+	upsertAuthor = `
+			INSERT INTO Author (id, username, avatar, bot, system)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			username = VALUES(username),
+			avatar = VALUES(avatar),
+			bot = VALUES(bot)
+		`
+	upsertMember = `
+			INSERT INTO Member (id, guild_id, author_id, nick, avatar, joined_at, premium_since, pending, synced_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			nick = VALUES(nick),
+			avatar = VALUES(avatar),
+			joined_at = VALUES(joined_at),
+			premium_since = VALUES(premium_since),
+			pending = VALUES(pending),
+			synced_at = VALUES(synced_at)
+		`
+	deleteMember = `
+			DELETE FROM Member WHERE guild_id = ? AND id = ?
+		`
+	deleteMemberRoles = `
+			DELETE FROM MemberRole WHERE guild_id = ? AND user_id = ?
+		`
+	insertMemberRole = `
+			INSERT IGNORE INTO MemberRole (guild_id, user_id, role_id) VALUES (?, ?, ?)
+		`
+	deleteStaleMemberRoles = `
+			DELETE MemberRole FROM MemberRole
+			JOIN Member ON Member.guild_id = MemberRole.guild_id AND Member.id = MemberRole.user_id
+			WHERE Member.guild_id = ? AND (Member.synced_at IS NULL OR Member.synced_at < ?)
+		`
+	deleteStaleMembers = `
+			DELETE FROM Member WHERE guild_id = ? AND (synced_at IS NULL OR synced_at < ?)
+		`
+	selectMembers = `
+			SELECT
+				Member.id,
+				Author.username,
+				Author.avatar,
+				Author.bot,
+				Member.nick,
+				Member.avatar,
+				Member.joined_at,
+				Member.premium_since,
+				Member.pending,
+				COALESCE((
+					SELECT Role.color FROM MemberRole
+					JOIN Role ON Role.id = MemberRole.role_id
+					WHERE MemberRole.guild_id = Member.guild_id AND MemberRole.user_id = Member.id AND Role.color <> 0
+					ORDER BY Role.position DESC
+					LIMIT 1
+				), 0),
+				COALESCE(GROUP_CONCAT(MemberRole.role_id), '')
+			FROM Member
+			LEFT JOIN Author ON Author.id = Member.author_id
+			LEFT JOIN MemberRole ON MemberRole.guild_id = Member.guild_id AND MemberRole.user_id = Member.id
+			WHERE Member.guild_id = ? AND Member.id > ?
+			GROUP BY Member.id
+			ORDER BY Member.id
+			LIMIT ?
+		`
+	upsertRole = `
+			INSERT INTO Role (id, guild_id, name, color, position, permissions, hoist, mentionable, managed)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			name = VALUES(name),
+			color = VALUES(color),
+			position = VALUES(position),
+			permissions = VALUES(permissions),
+			hoist = VALUES(hoist),
+			mentionable = VALUES(mentionable),
+			managed = VALUES(managed)
+		`
+	deleteRole = `
+			DELETE FROM Role WHERE id = ?
+		`
+	deleteRoleMembers = `
+			DELETE FROM MemberRole WHERE role_id = ?
+		`
+	deleteGuildRoles = `
+			DELETE FROM Role WHERE guild_id = ?
+		`
+	selectRoles = `
+			SELECT id, name, color, position, permissions, hoist, mentionable, managed
+			FROM Role
+			WHERE guild_id = ?
+			ORDER BY position DESC, id
+		`
+	insertPresenceSample = `
+			INSERT IGNORE INTO PresenceSample (guild_id, user_id, sampled_at, status, activity_type, activity_name)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`
+	insertThread = `
+			INSERT INTO Channel (id, guild_id, name, nsfw, position, type, parent_id, owner_id, archived, locked, archive_timestamp)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			guild_id = VALUES(guild_id),
+			name = VALUES(name),
+			nsfw = VALUES(nsfw),
+			type = VALUES(type),
+			parent_id = VALUES(parent_id),
+			owner_id = VALUES(owner_id),
+			archived = VALUES(archived),
+			locked = VALUES(locked),
+			archive_timestamp = VALUES(archive_timestamp)
+		`
+	deleteThreadTags = `
+			DELETE FROM ThreadTag WHERE thread_id = ?
+		`
+	insertThreadTag = `
+			INSERT IGNORE INTO ThreadTag (thread_id, tag_id) VALUES (?, ?)
+		`
+	deleteThreadMembers = `
+			DELETE FROM ThreadMember WHERE thread_id = ?
+		`
+	insertThreadMember = `
+			INSERT INTO ThreadMember (thread_id, user_id, join_timestamp)
+			VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE join_timestamp = VALUES(join_timestamp)
+		`
+	deleteThreadMember = `
+			DELETE FROM ThreadMember WHERE thread_id = ? AND user_id = ?
+		`
+	archiveStaleThreads = `
+			UPDATE Channel SET archived = TRUE
+			WHERE parent_id = ? AND archived = FALSE AND type IN (10, 11, 12)
+		`
+	archiveStaleGuildThreads = `
+			UPDATE Channel SET archived = TRUE
+			WHERE guild_id = ? AND archived = FALSE AND type IN (10, 11, 12)
+		`
+	insertForumTag = `
+			INSERT INTO ForumTag (id, channel_id, name, emoji_id, emoji_name, moderated)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			channel_id = VALUES(channel_id),
+			name = VALUES(name),
+			emoji_id = VALUES(emoji_id),
+			emoji_name = VALUES(emoji_name),
+			moderated = VALUES(moderated)
+		`
+	selectThreadBackfilled = `
+			SELECT backfilled_at FROM Channel WHERE id = ?
+		`
+	updateThreadBackfilled = `
+			UPDATE Channel SET backfilled_at = ? WHERE id = ?
+		`
+	selectThreads = `
+			SELECT
+				Channel.id,
+				Channel.guild_id,
+				Channel.parent_id,
+				Channel.owner_id,
+				Channel.name,
+				Channel.type,
+				Channel.archived,
+				Channel.locked,
+				Channel.archive_timestamp,
+				COALESCE(GROUP_CONCAT(ThreadTag.tag_id), '')
+			FROM Channel
+			LEFT JOIN ThreadTag ON ThreadTag.thread_id = Channel.id
+			WHERE Channel.parent_id = ? AND Channel.type IN (10, 11, 12)
+				AND (? IS NULL OR Channel.archived = ?)
+			GROUP BY Channel.id
+			ORDER BY COALESCE(Channel.archive_timestamp, '9999-12-31') DESC, Channel.id DESC
+		`
+	insertReaction = `
+			INSERT IGNORE INTO Reaction (message_id, user_id, emoji, emoji_id, emoji_name, channel_id, guild_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`
+	deleteReaction = `
+			DELETE FROM Reaction WHERE message_id = ? AND user_id = ? AND emoji = ?
+		`
+	deleteAllReactions = `
+			DELETE FROM Reaction WHERE message_id = ?
+		`
+	deleteEmojiReactions = `
+			DELETE FROM Reaction WHERE message_id = ? AND emoji = ?
+		`
+	// selectReactionCounts is completed with one placeholder per message ID.
+	selectReactionCounts = `
+			SELECT message_id, emoji, emoji_id, emoji_name, COUNT(*), SUM(user_id = ?)
+			FROM Reaction
+			WHERE message_id IN (%s)
+			GROUP BY message_id, emoji, emoji_id, emoji_name
+			ORDER BY MIN(created_at)
+		`
+	// selectTopReacted is completed with the column filtered on.
+	selectTopReacted = `
+			SELECT Reaction.message_id, Reaction.channel_id, COALESCE(Message.content, ''), COUNT(*) AS total
+			FROM Reaction
+			LEFT JOIN Message ON Message.id = Reaction.message_id
+			WHERE Reaction.%s = ?
+			GROUP BY Reaction.message_id, Reaction.channel_id, Message.content
+			ORDER BY total DESC
+			LIMIT ?
+		`
+	selectOpenVoiceSession = `
+			SELECT id, channel_id, joined_at FROM VoiceSession
+			WHERE guild_id = ? AND user_id = ? AND ended_at IS NULL
+			ORDER BY id DESC
+			LIMIT 1
+		`
+	closeVoiceSessions = `
+			UPDATE VoiceSession SET ended_at = ?
+			WHERE guild_id = ? AND user_id = ? AND ended_at IS NULL
+		`
+	closeGuildVoiceSessions = `
+			UPDATE VoiceSession SET ended_at = ?
+			WHERE guild_id = ? AND ended_at IS NULL
+		`
+	insertVoiceSession = `
+			INSERT INTO VoiceSession (guild_id, channel_id, user_id, session_id, joined_at, started_at,
+				mute, deaf, self_mute, self_deaf, self_stream, self_video, suppress)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`
+	selectVoiceOccupancy = `
+			SELECT
+				VoiceSession.channel_id,
+				VoiceSession.user_id,
+				Author.username,
+				Member.nick,
+				VoiceSession.joined_at,
+				VoiceSession.mute,
+				VoiceSession.deaf,
+				VoiceSession.self_mute,
+				VoiceSession.self_deaf,
+				VoiceSession.self_stream,
+				VoiceSession.self_video,
+				VoiceSession.suppress
+			FROM VoiceSession
+			LEFT JOIN Author ON Author.id = VoiceSession.user_id
+			LEFT JOIN Member ON Member.guild_id = VoiceSession.guild_id AND Member.id = VoiceSession.user_id
+			WHERE VoiceSession.guild_id = ? AND VoiceSession.ended_at IS NULL
+			ORDER BY VoiceSession.channel_id, VoiceSession.joined_at
+		`
+	// selectVoiceTimes clips every session to the requested window before summing, so
+	// sessions that started before it or are still open only count their overlap.
+	selectVoiceTimes = `
+			SELECT
+				VoiceSession.user_id,
+				Author.username,
+				SUM(TIMESTAMPDIFF(SECOND,
+					GREATEST(VoiceSession.started_at, ?),
+					LEAST(COALESCE(VoiceSession.ended_at, UTC_TIMESTAMP(3)), ?))),
+				SUM(CASE WHEN VoiceSession.self_stream OR VoiceSession.self_video THEN TIMESTAMPDIFF(SECOND,
+					GREATEST(VoiceSession.started_at, ?),
+					LEAST(COALESCE(VoiceSession.ended_at, UTC_TIMESTAMP(3)), ?)) ELSE 0 END)
+			FROM VoiceSession
+			LEFT JOIN Author ON Author.id = VoiceSession.user_id
+			WHERE VoiceSession.guild_id = ?
+				AND VoiceSession.started_at < ?
+				AND COALESCE(VoiceSession.ended_at, UTC_TIMESTAMP(3)) > ?
+			GROUP BY VoiceSession.user_id, Author.username
+			ORDER BY 3 DESC
+		`
+	insertInteractionState = `
+			INSERT INTO InteractionState (id, route, data, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)
+		`
+	selectInteractionState = `
+			SELECT data FROM InteractionState
+			WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)
+		`
+	deleteInteractionState = `
+			DELETE FROM InteractionState WHERE id = ?
+		`
+	deleteExpiredInteractionState = `
+			DELETE FROM InteractionState WHERE expires_at IS NOT NULL AND expires_at <= ?
+		`
+	insertDMChannel = `
+			INSERT INTO Channel (id, type, recipient_id)
+			VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			type = VALUES(type),
+			recipient_id = COALESCE(VALUES(recipient_id), recipient_id)
+		`
+	selectDMChannels = `
+			SELECT
+				Channel.id,
+				Channel.recipient_id,
+				Author.username,
+				Author.avatar,
+				MAX(Message.timestamp)
+			FROM Channel
+			LEFT JOIN Author ON Author.id = Channel.recipient_id
+			LEFT JOIN Message ON Message.channel_id = Channel.id
+			WHERE Channel.type = ? AND Channel.guild_id IS NULL
+			GROUP BY Channel.id, Channel.recipient_id, Author.username, Author.avatar
+			ORDER BY MAX(Message.timestamp) IS NULL, MAX(Message.timestamp) DESC
+		`
+	matchContent = `MATCH(Message.content) AGAINST (? IN BOOLEAN MODE)`
+
+	savepointMessage        = `SAVEPOINT msg`
+	rollbackToMessage       = `ROLLBACK TO SAVEPOINT msg`
+	releaseMessageSavepoint = `RELEASE SAVEPOINT msg`
+)
+
+// MySQL is the store backed by the bot's MySQL database.
+type MySQL struct {
+	db     *db.Manager
+	logger *logger.StandardLoggerHandler
+}
+
+func NewMySQL(dbManager *db.Manager) *MySQL {
+	return &MySQL{
+		db:     dbManager,
+		logger: logger.NewLogger(os.Stderr),
+	}
+}
+
+func (m *MySQL) SaveGuild(guild *discordgo.Guild) error {
+	if _, err := m.db.Execute(insertGuild, guild.ID, guild.Name, guild.Icon, guild.Region, guild.OwnerID); err != nil {
+		return fmt.Errorf("failed to store guild %s: %w", guild.ID, err)
+	}
+
+	return nil
+}
+
+// SaveChannel stores a channel and replaces its permission overwrites, so private
+// channels stay private when the channels are read back.
+func (m *MySQL) SaveChannel(guildID string, channel *discordgo.Channel) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	_, err = tx.Exec(insertChannel, channel.ID, guildID, channel.Name, channel.NSFW, channel.Position,
+		channel.Type, nullString(channel.ParentID))
+	if err != nil {
+		return fmt.Errorf("failed to store channel %s: %w", channel.ID, err)
+	}
+
+	if _, err := tx.Exec(deleteChannelOverwrites, channel.ID); err != nil {
+		return fmt.Errorf("failed to clear permission overwrites of channel %s: %w", channel.ID, err)
+	}
+
+	for _, overwrite := range channel.PermissionOverwrites {
+		_, err := tx.Exec(insertChannelOverwrite, channel.ID, overwrite.ID, overwrite.Type, overwrite.Allow, overwrite.Deny)
+		if err != nil {
+			return fmt.Errorf("failed to store permission overwrite %s of channel %s: %w", overwrite.ID, channel.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveMessages(messages []*discordgo.MessageCreate) (*SaveResult, error) {
+	result := &SaveResult{Failed: make(map[string]error)}
+
+	if len(messages) < 1 {
+		return result, nil
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	stmts := messageStatements{tx: tx}
+
+	if stmts.author, err = tx.Prepare(insertAuthor); err != nil {
+		return nil, fmt.Errorf("failed to prepare SQL statement for authors: %w", err)
+	}
+	defer stmts.author.Close()
+
+	if stmts.member, err = tx.Prepare(insertMember); err != nil {
+		return nil, fmt.Errorf("failed to prepare SQL statement for members: %w", err)
+	}
+	defer stmts.member.Close()
+
+	if stmts.message, err = tx.Prepare(insertMessage); err != nil {
+		return nil, fmt.Errorf("failed to prepare SQL statement for messages: %w", err)
+	}
+	defer stmts.message.Close()
+
+	if stmts.mention, err = tx.Prepare(insertMention); err != nil {
+		return nil, fmt.Errorf("failed to prepare SQL statement for mentions: %w", err)
+	}
+	defer stmts.mention.Close()
+
+	for _, message := range messages {
+		if message == nil || message.Author == nil {
+			continue
+		}
+
+		inserted, err := stmts.save(message)
+
+		var saveErr *messageError
+
+		switch {
+		case errors.As(err, &saveErr):
+			result.Failed[message.ID] = saveErr.err
+		case err != nil:
+			return nil, err
+		case inserted:
+			result.Inserted++
+		default:
+			result.Duplicates++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return result, nil
+}
+
+// messageStatements are the statements SaveMessages prepares in its transaction.
+type messageStatements struct {
+	tx                               *sql.Tx
+	author, member, message, mention *sql.Stmt
+}
+
+// messageError is a message that couldn't be stored. Its rows are rolled back and the
+// rest of the batch goes on.
+type messageError struct {
+	err error
+}
+
+func (e *messageError) Error() string {
+	return e.err.Error()
+}
+
+// save stores a message and reports whether it is new. Each message gets a savepoint, so
+// a statement that fails halfway undoes the author, member and mentions already written
+// for it and returns a messageError. Other errors leave the transaction unusable.
+func (s *messageStatements) save(message *discordgo.MessageCreate) (bool, error) {
+	if _, err := s.tx.Exec(savepointMessage); err != nil {
+		return false, fmt.Errorf("failed to set savepoint for message %s: %w", message.ID, err)
+	}
+
+	inserted, err := s.write(message)
+	if err != nil {
+		if _, rollbackErr := s.tx.Exec(rollbackToMessage); rollbackErr != nil {
+			return false, fmt.Errorf("failed to roll back message %s: %w", message.ID, rollbackErr)
+		}
+
+		return false, &messageError{err: err}
+	}
+
+	if _, err := s.tx.Exec(releaseMessageSavepoint); err != nil {
+		return false, fmt.Errorf("failed to release savepoint of message %s: %w", message.ID, err)
+	}
+
+	return inserted, nil
+}
+
+// write runs the statements of a message.
+func (s *messageStatements) write(message *discordgo.MessageCreate) (bool, error) {
+	attachments, embeds, err := encodeExtras(message.Message)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.author.Exec(
+		message.Author.ID, message.Author.Email, message.Author.Username,
+		message.Author.Avatar, message.Author.Bot, message.Author.System,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute SQL statement for authors: %w", err)
+	}
+
+	// DM messages have neither a guild nor a member.
+	var memberID interface{}
+
+	if message.GuildID != "" && message.Member != nil {
+		memberID = message.Author.ID
+
+		_, err = s.member.Exec(
+			message.Author.ID, message.GuildID, message.Author.ID, nullString(message.Member.Nick),
+			nullString(message.Member.Avatar),
+		)
+		if err != nil {
+			return false, fmt.Errorf("failed to execute SQL statement for members: %w", err)
+		}
+	}
+
+	stored, err := s.message.Exec(
+		message.ID, message.ChannelID, nullString(message.GuildID), message.Author.ID,
+		memberID, message.Pinned, message.Type,
+		message.Content, message.Timestamp, message.EditedTimestamp, len(message.Attachments),
+		attachments, embeds,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute SQL statement for messages: %w", err)
+	}
+
+	for _, user := range message.Mentions {
+		if _, err := s.mention.Exec(message.ID, user.ID); err != nil {
+			return false, fmt.Errorf("failed to execute SQL statement for mentions: %w", err)
+		}
+	}
+
+	// MySQL counts one row for an insert, two for an update and none for a message that
+	// is stored unchanged.
+	affected, err := stored.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count stored messages: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (m *MySQL) Guild(guildID string) (*discordgo.Guild, error) {
+	var (
+		guild                 discordgo.Guild
+		icon, region, ownerID sql.NullString
+	)
+
+	err := m.db.QueryRow(selectGuild, guildID).Scan(&guild.ID, &guild.Name, &icon, &region, &ownerID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: guild %s", ErrNotFound, guildID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch guild %s: %w", guildID, err)
+	}
+
+	guild.Icon, guild.Region, guild.OwnerID = icon.String, region.String, ownerID.String
+
+	return &guild, nil
+}
+
+func (m *MySQL) ChannelGuild(channelID string) (string, error) {
+	var guildID sql.NullString
+
+	err := m.db.QueryRow(selectChannelGuild, channelID).Scan(&guildID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: channel %s", ErrNotFound, channelID)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch channel %s: %w", channelID, err)
+	}
+
+	return guildID.String, nil
+}
+
+func (m *MySQL) Channel(channelID string) (*discordgo.Channel, error) {
+	var (
+		channel                 discordgo.Channel
+		guildID, name, parentID sql.NullString
+	)
+
+	err := m.db.QueryRow(selectChannel, channelID).Scan(&channel.ID, &guildID, &name, &channel.Type, &parentID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: channel %s", ErrNotFound, channelID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch channel %s: %w", channelID, err)
+	}
+
+	channel.GuildID, channel.Name, channel.ParentID = guildID.String, name.String, parentID.String
+
+	overwrites, err := m.channelOverwrites(selectChannelOverwrites, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	channel.PermissionOverwrites = overwrites[channelID]
+
+	return &channel, nil
+}
+
+func (m *MySQL) Guilds() ([]discordgo.Guild, error) {
+	rows, err := m.db.Query(selectGuilds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch guilds: %w", err)
+	}
+	defer rows.Close()
+
+	guilds := make([]discordgo.Guild, 0)
+
+	for rows.Next() {
+		var (
+			guild                 discordgo.Guild
+			icon, region, ownerID sql.NullString
+		)
+
+		if err := rows.Scan(&guild.ID, &guild.Name, &icon, &region, &ownerID); err != nil {
+			return nil, fmt.Errorf("failed to scan guild: %w", err)
+		}
+
+		guild.Icon, guild.Region, guild.OwnerID = icon.String, region.String, ownerID.String
+		guilds = append(guilds, guild)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate guilds: %w", err)
+	}
+
+	return guilds, nil
+}
+
+func (m *MySQL) GuildChannels(guildID string) ([]discordgo.Channel, error) {
+	rows, err := m.db.Query(selectGuildChannels, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch channels of guild %s: %w", guildID, err)
+	}
+	defer rows.Close()
+
+	channels := make([]discordgo.Channel, 0)
+
+	for rows.Next() {
+		var (
+			channel               discordgo.Channel
+			guild, name, parentID sql.NullString
+		)
+
+		err := rows.Scan(&channel.ID, &guild, &name, &channel.Type, &parentID, &channel.Position, &channel.NSFW)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan channel: %w", err)
+		}
+
+		channel.GuildID, channel.Name, channel.ParentID = guild.String, name.String, parentID.String
+		channels = append(channels, channel)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate channels: %w", err)
+	}
+
+	overwrites, err := m.channelOverwrites(selectGuildChannelOverwrites, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range channels {
+		channels[i].PermissionOverwrites = overwrites[channels[i].ID]
+	}
+
+	return channels, nil
+}
+
+// channelOverwrites returns the permission overwrites a query selects, by channel.
+func (m *MySQL) channelOverwrites(query, id string) (map[string][]*discordgo.PermissionOverwrite, error) {
+	rows, err := m.db.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch permission overwrites of %s: %w", id, err)
+	}
+	defer rows.Close()
+
+	overwrites := make(map[string][]*discordgo.PermissionOverwrite)
+
+	for rows.Next() {
+		var (
+			channelID string
+			overwrite discordgo.PermissionOverwrite
+		)
+
+		if err := rows.Scan(&channelID, &overwrite.ID, &overwrite.Type, &overwrite.Allow, &overwrite.Deny); err != nil {
+			return nil, fmt.Errorf("failed to scan permission overwrite: %w", err)
+		}
+
+		overwrites[channelID] = append(overwrites[channelID], &overwrite)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate permission overwrites: %w", err)
+	}
+
+	return overwrites, nil
+}
+
+func (m *MySQL) Message(messageID string) (*discordgo.Message, error) {
+	rows, err := m.db.Query(selectMessage, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message %s: %w", messageID, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to fetch message %s: %w", messageID, err)
+		}
+
+		return nil, fmt.Errorf("%w: message %s", ErrNotFound, messageID)
+	}
+
+	message, err := scanMessage(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func (m *MySQL) User(userID string) (*discordgo.User, error) {
+	var (
+		user   discordgo.User
+		avatar sql.NullString
+	)
+
+	err := m.db.QueryRow(selectUser, userID).Scan(&user.ID, &user.Username, &avatar, &user.Bot, &user.System)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: user %s", ErrNotFound, userID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user %s: %w", userID, err)
+	}
+
+	user.Avatar = avatar.String
+
+	return &user, nil
+}
+
+func (m *MySQL) UserGuilds(userID string) ([]string, error) {
+	rows, err := m.db.Query(selectUserGuilds, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch guilds of user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	guilds := make([]string, 0)
+
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			return nil, fmt.Errorf("failed to scan guild ID: %w", err)
+		}
+
+		guilds = append(guilds, guildID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate guilds: %w", err)
+	}
+
+	return guilds, nil
+}
+
+func (m *MySQL) KnownMessages(ids []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	if len(ids) == 0 {
+		return known, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	rows, err := m.db.Query(fmt.Sprintf(selectKnownMessages, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan message ID: %w", err)
+		}
+
+		known[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate message IDs: %w", err)
+	}
+
+	return known, nil
+}
+
+func (m *MySQL) EachMessage(filter *HistoryFilter, fn func(message *discordgo.Message) error) error {
+	filters, args := []string{"TRUE"}, make([]interface{}, 0)
+
+	if filter.GuildID != "" {
+		filters, args = append(filters, "Message.guild_id = ?"), append(args, filter.GuildID)
+	}
+
+	if filter.ChannelID != "" {
+		filters, args = append(filters, "Message.channel_id = ?"), append(args, filter.ChannelID)
+	}
+
+	if !filter.After.IsZero() {
+		filters, args = append(filters, "Message.timestamp >= ?"), append(args, filter.After)
+	}
+
+	if !filter.Before.IsZero() {
+		filters, args = append(filters, "Message.timestamp < ?"), append(args, filter.Before)
+	}
+
+	rows, err := m.db.Query(fmt.Sprintf(selectHistory, strings.Join(filters, " AND ")), args...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&message); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate messages: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) ChannelMessages(channelID string, limit, offset int) ([]discordgo.Message, error) {
+	rows, err := m.db.Query(selectMessages, channelID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]discordgo.Message, 0, limit)
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (m *MySQL) Search(query *SearchQuery) ([]SearchHit, error) {
+	score, args := "0", make([]interface{}, 0)
+
+	var filters []string
+
+	if match := booleanMatch(query); match != "" {
+		score, args = matchContent, append(args, match)
+		filters, args = append(filters, matchContent), append(args, match)
+	}
+
+	filter := func(condition string, arg interface{}) {
+		filters, args = append(filters, condition), append(args, arg)
+	}
+
+	if len(query.Guilds) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(query.Guilds)), ",")
+		filters = append(filters, "Message.guild_id IN ("+placeholders+")")
+
+		for _, guildID := range query.Guilds {
+			args = append(args, guildID)
+		}
+	}
+
+	if query.GuildID != "" {
+		filter("Message.guild_id = ?", query.GuildID)
+	}
+
+	if query.ChannelID != "" {
+		filter("Message.channel_id = ?", query.ChannelID)
+	}
+
+	if query.AuthorID != "" {
+		filter("Message.author_id = ?", query.AuthorID)
+	}
+
+	if query.Mentions != "" {
+		filter(`EXISTS (
+				SELECT 1 FROM MessageMention
+				WHERE MessageMention.message_id = Message.id AND MessageMention.user_id = ?
+			)`, query.Mentions)
+	}
+
+	if !query.After.IsZero() {
+		filter("Message.timestamp >= ?", query.After)
+	}
+
+	if !query.Before.IsZero() {
+		filter("Message.timestamp < ?", query.Before)
+	}
+
+	if query.HasAttachment {
+		filters = append(filters, "Message.attachment_count > 0")
+	}
+
+	if query.HasLink {
+		filters = append(filters, "(Message.content LIKE '%http://%' OR Message.content LIKE '%https://%')")
+	}
+
+	if query.Pinned != nil {
+		filter("Message.pinned = ?", *query.Pinned)
+	}
+
+	if len(filters) == 0 {
+		filters = append(filters, "TRUE")
+	}
+
+	args = append(args, query.Limit, query.Offset)
+
+	rows, err := m.db.Query(fmt.Sprintf(searchMessages, score, strings.Join(filters, " AND ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	terms := query.matchTerms()
+	hits := make([]SearchHit, 0, query.Limit)
+
+	for rows.Next() {
+		var hit SearchHit
+
+		if hit.Message, err = scanMessage(rows, &hit.Score); err != nil {
+			return nil, err
+		}
+
+		hit.Snippet = Snippet(hit.Message.Content, terms)
+		hits = append(hits, hit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate search results: %w", err)
+	}
+
+	return hits, nil
+}
+
+func (m *MySQL) RetentionRules() ([]RetentionRule, error) {
+	rows, err := m.db.Query(selectRetentionRules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch retention rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := make([]RetentionRule, 0)
+
+	for rows.Next() {
+		var rule RetentionRule
+
+		if err := rows.Scan(&rule.GuildID, &rule.ChannelID, &rule.Mode, &rule.Amount, &rule.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention rule: %w", err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate retention rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+func (m *MySQL) SaveRetentionRule(rule *RetentionRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	_, err := m.db.Execute(insertRetentionRule, rule.GuildID, rule.ChannelID, rule.Mode, rule.Amount, rule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store retention rule: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteRetentionRule(guildID, channelID string) error {
+	result, err := m.db.Execute(deleteRetentionRule, guildID, channelID)
+	if err != nil {
+		return fmt.Errorf("failed to delete retention rule: %w", err)
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return fmt.Errorf("%w: retention rule", ErrNotFound)
+	}
+
+	return nil
+}
+
+func (m *MySQL) StoredChannels(guildID string) ([]string, error) {
+	rows, err := m.db.Query(selectStoredChannels, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch channels of guild %s: %w", guildID, err)
+	}
+	defer rows.Close()
+
+	channels := make([]string, 0)
+
+	for rows.Next() {
+		var channelID string
+		if err := rows.Scan(&channelID); err != nil {
+			return nil, fmt.Errorf("failed to scan channel ID: %w", err)
+		}
+
+		channels = append(channels, channelID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate channels: %w", err)
+	}
+
+	return channels, nil
+}
+
+func (m *MySQL) PruneMessages(channelID string, before time.Time, keep, limit int) ([]string, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	if keep > 0 {
+		var count int
+		if err := tx.QueryRow(countChannelMessages, channelID).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count messages of channel %s: %w", channelID, err)
+		}
+
+		if count-keep < limit {
+			limit = count - keep
+		}
+	}
+
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	filter, args := "", []interface{}{channelID}
+	if !before.IsZero() {
+		filter, args = "AND timestamp < ?", append(args, before)
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(selectPrunable, filter), append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages to prune: %w", err)
+	}
+
+	var (
+		ids     []string
+		guildID sql.NullString
+		newest  time.Time
+	)
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id, &guildID, &newest); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan message to prune: %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate messages to prune: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	err = deleteByMessageID(tx, ids, deleteMessageReactions, deleteMessageMentions, deleteMessageMediaSources, deleteMessagesByID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prune messages of channel %s: %w", channelID, err)
+	}
+
+	_, err = tx.Exec(insertPrunedHistory, channelID, guildID, newest, len(ids), time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to record pruned history of channel %s: %w", channelID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return ids, nil
+}
+
+func (m *MySQL) PrunedHistory(channelID string) (*PrunedHistory, error) {
+	var (
+		pruned  PrunedHistory
+		guildID sql.NullString
+	)
+
+	err := m.db.QueryRow(selectPrunedHistory, channelID).Scan(
+		&pruned.ChannelID, &guildID, &pruned.Before, &pruned.Messages, &pruned.PrunedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: pruned history of channel %s", ErrNotFound, channelID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pruned history of channel %s: %w", channelID, err)
+	}
+
+	pruned.GuildID = guildID.String
+
+	return &pruned, nil
+}
+
+func (m *MySQL) ArchivableChannels(before time.Time) ([]string, error) {
+	rows, err := m.db.Query(selectArchivableChannels, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archivable channels: %w", err)
+	}
+	defer rows.Close()
+
+	channels := make([]string, 0)
+
+	for rows.Next() {
+		var channelID string
+		if err := rows.Scan(&channelID); err != nil {
+			return nil, fmt.Errorf("failed to scan channel ID: %w", err)
+		}
+
+		channels = append(channels, channelID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate channels: %w", err)
+	}
+
+	return channels, nil
+}
+
+func (m *MySQL) ArchivedPartitions(channelID string) ([]ArchivedPartition, error) {
+	rows, err := m.db.Query(selectArchivedPartitions, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch archived partitions of channel %s: %w", channelID, err)
+	}
+	defer rows.Close()
+
+	partitions := make([]ArchivedPartition, 0)
+
+	for rows.Next() {
+		var (
+			partition ArchivedPartition
+			guildID   sql.NullString
+		)
+
+		err := rows.Scan(&partition.ChannelID, &guildID, &partition.Month, &partition.Key, &partition.Messages,
+			&partition.First, &partition.Last, &partition.ArchivedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan archived partition: %w", err)
+		}
+
+		partition.GuildID = guildID.String
+		partitions = append(partitions, partition)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate archived partitions: %w", err)
+	}
+
+	return partitions, nil
+}
+
+func (m *MySQL) ArchivePartition(partition *ArchivedPartition, messageIDs []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	_, err = tx.Exec(insertArchivedPartition, partition.ChannelID, nullString(partition.GuildID), partition.Month,
+		partition.Key, partition.Messages, partition.First, partition.Last, partition.ArchivedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record archived partition %s: %w", partition.Key, err)
+	}
+
+	// MySQL limits the placeholders of a statement.
+	for start := 0; start < len(messageIDs); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(messageIDs) {
+			end = len(messageIDs)
+		}
+
+		err := deleteByMessageID(tx, messageIDs[start:end], deleteMessageMentions, deleteMessagesByID)
+		if err != nil {
+			return fmt.Errorf("failed to delete archived messages of %s: %w", partition.Key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) PruneArchivedPartition(partition *ArchivedPartition, messageIDs []string, newest time.Time) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	if partition.Messages == 0 {
+		_, err = tx.Exec(deleteArchivedPartition, partition.ChannelID, partition.Month)
+	} else {
+		_, err = tx.Exec(insertArchivedPartition, partition.ChannelID, nullString(partition.GuildID), partition.Month,
+			partition.Key, partition.Messages, partition.First, partition.Last, partition.ArchivedAt)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to record pruned partition %s: %w", partition.Key, err)
+	}
+
+	_, err = tx.Exec(insertPrunedHistory, partition.ChannelID, nullString(partition.GuildID), newest, len(messageIDs),
+		time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record pruned history of channel %s: %w", partition.ChannelID, err)
+	}
+
+	// MySQL limits the placeholders of a statement.
+	for start := 0; start < len(messageIDs); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(messageIDs) {
+			end = len(messageIDs)
+		}
+
+		err := deleteByMessageID(tx, messageIDs[start:end], deleteMessageReactions, deleteMessageMediaSources)
+		if err != nil {
+			return fmt.Errorf("failed to delete pruned messages of %s: %w", partition.Key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) ChannelMessageCount(channelID string) (int, error) {
+	var count int
+	if err := m.db.QueryRow(countChannelMessages, channelID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count messages of channel %s: %w", channelID, err)
+	}
+
+	return count, nil
+}
+
+func (m *MySQL) MirroredMedia(sources []string) (map[string]MediaObject, error) {
+	mirrored := make(map[string]MediaObject)
+	if len(sources) == 0 {
+		return mirrored, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sources)), ", ")
+	args := make([]interface{}, len(sources))
+
+	for i, source := range sources {
+		args[i] = source
+	}
+
+	rows, err := m.db.Query(fmt.Sprintf(selectMirroredMedia, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mirrored media: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			source string
+			object MediaObject
+		)
+
+		if err := rows.Scan(&source, &object.Hash, &object.ContentType, &object.Size, &object.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan mirrored media: %w", err)
+		}
+
+		mirrored[source] = object
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate mirrored media: %w", err)
+	}
+
+	return mirrored, nil
+}
+
+func (m *MySQL) SaveMedia(source, guildID, messageID string, object *MediaObject) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	if _, err := tx.Exec(insertMediaObject, object.Hash, object.ContentType, object.Size, object.CreatedAt); err != nil {
+		return fmt.Errorf("failed to store media object %s: %w", object.Hash, err)
+	}
+
+	_, err = tx.Exec(insertMediaSource, source, nullString(guildID), nullString(messageID), object.Hash, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store media source %s: %w", source, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) MediaSource(source string) (*MediaObject, string, error) {
+	var (
+		object  MediaObject
+		guildID sql.NullString
+	)
+
+	err := m.db.QueryRow(selectMediaSource, source).Scan(&guildID, &object.Hash, &object.ContentType, &object.Size, &object.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, "", fmt.Errorf("%w: media source %s", ErrNotFound, source)
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch media source %s: %w", source, err)
+	}
+
+	return &object, guildID.String, nil
+}
+
+func (m *MySQL) UnreferencedMedia() ([]string, error) {
+	rows, err := m.db.Query(selectUnreferencedMedia)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch unreferenced media: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := make([]string, 0)
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan unreferenced media: %w", err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate unreferenced media: %w", err)
+	}
+
+	return hashes, nil
+}
+
+func (m *MySQL) DeleteMediaObject(hash string) (bool, error) {
+	deleted, err := m.db.Execute(deleteUnreferencedMediaObject, hash, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete media object %s: %w", hash, err)
+	}
+
+	affected, err := deleted.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count deleted media objects: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (m *MySQL) MediaObject(hash string) (*MediaObject, error) {
+	var object MediaObject
+
+	err := m.db.QueryRow(selectMediaObject, hash).Scan(&object.Hash, &object.ContentType, &object.Size, &object.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: media object %s", ErrNotFound, hash)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch media object %s: %w", hash, err)
+	}
+
+	return &object, nil
+}
+
+func (m *MySQL) DeleteGuild(guildID string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	for _, statement := range []string{
+		deleteGuildMemberRoles, deleteGuildMembers, deleteGuildRoles, deleteGuildChannelOverwrites, deleteGuildChannels,
+		deleteGuild,
+	} {
+		if _, err := tx.Exec(statement, guildID); err != nil {
+			return fmt.Errorf("failed to delete guild %s: %w", guildID, err)
+		}
+	}
+
+	if _, err := tx.Exec(closeGuildVoiceSessions, time.Now().UTC(), guildID); err != nil {
+		return fmt.Errorf("failed to close voice sessions of guild %s: %w", guildID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteChannel(channelID string) error {
+	if err := m.execAll([]string{deleteChannelOverwrites, deleteChannel}, channelID); err != nil {
+		return fmt.Errorf("failed to delete channel %s: %w", channelID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) UpdateMessage(message *discordgo.Message) error {
+	_, err := m.db.Execute(updateMessage, message.Content, message.Pinned, message.EditedTimestamp, message.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update message %s: %w", message.ID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteMessage(messageID string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	err = deleteByMessageID(tx, []string{messageID}, deleteMessageReactions, deleteMessageMentions,
+		deleteMessageMediaSources, deleteMessagesByID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message %s: %w", messageID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveUser(user *discordgo.User) error {
+	if _, err := m.db.Execute(upsertAuthor, user.ID, user.Username, user.Avatar, user.Bot, user.System); err != nil {
+		return fmt.Errorf("failed to store user %s: %w", user.ID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveMember(member *discordgo.Member) error {
+	user := member.User
+	if user == nil {
+		return nil
+	}
+
+	var joinedAt, premiumSince sql.NullTime
+
+	if !member.JoinedAt.IsZero() {
+		joinedAt = sql.NullTime{Time: member.JoinedAt, Valid: true}
+	}
+
+	if member.PremiumSince != nil {
+		premiumSince = sql.NullTime{Time: *member.PremiumSince, Valid: true}
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	if _, err := tx.Exec(upsertAuthor, user.ID, user.Username, user.Avatar, user.Bot, user.System); err != nil {
+		return fmt.Errorf("failed to store user %s: %w", user.ID, err)
+	}
+
+	_, err = tx.Exec(upsertMember, user.ID, member.GuildID, user.ID, nullString(member.Nick), nullString(member.Avatar),
+		joinedAt, premiumSince, member.Pending, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store member %s of guild %s: %w", user.ID, member.GuildID, err)
+	}
+
+	if _, err := tx.Exec(deleteMemberRoles, member.GuildID, user.ID); err != nil {
+		return fmt.Errorf("failed to clear roles of member %s: %w", user.ID, err)
+	}
+
+	for _, roleID := range member.Roles {
+		if _, err := tx.Exec(insertMemberRole, member.GuildID, user.ID, roleID); err != nil {
+			return fmt.Errorf("failed to store role of member %s: %w", user.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteMember(guildID, userID string) error {
+	if err := m.execAll([]string{deleteMemberRoles, deleteMember}, guildID, userID); err != nil {
+		return fmt.Errorf("failed to remove member %s of guild %s: %w", userID, guildID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteStaleMembers(guildID string, syncedAt time.Time) error {
+	if err := m.execAll([]string{deleteStaleMemberRoles, deleteStaleMembers}, guildID, syncedAt); err != nil {
+		return fmt.Errorf("failed to remove departed members of guild %s: %w", guildID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) Members(guildID, afterID string, limit int) ([]Member, error) {
+	rows, err := m.db.Query(selectMembers, guildID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch members of guild %s: %w", guildID, err)
+	}
+	defer rows.Close()
+
+	members := make([]Member, 0)
+
+	for rows.Next() {
+		var (
+			member                          Member
+			username, avatar, nick, gAvatar sql.NullString
+			bot                             sql.NullBool
+			joinedAt, premiumSince          sql.NullTime
+			roles                           string
+		)
+
+		err := rows.Scan(&member.UserID, &username, &avatar, &bot, &nick, &gAvatar, &joinedAt, &premiumSince,
+			&member.Pending, &member.Color, &roles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+
+		member.Username, member.Avatar, member.Bot = username.String, avatar.String, bot.Bool
+		member.Nick, member.GuildAvatar = nick.String, gAvatar.String
+		member.Roles = []string{}
+
+		if roles != "" {
+			member.Roles = strings.Split(roles, ",")
+		}
+
+		if joinedAt.Valid {
+			member.JoinedAt = &joinedAt.Time
+		}
+
+		if premiumSince.Valid {
+			member.PremiumSince = &premiumSince.Time
+		}
+
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate members: %w", err)
+	}
+
+	return members, nil
+}
+
+func (m *MySQL) SaveRole(guildID string, role *discordgo.Role) error {
+	_, err := m.db.Execute(upsertRole, role.ID, guildID, role.Name, role.Color, role.Position, role.Permissions,
+		role.Hoist, role.Mentionable, role.Managed)
+	if err != nil {
+		return fmt.Errorf("failed to store role %s: %w", role.ID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) ReplaceRoles(guildID string, roles []*discordgo.Role) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	if _, err := tx.Exec(deleteGuildRoles, guildID); err != nil {
+		return fmt.Errorf("failed to clear roles of guild %s: %w", guildID, err)
+	}
+
+	for _, role := range roles {
+		_, err := tx.Exec(upsertRole, role.ID, guildID, role.Name, role.Color, role.Position, role.Permissions,
+			role.Hoist, role.Mentionable, role.Managed)
+		if err != nil {
+			return fmt.Errorf("failed to store role %s: %w", role.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteRole(roleID string) error {
+	if err := m.execAll([]string{deleteRoleMembers, deleteRole}, roleID); err != nil {
+		return fmt.Errorf("failed to delete role %s: %w", roleID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) Roles(guildID string) ([]discordgo.Role, error) {
+	rows, err := m.db.Query(selectRoles, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch roles of guild %s: %w", guildID, err)
+	}
+	defer rows.Close()
+
+	roles := make([]discordgo.Role, 0)
+
+	for rows.Next() {
+		var role discordgo.Role
+
+		err := rows.Scan(&role.ID, &role.Name, &role.Color, &role.Position, &role.Permissions, &role.Hoist,
+			&role.Mentionable, &role.Managed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+func (m *MySQL) SavePresenceSample(guildID string, presence *discordgo.Presence, sampledAt time.Time) error {
+	var activityType, activityName interface{}
+
+	if len(presence.Activities) > 0 {
+		activityType, activityName = presence.Activities[0].Type, presence.Activities[0].Name
+	}
+
+	_, err := m.db.Execute(insertPresenceSample, guildID, presence.User.ID, sampledAt, presence.Status,
+		activityType, activityName)
+	if err != nil {
+		return fmt.Errorf("failed to sample presence of %s: %w", presence.User.ID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveThread(thread *discordgo.Channel) error {
+	var (
+		archived, locked bool
+		archivedAt       sql.NullTime
+	)
+
+	if metadata := thread.ThreadMetadata; metadata != nil {
+		archived, locked = metadata.Archived, metadata.Locked
+
+		if !metadata.ArchiveTimestamp.IsZero() {
+			archivedAt = sql.NullTime{Time: metadata.ArchiveTimestamp, Valid: true}
+		}
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	_, err = tx.Exec(insertThread, thread.ID, thread.GuildID, thread.Name, thread.NSFW, thread.Position, thread.Type,
+		nullString(thread.ParentID), nullString(thread.OwnerID), archived, locked, archivedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store thread %s: %w", thread.ID, err)
+	}
+
+	if _, err := tx.Exec(deleteThreadTags, thread.ID); err != nil {
+		return fmt.Errorf("failed to clear tags of thread %s: %w", thread.ID, err)
+	}
+
+	for _, tagID := range thread.AppliedTags {
+		if _, err := tx.Exec(insertThreadTag, thread.ID, tagID); err != nil {
+			return fmt.Errorf("failed to store tag of thread %s: %w", thread.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteThread(threadID string) error {
+	if err := m.execAll([]string{deleteChannel, deleteThreadTags, deleteThreadMembers}, threadID); err != nil {
+		return fmt.Errorf("failed to delete thread %s: %w", threadID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) ArchiveThreads(guildID string, parentIDs []string) error {
+	if len(parentIDs) == 0 {
+		if _, err := m.db.Execute(archiveStaleGuildThreads, guildID); err != nil {
+			return fmt.Errorf("failed to archive stale threads of guild %s: %w", guildID, err)
+		}
+
+		return nil
+	}
+
+	for _, parentID := range parentIDs {
+		if _, err := m.db.Execute(archiveStaleThreads, parentID); err != nil {
+			return fmt.Errorf("failed to archive stale threads of %s: %w", parentID, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveThreadMember(threadID, userID string, joinedAt time.Time) error {
+	if _, err := m.db.Execute(insertThreadMember, threadID, userID, joinedAt); err != nil {
+		return fmt.Errorf("failed to store member of thread %s: %w", threadID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteThreadMember(threadID, userID string) error {
+	if _, err := m.db.Execute(deleteThreadMember, threadID, userID); err != nil {
+		return fmt.Errorf("failed to remove member of thread %s: %w", threadID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveForumTags(channel *discordgo.Channel) error {
+	for _, tag := range channel.AvailableTags {
+		_, err := m.db.Execute(insertForumTag, tag.ID, channel.ID, tag.Name, nullString(tag.EmojiID),
+			nullString(tag.EmojiName), tag.Moderated)
+		if err != nil {
+			return fmt.Errorf("failed to store tag %s of forum %s: %w", tag.ID, channel.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *MySQL) ThreadBackfilled(threadID string) (bool, error) {
+	var backfilledAt sql.NullTime
+
+	err := m.db.QueryRow(selectThreadBackfilled, threadID).Scan(&backfilledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("%w: thread %s", ErrNotFound, threadID)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch thread %s: %w", threadID, err)
+	}
+
+	return backfilledAt.Valid, nil
+}
+
+func (m *MySQL) MarkThreadBackfilled(threadID string, at time.Time) error {
+	if _, err := m.db.Execute(updateThreadBackfilled, at, threadID); err != nil {
+		return fmt.Errorf("failed to mark thread %s as backfilled: %w", threadID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) Threads(parentID string, archived *bool) ([]Thread, error) {
+	var state interface{}
+	if archived != nil {
+		state = *archived
+	}
+
+	rows, err := m.db.Query(selectThreads, parentID, state, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch threads of %s: %w", parentID, err)
+	}
+	defer rows.Close()
+
+	threads := make([]Thread, 0)
+
+	for rows.Next() {
+		var (
+			thread                   Thread
+			guildID, parent, ownerID sql.NullString
+			archivedAt               sql.NullTime
+			tags                     string
+		)
+
+		err := rows.Scan(&thread.ID, &guildID, &parent, &ownerID, &thread.Name, &thread.Type, &thread.Archived,
+			&thread.Locked, &archivedAt, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan thread: %w", err)
+		}
+
+		thread.GuildID, thread.ParentID, thread.OwnerID = guildID.String, parent.String, ownerID.String
+		thread.AppliedTags = []string{}
+
+		if tags != "" {
+			thread.AppliedTags = strings.Split(tags, ",")
+		}
+
+		if archivedAt.Valid {
+			thread.ArchiveTimestamp = &archivedAt.Time
+		}
+
+		threads = append(threads, thread)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate threads: %w", err)
+	}
+
+	return threads, nil
+}
+
+func (m *MySQL) SaveReaction(reaction *discordgo.MessageReaction) error {
+	_, err := m.db.Execute(insertReaction, reaction.MessageID, reaction.UserID, reaction.Emoji.APIName(),
+		nullString(reaction.Emoji.ID), reaction.Emoji.Name, reaction.ChannelID, nullString(reaction.GuildID),
+		time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store reaction on %s: %w", reaction.MessageID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteReaction(messageID, userID, emoji string) error {
+	if _, err := m.db.Execute(deleteReaction, messageID, userID, emoji); err != nil {
+		return fmt.Errorf("failed to delete reaction on %s: %w", messageID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteReactions(messageID, emoji string) error {
+	var err error
+
+	if emoji == "" {
+		_, err = m.db.Execute(deleteAllReactions, messageID)
+	} else {
+		_, err = m.db.Execute(deleteEmojiReactions, messageID, emoji)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to clear reactions on %s: %w", messageID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) ReactionCounts(messageIDs []string, userID string) (map[string][]*discordgo.MessageReactions, error) {
+	counts := make(map[string][]*discordgo.MessageReactions)
+
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	args := make([]interface{}, 0, len(messageIDs)+1)
+	args = append(args, userID)
+
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messageIDs)), ",")
+
+	rows, err := m.db.Query(fmt.Sprintf(selectReactionCounts, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			messageID, apiName, name string
+			emojiID                  sql.NullString
+			count, me                int
+		)
+
+		if err := rows.Scan(&messageID, &apiName, &emojiID, &name, &count, &me); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction count: %w", err)
+		}
+
+		counts[messageID] = append(counts[messageID], &discordgo.MessageReactions{
+			Count: count,
+			Me:    me > 0,
+			Emoji: &discordgo.Emoji{ID: emojiID.String, Name: name},
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate reaction counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (m *MySQL) TopReacted(guildID, channelID string, limit int) ([]ReactedMessage, error) {
+	column, id := "channel_id", channelID
+	if guildID != "" {
+		column, id = "guild_id", guildID
+	}
+
+	rows, err := m.db.Query(fmt.Sprintf(selectTopReacted, column), id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch top reactions: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]ReactedMessage, 0, limit)
+
+	for rows.Next() {
+		var message ReactedMessage
+		if err := rows.Scan(&message.MessageID, &message.ChannelID, &message.Content, &message.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan top reactions: %w", err)
+		}
+
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate top reactions: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (m *MySQL) RecordVoiceState(state *discordgo.VoiceState, at time.Time) (string, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	var (
+		openID            int64
+		previousChannelID string
+		joinedAt          time.Time
+	)
+
+	err = tx.QueryRow(selectOpenVoiceSession, state.GuildID, state.UserID).Scan(&openID, &previousChannelID, &joinedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to read voice session of %s: %w", state.UserID, err)
+	}
+
+	if _, err := tx.Exec(closeVoiceSessions, at, state.GuildID, state.UserID); err != nil {
+		return "", fmt.Errorf("failed to close voice session of %s: %w", state.UserID, err)
+	}
+
+	if state.ChannelID != "" {
+		if previousChannelID != state.ChannelID {
+			joinedAt = at
+		}
+
+		_, err := tx.Exec(insertVoiceSession, state.GuildID, state.ChannelID, state.UserID, nullString(state.SessionID),
+			joinedAt, at, state.Mute, state.Deaf, state.SelfMute, state.SelfDeaf, state.SelfStream, state.SelfVideo,
+			state.Suppress)
+		if err != nil {
+			return "", fmt.Errorf("failed to open voice session of %s: %w", state.UserID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return previousChannelID, nil
+}
+
+func (m *MySQL) CloseVoiceSessions(guildID string, at time.Time) error {
+	if _, err := m.db.Execute(closeGuildVoiceSessions, at, guildID); err != nil {
+		return fmt.Errorf("failed to close voice sessions of guild %s: %w", guildID, err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) VoiceOccupancy(guildID string) ([]VoiceChannel, error) {
+	rows, err := m.db.Query(selectVoiceOccupancy, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch voice occupancy of guild %s: %w", guildID, err)
+	}
+	defer rows.Close()
+
+	channels := make([]VoiceChannel, 0)
+
+	for rows.Next() {
+		var (
+			member         VoiceMember
+			channelID      string
+			username, nick sql.NullString
+		)
+
+		err := rows.Scan(&channelID, &member.UserID, &username, &nick, &member.JoinedAt, &member.Mute, &member.Deaf,
+			&member.SelfMute, &member.SelfDeaf, &member.SelfStream, &member.SelfVideo, &member.Suppress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan voice member: %w", err)
+		}
+
+		member.Username, member.Nick = username.String, nick.String
+
+		if len(channels) == 0 || channels[len(channels)-1].ChannelID != channelID {
+			channels = append(channels, VoiceChannel{ChannelID: channelID, Members: []VoiceMember{}})
+		}
+
+		last := &channels[len(channels)-1]
+		last.Members = append(last.Members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate voice members: %w", err)
+	}
+
+	return channels, nil
+}
+
+func (m *MySQL) VoiceTimes(guildID string, since, until time.Time) ([]VoiceTime, error) {
+	rows, err := m.db.Query(selectVoiceTimes, since, until, since, until, guildID, until, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch voice times of guild %s: %w", guildID, err)
+	}
+	defer rows.Close()
+
+	times := make([]VoiceTime, 0)
+
+	for rows.Next() {
+		var (
+			voiceTime VoiceTime
+			username  sql.NullString
+		)
+
+		if err := rows.Scan(&voiceTime.UserID, &username, &voiceTime.Seconds, &voiceTime.StreamedSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan voice time: %w", err)
+		}
+
+		voiceTime.Username = username.String
+		times = append(times, voiceTime)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate voice times: %w", err)
+	}
+
+	return times, nil
+}
+
+func (m *MySQL) SaveInteractionState(id, route string, data []byte, expiresAt time.Time) error {
+	var expires sql.NullTime
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt, Valid: true}
+	}
+
+	if _, err := m.db.Execute(insertInteractionState, id, route, string(data), time.Now().UTC(), expires); err != nil {
+		return fmt.Errorf("failed to store interaction state: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) InteractionState(id string) ([]byte, error) {
+	var data string
+
+	err := m.db.QueryRow(selectInteractionState, id, time.Now().UTC()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: interaction state %s", ErrNotFound, id)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load interaction state: %w", err)
+	}
+
+	return []byte(data), nil
+}
+
+func (m *MySQL) DeleteInteractionState(id string) error {
+	if _, err := m.db.Execute(deleteInteractionState, id); err != nil {
+		return fmt.Errorf("failed to delete interaction state: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DeleteExpiredInteractionState() error {
+	if _, err := m.db.Execute(deleteExpiredInteractionState, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to prune interaction state: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) SaveDMChannel(channel *discordgo.Channel) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	var recipientID interface{}
+
+	if len(channel.Recipients) > 0 {
+		user := channel.Recipients[0]
+		recipientID = user.ID
+
+		if _, err := tx.Exec(upsertAuthor, user.ID, user.Username, user.Avatar, user.Bot, user.System); err != nil {
+			return fmt.Errorf("failed to store recipient of DM channel %s: %w", channel.ID, err)
+		}
+	}
+
+	if _, err := tx.Exec(insertDMChannel, channel.ID, channel.Type, recipientID); err != nil {
+		return fmt.Errorf("failed to store DM channel %s: %w", channel.ID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MySQL) DMChannels() ([]DMChannel, error) {
+	rows, err := m.db.Query(selectDMChannels, discordgo.ChannelTypeDM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch DM channels: %w", err)
+	}
+	defer rows.Close()
+
+	channels := make([]DMChannel, 0)
+
+	for rows.Next() {
+		var (
+			channel                       DMChannel
+			recipientID, username, avatar sql.NullString
+			lastMessageAt                 sql.NullTime
+		)
+
+		if err := rows.Scan(&channel.ID, &recipientID, &username, &avatar, &lastMessageAt); err != nil {
+			return nil, fmt.Errorf("failed to scan DM channel: %w", err)
+		}
+
+		channel.RecipientID, channel.RecipientName, channel.RecipientAvatar = recipientID.String, username.String, avatar.String
+
+		if lastMessageAt.Valid {
+			channel.LastMessageAt = &lastMessageAt.Time
+		}
+
+		channels = append(channels, channel)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate DM channels: %w", err)
+	}
+
+	return channels, nil
+}
+
+// execAll runs statements taking the same arguments in one transaction.
+func (m *MySQL) execAll(statements []string, args ...interface{}) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			m.logger.Error("%v", err)
+		}
+	}()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, args...); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+
+	return nil
+}
+
+// deleteByMessageID runs deletes completed with a placeholder per message ID.
+func deleteByMessageID(tx *sql.Tx, ids []string, statements ...string) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(fmt.Sprintf(statement, placeholders), args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// booleanMatch builds the full-text search expression requiring every word and phrase
+// of a query. Only letters and digits reach it, so user input can't add operators.
+func booleanMatch(query *SearchQuery) string {
+	required := make([]string, 0)
+
+	for _, term := range SearchTerms(query.Text) {
+		required = append(required, "+"+term)
+	}
+
+	for _, phrase := range query.Phrases {
+		if words := SearchTerms(phrase); len(words) > 0 {
+			required = append(required, `+"`+strings.Join(words, " ")+`"`)
+		}
+	}
+
+	return strings.Join(required, " ")
+}
+
+// scanMessage reads a row of selectMessages or searchMessages, scanning any columns
+// after the message's into extra.
+func scanMessage(rows *sql.Rows, extra ...interface{}) (discordgo.Message, error) {
+	var (
+		message                    discordgo.Message
+		timestamp, editedTimestamp sql.NullTime
+		// DM messages have no guild and no member.
+		guildID, nick, memberAvatar sql.NullString
+		attachments, embeds         sql.NullString
+	)
+
+	message.Author = &discordgo.User{}
+
+	dest := []interface{}{
+		&message.ID,
+		&message.ChannelID,
+		&guildID,
+		&message.Author.ID,
+		&message.Pinned,
+		&message.Type,
+		&message.Content,
+		&timestamp,
+		&editedTimestamp,
+		&message.Author.Username,
+		&message.Author.Avatar,
+		&message.Author.Bot,
+		&nick,
+		&memberAvatar,
+		&attachments,
+		&embeds,
+	}
+
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return message, fmt.Errorf("failed to scan message: %w", err)
+	}
+
+	message.GuildID = guildID.String
+	message.Timestamp = timestamp.Time
+
+	if guildID.Valid {
+		message.Member = &discordgo.Member{Nick: nick.String, Avatar: memberAvatar.String}
+	}
+
+	if editedTimestamp.Valid {
+		message.EditedTimestamp = &editedTimestamp.Time
+	}
+
+	if attachments.Valid {
+		if err := json.Unmarshal([]byte(attachments.String), &message.Attachments); err != nil {
+			return message, fmt.Errorf("failed to decode attachments of message %s: %w", message.ID, err)
+		}
+	}
+
+	if embeds.Valid {
+		if err := json.Unmarshal([]byte(embeds.String), &message.Embeds); err != nil {
+			return message, fmt.Errorf("failed to decode embeds of message %s: %w", message.ID, err)
+		}
+	}
+
+	return message, nil
+}
+
+// encodeExtras encodes the attachments and embeds of a message for storage, as NULL
+// when there are none.
+func encodeExtras(message *discordgo.Message) (attachments, embeds interface{}, err error) {
+	if len(message.Attachments) > 0 {
+		encoded, err := json.Marshal(message.Attachments)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode attachments of message %s: %w", message.ID, err)
+		}
+
+		attachments = string(encoded)
+	}
+
+	if len(message.Embeds) > 0 {
+		encoded, err := json.Marshal(message.Embeds)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode embeds of message %s: %w", message.ID, err)
+		}
+
+		embeds = string(encoded)
+	}
+
+	return attachments, embeds, nil
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"database/sql/driver"
+	"discord-go-connect/internal/db/dbtest"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMySQLSaveMessagesRollsBackFailedMessage(t *testing.T) {
+	manager, rec := dbtest.NewRecorder(t)
+	mysql := NewMySQL(manager)
+
+	// The mention of message 2 fails after its author, member and message are written.
+	rec.Respond(func(exec dbtest.Execution) (int64, error) {
+		if strings.HasPrefix(exec.Query, "INSERT IGNORE INTO MessageMention") && exec.Args[0] == "2" {
+			return 0, errors.New("deadlock")
+		}
+
+		return 1, nil
+	})
+
+	batch := make([]*discordgo.MessageCreate, 0, 3)
+
+	for _, id := range []string{"1", "2", "3"} {
+		batch = append(batch, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID: id, GuildID: "1", ChannelID: "10", Author: &discordgo.User{ID: "42"}, Member: &discordgo.Member{},
+			Mentions: []*discordgo.User{{ID: "43"}}, Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}})
+	}
+
+	result, err := mysql.SaveMessages(batch)
+	if err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	if result.Inserted != 2 || len(result.Failed) != 1 || result.Failed["2"] == nil {
+		t.Fatalf("result = %+v, want 1 and 3 inserted and 2 failed", result)
+	}
+
+	var statements []string
+
+	for _, exec := range rec.Executions("") {
+		if exec.Query == "BEGIN" || exec.Query == "COMMIT" || strings.HasPrefix(exec.Query, "SAVEPOINT") ||
+			strings.HasPrefix(exec.Query, "ROLLBACK") || strings.HasPrefix(exec.Query, "RELEASE") {
+			statements = append(statements, exec.Query)
+			continue
+		}
+
+		// Inserts are named by their table.
+		_, table, _ := strings.Cut(exec.Query, "INTO ")
+		statements = append(statements, strings.Fields(table)[0])
+	}
+
+	message := []string{"SAVEPOINT msg", "Author", "Member", "Message", "MessageMention"}
+	want := append([]string{"BEGIN"}, message...)
+	want = append(want, "RELEASE SAVEPOINT msg")
+	want = append(want, message...)
+	want = append(want, "ROLLBACK TO SAVEPOINT msg")
+	want = append(want, message...)
+	want = append(want, "RELEASE SAVEPOINT msg", "COMMIT")
+
+	if !reflect.DeepEqual(statements, want) {
+		t.Fatalf("statements = %v, want %v", statements, want)
+	}
+}
+
+func TestMySQLArchiveKeepsMediaSourcesUntilPruned(t *testing.T) {
+	manager, rec := dbtest.NewRecorder(t)
+	mysql := NewMySQL(manager)
+
+	partition := &ArchivedPartition{ChannelID: "10", GuildID: "1", Month: "2024-01", Key: "messages/10/2024-01.jsonl.gz", Messages: 2}
+
+	if err := mysql.ArchivePartition(partition, []string{"1", "2"}); err != nil {
+		t.Fatalf("failed to archive: %v", err)
+	}
+
+	partition.Messages = 0
+	if err := mysql.PruneArchivedPartition(partition, []string{"1", "2"}, time.Now()); err != nil {
+		t.Fatalf("failed to prune the archive: %v", err)
+	}
+
+	var statements []string
+
+	for _, exec := range rec.Executions("") {
+		switch {
+		case exec.Query == "BEGIN" || exec.Query == "COMMIT":
+			statements = append(statements, exec.Query)
+		case strings.HasPrefix(exec.Query, "DELETE FROM MediaSource"):
+			if !reflect.DeepEqual(exec.Args, []driver.Value{"1", "2"}) {
+				t.Errorf("media sources deleted for %v, want messages 1 and 2", exec.Args)
+			}
+
+			statements = append(statements, "MediaSource")
+		}
+	}
+
+	// Archiving leaves the sources alone; pruning the archived messages deletes them.
+	if want := []string{"BEGIN", "COMMIT", "BEGIN", "MediaSource", "COMMIT"}; !reflect.DeepEqual(statements, want) {
+		t.Fatalf("statements = %v, want %v", statements, want)
+	}
+}
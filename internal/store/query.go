@@ -0,0 +1,206 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QueryError is a syntax error in a search query. Column counts characters from 1.
+type QueryError struct {
+	Message string
+	Column  int
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+// QueryRef is a user or a channel named in a search query, as written: an ID, a mention
+// such as <@123> or <#123>, or a name.
+type QueryRef struct {
+	Name   string
+	Column int
+}
+
+// QueryRefs are the users and channels a search query names. They are nil when the
+// query doesn't use the filter.
+type QueryRefs struct {
+	From     *QueryRef
+	In       *QueryRef
+	Mentions *QueryRef
+}
+
+// queryToken is a word, a quoted phrase or a filter of a search query.
+type queryToken struct {
+	key   string
+	value string
+	// column is where the word, the phrase or the value of the filter starts.
+	column int
+	quoted bool
+}
+
+// ParseQuery compiles a query such as `from:carol in:#general before:2024-01-01 has:link
+// pinned:true "exact phrase"` in query.Text into the filters of query. The plain words are
+// left in Text and quoted phrases go to Phrases. Words that look like filters with an
+// unknown key, such as links, are plain words.
+func ParseQuery(query *SearchQuery) (*QueryRefs, error) {
+	tokens, err := tokenizeQuery(query.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := &QueryRefs{}
+	words := make([]string, 0, len(tokens))
+
+	for _, token := range tokens {
+		if token.key == "" {
+			if token.quoted {
+				query.Phrases = append(query.Phrases, token.value)
+			} else {
+				words = append(words, token.value)
+			}
+
+			continue
+		}
+
+		if token.value == "" {
+			return nil, &QueryError{Message: fmt.Sprintf("%s: needs a value", token.key), Column: token.column}
+		}
+
+		if err := applyFilter(query, refs, &token); err != nil {
+			return nil, err
+		}
+	}
+
+	query.Text = strings.Join(words, " ")
+
+	return refs, nil
+}
+
+func applyFilter(query *SearchQuery, refs *QueryRefs, token *queryToken) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &QueryError{Message: fmt.Sprintf(format, args...), Column: token.column}
+	}
+
+	switch token.key {
+	case "from":
+		refs.From = &QueryRef{Name: token.value, Column: token.column}
+	case "in":
+		refs.In = &QueryRef{Name: token.value, Column: token.column}
+	case "mentions":
+		refs.Mentions = &QueryRef{Name: token.value, Column: token.column}
+	case "before", "after", "during", "on":
+		date, dayOnly, ok := parseQueryDate(token.value)
+		if !ok {
+			return invalid("%q is not a date like 2024-01-31", token.value)
+		}
+
+		switch {
+		case token.key == "before":
+			query.Before = date
+		case token.key == "after" && dayOnly:
+			query.After = date.AddDate(0, 0, 1)
+		case token.key == "after":
+			query.After = date
+		default:
+			query.After, query.Before = date, date.AddDate(0, 0, 1)
+		}
+	case "has":
+		switch strings.ToLower(token.value) {
+		case "link":
+			query.HasLink = true
+		case "attachment", "file":
+			query.HasAttachment = true
+		default:
+			return invalid("has: is link, attachment or file, not %q", token.value)
+		}
+	case "pinned":
+		switch strings.ToLower(token.value) {
+		case "true", "yes":
+			pinned := true
+			query.Pinned = &pinned
+		case "false", "no":
+			pinned := false
+			query.Pinned = &pinned
+		default:
+			return invalid("pinned: is true or false, not %q", token.value)
+		}
+	}
+
+	return nil
+}
+
+// queryFilters are the keys ParseQuery understands.
+var queryFilters = map[string]bool{
+	"from": true, "in": true, "mentions": true, "before": true, "after": true, "during": true, "on": true,
+	"has": true, "pinned": true,
+}
+
+func tokenizeQuery(input string) ([]queryToken, error) {
+	runes := []rune(input)
+	tokens := make([]queryToken, 0)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		token := queryToken{column: i + 1}
+		start := i
+
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+			i++
+		}
+
+		if key, value, ok := strings.Cut(string(runes[start:i]), ":"); ok && queryFilters[strings.ToLower(key)] {
+			token.key, token.value = strings.ToLower(key), value
+			token.column = start + len([]rune(key)) + 2
+		} else {
+			token.value = string(runes[start:i])
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			// A quote starts the value of a filter it directly follows, or else a phrase.
+			if i > start && (token.key == "" || token.value != "") {
+				tokens = append(tokens, token)
+				token = queryToken{column: i + 1}
+			}
+
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+
+			if end == len(runes) {
+				return nil, &QueryError{Message: "unterminated quote", Column: i + 1}
+			}
+
+			token.value = string(runes[i+1 : end])
+			token.column = i + 2
+			token.quoted = token.key == ""
+			i = end + 1
+		}
+
+		if token.value != "" || token.key != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// parseQueryDate reads a plain date or an RFC 3339 timestamp and reports which it was.
+func parseQueryDate(value string) (time.Time, bool, bool) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, true, true
+	}
+
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, false, true
+	}
+
+	return time.Time{}, false, false
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pinned, unpinned := true, false
+
+	tests := []struct {
+		input string
+		want  SearchQuery
+		refs  QueryRefs
+	}{
+		{
+			input: `deploy failed`,
+			want:  SearchQuery{Text: "deploy failed"},
+		},
+		{
+			input: `from:carol in:#general before:2024-01-01 has:link pinned:true "exact phrase"`,
+			want:  SearchQuery{Before: day, HasLink: true, Pinned: &pinned, Phrases: []string{"exact phrase"}},
+			refs: QueryRefs{
+				From: &QueryRef{Name: "carol", Column: 6},
+				In:   &QueryRef{Name: "#general", Column: 15},
+			},
+		},
+		{
+			input: `after:2024-01-01 roll "back now"out`,
+			want:  SearchQuery{After: day.AddDate(0, 0, 1), Text: "roll out", Phrases: []string{"back now"}},
+		},
+		{
+			input: `during:2024-01-01 has:file pinned:no`,
+			want:  SearchQuery{After: day, Before: day.AddDate(0, 0, 1), HasAttachment: true, Pinned: &unpinned},
+		},
+		{
+			input: `mentions:"<@42>" https://example.com/x FROM:<@!7>`,
+			want:  SearchQuery{Text: "https://example.com/x"},
+			refs: QueryRefs{
+				From:     &QueryRef{Name: "<@!7>", Column: 45},
+				Mentions: &QueryRef{Name: "<@42>", Column: 11},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		query := SearchQuery{Text: test.input}
+
+		refs, err := ParseQuery(&query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.input, err)
+		}
+
+		if !reflect.DeepEqual(query, test.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", test.input, query, test.want)
+		}
+
+		if !reflect.DeepEqual(*refs, test.refs) {
+			t.Errorf("%s: refs = %+v, want %+v", test.input, *refs, test.refs)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		column int
+	}{
+		{`deploy "failed`, 8},
+		{`from: carol`, 6},
+		{`x before:yesterday`, 10},
+		{`has:image`, 5},
+		{`pinned:maybe`, 8},
+		{`in:""`, 5},
+	}
+
+	for _, test := range tests {
+		query := SearchQuery{Text: test.input}
+
+		_, err := ParseQuery(&query)
+
+		var queryErr *QueryError
+		if !errors.As(err, &queryErr) || queryErr.Column != test.column {
+			t.Errorf("%s: err = %v, want a syntax error at column %d", test.input, err, test.column)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	content := "a long preamble that goes on and on before it finally says Deploy & roll back <now>"
+
+	want := "…goes on and on before it finally says <mark>Deploy</mark> &amp; <mark>roll back</mark> &lt;now&gt;"
+	if got := Snippet(content, []string{"deploy", "roll back"}); got != want {
+		t.Fatalf("snippet =\n%q, want\n%q", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+package store
+
+import "github.com/bwmarrin/discordgo"
+
+// ReactedMessage is a message with the number of reactions it got.
+type ReactedMessage struct {
+	MessageID string `json:"message_id"`
+	ChannelID string `json:"channel_id"`
+	Content   string `json:"content"`
+	Total     int    `json:"total"`
+}
+
+// Reactions stores who reacted to messages with which emoji. Emoji are named as
+// discordgo's Emoji.APIName returns them.
+type Reactions interface {
+	// SaveReaction stores a reaction of a user to a message.
+	SaveReaction(reaction *discordgo.MessageReaction) error
+	// DeleteReaction removes the reaction of a user with an emoji to a message.
+	DeleteReaction(messageID, userID, emoji string) error
+	// DeleteReactions removes the reactions to a message with an emoji, or every reaction
+	// to it when emoji is empty.
+	DeleteReactions(messageID, emoji string) error
+	// ReactionCounts counts the reactions to messages per emoji, by message ID and in the
+	// order the emoji were first used. Me is set where userID reacted.
+	ReactionCounts(messageIDs []string, userID string) (map[string][]*discordgo.MessageReactions, error)
+	// TopReacted returns up to limit messages of a guild, or of a channel when guildID is
+	// empty, with the most reactions first.
+	TopReacted(guildID, channelID string, limit int) ([]ReactedMessage, error)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetentionMode is how much of its history a guild or a channel keeps.
+type RetentionMode string
+
+const (
+	// KeepForever never prunes. On a channel it exempts it from the rule of its guild.
+	KeepForever RetentionMode = "forever"
+	// KeepDays prunes messages older than Amount days.
+	KeepDays RetentionMode = "days"
+	// KeepMessages prunes all but the newest Amount messages of each channel.
+	KeepMessages RetentionMode = "messages"
+)
+
+// ErrInvalidRule is returned for retention rules without a guild, or with an unknown
+// mode or amount.
+var ErrInvalidRule = errors.New("invalid retention rule")
+
+// RetentionRule limits the stored history of a guild or, when ChannelID is set, of one of
+// its channels. Channel rules take precedence over the rule of their guild.
+type RetentionRule struct {
+	UpdatedAt time.Time     `json:"updated_at"`
+	GuildID   string        `json:"guild_id"`
+	ChannelID string        `json:"channel_id,omitempty"`
+	Mode      RetentionMode `json:"mode"`
+	// Amount is the number of days or messages kept.
+	Amount int `json:"amount,omitempty"`
+}
+
+// Validate checks that a rule has a guild, a known mode and a positive amount where the
+// mode needs one.
+func (r *RetentionRule) Validate() error {
+	if r.GuildID == "" {
+		return fmt.Errorf("%w: a guild is required", ErrInvalidRule)
+	}
+
+	switch r.Mode {
+	case KeepForever:
+		r.Amount = 0
+	case KeepDays, KeepMessages:
+		if r.Amount < 1 {
+			return fmt.Errorf("%w: %s needs a positive amount", ErrInvalidRule, r.Mode)
+		}
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidRule, r.Mode)
+	}
+
+	return nil
+}
+
+// PrunedHistory records that the oldest messages of a channel were pruned.
+type PrunedHistory struct {
+	// Before is the time of the newest pruned message; nothing older is stored.
+	Before    time.Time `json:"before"`
+	PrunedAt  time.Time `json:"pruned_at"`
+	ChannelID string    `json:"channel_id"`
+	GuildID   string    `json:"guild_id,omitempty"`
+	// Messages counts every message pruned from the channel.
+	Messages int `json:"messages"`
+}
+
+// Retention stores the retention rules and prunes history.
+type Retention interface {
+	// RetentionRules returns every rule, guild rules before the rules of their channels.
+	RetentionRules() ([]RetentionRule, error)
+	// SaveRetentionRule adds a rule or replaces the one for the same guild and channel.
+	SaveRetentionRule(rule *RetentionRule) error
+	// DeleteRetentionRule removes a rule. It returns ErrNotFound if there was none.
+	DeleteRetentionRule(guildID, channelID string) error
+	// StoredChannels returns the channels of a guild that have stored messages.
+	StoredChannels(guildID string) ([]string, error)
+	// PruneMessages deletes up to limit of the oldest messages of a channel, together
+	// with their reactions and mentions, records them in the channel's PrunedHistory and
+	// returns their IDs. Only messages sent before before, unless it is zero, and not
+	// among the newest keep are pruned.
+	PruneMessages(channelID string, before time.Time, keep, limit int) ([]string, error)
+	// PrunedHistory returns what was pruned from a channel, or ErrNotFound if nothing was.
+	PrunedHistory(channelID string) (*PrunedHistory, error)
+}
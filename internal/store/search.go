@@ -0,0 +1,159 @@
+package store
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// snippetLength is the longest snippet in bytes, not counting the highlight marks.
+	snippetLength = 160
+	// snippetLead is how much of the content before the first match a snippet keeps.
+	snippetLead = 40
+)
+
+// SearchQuery filters and ranks stored messages. Empty fields don't filter.
+type SearchQuery struct {
+	After         time.Time `json:"after"`
+	Before        time.Time `json:"before"`
+	Text          string    `json:"query"`
+	GuildID       string    `json:"guild_id"`
+	ChannelID     string    `json:"channel_id"`
+	AuthorID      string    `json:"author_id"`
+	Mentions      string    `json:"mentions"`
+	Pinned        *bool     `json:"pinned"`
+	HasAttachment bool      `json:"has_attachment"`
+	HasLink       bool      `json:"has_link"`
+	// Phrases have to occur as written, ignoring case. ParseQuery takes them out of Text.
+	Phrases []string `json:"-"`
+	// Guilds limits the search to messages of these guilds when it isn't empty.
+	Guilds []string `json:"-"`
+	Limit  int      `json:"-"`
+	Offset int      `json:"-"`
+}
+
+// SearchHit is a message matching a search, with its relevance and a snippet of its
+// content in which the matched terms are wrapped in <mark> tags. The rest of the
+// snippet is HTML escaped.
+type SearchHit struct {
+	Message discordgo.Message `json:"message"`
+	Snippet string            `json:"snippet"`
+	Score   float64           `json:"score"`
+}
+
+// Searcher finds stored messages, best matches first and newest first among equals.
+type Searcher interface {
+	Search(query *SearchQuery) ([]SearchHit, error)
+}
+
+// Index is a search index kept next to the store, such as an embedded full-text index
+// for installs whose database has none. The bot feeds it every message it stores.
+type Index interface {
+	Searcher
+	// IndexMessages adds messages to the index, replacing earlier versions of them.
+	IndexMessages(messages []*discordgo.Message) error
+	// RemoveMessage drops a deleted message from the index.
+	RemoveMessage(messageID string) error
+}
+
+// matchTerms are the words and phrases a message has to contain, as lower-cased words
+// separated by single spaces.
+func (q *SearchQuery) matchTerms() []string {
+	terms := SearchTerms(q.Text)
+
+	for _, phrase := range q.Phrases {
+		if words := SearchTerms(phrase); len(words) > 0 {
+			terms = append(terms, strings.Join(words, " "))
+		}
+	}
+
+	return terms
+}
+
+// SearchTerms splits search text into the lower-cased words it matches.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Snippet cuts the part of content around the first of the terms and highlights the
+// terms in it. The words of a term may be separated by anything but letters and digits.
+func Snippet(content string, terms []string) string {
+	var matches [][]int
+
+	if len(terms) > 0 {
+		quoted := make([]string, len(terms))
+		for i, term := range terms {
+			words := strings.Fields(term)
+			for j, word := range words {
+				words[j] = regexp.QuoteMeta(word)
+			}
+
+			quoted[i] = strings.Join(words, `[^\pL\pN]+`)
+		}
+
+		matches = regexp.MustCompile(`(?i)`+strings.Join(quoted, "|")).FindAllStringIndex(content, -1)
+	}
+
+	start, end := 0, len(content)
+
+	// Cuts fall on spaces where possible, so the snippet starts and ends with whole words.
+	if len(matches) > 0 && matches[0][0] > snippetLead {
+		start = runeStart(content, matches[0][0]-snippetLead)
+
+		if space := strings.IndexByte(content[start:matches[0][0]], ' '); space >= 0 {
+			start += space + 1
+		}
+	}
+
+	if end-start > snippetLength {
+		end = runeStart(content, start+snippetLength)
+
+		if space := strings.LastIndexByte(content[start:end], ' '); space > 0 {
+			end = start + space
+		}
+	}
+
+	var snippet strings.Builder
+
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+
+	at := start
+
+	for _, match := range matches {
+		if match[0] < at || match[1] > end {
+			continue
+		}
+
+		snippet.WriteString(html.EscapeString(content[at:match[0]]))
+		snippet.WriteString("<mark>" + html.EscapeString(content[match[0]:match[1]]) + "</mark>")
+
+		at = match[1]
+	}
+
+	snippet.WriteString(html.EscapeString(content[at:end]))
+
+	if end < len(content) {
+		snippet.WriteString("…")
+	}
+
+	return snippet.String()
+}
+
+// runeStart moves a byte offset back to the start of the rune it falls in.
+func runeStart(s string, i int) int {
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+
+	return i
+}
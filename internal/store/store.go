@@ -0,0 +1,95 @@
+// Package store persists the guilds, channels and messages the bot sees, reads the
+// stored history back for the API and searches it.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ErrNotFound is returned for lookups of records the store doesn't have.
+var ErrNotFound = errors.New("not found")
+
+// HistoryFilter selects stored messages. Empty fields don't filter.
+type HistoryFilter struct {
+	After     time.Time
+	Before    time.Time
+	GuildID   string
+	ChannelID string
+}
+
+// SaveResult tells how the messages of a batch were stored.
+type SaveResult struct {
+	// Failed holds the errors of the messages that couldn't be stored, by message ID.
+	Failed map[string]error
+	// Inserted counts the messages that weren't stored before.
+	Inserted int
+	// Duplicates counts the messages that were, such as replays after a reconnect.
+	Duplicates int
+}
+
+// Stored reports whether a message of the batch is stored.
+func (r *SaveResult) Stored(messageID string) bool {
+	_, failed := r.Failed[messageID]
+	return !failed
+}
+
+// Store is where the bot writes what it sees and where the API reads history from.
+// MySQL is the production store; Memory backs tests and trial runs without a database.
+type Store interface {
+	Searcher
+	Retention
+	ArchiveIndex
+	MediaIndex
+	Members
+	Threads
+	Reactions
+	Voice
+	InteractionStates
+	DirectMessages
+	// SaveGuild stores a guild without its channels.
+	SaveGuild(guild *discordgo.Guild) error
+	// DeleteGuild removes a guild the bot left with its channels, members and roles, and
+	// ends its open voice sessions. Its messages stay.
+	DeleteGuild(guildID string) error
+	// SaveChannel stores a channel of a guild with its permission overwrites.
+	SaveChannel(guildID string, channel *discordgo.Channel) error
+	// DeleteChannel removes a channel with its permission overwrites.
+	DeleteChannel(channelID string) error
+	// SaveMessages stores a batch of messages together with their authors and, for guild
+	// messages, their members. Messages are keyed by ID, so saving one again updates it
+	// unless the stored version is newer. A message that can't be stored doesn't stop the
+	// others; it is reported in the result, and the error is for failures of the batch.
+	SaveMessages(messages []*discordgo.MessageCreate) (*SaveResult, error)
+	// UpdateMessage stores the new content, pin state and edit time of a stored message.
+	UpdateMessage(message *discordgo.Message) error
+	// DeleteMessage removes a message with its mentions, reactions and mirrored media
+	// sources.
+	DeleteMessage(messageID string) error
+	// Guild returns a stored guild without its channels.
+	Guild(guildID string) (*discordgo.Guild, error)
+	// ChannelGuild returns the guild of a channel, or "" for DM channels.
+	ChannelGuild(channelID string) (string, error)
+	// Channel returns a stored channel without its messages.
+	Channel(channelID string) (*discordgo.Channel, error)
+	// Guilds returns the stored guilds without their channels, ordered by name.
+	Guilds() ([]discordgo.Guild, error)
+	// GuildChannels returns the stored channels of a guild in sidebar order, leaving out
+	// threads.
+	GuildChannels(guildID string) ([]discordgo.Channel, error)
+	// Message returns a stored message with its author.
+	Message(messageID string) (*discordgo.Message, error)
+	// User returns a stored message author.
+	User(userID string) (*discordgo.User, error)
+	// UserGuilds returns the guilds a user is a stored member of.
+	UserGuilds(userID string) ([]string, error)
+	// KnownMessages returns which of the message IDs are stored.
+	KnownMessages(ids []string) (map[string]bool, error)
+	// EachMessage calls fn with the stored messages matching a filter, oldest first,
+	// until fn returns an error, which EachMessage returns.
+	EachMessage(filter *HistoryFilter, fn func(message *discordgo.Message) error) error
+	// ChannelMessages returns a channel's messages newest first, skipping offset messages.
+	ChannelMessages(channelID string, limit, offset int) ([]discordgo.Message, error)
+}
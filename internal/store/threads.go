@@ -0,0 +1,46 @@
+package store
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Thread is a stored thread or forum post.
+type Thread struct {
+	ArchiveTimestamp *time.Time `json:"archive_timestamp"`
+	ID               string     `json:"id"`
+	GuildID          string     `json:"guild_id"`
+	ParentID         string     `json:"parent_id"`
+	OwnerID          string     `json:"owner_id"`
+	Name             string     `json:"name"`
+	AppliedTags      []string   `json:"applied_tags"`
+	Type             int        `json:"type"`
+	Archived         bool       `json:"archived"`
+	Locked           bool       `json:"locked"`
+}
+
+// Threads stores threads and forum posts, who follows them and the tags forums offer.
+type Threads interface {
+	// SaveThread stores a thread or forum post with its parent, archive state and tags.
+	SaveThread(thread *discordgo.Channel) error
+	// DeleteThread removes a thread with its tags and members.
+	DeleteThread(threadID string) error
+	// ArchiveThreads marks the active threads of the parent channels as archived, or
+	// those of the whole guild when there are no parents.
+	ArchiveThreads(guildID string, parentIDs []string) error
+	// SaveThreadMember records that a user follows a thread.
+	SaveThreadMember(threadID, userID string, joinedAt time.Time) error
+	// DeleteThreadMember records that a user left a thread.
+	DeleteThreadMember(threadID, userID string) error
+	// SaveForumTags stores the tags a forum channel offers.
+	SaveForumTags(channel *discordgo.Channel) error
+	// ThreadBackfilled reports whether the history of a stored thread was backfilled, or
+	// returns ErrNotFound.
+	ThreadBackfilled(threadID string) (bool, error)
+	// MarkThreadBackfilled records that the history of a thread was backfilled at.
+	MarkThreadBackfilled(threadID string, at time.Time) error
+	// Threads returns the threads of a channel or forum, the most recently archived
+	// first after the active ones. A non-nil archived only returns threads in that state.
+	Threads(parentID string, archived *bool) ([]Thread, error)
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// VoiceMember is a member in a voice channel.
+type VoiceMember struct {
+	JoinedAt   time.Time `json:"joined_at"`
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Nick       string    `json:"nick"`
+	Mute       bool      `json:"mute"`
+	Deaf       bool      `json:"deaf"`
+	SelfMute   bool      `json:"self_mute"`
+	SelfDeaf   bool      `json:"self_deaf"`
+	SelfStream bool      `json:"self_stream"`
+	SelfVideo  bool      `json:"self_video"`
+	Suppress   bool      `json:"suppress"`
+}
+
+// VoiceChannel is a voice channel with the members in it.
+type VoiceChannel struct {
+	ChannelID string        `json:"channel_id"`
+	Members   []VoiceMember `json:"members"`
+}
+
+// VoiceTime is how long a member spent in voice, and how much of it streaming or with
+// video on.
+type VoiceTime struct {
+	UserID          string `json:"user_id"`
+	Username        string `json:"username"`
+	Seconds         int64  `json:"seconds"`
+	StreamedSeconds int64  `json:"streamed_seconds"`
+}
+
+// Voice stores the voice sessions of guild members. A session covers a stretch of time a
+// user spent in one voice channel with the same mute, deaf, stream and video flags.
+type Voice interface {
+	// RecordVoiceState closes the open voice session of a user and, unless the user left
+	// voice, opens a new one for its current state at. The join time carries over while
+	// the user stays in the same channel. It returns the channel of the closed session.
+	RecordVoiceState(state *discordgo.VoiceState, at time.Time) (string, error)
+	// CloseVoiceSessions ends the open voice sessions of a guild at.
+	CloseVoiceSessions(guildID string, at time.Time) error
+	// VoiceOccupancy returns the members in each voice channel of a guild, by channel and
+	// in the order they joined.
+	VoiceOccupancy(guildID string) ([]VoiceChannel, error)
+	// VoiceTimes returns how long the members of a guild spent in voice between since and
+	// until, longest first. Sessions only count their overlap with that window.
+	VoiceTimes(guildID string, since, until time.Time) ([]VoiceTime, error)
+}
@@ -1,8 +1,11 @@
 package wshub
 
 import (
+	"discord-go-connect/internal/auth"
 	"discord-go-connect/internal/logger"
+	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,8 +16,11 @@ type Client struct {
 	hub        *Hub
 	Conn       *websocket.Conn
 	logger     *logger.StandardLoggerHandler
+	grant      *auth.Grant
 	ID         string
 	ClientType string
+	// primary marks the bot process that runs shard 0 and handles requests.
+	primary bool
 }
 
 const (
@@ -34,6 +40,7 @@ type WSJSONResponse struct {
 	Action    Action[ClientAction] `json:"action"`
 	MessageID string               `json:"message_id"`
 	Message   string               `json:"message"`
+	Nonce     string               `json:"nonce,omitempty"`
 }
 
 // WSPayload represents the payload sent over WebSocket.
@@ -42,12 +49,37 @@ type WSPayload struct {
 	MessageID string               `json:"message_id"`
 	Message   string               `json:"message"`
 	Receiver  string               `json:"receiver"`
-	Client    Client               `json:"-"`
+	// Caller names the API key of the client that sent the payload. The hub sets it,
+	// so the bot can trust it.
+	Caller string `json:"caller,omitempty"`
+	// Nonce is chosen by the client and echoed back on the response to its request.
+	Nonce string `json:"nonce,omitempty"`
+	// GuildID limits a broadcast to the clients whose key grants access to that guild.
+	GuildID string `json:"guild_id,omitempty"`
+	// Data carries the structured arguments of actions that need more than Message.
+	Data   json.RawMessage `json:"data,omitempty"`
+	Client Client          `json:"-"`
 }
 
 // WSHandler is the HTTP handler for WebSocket connections.
 func WSHandler(h *Hub, w http.ResponseWriter, r *http.Request) {
 	clientType := r.URL.Query().Get("type")
+	token := r.URL.Query().Get("token")
+
+	var grant *auth.Grant
+
+	if clientType == "D-BOT" {
+		if !h.keys.IsBotSecret(token) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		var ok bool
+		if grant, ok = h.keys.FromRequest(r); !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
 
 	ws, err := upgradeConnection.Upgrade(w, r, nil)
 	if err != nil {
@@ -55,7 +87,13 @@ func WSHandler(h *Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := Client{Conn: ws, hub: h, ID: uuid.NewString(), ClientType: clientType, logger: h.logger}
+	client := Client{Conn: ws, hub: h, ID: uuid.NewString(), ClientType: clientType, logger: h.logger, grant: grant}
+
+	// Bots list their shards; a bot without the list runs them all.
+	if clientType == "D-BOT" {
+		shards := r.URL.Query().Get("shards")
+		client.primary = shards == "" || containsShard(shards, "0")
+	}
 	h.register <- &client
 
 	go client.ReadWS()
@@ -84,9 +122,9 @@ func (c *Client) ReadWS() {
 		return nil
 	})
 
-	var payload WSPayload
-
 	for {
+		var payload WSPayload
+
 		err = c.Conn.ReadJSON(&payload)
 
 		if err != nil {
@@ -105,24 +143,51 @@ func (c *Client) ReadWS() {
 			continue
 		}
 
-		if _, ok := c.hub.clients[c]; ok && c.hub.discordBot != nil {
+		// Only bots publish to other clients. Web clients' payloads are requests for a bot,
+		// and without one they can't be served.
+		switch {
+		case c.ClientType == "D-BOT" && len(payload.Receiver) > 0:
+			c.hub.unicast <- payload
+		case c.ClientType == "D-BOT":
+			c.hub.broadcast <- payload
+		case c.hub.botCount.Load() > 0:
 			payload.Receiver = c.ID
+			payload.Caller = c.grant.Name
 			c.hub.server <- payload
-		} else {
-			if len(payload.Receiver) > 0 {
-				c.hub.unicast <- payload
-			} else {
-				c.hub.broadcast <- payload
-			}
+		default:
+			c.hub.unicast <- c.noBotError(&payload)
 		}
 	}
 }
 
+// noBotError answers a web client's request when no bot is connected, in the shape of
+// the errors bots send.
+func (c *Client) noBotError(request *WSPayload) WSPayload {
+	message, _ := json.Marshal(struct {
+		Action string `json:"action"`
+		Error  string `json:"error"`
+	}{Action: string(request.Action), Error: "no bot is connected"})
+
+	return WSPayload{Action: ServerError, Message: string(message), Receiver: c.ID, Nonce: request.Nonce}
+}
+
+// SendMessage writes to the client. It runs on the hub's goroutine, so a failed client is
+// unregistered directly.
 func (c *Client) SendMessage(wsJSONMessage *WSJSONResponse) {
 	if err := c.Conn.WriteJSON(wsJSONMessage); err != nil {
 		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 			c.logger.Debug("error: %v", err)
 		}
-		c.hub.unregister <- c
+		c.hub.unregisterClient(c)
 	}
 }
+
+func containsShard(shards, id string) bool {
+	for _, shard := range strings.Split(shards, ",") {
+		if shard == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -14,7 +14,49 @@ const (
 	ClientGuildMessage     Action[ClientAction] = "guild_message"
 	ClientSubscribeToGuild Action[ClientAction] = "get_messages"
 	ClientDmMessage        Action[ClientAction] = "dm_message"
+	ClientListDms          Action[ClientAction] = "list_dms"
+	ClientComponentMessage Action[ClientAction] = "component_message"
+	ClientEditMessage      Action[ClientAction] = "edit_message"
+	ClientDeleteMessage    Action[ClientAction] = "delete_message"
+	ClientPinMessage       Action[ClientAction] = "pin_message"
+	ClientUnpinMessage     Action[ClientAction] = "unpin_message"
+	ClientAddReaction      Action[ClientAction] = "add_reaction"
+	ClientRemoveReaction   Action[ClientAction] = "remove_reaction"
+	ClientSubscribe        Action[ClientAction] = "subscribe"
+	ClientUnsubscribe      Action[ClientAction] = "unsubscribe"
+	ClientTyping           Action[ClientAction] = "typing"
+	ClientSearch           Action[ClientAction] = "search"
 	ServerHandshake        Action[ServerAction] = "handshake"
 	ServerListGuilds       Action[ServerAction] = "guilds"
 	ServerListDms          Action[ServerAction] = "list_dms"
+	ServerMessageSent      Action[ServerAction] = "message_sent"
+	ServerError            Action[ServerAction] = "error"
+	ServerMessageUpdated   Action[ServerAction] = "message_updated"
+	ServerMessageDeleted   Action[ServerAction] = "message_deleted"
+	ServerReactionDelta    Action[ServerAction] = "reaction_delta"
+	ServerThreadCreated    Action[ServerAction] = "thread_created"
+	ServerThreadUpdated    Action[ServerAction] = "thread_updated"
+	ServerThreadDeleted    Action[ServerAction] = "thread_deleted"
+	ServerGuildUpdated     Action[ServerAction] = "guild_updated"
+	ServerChannelUpdated   Action[ServerAction] = "channel_updated"
+	ServerMemberUpdated    Action[ServerAction] = "member_updated"
+	ServerRoleUpdated      Action[ServerAction] = "role_updated"
+	ServerVoiceState       Action[ServerAction] = "voice_state"
+	ServerPresenceUpdated  Action[ServerAction] = "presence_updated"
+	ServerTyping           Action[ServerAction] = "typing"
+	ServerShardState       Action[ServerAction] = "shard_state"
+	ServerSearchResults    Action[ServerAction] = "search_results"
+
+	ServerMessageActionResult Action[ServerAction] = "message_action"
+
+	ServerComponentInteraction Action[ServerAction] = "component_interaction"
 )
+
+// fanOutActions are sent to every bot process instead of only the primary one.
+var fanOutActions = map[Action[ClientAction]]bool{
+	ClientJoin:             true,
+	ClientSubscribeToGuild: true,
+	ClientSubscribe:        true,
+	ClientUnsubscribe:      true,
+	ClientLeave:            true,
+}
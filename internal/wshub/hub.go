@@ -1,33 +1,39 @@
 package wshub
 
 import (
+	"discord-go-connect/internal/auth"
 	"discord-go-connect/internal/logger"
 	"encoding/json"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
 
 type Hub struct {
 	clients      map[*Client]struct{}
-	discordBot   *Client
+	bots         map[*Client]struct{}
+	botCount     atomic.Int32
 	broadcast    chan WSPayload
 	unicast      chan WSPayload
 	server       chan WSPayload
 	register     chan *Client
 	unregister   chan *Client
 	logger       *logger.StandardLoggerHandler
+	keys         *auth.Keys
 	clientLookup sync.Map
 }
 
-func NewHub() *Hub {
+func NewHub(keys *auth.Keys) *Hub {
 	return &Hub{
+		keys:       keys,
 		broadcast:  make(chan WSPayload),
 		unicast:    make(chan WSPayload),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]struct{}),
+		bots:       make(map[*Client]struct{}),
 		server:     make(chan WSPayload, 10),
 		logger:     logger.NewLogger(os.Stderr),
 	}
@@ -53,13 +59,19 @@ func (h *Hub) ListenToWSChannel() {
 	}
 }
 
+// BotCount returns the number of bot processes connected to the hub.
+func (h *Hub) BotCount() int {
+	return int(h.botCount.Load())
+}
+
 func (h *Hub) registerClient(c *Client) {
 	if c.ClientType == "D-BOT" {
 		h.logger.Debug("Registering bot with id: %s", c.ID)
-		h.discordBot = c
+		h.bots[c] = struct{}{}
+		h.botCount.Store(int32(len(h.bots)))
 	} else {
 		h.clients[c] = struct{}{}
-		h.clientLookup.Store(c.ID, *c)
+		h.clientLookup.Store(c.ID, c)
 		h.logger.Debug("Registering client with id: %s", c.ID)
 	}
 }
@@ -89,7 +101,9 @@ func (h *Hub) unregisterClient(client *Client) {
 		h.clientLookup.Delete(client.ID)
 		delete(h.clients, client)
 		client.Conn.Close()
-	} else if h.discordBot != nil {
+	} else if _, ok := h.bots[client]; ok {
+		delete(h.bots, client)
+		h.botCount.Store(int32(len(h.bots)))
 		client.Conn.Close()
 		h.logger.Debug("Unregistering %s with id: %s", client.ClientType, client.ID)
 	}
@@ -97,6 +111,10 @@ func (h *Hub) unregisterClient(client *Client) {
 
 func (h *Hub) broadcastMessage(payload *WSPayload) {
 	for client := range h.clients {
+		if payload.GuildID != "" && !client.grant.CanAccessGuild(payload.GuildID) {
+			continue
+		}
+
 		message := WSJSONResponse{Action: Action[ClientAction](payload.Action), MessageID: payload.MessageID, Message: payload.Message}
 		client.SendMessage(&message)
 	}
@@ -104,23 +122,56 @@ func (h *Hub) broadcastMessage(payload *WSPayload) {
 
 func (h *Hub) unicastMessage(payload *WSPayload) {
 	if client, ok := h.clientLookup.Load(payload.Receiver); ok {
-		message := WSJSONResponse{Action: Action[ClientAction](payload.Action), MessageID: payload.MessageID, Message: payload.Message}
+		message := WSJSONResponse{
+			Action:    Action[ClientAction](payload.Action),
+			MessageID: payload.MessageID,
+			Message:   payload.Message,
+			Nonce:     payload.Nonce,
+		}
 
-		if c, ok := client.(Client); ok {
+		if c, ok := client.(*Client); ok {
 			c.SendMessage(&message)
 		}
 	}
 }
 
+// sendPayloadToBot forwards a web client's payload. Subscriptions and lookups go to every
+// bot process, since each one only publishes the events of its own shards; requests that
+// act on Discord go to the primary bot alone.
 func (h *Hub) sendPayloadToBot(payload *WSPayload) {
-	if h.discordBot == nil {
+	if fanOutActions[Action[ClientAction](payload.Action)] {
+		for bot := range h.bots {
+			h.writeToBot(bot, payload)
+		}
+
 		return
 	}
 
-	if err := h.discordBot.Conn.WriteJSON(payload); err != nil {
+	if bot := h.primaryBot(); bot != nil {
+		h.writeToBot(bot, payload)
+	}
+}
+
+func (h *Hub) primaryBot() *Client {
+	var fallback *Client
+
+	for bot := range h.bots {
+		if bot.primary {
+			return bot
+		}
+
+		fallback = bot
+	}
+
+	return fallback
+}
+
+func (h *Hub) writeToBot(bot *Client, payload *WSPayload) {
+	if err := bot.Conn.WriteJSON(payload); err != nil {
 		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 			h.logger.Debug("error: %v", err)
 		}
-		h.unregister <- h.discordBot
+
+		h.unregisterClient(bot)
 	}
 }